@@ -0,0 +1,395 @@
+package analytics
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// refreshBatch is how many changed diaries are loaded at once while refreshing
+const refreshBatch = 200
+
+// MaxRangeDays is the longest range a report covers
+const MaxRangeDays = 3 * 366
+
+// ErrRangeTooLong is returned for report ranges longer than MaxRangeDays
+var ErrRangeTooLong = fmt.Errorf("the range must not be longer than %d days", MaxRangeDays)
+
+// DayStats sums the diaries of one day
+type DayStats struct {
+	Date    string `db:"date" json:"date"`
+	Entries int    `db:"entries" json:"entries"`
+	Words   int    `db:"words" json:"words"`
+	Chars   int    `db:"chars" json:"chars"`
+}
+
+// HeatmapDay is one cell of the calendar heatmap, level goes from 0 (no diary) to 4
+type HeatmapDay struct {
+	Date  string `json:"date"`
+	Words int    `json:"words"`
+	Level int    `json:"level"`
+}
+
+// MonthCounts counts diaries per value, such as a mood, in one month
+type MonthCounts struct {
+	Month  string         `json:"month"`
+	Counts map[string]int `json:"counts"`
+}
+
+// MonthTotals sums the diaries of one month
+type MonthTotals struct {
+	Month   string `json:"month"`
+	Entries int    `json:"entries"`
+	Words   int    `json:"words"`
+	Chars   int    `json:"chars"`
+}
+
+// Report is the writing analytics of a user. Totals and streaks cover all diaries,
+// the other series only the diaries between Start and End.
+type Report struct {
+	Start         string        `json:"start"`
+	End           string        `json:"end"`
+	TotalEntries  int           `json:"total_entries"`
+	TotalWords    int           `json:"total_words"`
+	TotalChars    int           `json:"total_chars"`
+	CurrentStreak Streak        `json:"current_streak"`
+	LongestStreak Streak        `json:"longest_streak"`
+	Days          []DayStats    `json:"days"`
+	Heatmap       []HeatmapDay  `json:"heatmap"`
+	Moods         []MonthCounts `json:"moods"`
+	Weather       []MonthCounts `json:"weather"`
+	// Hours counts diaries by the hour they were first written, in the report's time zone
+	Hours   [24]int       `json:"hours"`
+	Monthly []MonthTotals `json:"monthly"`
+}
+
+// statsRow is a cached diary_stats row
+type statsRow struct {
+	Date      string `db:"date"`
+	Words     int    `db:"words"`
+	Chars     int    `db:"chars"`
+	Mood      string `db:"mood"`
+	Weather   string `db:"weather"`
+	WrittenAt string `db:"written_at"`
+}
+
+// Service computes writing analytics from per-diary aggregates cached in the
+// diary_stats collection. Only diaries added or changed since the last refresh
+// are counted again, so reports stay fast for journals of many years.
+type Service struct {
+	app           *pocketbase.PocketBase
+	configService *config.ConfigService
+	locks         sync.Map
+}
+
+// NewService creates a new analytics service
+func NewService(app *pocketbase.PocketBase) *Service {
+	return &Service{
+		app:           app,
+		configService: config.NewConfigService(app),
+	}
+}
+
+// Refresh brings the user's cached aggregates up to date and returns how many
+// diaries were counted again. Aggregates of deleted diaries go with them.
+func (s *Service) Refresh(userID string) (int, error) {
+	lock, _ := s.locks.LoadOrStore(userID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	var stale []struct {
+		Diary string `db:"diary"`
+		Stats string `db:"stats"`
+	}
+	err := s.app.Dao().DB().
+		NewQuery(`SELECT d.id AS diary, COALESCE(s.id, '') AS stats FROM diaries d
+			LEFT JOIN diary_stats s ON s.diary = d.id
+			WHERE d.owner = {:owner} AND (s.id IS NULL OR s.diary_updated != d.updated)`).
+		Bind(dbx.Params{"owner": userID}).
+		All(&stale)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find changed diaries: %w", err)
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	collection, err := s.app.Dao().FindCollectionByNameOrId("diary_stats")
+	if err != nil {
+		return 0, fmt.Errorf("failed to find diary_stats collection: %w", err)
+	}
+
+	err = s.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		for start := 0; start < len(stale); start += refreshBatch {
+			batch := stale[start:min(start+refreshBatch, len(stale))]
+			ids := make([]string, len(batch))
+			statsIDs := make(map[string]string, len(batch))
+			for i, row := range batch {
+				ids[i] = row.Diary
+				statsIDs[row.Diary] = row.Stats
+			}
+
+			diaries, err := txDao.FindRecordsByIds("diaries", ids)
+			if err != nil {
+				return fmt.Errorf("failed to fetch diaries: %w", err)
+			}
+			for _, diary := range diaries {
+				record := models.NewRecord(collection)
+				if id := statsIDs[diary.Id]; id != "" {
+					if record, err = txDao.FindRecordById("diary_stats", id); err != nil {
+						return fmt.Errorf("failed to find stats of diary %s: %w", diary.Id, err)
+					}
+				}
+				fillStats(record, diary)
+				if err := txDao.SaveRecord(record); err != nil {
+					return fmt.Errorf("failed to save stats of diary %s: %w", diary.Id, err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Info("[Analytics] refreshed %d diaries of user %s", len(stale), userID)
+	return len(stale), nil
+}
+
+// Dates returns the dates of all of the user's diaries, in ascending order
+func Dates(dao *daos.Dao, userID string) ([]string, error) {
+	var dates []string
+	err := dao.DB().
+		NewQuery("SELECT DISTINCT substr(date, 1, 10) FROM diaries WHERE owner = {:owner} ORDER BY 1").
+		Bind(dbx.Params{"owner": userID}).
+		Column(&dates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch diary dates: %w", err)
+	}
+	return dates, nil
+}
+
+// StreakRules returns the user's streak freezes and vacations
+func (s *Service) StreakRules(userID string) (StreakRules, error) {
+	freezes, _ := s.configService.GetInt(userID, "streak.freezes")
+	rules := StreakRules{Freezes: max(freezes, 0)}
+
+	records, err := s.app.Dao().FindRecordsByFilter("vacations", "owner = {:owner}", "start", 0, 0, dbx.Params{"owner": userID})
+	if err != nil {
+		return rules, fmt.Errorf("failed to fetch vacations: %w", err)
+	}
+	for _, record := range records {
+		rules.Vacations = append(rules.Vacations, Vacation{
+			Start: record.GetString("start"),
+			End:   record.GetString("end"),
+		})
+	}
+	return rules, nil
+}
+
+// DailyTotals refreshes the cached aggregates and sums the user's diaries per day
+// from start to end (YYYY-MM-DD, inclusive), in ascending order
+func (s *Service) DailyTotals(userID, start, end string) ([]DayStats, error) {
+	if _, err := s.Refresh(userID); err != nil {
+		return nil, err
+	}
+
+	var days []DayStats
+	err := s.app.Dao().DB().
+		NewQuery(`SELECT date, COUNT(*) AS entries, COALESCE(SUM(words), 0) AS words, COALESCE(SUM(chars), 0) AS chars
+			FROM diary_stats WHERE owner = {:owner} AND date >= {:start} AND date <= {:end}
+			GROUP BY date ORDER BY date`).
+		Bind(dbx.Params{"owner": userID, "start": start, "end": end}).
+		All(&days)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum diary stats: %w", err)
+	}
+	return days, nil
+}
+
+// Report refreshes the cached aggregates and computes the user's analytics.
+// start and end are YYYY-MM-DD days in loc; empty values default to the last 365 days.
+func (s *Service) Report(userID string, loc *time.Location, start, end string) (*Report, error) {
+	today := time.Now().In(loc)
+	if end == "" {
+		end = today.Format(dateLayout)
+	}
+	endDay, err := time.Parse(dateLayout, end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end date %q", end)
+	}
+	if start == "" {
+		start = endDay.AddDate(0, 0, -364).Format(dateLayout)
+	}
+	startDay, err := time.Parse(dateLayout, start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start date %q", start)
+	}
+	if startDay.After(endDay) {
+		return nil, fmt.Errorf("start date %s is after end date %s", start, end)
+	}
+	if endDay.Sub(startDay) >= MaxRangeDays*24*time.Hour {
+		return nil, ErrRangeTooLong
+	}
+
+	if _, err := s.Refresh(userID); err != nil {
+		return nil, err
+	}
+
+	var rows []statsRow
+	err = s.app.Dao().DB().
+		NewQuery("SELECT date, words, chars, mood, weather, written_at FROM diary_stats WHERE owner = {:owner} ORDER BY date").
+		Bind(dbx.Params{"owner": userID}).
+		All(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch diary stats: %w", err)
+	}
+
+	report := &Report{
+		Start:   start,
+		End:     end,
+		Days:    []DayStats{},
+		Heatmap: []HeatmapDay{},
+		Moods:   []MonthCounts{},
+		Weather: []MonthCounts{},
+		Monthly: []MonthTotals{},
+	}
+
+	dates := make([]string, 0, len(rows))
+	dateSet := make(map[string]bool, len(rows))
+	moods := make(map[string]map[string]int)
+	weather := make(map[string]map[string]int)
+	for _, row := range rows {
+		report.TotalEntries++
+		report.TotalWords += row.Words
+		report.TotalChars += row.Chars
+		if !dateSet[row.Date] {
+			dateSet[row.Date] = true
+			dates = append(dates, row.Date)
+		}
+
+		if row.Date < start || row.Date > end {
+			continue
+		}
+
+		if n := len(report.Days); n > 0 && report.Days[n-1].Date == row.Date {
+			report.Days[n-1].Entries++
+			report.Days[n-1].Words += row.Words
+			report.Days[n-1].Chars += row.Chars
+		} else {
+			report.Days = append(report.Days, DayStats{Date: row.Date, Entries: 1, Words: row.Words, Chars: row.Chars})
+		}
+
+		month := row.Date[:7]
+		if n := len(report.Monthly); n > 0 && report.Monthly[n-1].Month == month {
+			report.Monthly[n-1].Entries++
+			report.Monthly[n-1].Words += row.Words
+			report.Monthly[n-1].Chars += row.Chars
+		} else {
+			report.Monthly = append(report.Monthly, MonthTotals{Month: month, Entries: 1, Words: row.Words, Chars: row.Chars})
+		}
+
+		count(moods, month, row.Mood)
+		count(weather, month, row.Weather)
+
+		if writtenAt, err := types.ParseDateTime(row.WrittenAt); err == nil && !writtenAt.IsZero() {
+			report.Hours[writtenAt.Time().In(loc).Hour()]++
+		}
+	}
+
+	rules, err := s.StreakRules(userID)
+	if err != nil {
+		return nil, err
+	}
+	report.CurrentStreak, report.LongestStreak = Streaks(dates, today, rules)
+	report.Heatmap = heatmap(report.Days, startDay, endDay)
+	report.Moods = monthCounts(moods)
+	report.Weather = monthCounts(weather)
+	return report, nil
+}
+
+// fillStats sets the aggregates of a diary on its diary_stats record
+func fillStats(record, diary *models.Record) {
+	words, chars := CountText(diary.GetString("content"))
+	date := diary.GetString("date")
+	if len(date) > 10 {
+		date = date[:10]
+	}
+
+	record.Set("owner", diary.GetString("owner"))
+	record.Set("diary", diary.Id)
+	record.Set("date", date)
+	record.Set("words", words)
+	record.Set("chars", chars)
+	record.Set("mood", diary.GetString("mood"))
+	record.Set("weather", diary.GetString("weather"))
+	record.Set("written_at", diary.Created)
+	record.Set("diary_updated", diary.Updated.String())
+}
+
+// heatmap returns a cell for every day from start to end. Levels split the days
+// with diaries into quartiles by their word count.
+func heatmap(days []DayStats, start, end time.Time) []HeatmapDay {
+	words := make(map[string]int, len(days))
+	counts := make([]int, 0, len(days))
+	for _, day := range days {
+		words[day.Date] = day.Words
+		counts = append(counts, day.Words)
+	}
+	sort.Ints(counts)
+	quartile := func(q int) int {
+		if len(counts) == 0 {
+			return 0
+		}
+		return counts[(len(counts)-1)*q/4]
+	}
+	thresholds := [3]int{quartile(1), quartile(2), quartile(3)}
+
+	cells := make([]HeatmapDay, 0, int(end.Sub(start).Hours()/24)+1)
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format(dateLayout)
+		cell := HeatmapDay{Date: date}
+		if n, ok := words[date]; ok {
+			cell.Words = n
+			cell.Level = 1
+			for _, threshold := range thresholds {
+				if n > threshold {
+					cell.Level++
+				}
+			}
+		}
+		cells = append(cells, cell)
+	}
+	return cells
+}
+
+// count adds a non-empty value to the counts of a month
+func count(months map[string]map[string]int, month, value string) {
+	if value == "" {
+		return
+	}
+	if months[month] == nil {
+		months[month] = make(map[string]int)
+	}
+	months[month][value]++
+}
+
+// monthCounts returns the counts per month in ascending order
+func monthCounts(months map[string]map[string]int) []MonthCounts {
+	list := make([]MonthCounts, 0, len(months))
+	for month, counts := range months {
+		list = append(list, MonthCounts{Month: month, Counts: counts})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Month < list[j].Month })
+	return list
+}
@@ -0,0 +1,101 @@
+package analytics
+
+import "time"
+
+const dateLayout = "2006-01-02"
+
+// Streak is a run of days with diaries, bridged by vacations and streak freezes
+type Streak struct {
+	Days        int    `json:"days"`
+	Start       string `json:"start,omitempty"`
+	End         string `json:"end,omitempty"`
+	FreezesUsed int    `json:"freezes_used"`
+}
+
+// Vacation is a planned break from Start to End (YYYY-MM-DD, inclusive)
+type Vacation struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// StreakRules are the planned breaks that do not end a streak. Vacation days neither
+// count towards nor break a streak; Freezes is how many other missed days per calendar
+// month are forgiven.
+type StreakRules struct {
+	Freezes   int
+	Vacations []Vacation
+}
+
+// OnVacation reports whether a YYYY-MM-DD date falls in a vacation
+func (r StreakRules) OnVacation(date string) bool {
+	for _, v := range r.Vacations {
+		if date >= v.Start && date <= v.End {
+			return true
+		}
+	}
+	return false
+}
+
+// Streaks returns the current and the longest streak of dates, which are YYYY-MM-DD
+// strings in ascending order. The current streak ends today, or on an earlier day when
+// the days since are bridged, so a missing diary today does not break it yet.
+// Freezes are used in date order and only to bridge gaps they fully cover.
+func Streaks(dates []string, today time.Time, rules StreakRules) (current, longest Streak) {
+	todayDay, _ := time.Parse(dateLayout, today.Format(dateLayout))
+	used := make(map[string]int)
+
+	// bridge uses the freezes needed to cover the days between from and to
+	bridge := func(from, to time.Time) (int, bool) {
+		needed := make(map[string]int)
+		total := 0
+		for day := from.AddDate(0, 0, 1); day.Before(to); day = day.AddDate(0, 0, 1) {
+			date := day.Format(dateLayout)
+			if rules.OnVacation(date) {
+				continue
+			}
+			needed[date[:7]]++
+			total++
+		}
+		for month, n := range needed {
+			if used[month]+n > rules.Freezes {
+				return 0, false
+			}
+		}
+		for month, n := range needed {
+			used[month] += n
+		}
+		return total, true
+	}
+
+	var run Streak
+	var previous time.Time
+	for _, date := range dates {
+		day, err := time.Parse(dateLayout, date)
+		if err != nil || day.After(todayDay) || (run.Days > 0 && !day.After(previous)) {
+			continue
+		}
+		freezes, bridged := 0, false
+		if run.Days > 0 {
+			freezes, bridged = bridge(previous, day)
+		}
+		if bridged {
+			run.Days++
+			run.End = date
+			run.FreezesUsed += freezes
+		} else {
+			run = Streak{Days: 1, Start: date, End: date}
+		}
+		if run.Days > longest.Days {
+			longest = run
+		}
+		previous = day
+	}
+
+	if run.Days > 0 {
+		if freezes, ok := bridge(previous, todayDay); ok {
+			current = run
+			current.FreezesUsed += freezes
+		}
+	}
+	return current, longest
+}
@@ -0,0 +1,72 @@
+package analytics
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// blockTags separate words even when no whitespace is written between them
+var blockTags = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "ul": true, "ol": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "pre": true, "tr": true, "td": true, "th": true, "hr": true,
+}
+
+// CountText counts the words and characters of editor HTML. Every Chinese and
+// Japanese character counts as a word, as those scripts are written without spaces;
+// other words are runs of letters and digits. Characters exclude whitespace.
+func CountText(content string) (words, chars int) {
+	inWord := false
+	for _, r := range plainText(content) {
+		if unicode.IsSpace(r) {
+			inWord = false
+			continue
+		}
+		chars++
+		switch {
+		case isCJK(r):
+			words++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r):
+			if !inWord {
+				words++
+				inWord = true
+			}
+		case r == '\'' || r == '’' || r == '-':
+			// Apostrophes and hyphens keep words such as "don't" and "well-known" together
+		default:
+			inWord = false
+		}
+	}
+	return words, chars
+}
+
+// plainText returns the text of editor HTML with block elements separated by newlines
+func plainText(content string) string {
+	if !strings.Contains(content, "<") {
+		return content
+	}
+
+	var sb strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return sb.String()
+		case html.TextToken:
+			sb.Write(tokenizer.Text())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			if blockTags[string(name)] {
+				sb.WriteByte('\n')
+			}
+		}
+	}
+}
+
+// isCJK reports whether a rune belongs to a script written without spaces between words
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
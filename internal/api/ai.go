@@ -3,11 +3,13 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/labstack/echo/v5"
 	"github.com/pocketbase/pocketbase"
@@ -35,8 +37,11 @@ type ModelsResponse struct {
 	Data   []ModelInfo `json:"data"`
 }
 
+// maxSystemPromptLength is the longest custom system prompt in characters
+const maxSystemPromptLength = 8000
+
 // RegisterAIRoutes registers AI-related API endpoints
-func RegisterAIRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingService *embedding.EmbeddingService) {
+func RegisterAIRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingService *embedding.EmbeddingService, chatService *chat.ChatService) {
 	configService := config.NewConfigService(app)
 
 	// Get AI settings
@@ -53,6 +58,22 @@ func RegisterAIRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingS
 		chatModel, _ := configService.GetString(userId, "ai.chat_model")
 		embeddingModel, _ := configService.GetString(userId, "ai.embedding_model")
 		enabled, _ := configService.GetBool(userId, "ai.enabled")
+		maxSteps, _ := configService.GetInt(userId, "ai.max_steps")
+		contextWindow, _ := configService.GetInt(userId, "ai.context_window")
+		chatProvider, _ := configService.GetString(userId, "ai.chat_provider")
+		chatBaseURL, _ := configService.GetString(userId, "ai.chat_base_url")
+		chatAPIKey, _ := configService.GetString(userId, "ai.chat_api_key")
+		systemPrompt, _ := configService.GetString(userId, "ai.system_prompt")
+		persona, _ := configService.GetString(userId, "ai.persona")
+		language, _ := configService.GetString(userId, "ai.language")
+		temperature, _ := configService.GetFloat(userId, "ai.temperature")
+		maxTokens, _ := configService.GetInt(userId, "ai.max_tokens")
+		timezone, _ := configService.GetString(userId, "user.timezone")
+		digestWeekly, _ := configService.GetBool(userId, "ai.digest_weekly")
+		digestMonthly, _ := configService.GetBool(userId, "ai.digest_monthly")
+		digestEmail, _ := configService.GetBool(userId, "ai.digest_email")
+		enrichment, _ := configService.GetBool(userId, "ai.enrichment")
+		dailyPrompt, _ := configService.GetBool(userId, "ai.daily_prompt")
 
 		return c.JSON(http.StatusOK, map[string]any{
 			"api_key":         apiKey,
@@ -60,6 +81,22 @@ func RegisterAIRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingS
 			"chat_model":      chatModel,
 			"embedding_model": embeddingModel,
 			"enabled":         enabled,
+			"max_steps":       maxSteps,
+			"context_window":  contextWindow,
+			"chat_provider":   chatProvider,
+			"chat_base_url":   chatBaseURL,
+			"chat_api_key":    chatAPIKey,
+			"system_prompt":   systemPrompt,
+			"persona":         persona,
+			"language":        language,
+			"temperature":     temperature,
+			"max_tokens":      maxTokens,
+			"timezone":        timezone,
+			"digest_weekly":   digestWeekly,
+			"digest_monthly":  digestMonthly,
+			"digest_email":    digestEmail,
+			"enrichment":      enrichment,
+			"daily_prompt":    dailyPrompt,
 		})
 	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
 
@@ -73,16 +110,61 @@ func RegisterAIRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingS
 		userId := authRecord.Id
 
 		var body struct {
-			APIKey         string `json:"api_key"`
-			BaseURL        string `json:"base_url"`
-			ChatModel      string `json:"chat_model"`
-			EmbeddingModel string `json:"embedding_model"`
-			Enabled        bool   `json:"enabled"`
+			APIKey         string   `json:"api_key"`
+			BaseURL        string   `json:"base_url"`
+			ChatModel      string   `json:"chat_model"`
+			EmbeddingModel string   `json:"embedding_model"`
+			Enabled        bool     `json:"enabled"`
+			MaxSteps       *int     `json:"max_steps"`
+			ContextWindow  *int     `json:"context_window"`
+			ChatProvider   *string  `json:"chat_provider"`
+			ChatBaseURL    *string  `json:"chat_base_url"`
+			ChatAPIKey     *string  `json:"chat_api_key"`
+			SystemPrompt   *string  `json:"system_prompt"`
+			Persona        *string  `json:"persona"`
+			Language       *string  `json:"language"`
+			Temperature    *float64 `json:"temperature"`
+			MaxTokens      *int     `json:"max_tokens"`
+			Timezone       *string  `json:"timezone"`
+			DigestWeekly   *bool    `json:"digest_weekly"`
+			DigestMonthly  *bool    `json:"digest_monthly"`
+			DigestEmail    *bool    `json:"digest_email"`
+			Enrichment     *bool    `json:"enrichment"`
+			DailyPrompt    *bool    `json:"daily_prompt"`
 		}
 		if err := c.Bind(&body); err != nil {
 			return apis.NewBadRequestError("Invalid request body", err)
 		}
 
+		if body.MaxSteps != nil && (*body.MaxSteps < 1 || *body.MaxSteps > 20) {
+			return apis.NewBadRequestError("max_steps must be between 1 and 20", nil)
+		}
+		if body.ContextWindow != nil && *body.ContextWindow != 0 && *body.ContextWindow < 2048 {
+			return apis.NewBadRequestError("context_window must be 0 (auto) or at least 2048", nil)
+		}
+		if body.ChatProvider != nil && *body.ChatProvider != "" && !chat.IsValidProvider(*body.ChatProvider) {
+			return apis.NewBadRequestError("chat_provider must be one of openai, anthropic, ollama", nil)
+		}
+
+		if body.SystemPrompt != nil && utf8.RuneCountInString(*body.SystemPrompt) > maxSystemPromptLength {
+			return apis.NewBadRequestError(fmt.Sprintf("system_prompt must be at most %d characters", maxSystemPromptLength), nil)
+		}
+		if body.Persona != nil && !chat.IsValidPersona(*body.Persona) {
+			return apis.NewBadRequestError("persona must be one of "+strings.Join(chat.Personas(), ", "), nil)
+		}
+		if body.Language != nil && !chat.IsValidLanguage(*body.Language) {
+			return apis.NewBadRequestError("language must be one of "+strings.Join(chat.Languages(), ", "), nil)
+		}
+		if body.Temperature != nil && *body.Temperature > 2 {
+			return apis.NewBadRequestError("temperature must be negative (default) or between 0 and 2", nil)
+		}
+		if body.MaxTokens != nil && *body.MaxTokens != 0 && (*body.MaxTokens < 64 || *body.MaxTokens > 65536) {
+			return apis.NewBadRequestError("max_tokens must be 0 (default) or between 64 and 65536", nil)
+		}
+		if body.Timezone != nil && !chat.IsValidTimezone(*body.Timezone) {
+			return apis.NewBadRequestError("timezone must be an IANA time zone name, e.g. Asia/Shanghai", nil)
+		}
+
 		// Validate: if enabled is true, all fields must be filled
 		if body.Enabled {
 			if body.APIKey == "" || body.BaseURL == "" || body.ChatModel == "" || body.EmbeddingModel == "" {
@@ -97,6 +179,62 @@ func RegisterAIRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingS
 			"ai.embedding_model": body.EmbeddingModel,
 			"ai.enabled":         body.Enabled,
 		}
+		if body.MaxSteps != nil {
+			settings["ai.max_steps"] = *body.MaxSteps
+		}
+		if body.ContextWindow != nil {
+			settings["ai.context_window"] = *body.ContextWindow
+		}
+		if body.ChatProvider != nil {
+			provider := *body.ChatProvider
+			if provider == "" {
+				provider = chat.ProviderOpenAI
+			}
+			settings["ai.chat_provider"] = provider
+		}
+		if body.ChatBaseURL != nil {
+			settings["ai.chat_base_url"] = *body.ChatBaseURL
+		}
+		if body.ChatAPIKey != nil {
+			settings["ai.chat_api_key"] = *body.ChatAPIKey
+		}
+		if body.SystemPrompt != nil {
+			settings["ai.system_prompt"] = *body.SystemPrompt
+		}
+		if body.Persona != nil {
+			settings["ai.persona"] = *body.Persona
+		}
+		if body.Language != nil {
+			settings["ai.language"] = *body.Language
+		}
+		if body.Temperature != nil {
+			temperature := *body.Temperature
+			if temperature < 0 {
+				temperature = -1
+			}
+			settings["ai.temperature"] = temperature
+		}
+		if body.MaxTokens != nil {
+			settings["ai.max_tokens"] = *body.MaxTokens
+		}
+		if body.Timezone != nil {
+			settings["user.timezone"] = *body.Timezone
+		}
+		if body.DigestWeekly != nil {
+			settings["ai.digest_weekly"] = *body.DigestWeekly
+		}
+		if body.DigestMonthly != nil {
+			settings["ai.digest_monthly"] = *body.DigestMonthly
+		}
+		if body.DigestEmail != nil {
+			settings["ai.digest_email"] = *body.DigestEmail
+		}
+		if body.Enrichment != nil {
+			settings["ai.enrichment"] = *body.Enrichment
+		}
+		if body.DailyPrompt != nil {
+			settings["ai.daily_prompt"] = *body.DailyPrompt
+		}
 
 		if err := configService.SetBatch(userId, settings); err != nil {
 			return apis.NewBadRequestError("Failed to save AI settings", err)
@@ -163,9 +301,6 @@ func RegisterAIRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingS
 		return c.JSON(http.StatusOK, result)
 	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
 
-	// Initialize chat service
-	chatService := chat.NewChatService(app, embeddingService)
-
 	// Incremental build vectors (only new and outdated)
 	e.Router.POST("/api/ai/vectors/build-incremental", func(c echo.Context) error {
 		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
@@ -298,35 +433,37 @@ func RegisterAIRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingS
 			return apis.NewForbiddenError("Access denied", nil)
 		}
 
-		messages, err := app.Dao().FindRecordsByFilter(
-			"ai_messages",
-			"conversation = {:conv}",
-			"created",
-			100,
-			0,
-			map[string]any{"conv": convID},
-		)
+		messages, branches, err := chatService.GetActiveBranch(convID)
 		if err != nil {
 			return apis.NewBadRequestError("Failed to fetch messages", err)
 		}
 
 		msgList := make([]map[string]any, 0, len(messages))
 		for _, msg := range messages {
-			msgList = append(msgList, map[string]any{
+			item := map[string]any{
 				"id":                 msg.Id,
 				"role":               msg.GetString("role"),
 				"content":            msg.GetString("content"),
 				"referenced_diaries": msg.Get("referenced_diaries"),
+				"tool_calls":         msg.Get("tool_calls"),
+				"tool_call_id":       msg.GetString("tool_call_id"),
+				"parent":             msg.GetString("parent"),
 				"created":            msg.Created.String(),
-			})
+			}
+			if info, ok := branches[msg.Id]; ok {
+				item["branch"] = info
+			}
+			msgList = append(msgList, item)
 		}
 
 		return c.JSON(http.StatusOK, map[string]any{
 			"conversation": map[string]any{
-				"id":      conv.Id,
-				"title":   conv.GetString("title"),
-				"created": conv.Created.String(),
-				"updated": conv.Updated.String(),
+				"id":         conv.Id,
+				"title":      conv.GetString("title"),
+				"summary":    conv.GetString("summary"),
+				"generating": chatService.IsGenerating(conv.Id),
+				"created":    conv.Created.String(),
+				"updated":    conv.Updated.String(),
 			},
 			"messages": msgList,
 		})
@@ -349,6 +486,13 @@ func RegisterAIRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingS
 			return apis.NewForbiddenError("Access denied", nil)
 		}
 
+		// A running generation would keep writing to the deleted conversation
+		if gen, err := chatService.GetGeneration(convID); err == nil {
+			if err := gen.Stop(30 * time.Second); err != nil {
+				logger.Warn("[DELETE /api/ai/conversations/:id] %v", err)
+			}
+		}
+
 		if err := app.Dao().DeleteRecord(conv); err != nil {
 			return apis.NewBadRequestError("Failed to delete conversation", err)
 		}
@@ -392,6 +536,60 @@ func RegisterAIRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingS
 		})
 	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
 
+	// Get conversation summary
+	e.Router.GET("/api/ai/conversations/:id/summary", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		convID := c.PathParam("id")
+		conv, err := app.Dao().FindRecordById("ai_conversations", convID)
+		if err != nil {
+			return apis.NewNotFoundError("Conversation not found", err)
+		}
+
+		if conv.GetString("owner") != authRecord.Id {
+			return apis.NewForbiddenError("Access denied", nil)
+		}
+
+		summary, err := chatService.GetSummary(convID)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to get summary", err)
+		}
+
+		return c.JSON(http.StatusOK, summary)
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Regenerate conversation summary
+	e.Router.POST("/api/ai/conversations/:id/summary", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		convID := c.PathParam("id")
+		conv, err := app.Dao().FindRecordById("ai_conversations", convID)
+		if err != nil {
+			return apis.NewNotFoundError("Conversation not found", err)
+		}
+
+		if conv.GetString("owner") != authRecord.Id {
+			return apis.NewForbiddenError("Access denied", nil)
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request().Context(), 5*time.Minute)
+		defer cancel()
+
+		summary, err := chatService.RegenerateSummary(ctx, authRecord.Id, convID)
+		if err != nil {
+			logger.Error("[POST /api/ai/conversations/:id/summary] failed to regenerate summary: %v", err)
+			return apis.NewBadRequestError("Failed to regenerate summary: "+err.Error(), nil)
+		}
+
+		return c.JSON(http.StatusOK, summary)
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
 	// Streaming chat endpoint
 	e.Router.POST("/api/ai/chat", func(c echo.Context) error {
 		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
@@ -420,6 +618,14 @@ func RegisterAIRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingS
 			return apis.NewForbiddenError("Access denied", nil)
 		}
 
+		gen, err := chatService.BeginGeneration(authRecord.Id, body.ConversationID)
+		if errors.Is(err, chat.ErrGenerationRunning) {
+			return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+		}
+		if err != nil {
+			return apis.NewBadRequestError("Failed to start chat", err)
+		}
+
 		// Check if this is the first message (for auto title generation)
 		messageCount, _ := chatService.GetConversationMessageCount(body.ConversationID)
 		isFirstMessage := messageCount == 0
@@ -435,70 +641,275 @@ func RegisterAIRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingS
 			logger.Info("[POST /api/ai/chat] saved user message: %s", userMsg.Id)
 		}
 
-		// Set SSE headers
-		c.Response().Header().Set("Content-Type", "text/event-stream")
-		c.Response().Header().Set("Cache-Control", "no-cache")
-		c.Response().Header().Set("Connection", "keep-alive")
-		c.Response().WriteHeader(http.StatusOK)
-
-		// Create stream writer
-		writer := &sseWriter{w: c.Response()}
-
-		ctx, cancel := context.WithTimeout(c.Request().Context(), 5*time.Minute)
-		defer cancel()
-
-		// Generate title first for new conversations (before streaming response)
-		var newTitle string
-		if isFirstMessage && currentTitle == "" {
-			logger.Info("[POST /api/ai/chat] generating title for conversation=%s (before streaming)", body.ConversationID)
-			title, err := chatService.GenerateTitleFromUserMessage(ctx, authRecord.Id, body.Content)
-			if err != nil {
-				logger.Error("[POST /api/ai/chat] failed to generate title: %v", err)
-			} else {
-				newTitle = title
-				logger.Info("[POST /api/ai/chat] generated title: %s", title)
-				if err := chatService.UpdateConversationTitle(body.ConversationID, title); err != nil {
-					logger.Error("[POST /api/ai/chat] failed to update title: %v", err)
+		// The answer is generated in the background, so closing the connection does not lose it
+		gen.Run(func(ctx context.Context, writer chat.StreamWriter) {
+			// Generate title first for new conversations (before streaming response)
+			var newTitle string
+			if isFirstMessage && currentTitle == "" {
+				logger.Info("[POST /api/ai/chat] generating title for conversation=%s (before streaming)", body.ConversationID)
+				title, err := chatService.GenerateTitleFromUserMessage(ctx, authRecord.Id, body.Content)
+				if err != nil {
+					logger.Error("[POST /api/ai/chat] failed to generate title: %v", err)
 				} else {
-					// Send title event immediately
-					titleData, _ := json.Marshal(map[string]any{
-						"title": newTitle,
-					})
-					writer.Write([]byte("data: " + string(titleData) + "\n\n"))
-					writer.Flush()
+					newTitle = title
+					logger.Info("[POST /api/ai/chat] generated title: %s", title)
+					if err := chatService.UpdateConversationTitle(body.ConversationID, title); err != nil {
+						logger.Error("[POST /api/ai/chat] failed to update title: %v", err)
+					} else {
+						// Send title event immediately
+						chat.WriteEvent(writer, chat.EventTitle, chat.TitleEvent{Title: newTitle})
+					}
 				}
 			}
+
+			var userMsgID string
+			if userMsg != nil {
+				userMsgID = userMsg.Id
+			}
+			streamAnswer(ctx, writer, chatService, authRecord.Id, body.ConversationID, userMsgID, body.Content, newTitle, "[POST /api/ai/chat]")
+		})
+
+		gen.Subscribe(c.Request().Context(), "", startSSE(c))
+		return nil
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Regenerate the answer to a message as a new branch
+	e.Router.POST("/api/ai/conversations/:id/messages/:msgId/regenerate", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
 		}
 
-		// Stream chat response
-		fullResponse, referencedDiaries, err := chatService.StreamChat(ctx, authRecord.Id, body.ConversationID, body.Content, writer)
+		convID := c.PathParam("id")
+		conv, err := app.Dao().FindRecordById("ai_conversations", convID)
 		if err != nil {
-			logger.Error("[POST /api/ai/chat] stream chat error: %v", err)
-			errData, _ := json.Marshal(map[string]string{"error": err.Error()})
-			writer.Write([]byte("data: " + string(errData) + "\n\n"))
-			writer.Flush()
-			return nil
+			return apis.NewNotFoundError("Conversation not found", err)
+		}
+		if conv.GetString("owner") != authRecord.Id {
+			return apis.NewForbiddenError("Access denied", nil)
 		}
 
-		// Save assistant message
-		assistantMsg, err := chatService.SaveMessage(authRecord.Id, body.ConversationID, "assistant", fullResponse, referencedDiaries)
+		gen, err := chatService.BeginGeneration(authRecord.Id, convID)
+		if errors.Is(err, chat.ErrGenerationRunning) {
+			return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+		}
 		if err != nil {
-			logger.Error("[POST /api/ai/chat] failed to save assistant message: %v", err)
-		} else {
-			logger.Info("[POST /api/ai/chat] saved assistant message: %s", assistantMsg.Id)
+			return apis.NewBadRequestError("Failed to start chat", err)
+		}
+
+		userMsg, err := chatService.PrepareRegenerate(convID, c.PathParam("msgId"))
+		if err != nil {
+			gen.Discard()
+			return apis.NewBadRequestError("Failed to regenerate: "+err.Error(), nil)
 		}
 
-		// Send done event
-		doneData, _ := json.Marshal(map[string]any{
-			"done":               true,
-			"referenced_diaries": referencedDiaries,
-			"title":              newTitle,
+		gen.Run(func(ctx context.Context, writer chat.StreamWriter) {
+			streamAnswer(ctx, writer, chatService, authRecord.Id, convID, userMsg.Id, userMsg.GetString("content"), "", "[POST /api/ai/conversations/:id/messages/:msgId/regenerate]")
 		})
-		writer.Write([]byte("data: " + string(doneData) + "\n\n"))
-		writer.Flush()
 
+		gen.Subscribe(c.Request().Context(), "", startSSE(c))
 		return nil
 	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Edit a user message and answer it as a new branch
+	e.Router.POST("/api/ai/conversations/:id/messages/:msgId/edit", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		var body struct {
+			Content string `json:"content"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return apis.NewBadRequestError("Invalid request body", err)
+		}
+		if body.Content == "" {
+			return apis.NewBadRequestError("content is required", nil)
+		}
+
+		convID := c.PathParam("id")
+		conv, err := app.Dao().FindRecordById("ai_conversations", convID)
+		if err != nil {
+			return apis.NewNotFoundError("Conversation not found", err)
+		}
+		if conv.GetString("owner") != authRecord.Id {
+			return apis.NewForbiddenError("Access denied", nil)
+		}
+
+		gen, err := chatService.BeginGeneration(authRecord.Id, convID)
+		if errors.Is(err, chat.ErrGenerationRunning) {
+			return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+		}
+		if err != nil {
+			return apis.NewBadRequestError("Failed to start chat", err)
+		}
+
+		userMsg, err := chatService.EditMessage(authRecord.Id, convID, c.PathParam("msgId"), body.Content)
+		if err != nil {
+			gen.Discard()
+			return apis.NewBadRequestError("Failed to edit message: "+err.Error(), nil)
+		}
+
+		gen.Run(func(ctx context.Context, writer chat.StreamWriter) {
+			streamAnswer(ctx, writer, chatService, authRecord.Id, convID, userMsg.Id, body.Content, "", "[POST /api/ai/conversations/:id/messages/:msgId/edit]")
+		})
+
+		gen.Subscribe(c.Request().Context(), "", startSSE(c))
+		return nil
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Switch the active branch of a conversation
+	e.Router.PUT("/api/ai/conversations/:id/branch", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		var body struct {
+			MessageID string `json:"message_id"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return apis.NewBadRequestError("Invalid request body", err)
+		}
+		if body.MessageID == "" {
+			return apis.NewBadRequestError("message_id is required", nil)
+		}
+
+		convID := c.PathParam("id")
+		conv, err := app.Dao().FindRecordById("ai_conversations", convID)
+		if err != nil {
+			return apis.NewNotFoundError("Conversation not found", err)
+		}
+		if conv.GetString("owner") != authRecord.Id {
+			return apis.NewForbiddenError("Access denied", nil)
+		}
+
+		if chatService.IsGenerating(convID) {
+			return apis.NewApiError(http.StatusConflict, chat.ErrGenerationRunning.Error(), nil)
+		}
+
+		if err := chatService.SwitchBranch(convID, body.MessageID); err != nil {
+			return apis.NewBadRequestError("Failed to switch branch: "+err.Error(), nil)
+		}
+
+		return c.JSON(http.StatusOK, map[string]any{"success": true})
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Reattach to the response being generated, resuming after Last-Event-ID
+	e.Router.GET("/api/ai/conversations/:id/stream", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		convID := c.PathParam("id")
+		conv, err := app.Dao().FindRecordById("ai_conversations", convID)
+		if err != nil {
+			return apis.NewNotFoundError("Conversation not found", err)
+		}
+		if conv.GetString("owner") != authRecord.Id {
+			return apis.NewForbiddenError("Access denied", nil)
+		}
+
+		gen, err := chatService.GetGeneration(convID)
+		if err != nil {
+			return apis.NewNotFoundError(err.Error(), nil)
+		}
+
+		lastEventID := c.Request().Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = c.QueryParam("last_event_id")
+		}
+
+		gen.Subscribe(c.Request().Context(), lastEventID, startSSE(c))
+		return nil
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Stop the response being generated, keeping the partial answer
+	e.Router.POST("/api/ai/conversations/:id/stop", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		convID := c.PathParam("id")
+		conv, err := app.Dao().FindRecordById("ai_conversations", convID)
+		if err != nil {
+			return apis.NewNotFoundError("Conversation not found", err)
+		}
+		if conv.GetString("owner") != authRecord.Id {
+			return apis.NewForbiddenError("Access denied", nil)
+		}
+
+		gen, err := chatService.GetGeneration(convID)
+		if err != nil {
+			return apis.NewNotFoundError(err.Error(), nil)
+		}
+		if err := gen.Stop(30 * time.Second); err != nil {
+			logger.Error("[POST /api/ai/conversations/:id/stop] %v", err)
+			return apis.NewBadRequestError("Failed to stop generation", err)
+		}
+
+		return c.JSON(http.StatusOK, map[string]any{"success": true})
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+}
+
+// startSSE sets the event stream headers and returns a writer for the response
+func startSSE(c echo.Context) *sseWriter {
+	c.Response().Header().Set("Content-Type", "text/event-stream")
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().Header().Set("Connection", "keep-alive")
+	// Keep reverse proxies like nginx from buffering the stream
+	c.Response().Header().Set("X-Accel-Buffering", "no")
+	c.Response().Header().Set(chat.ProtocolHeader, chat.ProtocolVersion)
+	c.Response().WriteHeader(http.StatusOK)
+	return &sseWriter{w: c.Response()}
+}
+
+// streamAnswer streams the assistant's answer to the user message ending the active branch,
+// saves it and sends the usage and done events
+func streamAnswer(ctx context.Context, writer chat.StreamWriter, chatService *chat.ChatService, userID, convID, userMsgID, content, newTitle, tag string) {
+	result, err := chatService.StreamChat(ctx, userID, convID, content, writer)
+	if err != nil {
+		logger.Error("%s stream chat error: %v", tag, err)
+		chat.WriteEvent(writer, chat.EventError, chat.ErrorEvent{Message: err.Error()})
+		return
+	}
+
+	// Save assistant message, a stopped answer is kept unless nothing was written yet
+	var assistantMsg *models.Record
+	if !result.Stopped || result.Content != "" {
+		assistantMsg, err = chatService.SaveMessage(userID, convID, "assistant", result.Content, result.ReferencedDiaries)
+		if err != nil {
+			logger.Error("%s failed to save assistant message: %v", tag, err)
+		} else {
+			logger.Info("%s saved assistant message: %s", tag, assistantMsg.Id)
+		}
+	}
+
+	// Condense older messages of long conversations in the background
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if err := chatService.MaybeSummarize(ctx, userID, convID); err != nil {
+			logger.Error("%s failed to summarize conversation %s: %v", tag, convID, err)
+		}
+	}()
+
+	chat.WriteEvent(writer, chat.EventUsage, result.Usage)
+
+	done := chat.DoneEvent{
+		UserMessageID:     userMsgID,
+		ReferencedDiaries: result.ReferencedDiaries,
+		Citations:         result.Citations,
+		Title:             newTitle,
+		Stopped:           result.Stopped,
+	}
+	if assistantMsg != nil {
+		done.MessageID = assistantMsg.Id
+	}
+	chat.WriteEvent(writer, chat.EventDone, done)
 }
 
 // fetchModels fetches available models from an OpenAI-compatible API
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+
+	"github.com/songtianlun/diarum/internal/analytics"
+	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// RegisterAnalyticsRoutes registers the writing analytics endpoint
+func RegisterAnalyticsRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, service *analytics.Service) {
+	configService := config.NewConfigService(app)
+
+	// Get writing analytics, start and end (YYYY-MM-DD) limit the series to at most
+	// analytics.MaxRangeDays and default to the last 365 days
+	e.Router.GET("/api/diaries/analytics", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		userId := authRecord.Id
+
+		loc := requestLocation(c, configService, userId)
+
+		start := c.QueryParam("start")
+		end := c.QueryParam("end")
+		for _, date := range []string{start, end} {
+			if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+				return apis.NewBadRequestError("start and end must be in YYYY-MM-DD format", nil)
+			}
+		}
+		if start != "" && end != "" && start > end {
+			return apis.NewBadRequestError("start must not be after end", nil)
+		}
+
+		report, err := service.Report(userId, loc, start, end)
+		if errors.Is(err, analytics.ErrRangeTooLong) {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		if err != nil {
+			logger.Error("[GET /api/diaries/analytics] failed for user %s: %v", userId, err)
+			return apis.NewBadRequestError("Failed to compute analytics", err)
+		}
+
+		return c.JSON(http.StatusOK, report)
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+
+	"github.com/songtianlun/diarum/internal/backup"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// NewBackupService creates the backup service wired to the export/import logic
+func NewBackupService(app *pocketbase.PocketBase, cfg backup.Config) *backup.Service {
+	exporter := func(userID string) ([]byte, string, error) {
+		result, err := ExportArchive(app, userID, ExportRequest{
+			DateRange:            "all",
+			IncludeDiaries:       true,
+			IncludeMedia:         true,
+			IncludeConversations: true,
+			IncludeSettings:      true,
+			Passphrase:           cfg.Passphrase,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		return result.Data, result.Filename, nil
+	}
+	importer := func(userID string, data []byte) error {
+		_, err := ImportArchive(app, userID, data, ImportOptions{Passphrase: cfg.Passphrase})
+		return err
+	}
+	return backup.NewService(app, cfg, exporter, importer)
+}
+
+// RegisterBackupRoutes registers the admin backup endpoints
+func RegisterBackupRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, service *backup.Service) {
+	// List backups
+	e.Router.GET("/api/admin/backups", func(c echo.Context) error {
+		entries, err := service.List()
+		if err != nil {
+			return apis.NewBadRequestError("Failed to list backups", err)
+		}
+		cfg := service.Config()
+		return c.JSON(http.StatusOK, map[string]any{
+			"items":           entries,
+			"cron":            cfg.Cron,
+			"mode":            cfg.Mode,
+			"target":          backupTargetName(cfg),
+			"pending_restore": backup.HasPendingRestore(app.DataDir()),
+		})
+	}, apis.ActivityLogger(app), apis.RequireAdminAuth())
+
+	// Run a backup now
+	e.Router.POST("/api/admin/backups", func(c echo.Context) error {
+		var req struct {
+			Mode string `json:"mode"`
+		}
+		c.Bind(&req)
+		if req.Mode == "" {
+			req.Mode = service.Config().Mode
+		}
+		if req.Mode != backup.ModeSnapshot && req.Mode != backup.ModeUsers && req.Mode != backup.ModeBoth {
+			return apis.NewBadRequestError("Invalid mode, expected snapshot, users or both", nil)
+		}
+
+		result, err := service.Run(req.Mode)
+		if errors.Is(err, backup.ErrBackupRunning) {
+			return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+		}
+		if err != nil {
+			logger.Error("[Backup] manual backup failed: %v", err)
+			return apis.NewBadRequestError("Backup failed", err)
+		}
+		return c.JSON(http.StatusOK, result)
+	}, apis.ActivityLogger(app), apis.RequireAdminAuth())
+
+	// Restore a backup
+	e.Router.POST("/api/admin/backups/restore", func(c echo.Context) error {
+		var req struct {
+			Key string `json:"key"`
+		}
+		if err := c.Bind(&req); err != nil || req.Key == "" {
+			return apis.NewBadRequestError("Missing backup key", err)
+		}
+
+		pendingRestart, err := service.Restore(req.Key)
+		if errors.Is(err, backup.ErrBackupRunning) {
+			return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+		}
+		var verifyErr *VerificationError
+		if errors.As(err, &verifyErr) {
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"code":         http.StatusBadRequest,
+				"message":      "Backup archive failed integrity verification",
+				"verification": verifyErr.Result,
+			})
+		}
+		if err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+
+		message := "Backup restored"
+		if pendingRestart {
+			message = "Snapshot staged, restart the server to apply it"
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"key":             req.Key,
+			"pending_restart": pendingRestart,
+			"message":         message,
+		})
+	}, apis.ActivityLogger(app), apis.RequireAdminAuth())
+}
+
+// backupTargetName describes the backup target without exposing credentials
+func backupTargetName(cfg backup.Config) string {
+	if cfg.UsesS3() {
+		return "s3://" + cfg.S3.Bucket
+	}
+	return cfg.Dir
+}
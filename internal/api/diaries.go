@@ -9,10 +9,16 @@ import (
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/models"
+
+	"github.com/songtianlun/diarum/internal/analytics"
+	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/goals"
 )
 
 // RegisterDiaryRoutes registers custom API endpoints for diary operations
-func RegisterDiaryRoutes(app *pocketbase.PocketBase, e *core.ServeEvent) {
+func RegisterDiaryRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, analyticsService *analytics.Service, goalsService *goals.Service) {
+	configService := config.NewConfigService(app)
+
 	// Get diary by date
 	e.Router.GET("/api/diaries/by-date/:date", func(c echo.Context) error {
 		dateStr := c.PathParam("date")
@@ -121,7 +127,7 @@ func RegisterDiaryRoutes(app *pocketbase.PocketBase, e *core.ServeEvent) {
 		})
 	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
 
-	// Get diary stats (streak and total)
+	// Get diary stats (streaks, total and goal progress)
 	e.Router.GET("/api/diaries/stats", func(c echo.Context) error {
 		// Get authenticated user
 		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
@@ -131,14 +137,7 @@ func RegisterDiaryRoutes(app *pocketbase.PocketBase, e *core.ServeEvent) {
 
 		userId := authRecord.Id
 
-		// Get timezone from query param, default to UTC
-		tz := c.QueryParam("tz")
-		loc := time.UTC
-		if tz != "" {
-			if parsedLoc, err := time.LoadLocation(tz); err == nil {
-				loc = parsedLoc
-			}
-		}
+		loc := requestLocation(c, configService, userId)
 
 		// Get total count using COUNT query for better performance
 		var total int
@@ -150,59 +149,30 @@ func RegisterDiaryRoutes(app *pocketbase.PocketBase, e *core.ServeEvent) {
 			total = 0
 		}
 
-		// Calculate streak - only fetch recent records (last 365 days max)
-		streak := 0
+		// Calculate streaks over all diary dates, honoring streak freezes and vacations
 		now := time.Now().In(loc)
-		today := now.Format("2006-01-02")
-		oneYearAgo := now.AddDate(-1, 0, 0).Format("2006-01-02")
-
-		records, err := app.Dao().FindRecordsByFilter(
-			"diaries",
-			"owner = {:owner} && date >= {:start}",
-			"-date",
-			365,
-			0,
-			map[string]any{
-				"owner": userId,
-				"start": oneYearAgo + " 00:00:00.000Z",
-			},
-		)
-
-		if err == nil && len(records) > 0 {
-			// Create a set of dates for quick lookup
-			dateSet := make(map[string]bool)
-			for _, record := range records {
-				dateTime := record.GetString("date")
-				if len(dateTime) >= 10 {
-					dateSet[dateTime[:10]] = true
-				}
-			}
-
-			// Start counting from today or yesterday
-			yesterday := now.AddDate(0, 0, -1).Format("2006-01-02")
-			var checkDate time.Time
-			if dateSet[today] {
-				checkDate = now
-			} else if dateSet[yesterday] {
-				checkDate = now.AddDate(0, 0, -1)
-			}
+		rules, err := analyticsService.StreakRules(userId)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to get streak rules", err)
+		}
+		var current, longest analytics.Streak
+		if dates, err := analytics.Dates(app.Dao(), userId); err == nil {
+			current, longest = analytics.Streaks(dates, now, rules)
+		}
 
-			if !checkDate.IsZero() {
-				for {
-					dateStr := checkDate.Format("2006-01-02")
-					if dateSet[dateStr] {
-						streak++
-						checkDate = checkDate.AddDate(0, 0, -1)
-					} else {
-						break
-					}
-				}
-			}
+		// Progress of the goals in their current period
+		progress, err := goalsService.Progress(userId, now, 1)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to evaluate goals", err)
 		}
 
 		return c.JSON(http.StatusOK, map[string]any{
-			"total":  total,
-			"streak": streak,
+			"total":          total,
+			"streak":         current.Days,
+			"longest_streak": longest.Days,
+			"freezes_used":   current.FreezesUsed,
+			"freezes":        rules.Freezes,
+			"goals":          progress,
 		})
 	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
 
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+
+	digests "github.com/songtianlun/diarum/internal/digest"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// RegisterDigestRoutes registers the reflection digest endpoints
+func RegisterDigestRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, service *digests.Service) {
+	// List digests, newest period first
+	e.Router.GET("/api/ai/digests", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		period := c.QueryParam("period")
+		if period != "" && !digests.IsValidPeriod(period) {
+			return apis.NewBadRequestError("period must be week or month", nil)
+		}
+		page, _ := strconv.Atoi(c.QueryParam("page"))
+		if page < 1 {
+			page = 1
+		}
+		perPage, _ := strconv.Atoi(c.QueryParam("per_page"))
+		if perPage < 1 || perPage > 100 {
+			perPage = 20
+		}
+
+		records, total, err := service.List(authRecord.Id, period, page, perPage)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to fetch digests", err)
+		}
+
+		items := make([]map[string]any, 0, len(records))
+		for _, record := range records {
+			items = append(items, formatDigest(record))
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"items":    items,
+			"page":     page,
+			"per_page": perPage,
+			"total":    total,
+		})
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Get a digest
+	e.Router.GET("/api/ai/digests/:id", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		record, err := app.Dao().FindRecordById("digests", c.PathParam("id"))
+		if err != nil || record.GetString("owner") != authRecord.Id {
+			return apis.NewNotFoundError("Digest not found", nil)
+		}
+		return c.JSON(http.StatusOK, formatDigest(record))
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Digest a finished period now, defaults to the last finished one.
+	// Periods that are already digested return their existing digest.
+	e.Router.POST("/api/ai/digests/generate", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+		userId := authRecord.Id
+
+		var body struct {
+			Period string `json:"period"`
+			Date   string `json:"date"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return apis.NewBadRequestError("Invalid request body", err)
+		}
+		if !digests.IsValidPeriod(body.Period) {
+			return apis.NewBadRequestError("period must be week or month", nil)
+		}
+
+		date := service.LastFinished(userId, body.Period)
+		if body.Date != "" {
+			parsed, err := time.Parse("2006-01-02", body.Date)
+			if err != nil {
+				return apis.NewBadRequestError("date must be in YYYY-MM-DD format", nil)
+			}
+			date = parsed
+		}
+
+		record, err := service.Generate(c.Request().Context(), userId, body.Period, date)
+		switch {
+		case errors.Is(err, digests.ErrDigestRunning):
+			return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+		case errors.Is(err, digests.ErrPeriodNotFinished), errors.Is(err, digests.ErrNoDiaries):
+			return apis.NewBadRequestError(err.Error(), nil)
+		case err != nil:
+			logger.Error("[POST /api/ai/digests/generate] failed for user %s: %v", userId, err)
+			return apis.NewBadRequestError("Failed to generate digest", err)
+		}
+		return c.JSON(http.StatusOK, formatDigest(record))
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+}
+
+// formatDigest converts a digest record to its API representation
+func formatDigest(record *models.Record) map[string]any {
+	themes := []string{}
+	record.UnmarshalJSONField("themes", &themes)
+	moods := map[string]int{}
+	record.UnmarshalJSONField("moods", &moods)
+
+	emailed := ""
+	if !record.GetDateTime("emailed").IsZero() {
+		emailed = record.GetDateTime("emailed").String()
+	}
+
+	return map[string]any{
+		"id":           record.Id,
+		"period":       record.GetString("period"),
+		"period_start": record.GetString("period_start"),
+		"period_end":   record.GetString("period_end"),
+		"summary":      record.GetString("summary"),
+		"themes":       themes,
+		"moods":        moods,
+		"mood_trend":   record.GetString("mood_trend"),
+		"diaries":      record.GetStringSlice("diaries"),
+		"emailed":      emailed,
+		"created":      record.Created.String(),
+	}
+}
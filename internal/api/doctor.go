@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+
+	"github.com/songtianlun/diarum/internal/doctor"
+	"github.com/songtianlun/diarum/internal/embedding"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// RegisterDoctorRoutes registers the admin consistency check endpoints
+func RegisterDoctorRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, vectorDB *embedding.VectorDB) {
+	d := doctor.NewDoctor(app, vectorDB)
+
+	// Report issues without changing anything
+	e.Router.GET("/api/admin/doctor", func(c echo.Context) error {
+		report, err := d.Run(c.Request().Context(), false)
+		if err != nil {
+			logger.Error("[GET /api/admin/doctor] error: %v", err)
+			return apis.NewBadRequestError("Failed to run consistency check", err)
+		}
+		return c.JSON(http.StatusOK, report)
+	}, apis.ActivityLogger(app), apis.RequireAdminAuth())
+
+	// Repair fixable issues
+	e.Router.POST("/api/admin/doctor/fix", func(c echo.Context) error {
+		report, err := d.Run(c.Request().Context(), true)
+		if err != nil {
+			logger.Error("[POST /api/admin/doctor/fix] error: %v", err)
+			return apis.NewBadRequestError("Failed to run consistency check", err)
+		}
+		return c.JSON(http.StatusOK, report)
+	}, apis.ActivityLogger(app), apis.RequireAdminAuth())
+}
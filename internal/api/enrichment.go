@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+
+	"github.com/songtianlun/diarum/internal/enrichment"
+	"github.com/songtianlun/diarum/internal/logger"
+	"github.com/songtianlun/diarum/internal/tags"
+)
+
+// RegisterEnrichmentRoutes registers the endpoints to review AI suggestions of diaries
+func RegisterEnrichmentRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, service *enrichment.Service) {
+	// findDiary returns the authenticated user's diary of the request
+	findDiary := func(c echo.Context) (*models.Record, error) {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return nil, apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+		diary, err := app.Dao().FindRecordById("diaries", c.PathParam("id"))
+		if err != nil || diary.GetString("owner") != authRecord.Id {
+			return nil, apis.NewNotFoundError("Diary not found", nil)
+		}
+		return diary, nil
+	}
+
+	respond := func(c echo.Context, diary *models.Record) error {
+		return c.JSON(http.StatusOK, map[string]any{
+			"suggestions": enrichment.Suggestions(diary),
+			"pending":     service.Pending(diary.Id),
+			"mood":        diary.GetString("mood"),
+			"tags":        nonNilNames(tags.Names(app.Dao(), diary.GetStringSlice("tags"))),
+		})
+	}
+
+	// Get the suggestions of a diary
+	e.Router.GET("/api/diaries/:id/enrichment", func(c echo.Context) error {
+		diary, err := findDiary(c)
+		if err != nil {
+			return err
+		}
+		return respond(c, diary)
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Enrich a diary now, even if its content did not change
+	e.Router.POST("/api/diaries/:id/enrichment", func(c echo.Context) error {
+		diary, err := findDiary(c)
+		if err != nil {
+			return err
+		}
+		owner := diary.GetString("owner")
+		if !service.Enabled(owner) {
+			return apis.NewBadRequestError("Enable AI features and diary enrichment in the AI settings first", nil)
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request().Context(), 2*time.Minute)
+		defer cancel()
+
+		diary, err = service.Enrich(ctx, diary.Id, true)
+		if errors.Is(err, enrichment.ErrEnrichmentRunning) {
+			return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+		}
+		if err != nil {
+			logger.Error("[POST /api/diaries/:id/enrichment] failed for user %s: %v", owner, err)
+			return apis.NewBadRequestError("Failed to enrich diary", err)
+		}
+		return respond(c, diary)
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Accept suggestions into the diary's mood and tags
+	e.Router.POST("/api/diaries/:id/enrichment/accept", func(c echo.Context) error {
+		diary, err := findDiary(c)
+		if err != nil {
+			return err
+		}
+		var sel enrichment.Selection
+		if err := c.Bind(&sel); err != nil {
+			return apis.NewBadRequestError("Invalid request body", err)
+		}
+
+		diary, err = service.Accept(diary, sel)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to accept suggestions", err)
+		}
+		return respond(c, diary)
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Dismiss suggestions
+	e.Router.POST("/api/diaries/:id/enrichment/dismiss", func(c echo.Context) error {
+		diary, err := findDiary(c)
+		if err != nil {
+			return err
+		}
+		var sel enrichment.Selection
+		if err := c.Bind(&sel); err != nil {
+			return apis.NewBadRequestError("Invalid request body", err)
+		}
+
+		diary, err = service.Dismiss(diary, sel)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to dismiss suggestions", err)
+		}
+		return respond(c, diary)
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+}
+
+// nonNilNames returns names, or an empty list so that it encodes as []
+func nonNilNames(names []string) []string {
+	if names == nil {
+		return []string{}
+	}
+	return names
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+
+	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/logger"
+	"github.com/songtianlun/diarum/internal/publish"
+)
+
+// Export formats
+const (
+	exportFormatZip  = "zip"
+	exportFormatHTML = "html"
+	exportFormatEPUB = "epub"
+)
+
+// handleBookExport renders the diaries in the requested date range as a static HTML site or an EPUB book.
+// Only images referenced from the exported diaries are bundled.
+func handleBookExport(c echo.Context, app *pocketbase.PocketBase, authRecord *models.Record, req ExportRequest, startDate, endDate time.Time) error {
+	userID := authRecord.Id
+
+	stats := exportStats{
+		DateRangeType: req.DateRange,
+		StartDate:     startDate.Format("2006-01-02"),
+		EndDate:       endDate.Format("2006-01-02"),
+		FailedItems:   make([]exportFailedItem, 0),
+	}
+
+	allDiaries, err := app.Dao().FindRecordsByFilter(
+		"diaries", "owner = {:owner}", "date", -1, 0,
+		map[string]any{"owner": userID},
+	)
+	if err != nil {
+		return apis.NewBadRequestError("Failed to fetch diaries", err)
+	}
+	stats.Diaries.TotalInSystem = len(allDiaries)
+
+	book := publish.Book{
+		ID:     fmt.Sprintf("urn:diarum:%s:%s:%s", userID, stats.StartDate, stats.EndDate),
+		Title:  fmt.Sprintf("Diarum Journal %s – %s", stats.StartDate, stats.EndDate),
+		Author: authRecord.GetString("name"),
+	}
+	if book.Author == "" {
+		book.Author = authRecord.Username()
+	}
+
+	// Collect diaries in range and the media they reference
+	var mediaIDs []string
+	seenMedia := make(map[string]bool)
+	for _, d := range allDiaries {
+		date := extractExportDate(d.GetString("date"))
+		if !isDateInRange(date, startDate, endDate) {
+			continue
+		}
+		content := d.GetString("content")
+		book.Entries = append(book.Entries, publish.Entry{
+			ID:      d.Id,
+			Date:    date,
+			Content: content,
+			Mood:    d.GetString("mood"),
+			Weather: d.GetString("weather"),
+		})
+		for _, id := range referencedMediaIDs(content) {
+			if !seenMedia[id] {
+				seenMedia[id] = true
+				mediaIDs = append(mediaIDs, id)
+			}
+		}
+	}
+	stats.Diaries.ShouldExport = len(book.Entries)
+	stats.Diaries.ActualExported = len(book.Entries)
+	stats.Media.ShouldExport = len(mediaIDs)
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		logger.Error("[Export] failed to init filesystem: %v", err)
+		return apis.NewBadRequestError("Failed to initialize filesystem", err)
+	}
+	defer fsys.Close()
+
+	mediaFiles := make(map[string]string) // media record ID -> file name
+	for _, id := range mediaIDs {
+		record, err := app.Dao().FindRecordById("media", id)
+		if err != nil || record.GetString("owner") != userID {
+			stats.FailedItems = append(stats.FailedItems, exportFailedItem{
+				Type:   "media",
+				ID:     id,
+				Reason: "record not found",
+			})
+			continue
+		}
+
+		content, err := readMediaFile(fsys, record)
+		if err != nil {
+			logger.Warn("[Export] failed to read media file for %s: %v", id, err)
+			stats.FailedItems = append(stats.FailedItems, exportFailedItem{
+				Type:   "media",
+				ID:     id,
+				Reason: err.Error(),
+			})
+			continue
+		}
+
+		mimeType, allowed := config.IsAllowedMediaType(content)
+		if !allowed {
+			stats.FailedItems = append(stats.FailedItems, exportFailedItem{
+				Type:   "media",
+				ID:     id,
+				Reason: "disallowed MIME type: " + mimeType,
+			})
+			continue
+		}
+
+		file := record.GetString("file")
+		book.Images = append(book.Images, publish.Image{File: file, Data: content, MediaType: mimeType})
+		mediaFiles[id] = file
+	}
+	stats.Media.ActualExported = len(book.Images)
+
+	book.ImageFile = func(src string) string {
+		match := mediaURLPattern.FindStringSubmatch(src)
+		if match == nil {
+			return ""
+		}
+		return mediaFiles[match[1]]
+	}
+
+	var buf bytes.Buffer
+	contentType := "application/zip"
+	filename := "diarum_site.zip"
+	if req.Format == exportFormatEPUB {
+		contentType = "application/epub+zip"
+		filename = "diarum_journal.epub"
+		err = publish.WriteEPUB(&buf, book)
+	} else {
+		err = publish.WriteSite(&buf, book)
+	}
+	if err != nil {
+		logger.Error("[Export] failed to render %s export: %v", req.Format, err)
+		return apis.NewBadRequestError("Failed to render export", err)
+	}
+
+	statsJSON, _ := json.Marshal(stats)
+
+	c.Response().Header().Set("Content-Type", contentType)
+	c.Response().Header().Set("Content-Disposition", "attachment; filename="+filename)
+	c.Response().Header().Set("X-Export-Stats", string(statsJSON))
+	c.Response().Header().Set("Access-Control-Expose-Headers", "X-Export-Stats")
+	c.Response().WriteHeader(http.StatusOK)
+	c.Response().Write(buf.Bytes())
+
+	logger.Info("[Export] %s export completed for user %s: %d diaries, %d media",
+		req.Format, userID, stats.Diaries.ActualExported, stats.Media.ActualExported)
+
+	return nil
+}
+
+// referencedMediaIDs returns the IDs of media records referenced by PocketBase file URLs in content
+func referencedMediaIDs(content string) []string {
+	matches := mediaURLPattern.FindAllStringSubmatch(content, -1)
+	ids := make([]string, 0, len(matches))
+	for _, m := range matches {
+		ids = append(ids, m[1])
+	}
+	return ids
+}
+
+// readMediaFile reads the stored file of a media record
+func readMediaFile(fsys *filesystem.System, record *models.Record) ([]byte, error) {
+	file := record.GetString("file")
+	if file == "" {
+		return nil, fmt.Errorf("media record has no file")
+	}
+
+	fileKey := record.BaseFilesPath() + "/" + file
+	reader, err := fsys.GetFile(fileKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read content: %w", err)
+	}
+	return content, nil
+}
@@ -1,13 +1,12 @@
 package api
 
 import (
-	"archive/zip"
 	"bytes"
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
@@ -18,13 +17,21 @@ import (
 	"github.com/pocketbase/pocketbase/models"
 
 	"github.com/songtianlun/diarum/internal/config"
-	"github.com/songtianlun/diarum/internal/embedding"
+	"github.com/songtianlun/diarum/internal/encrypt"
+	"github.com/songtianlun/diarum/internal/enrichment"
 	"github.com/songtianlun/diarum/internal/logger"
+	"github.com/songtianlun/diarum/internal/markdown"
+	"github.com/songtianlun/diarum/internal/tags"
 )
 
 const maxImportSize = 200 << 20     // 200MB total upload
 const maxSingleFileSize = 100 << 20 // 100MB per file (ZIP bomb protection)
 
+// exportVersion is the current export format version
+// v1: diaries, media, conversations
+// v2: adds optional settings and encrypted secrets
+const exportVersion = 2
+
 // ---------- Export Request ----------
 
 // ExportRequest defines the export options
@@ -39,6 +46,15 @@ type ExportRequest struct {
 	IncludeDiaries       bool `json:"include_diaries"`
 	IncludeMedia         bool `json:"include_media"`
 	IncludeConversations bool `json:"include_conversations"`
+	IncludeSettings      bool `json:"include_settings"`
+	// SecretsPassphrase: when set, secret settings (AI API key, API token) are
+	// included encrypted with this passphrase; otherwise they are left out
+	SecretsPassphrase string `json:"secrets_passphrase,omitempty"`
+	// Passphrase: when set, the whole archive is encrypted (AES-256-GCM, scrypt-derived key)
+	// and downloaded as .diarum.enc; it also protects secrets unless SecretsPassphrase is set
+	Passphrase string `json:"passphrase,omitempty"`
+	// Format: "zip" (default, re-importable archive), "html" (static site) or "epub" (book)
+	Format string `json:"format,omitempty"`
 }
 
 // ---------- Export/Import 数据结构 ----------
@@ -49,6 +65,10 @@ type exportData struct {
 	Diaries      []exportDiary      `json:"diaries"`
 	Media        []exportMedia      `json:"media"`
 	Conversations []exportConversation `json:"conversations"`
+	// Settings holds non-secret user settings (v2+)
+	Settings map[string]any `json:"settings,omitempty"`
+	// EncryptedSecrets holds secret settings sealed with the export passphrase, base64 encoded (v2+)
+	EncryptedSecrets string `json:"encrypted_secrets,omitempty"`
 }
 
 type exportDiary struct {
@@ -57,6 +77,8 @@ type exportDiary struct {
 	Content string `json:"content"`
 	Mood    string `json:"mood,omitempty"`
 	Weather string `json:"weather,omitempty"`
+	// Tags are the names of the diary's tags, also written to the Markdown front-matter
+	Tags []string `json:"tags,omitempty"`
 }
 
 type exportMedia struct {
@@ -71,6 +93,8 @@ type exportConversation struct {
 	ID       string          `json:"id"`
 	Title    string          `json:"title"`
 	Messages []exportMessage `json:"messages"`
+	// ActiveMessage is the last message of the active branch
+	ActiveMessage string `json:"active_message,omitempty"`
 }
 
 type exportMessage struct {
@@ -78,6 +102,11 @@ type exportMessage struct {
 	Role               string   `json:"role"`
 	Content            string   `json:"content"`
 	ReferencedDiaries  []string `json:"referenced_diaries,omitempty"`
+	// ToolCalls and ToolCallID are only set on agent tool messages
+	ToolCalls  json.RawMessage `json:"tool_calls,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+	// Parent is the message this one follows, absent in archives without branches
+	Parent string `json:"parent,omitempty"`
 }
 
 type exportStats struct {
@@ -90,6 +119,9 @@ type exportStats struct {
 	Media         exportCountDetail `json:"media"`
 	Conversations exportCountDetail `json:"conversations"`
 	Messages      int               `json:"messages"`
+	Settings      int               `json:"settings"`
+	// SecretsIncluded reports whether encrypted secrets were added to the export
+	SecretsIncluded bool `json:"secrets_included"`
 	// Failed items with reasons
 	FailedItems []exportFailedItem `json:"failed_items,omitempty"`
 }
@@ -110,6 +142,10 @@ type importStats struct {
 	Diaries       importCounters `json:"diaries"`
 	Media         importCounters `json:"media"`
 	Conversations importCounters `json:"conversations"`
+	Settings      importCounters `json:"settings"`
+	// Verification is the manifest check result; SkippedFiles lists ZIP entries that were not read
+	Verification *archiveVerification `json:"verification,omitempty"`
+	SkippedFiles []skippedFile        `json:"skipped_files,omitempty"`
 }
 
 type importCounters struct {
@@ -121,13 +157,17 @@ type importCounters struct {
 
 // ---------- Route Registration ----------
 
-func RegisterExportImportRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingService *embedding.EmbeddingService) {
+func RegisterExportImportRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, enrichmentService *enrichment.Service) {
 	e.Router.POST("/api/export", func(c echo.Context) error {
 		return handleExport(c, app)
 	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
 
 	e.Router.POST("/api/import", func(c echo.Context) error {
-		return handleImport(c, app, embeddingService)
+		return handleImport(c, app, enrichmentService)
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	e.Router.POST("/api/import/verify", func(c echo.Context) error {
+		return handleImportVerify(c)
 	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
 }
 
@@ -149,6 +189,7 @@ func handleExport(c echo.Context, app *pocketbase.PocketBase) error {
 			IncludeDiaries:       true,
 			IncludeMedia:         true,
 			IncludeConversations: true,
+			IncludeSettings:      true,
 		}
 	}
 
@@ -157,10 +198,60 @@ func handleExport(c echo.Context, app *pocketbase.PocketBase) error {
 		req.DateRange = "3m"
 	}
 
+	switch req.Format {
+	case "", exportFormatZip:
+	case exportFormatHTML, exportFormatEPUB:
+		if req.Passphrase != "" {
+			return apis.NewBadRequestError("Passphrase encryption is only supported for the zip format", nil)
+		}
+		startDate, endDate, err := calculateDateRange(req)
+		if err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		return handleBookExport(c, app, authRecord, req, startDate, endDate)
+	default:
+		return apis.NewBadRequestError("Unsupported export format: "+req.Format, nil)
+	}
+
+	result, err := ExportArchive(app, userID, req)
+	if err != nil {
+		return apis.NewBadRequestError(err.Error(), nil)
+	}
+
+	// 序列化 stats 放入 header
+	statsJSON, _ := json.Marshal(result.Stats)
+
+	// 返回 ZIP 响应
+	c.Response().Header().Set("Content-Type", result.ContentType)
+	c.Response().Header().Set("Content-Disposition", "attachment; filename="+result.Filename)
+	c.Response().Header().Set("X-Export-Stats", string(statsJSON))
+	c.Response().Header().Set("Access-Control-Expose-Headers", "X-Export-Stats")
+	c.Response().WriteHeader(http.StatusOK)
+	c.Response().Write(result.Data)
+
+	return nil
+}
+
+// ExportResult is a generated export archive
+type ExportResult struct {
+	Data        []byte
+	Filename    string
+	ContentType string
+	Stats       exportStats
+}
+
+// ExportArchive builds the re-importable ZIP export for a user, encrypting it
+// when req.Passphrase is set. It is shared by the HTTP handler, scheduled
+// backups and the CLI.
+func ExportArchive(app *pocketbase.PocketBase, userID string, req ExportRequest) (*ExportResult, error) {
+	if req.DateRange == "" {
+		req.DateRange = "3m"
+	}
+
 	// Calculate date range
 	startDate, endDate, err := calculateDateRange(req)
 	if err != nil {
-		return apis.NewBadRequestError(err.Error(), nil)
+		return nil, err
 	}
 
 	stats := exportStats{
@@ -227,7 +318,11 @@ func handleExport(c echo.Context, app *pocketbase.PocketBase) error {
 	}
 	stats.Conversations.ShouldExport = len(conversations)
 
-	// Build diary list
+	// Build diary list, looking up the user's tags once for all diaries
+	tagIndex, err := tags.Load(app.Dao(), userID)
+	if err != nil {
+		return nil, err
+	}
 	exportDiaries := make([]exportDiary, 0, len(diaries))
 	for _, d := range diaries {
 		exportDiaries = append(exportDiaries, exportDiary{
@@ -236,6 +331,7 @@ func handleExport(c echo.Context, app *pocketbase.PocketBase) error {
 			Content: d.GetString("content"),
 			Mood:    d.GetString("mood"),
 			Weather: d.GetString("weather"),
+			Tags:    tagIndex.Names(d.GetStringSlice("tags")),
 		})
 	}
 	stats.Diaries.ActualExported = len(exportDiaries)
@@ -283,66 +379,81 @@ func handleExport(c echo.Context, app *pocketbase.PocketBase) error {
 					}
 				}
 			}
-			msgs = append(msgs, exportMessage{
+			m := exportMessage{
 				ID:                msg.Id,
 				Role:              msg.GetString("role"),
 				Content:           msg.GetString("content"),
 				ReferencedDiaries: refDiaries,
-			})
+				ToolCallID:        msg.GetString("tool_call_id"),
+				Parent:            msg.GetString("parent"),
+			}
+			if raw := msg.GetString("tool_calls"); raw != "" && raw != "null" {
+				m.ToolCalls = json.RawMessage(raw)
+			}
+			msgs = append(msgs, m)
 		}
 		stats.Messages += len(msgs)
 		exportConvs = append(exportConvs, exportConversation{
-			ID:       conv.Id,
-			Title:    conv.GetString("title"),
-			Messages: msgs,
+			ID:            conv.Id,
+			Title:         conv.GetString("title"),
+			Messages:      msgs,
+			ActiveMessage: conv.GetString("active_message"),
 		})
 	}
 	stats.Conversations.ActualExported = len(exportConvs)
 
+	// 导出用户设置（密钥仅在提供口令时加密导出）
+	var settings map[string]any
+	var encryptedSecrets string
+	if req.IncludeSettings {
+		secretsPassphrase := req.SecretsPassphrase
+		if secretsPassphrase == "" {
+			secretsPassphrase = req.Passphrase
+		}
+		settings, encryptedSecrets, err = buildExportSettings(app, userID, secretsPassphrase)
+		if err != nil {
+			logger.Error("[Export] failed to export settings: %v", err)
+			return nil, fmt.Errorf("failed to export settings: %w", err)
+		}
+		stats.Settings = len(settings)
+		stats.SecretsIncluded = encryptedSecrets != ""
+	}
+
 	// 序列化 JSON
 	data := exportData{
-		Version:       1,
-		ExportedAt:    time.Now().UTC().Format(time.RFC3339),
-		Diaries:       exportDiaries,
-		Media:         exportMediaList,
-		Conversations: exportConvs,
+		Version:          exportVersion,
+		ExportedAt:       time.Now().UTC().Format(time.RFC3339),
+		Diaries:          exportDiaries,
+		Media:            exportMediaList,
+		Conversations:    exportConvs,
+		Settings:         settings,
+		EncryptedSecrets: encryptedSecrets,
 	}
 	jsonBytes, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
-		return apis.NewBadRequestError("Failed to serialize export data", err)
+		return nil, fmt.Errorf("failed to serialize export data: %w", err)
 	}
 
 	// 初始化 filesystem（本地/S3 透明）
 	fsys, err := app.NewFilesystem()
 	if err != nil {
 		logger.Error("[Export] failed to init filesystem: %v", err)
-		return apis.NewBadRequestError("Failed to initialize filesystem", err)
+		return nil, fmt.Errorf("failed to initialize filesystem: %w", err)
 	}
 	defer fsys.Close()
 
-	// 构建 ZIP
+	// 构建 ZIP（同时记录每个文件的 SHA-256 到 manifest.json）
 	var buf bytes.Buffer
-	zipWriter := zip.NewWriter(&buf)
+	zipWriter := newManifestZipWriter(&buf)
 
 	// 写入 diarum_export.json
-	if w, err := zipWriter.Create("diarum_export.json"); err == nil {
-		w.Write(jsonBytes)
-	}
-
-	// 写入 markdown/ 目录
-	for _, d := range exportDiaries {
-		filename := d.Date + ".md"
-		if d.Mood != "" {
-			filename = d.Date + "_" + d.Mood + ".md"
-		}
-		md := generateMarkdown(d)
-		if w, err := zipWriter.Create("markdown/" + filename); err == nil {
-			w.Write([]byte(md))
-		}
+	if err := zipWriter.Add("diarum_export.json", jsonBytes); err != nil {
+		return nil, fmt.Errorf("failed to create ZIP: %w", err)
 	}
 
 	// 写入 media/ 目录
 	mediaExportedCount := 0
+	exportedMediaFiles := make(map[string]string) // media record ID -> file name
 	for _, m := range exportMediaList {
 		if m.File == "" {
 			continue
@@ -359,161 +470,198 @@ func handleExport(c echo.Context, app *pocketbase.PocketBase) error {
 			continue
 		}
 
-		fileKey := record.BaseFilesPath() + "/" + m.File
-		reader, err := fsys.GetFile(fileKey)
+		content, err := readMediaFile(fsys, record)
 		if err != nil {
-			logger.Warn("[Export] failed to read media file %s: %v", fileKey, err)
+			logger.Warn("[Export] failed to read media file %s: %v", m.File, err)
 			stats.FailedItems = append(stats.FailedItems, exportFailedItem{
 				Type:   "media",
 				ID:     m.ID,
-				Reason: fmt.Sprintf("failed to read file: %v", err),
+				Reason: err.Error(),
 			})
 			continue
 		}
 
-		content, err := io.ReadAll(reader)
-		reader.Close()
-		if err != nil {
-			logger.Warn("[Export] failed to read media content %s: %v", fileKey, err)
-			stats.FailedItems = append(stats.FailedItems, exportFailedItem{
-				Type:   "media",
-				ID:     m.ID,
-				Reason: fmt.Sprintf("failed to read content: %v", err),
-			})
-			continue
-		}
-
-		if w, err := zipWriter.Create("media/" + m.File); err == nil {
-			w.Write(content)
+		if err := zipWriter.Add("media/"+m.File, content); err == nil {
 			mediaExportedCount++
+			exportedMediaFiles[m.ID] = m.File
 		}
 	}
 	stats.Media.ActualExported = mediaExportedCount
 
-	if err := zipWriter.Close(); err != nil {
-		return apis.NewBadRequestError("Failed to create ZIP", err)
+	// 写入 markdown/ 目录（图片链接指向 ZIP 内的 media/ 文件）
+	for _, d := range exportDiaries {
+		md := generateMarkdown(d, exportedMediaFiles)
+		if err := zipWriter.Add("markdown/"+d.Date+".md", []byte(md)); err != nil {
+			logger.Warn("[Export] failed to write markdown for %s: %v", d.Date, err)
+		}
 	}
 
-	// 序列化 stats 放入 header
-	statsJSON, _ := json.Marshal(stats)
+	if err := zipWriter.Close(countExportRecords(&data)); err != nil {
+		return nil, fmt.Errorf("failed to create ZIP: %w", err)
+	}
 
-	// 返回 ZIP 响应
-	c.Response().Header().Set("Content-Type", "application/zip")
-	c.Response().Header().Set("Content-Disposition", "attachment; filename=diarum_export.zip")
-	c.Response().Header().Set("X-Export-Stats", string(statsJSON))
-	c.Response().Header().Set("Access-Control-Expose-Headers", "X-Export-Stats")
-	c.Response().WriteHeader(http.StatusOK)
-	c.Response().Write(buf.Bytes())
+	archive := buf.Bytes()
+	contentType := "application/zip"
+	filename := "diarum_export.zip"
+
+	// 使用口令加密整个 ZIP
+	if req.Passphrase != "" {
+		archive, err = encrypt.Seal(archive, req.Passphrase)
+		if err != nil {
+			logger.Error("[Export] failed to encrypt archive: %v", err)
+			return nil, fmt.Errorf("failed to encrypt export: %w", err)
+		}
+		contentType = "application/octet-stream"
+		filename = "diarum_export.diarum.enc"
+	}
 
 	logger.Info("[Export] completed for user %s: %d diaries, %d media, %d conversations",
 		userID, stats.Diaries.ActualExported, stats.Media.ActualExported, stats.Conversations.ActualExported)
 
-	return nil
+	return &ExportResult{
+		Data:        archive,
+		Filename:    filename,
+		ContentType: contentType,
+		Stats:       stats,
+	}, nil
 }
 
 // ---------- Import Handler ----------
 
-func handleImport(c echo.Context, app *pocketbase.PocketBase, embeddingService *embedding.EmbeddingService) error {
+func handleImport(c echo.Context, app *pocketbase.PocketBase, enrichmentService *enrichment.Service) error {
 	authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 	if authRecord == nil {
 		return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
 	}
 	userID := authRecord.Id
 
-	// 读取上传的 ZIP 文件
-	fh, err := c.FormFile("file")
+	// 读取上传的文件
+	raw, err := readUploadBytes(c)
 	if err != nil {
-		return apis.NewBadRequestError("Missing upload file", err)
-	}
-	if fh.Size > maxImportSize {
-		return apis.NewBadRequestError("File too large (max 200MB). Please use segmented export with date range filters to create smaller export files, then import them separately.", nil)
+		return err
 	}
 
-	f, err := fh.Open()
-	if err != nil {
-		return apis.NewBadRequestError("Failed to open upload", err)
-	}
-	defer f.Close()
-
-	zipBytes, err := io.ReadAll(io.LimitReader(f, maxImportSize+1))
-	if err != nil {
-		return apis.NewBadRequestError("Failed to read upload", err)
-	}
-	if int64(len(zipBytes)) > maxImportSize {
-		return apis.NewBadRequestError("File too large (max 200MB). Please use segmented export with date range filters to create smaller export files, then import them separately.", nil)
+	// Imported diaries are indexed by the diary save hooks, enrichment is held back
+	// so that they are enriched one after another once the import is done
+	release := enrichmentService.Batch(userID)
+	defer release()
+
+	stats, err := ImportArchive(app, userID, raw, ImportOptions{
+		Passphrase:        c.FormValue("passphrase"),
+		SecretsPassphrase: c.FormValue("secrets_passphrase"),
+		Force:             c.FormValue("force") == "true",
+	})
+	var verifyErr *VerificationError
+	if errors.As(err, &verifyErr) {
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"code":         http.StatusBadRequest,
+			"message":      "Export archive failed integrity verification",
+			"verification": verifyErr.Result,
+		})
 	}
-
-	// 解压 ZIP
-	zipReader, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
 	if err != nil {
-		return apis.NewBadRequestError("Failed to read ZIP file", err)
+		return apis.NewBadRequestError(err.Error(), nil)
 	}
 
-	// 读取 ZIP 中的各文件到内存
-	var exportJSON []byte
-	mediaFiles := make(map[string][]byte) // filename -> bytes
+	return c.JSON(http.StatusOK, stats)
+}
 
-	for _, zf := range zipReader.File {
-		// Path traversal protection
-		if !isValidZipPath(zf.Name) {
-			logger.Warn("[Import] skipping file with invalid path: %s", zf.Name)
+// existingMessageID returns the ID of the first exported message that already exists
+func existingMessageID(app *pocketbase.PocketBase, messages []exportMessage) string {
+	for _, msg := range messages {
+		if msg.ID == "" {
 			continue
 		}
-
-		// ZIP bomb protection - check uncompressed size
-		if zf.UncompressedSize64 > maxSingleFileSize {
-			logger.Warn("[Import] skipping file exceeding size limit: %s (%d bytes)", zf.Name, zf.UncompressedSize64)
-			continue
+		if existing, _ := app.Dao().FindRecordById("ai_messages", msg.ID); existing != nil {
+			return msg.ID
 		}
+	}
+	return ""
+}
 
-		rc, err := zf.Open()
-		if err != nil {
-			continue
+// hasParents reports whether exported messages carry their branch structure
+func hasParents(messages []exportMessage) bool {
+	for _, msg := range messages {
+		if msg.Parent != "" {
+			return true
 		}
+	}
+	return false
+}
 
-		// Read with size limit (defense in depth)
-		limitedReader := io.LimitReader(rc, maxSingleFileSize+1)
-		data, err := io.ReadAll(limitedReader)
-		rc.Close()
-		if err != nil {
-			continue
-		}
-		if int64(len(data)) > maxSingleFileSize {
-			logger.Warn("[Import] file exceeded size limit during read: %s", zf.Name)
-			continue
-		}
+// ImportOptions controls how an export archive is imported
+type ImportOptions struct {
+	// Passphrase decrypts .diarum.enc archives and, unless SecretsPassphrase is set, encrypted secrets
+	Passphrase        string
+	SecretsPassphrase string
+	// Force imports even when the archive fails manifest verification
+	Force bool
+}
 
-		switch {
-		case zf.Name == "diarum_export.json":
-			exportJSON = data
-		case strings.HasPrefix(zf.Name, "media/"):
-			name := strings.TrimPrefix(zf.Name, "media/")
-			if name != "" {
-				mediaFiles[name] = data
-			}
-		}
+// VerificationError is returned by ImportArchive when the archive does not match its manifest
+type VerificationError struct {
+	Result archiveVerification
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("export archive failed integrity verification (%d issues)", len(e.Result.Issues))
+}
+
+// ImportArchive imports an export archive (plain ZIP or .diarum.enc) for a user.
+// Diaries are deduplicated by date, media and conversations by ID, and settings
+// only fill keys the user has not configured. It is shared by the HTTP handler,
+// backup restore and the CLI.
+func ImportArchive(app *pocketbase.PocketBase, userID string, raw []byte, opts ImportOptions) (*importStats, error) {
+	archive, err := openImportArchive(raw, opts.Passphrase)
+	if err != nil {
+		return nil, err
 	}
+	exportJSON := archive.ExportJSON
+	mediaFiles := archive.MediaFiles
 
 	if exportJSON == nil {
-		return apis.NewBadRequestError("ZIP missing diarum_export.json", nil)
+		return nil, fmt.Errorf("ZIP missing diarum_export.json")
 	}
 
 	// 解析 JSON
 	var data exportData
 	if err := json.Unmarshal(exportJSON, &data); err != nil {
-		return apis.NewBadRequestError("Failed to parse diarum_export.json", err)
+		return nil, fmt.Errorf("failed to parse diarum_export.json: %w", err)
 	}
 
 	if data.Version < 1 {
-		return apis.NewBadRequestError("Invalid export version", nil)
+		return nil, fmt.Errorf("invalid export version")
+	}
+	if data.Version > exportVersion {
+		return nil, fmt.Errorf("export was created by a newer version of Diarum")
+	}
+
+	// 校验 manifest，文件被截断或篡改时拒绝导入（Force 可跳过）
+	verification := archive.verify(&data)
+	if verification.HasManifest && !verification.Valid && !opts.Force {
+		logger.Warn("[Import] archive verification failed for user %s: %d issues", userID, len(verification.Issues))
+		return nil, &VerificationError{Result: verification}
 	}
 
-	stats := importStats{}
+	// 先解密密钥，口令错误时不导入任何数据
+	secretsPassphrase := opts.SecretsPassphrase
+	if secretsPassphrase == "" {
+		secretsPassphrase = opts.Passphrase
+	}
+	secrets, err := openExportSecrets(data.EncryptedSecrets, secretsPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secrets: wrong passphrase or corrupted export")
+	}
+
+	stats := importStats{
+		Verification: &verification,
+		SkippedFiles: archive.Skipped,
+	}
 
 	// 初始化 filesystem
 	fsys, err := app.NewFilesystem()
 	if err != nil {
-		return apis.NewBadRequestError("Failed to initialize filesystem", err)
+		return nil, fmt.Errorf("failed to initialize filesystem: %w", err)
 	}
 	defer fsys.Close()
 
@@ -539,7 +687,13 @@ func handleImport(c echo.Context, app *pocketbase.PocketBase, embeddingService *
 
 	diariesCollection, err := app.Dao().FindCollectionByNameOrId("diaries")
 	if err != nil {
-		return apis.NewBadRequestError("Failed to find diaries collection", err)
+		return nil, fmt.Errorf("failed to find diaries collection: %w", err)
+	}
+
+	// 用户已有的标签只加载一次，导入时按名称复用或创建
+	tagIndex, err := tags.Load(app.Dao(), userID)
+	if err != nil {
+		return nil, err
 	}
 
 	for _, d := range data.Diaries {
@@ -565,6 +719,13 @@ func handleImport(c echo.Context, app *pocketbase.PocketBase, embeddingService *
 		if d.Weather != "" {
 			record.Set("weather", d.Weather)
 		}
+		if len(d.Tags) > 0 {
+			tagIDs, err := tagIndex.Ensure(d.Tags)
+			if err != nil {
+				logger.Warn("[Import] failed to restore tags of diary %s: %v", d.Date, err)
+			}
+			record.Set("tags", tagIDs)
+		}
 
 		if err := app.Dao().SaveRecord(record); err != nil {
 			logger.Error("[Import] failed to save diary %s: %v", d.Date, err)
@@ -595,7 +756,11 @@ func handleImport(c echo.Context, app *pocketbase.PocketBase, embeddingService *
 			// Check if file exists in ZIP
 			fileBytes, ok := mediaFiles[m.File]
 			if !ok {
-				logger.Warn("[Import] media file %s not found in ZIP", m.File)
+				if reason := archive.skippedReason("media/" + m.File); reason != "" {
+					logger.Warn("[Import] media file %s was skipped: %s", m.File, reason)
+				} else {
+					logger.Warn("[Import] media file %s not found in ZIP", m.File)
+				}
 				stats.Media.Failed++
 				continue
 			}
@@ -684,6 +849,13 @@ func handleImport(c echo.Context, app *pocketbase.PocketBase, embeddingService *
 					continue
 				}
 			}
+			// Messages keep their IDs in the archive, a conversation with a message that
+			// already exists is skipped as a whole so that no branch loses its parent
+			if id := existingMessageID(app, conv.Messages); id != "" {
+				logger.Info("[Import] message %s of conversation %s already exists, skipping the conversation", id, conv.ID)
+				stats.Conversations.Skipped++
+				continue
+			}
 
 			// Create conversation record
 			convRecord := models.NewRecord(convCollection)
@@ -696,22 +868,27 @@ func handleImport(c echo.Context, app *pocketbase.PocketBase, embeddingService *
 				continue
 			}
 
-			// Import messages
+			// Import messages, older archives without parents become a single branch
+			msgIDMap := make(map[string]string)
+			linear := !hasParents(conv.Messages)
+			lastID := ""
 			for _, msg := range conv.Messages {
-				// Check if message with same ID already exists - skip if so
-				if msg.ID != "" {
-					existing, _ := app.Dao().FindRecordById("ai_messages", msg.ID)
-					if existing != nil {
-						logger.Info("[Import] message %s already exists, skipping", msg.ID)
-						continue
-					}
-				}
-
 				msgRecord := models.NewRecord(msgCollection)
 				msgRecord.Set("conversation", convRecord.Id)
 				msgRecord.Set("role", msg.Role)
 				msgRecord.Set("content", msg.Content)
 				msgRecord.Set("owner", userID)
+				if msg.Parent != "" {
+					msgRecord.Set("parent", msgIDMap[msg.Parent])
+				} else if linear && lastID != "" {
+					msgRecord.Set("parent", lastID)
+				}
+				if len(msg.ToolCalls) > 0 {
+					msgRecord.Set("tool_calls", msg.ToolCalls)
+				}
+				if msg.ToolCallID != "" {
+					msgRecord.Set("tool_call_id", msg.ToolCallID)
+				}
 
 				// 修复 referenced_diaries 关联
 				if len(msg.ReferencedDiaries) > 0 {
@@ -730,36 +907,38 @@ func handleImport(c echo.Context, app *pocketbase.PocketBase, embeddingService *
 					logger.Error("[Import] failed to create message: %v", err)
 					continue
 				}
+				msgIDMap[msg.ID] = msgRecord.Id
+				lastID = msgRecord.Id
+			}
+
+			// Restore the active branch
+			activeID := msgIDMap[conv.ActiveMessage]
+			if activeID == "" {
+				activeID = lastID
+			}
+			if activeID != "" {
+				convRecord.Set("active_message", activeID)
+				if err := app.Dao().SaveRecord(convRecord); err != nil {
+					logger.Warn("[Import] failed to set active branch of conversation %s: %v", convRecord.Id, err)
+				}
 			}
 
 			stats.Conversations.Imported++
 		}
 	}
 
-	// ---------- 导入后异步触发向量重建 ----------
-	if embeddingService != nil {
-		configService := config.NewConfigService(app)
-		enabled, _ := configService.GetBool(userID, "ai.enabled")
-		if enabled {
-			go func() {
-				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-				defer cancel()
-				logger.Info("[Import] triggering incremental vector rebuild for user: %s", userID)
-				result, err := embeddingService.BuildIncrementalVectors(ctx, userID)
-				if err != nil {
-					logger.Error("[Import] vector rebuild failed for user %s: %v", userID, err)
-					return
-				}
-				logger.Info("[Import] vector rebuild completed for user %s: %d built, %d failed",
-					userID, result.Success, result.Failed)
-			}()
-		}
+	// ---------- 导入设置 ----------
+	if len(data.Settings) > 0 || len(secrets) > 0 {
+		importSettings(app, userID, data.Settings, secrets, &stats.Settings)
+	}
+	if data.EncryptedSecrets != "" && secrets == nil {
+		logger.Info("[Import] export contains encrypted secrets but no passphrase was given, skipping them")
 	}
 
-	logger.Info("[Import] completed for user %s: diaries=%+v, media=%+v, conversations=%+v",
-		userID, stats.Diaries, stats.Media, stats.Conversations)
+	logger.Info("[Import] completed for user %s: diaries=%+v, media=%+v, conversations=%+v, settings=%+v",
+		userID, stats.Diaries, stats.Media, stats.Conversations, stats.Settings)
 
-	return c.JSON(http.StatusOK, stats)
+	return &stats, nil
 }
 
 // ---------- Helpers ----------
@@ -783,22 +962,56 @@ func extractExportDate(dateTime string) string {
 	return dateTime
 }
 
-func generateMarkdown(d exportDiary) string {
+// mediaURLPattern matches PocketBase file URLs: /api/files/{collection}/{recordId}/{filename}
+var mediaURLPattern = regexp.MustCompile(`/api/files/[^/]+/([^/]+)/([^/?#]+)`)
+
+// generateMarkdown renders a diary as Markdown with YAML front-matter.
+// Images that were exported into the archive are rewritten to relative media/ paths.
+func generateMarkdown(d exportDiary, mediaFiles map[string]string) string {
 	var sb strings.Builder
-	sb.WriteString("# " + d.Date + "\n\n")
+	sb.WriteString("---\n")
+	sb.WriteString("id: " + yamlString(d.ID) + "\n")
+	sb.WriteString("date: " + d.Date + "\n")
 	if d.Mood != "" {
-		sb.WriteString("**Mood:** " + d.Mood + "\n")
+		sb.WriteString("mood: " + yamlString(d.Mood) + "\n")
 	}
 	if d.Weather != "" {
-		sb.WriteString("**Weather:** " + d.Weather + "\n")
+		sb.WriteString("weather: " + yamlString(d.Weather) + "\n")
 	}
-	if d.Mood != "" || d.Weather != "" {
-		sb.WriteString("\n")
-	}
-	sb.WriteString(d.Content)
+	sb.WriteString("tags: " + yamlList(d.Tags) + "\n")
+	sb.WriteString("---\n\n")
+
+	sb.WriteString(markdown.FromHTML(d.Content, markdown.Options{
+		RewriteImage: func(src string) string {
+			match := mediaURLPattern.FindStringSubmatch(src)
+			if match == nil {
+				return ""
+			}
+			if file, ok := mediaFiles[match[1]]; ok {
+				// markdown/ and media/ are siblings inside the archive
+				return "../media/" + file
+			}
+			return ""
+		},
+	}))
 	return sb.String()
 }
 
+// yamlString quotes a string as a YAML scalar (JSON strings are valid YAML)
+func yamlString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// yamlList renders a string slice as a YAML flow sequence
+func yamlList(items []string) string {
+	quoted := make([]string, 0, len(items))
+	for _, item := range items {
+		quoted = append(quoted, yamlString(item))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
 // calculateDateRange calculates the start and end dates based on the export request
 func calculateDateRange(req ExportRequest) (time.Time, time.Time, error) {
 	now := time.Now().UTC()
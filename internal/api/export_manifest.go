@@ -0,0 +1,406 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+
+	"github.com/songtianlun/diarum/internal/encrypt"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+const manifestFileName = "manifest.json"
+
+// ---------- Manifest 数据结构 ----------
+
+// exportManifest lists every file in an export archive with its size and SHA-256 hash
+type exportManifest struct {
+	Version   int                     `json:"version"`
+	CreatedAt string                  `json:"created_at"`
+	Files     map[string]manifestFile `json:"files"`
+	Counts    manifestCounts          `json:"counts"`
+}
+
+type manifestFile struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// manifestCounts records how many records diarum_export.json contains
+type manifestCounts struct {
+	Diaries       int `json:"diaries"`
+	Media         int `json:"media"`
+	Conversations int `json:"conversations"`
+	Messages      int `json:"messages"`
+	Settings      int `json:"settings"`
+}
+
+// archiveVerification is the result of checking an archive against its manifest
+type archiveVerification struct {
+	Encrypted   bool                `json:"encrypted"`
+	HasManifest bool                `json:"has_manifest"`
+	Valid       bool                `json:"valid"`
+	Files       int                 `json:"files"`
+	Counts      *manifestCounts     `json:"counts,omitempty"`
+	Issues      []verificationIssue `json:"issues,omitempty"`
+}
+
+type verificationIssue struct {
+	Path     string `json:"path,omitempty"`
+	Problem  string `json:"problem"` // "missing", "size_mismatch", "hash_mismatch", "unexpected_file", "skipped", "count_mismatch", "invalid"
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// skippedFile is a ZIP entry that was not read during import
+type skippedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// ---------- Writing ----------
+
+// manifestZipWriter writes files into a ZIP while recording them in a manifest
+type manifestZipWriter struct {
+	zw       *zip.Writer
+	manifest exportManifest
+}
+
+func newManifestZipWriter(w io.Writer) *manifestZipWriter {
+	return &manifestZipWriter{
+		zw: zip.NewWriter(w),
+		manifest: exportManifest{
+			Version: exportVersion,
+			Files:   make(map[string]manifestFile),
+		},
+	}
+}
+
+// Add writes a file to the archive and records its hash
+func (m *manifestZipWriter) Add(name string, data []byte) error {
+	w, err := m.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	m.manifest.Files[name] = digest(data)
+	return nil
+}
+
+// Close writes manifest.json with the given record counts and finalizes the archive
+func (m *manifestZipWriter) Close(counts manifestCounts) error {
+	m.manifest.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	m.manifest.Counts = counts
+
+	manifestJSON, err := json.MarshalIndent(m.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := m.zw.Create(manifestFileName)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(manifestJSON); err != nil {
+		return err
+	}
+	return m.zw.Close()
+}
+
+// ---------- Reading ----------
+
+// importArchive holds the files read from an uploaded export archive
+type importArchive struct {
+	Encrypted  bool
+	ExportJSON []byte
+	MediaFiles map[string][]byte // filename -> bytes
+	Manifest   *exportManifest
+	Skipped    []skippedFile
+	digests    map[string]manifestFile // every file read, including markdown
+}
+
+// readUploadBytes reads the uploaded file from the "file" form field
+func readUploadBytes(c echo.Context) ([]byte, error) {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return nil, apis.NewBadRequestError("Missing upload file", err)
+	}
+	if fh.Size > maxImportSize {
+		return nil, apis.NewBadRequestError("File too large (max 200MB). Please use segmented export with date range filters to create smaller export files, then import them separately.", nil)
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return nil, apis.NewBadRequestError("Failed to open upload", err)
+	}
+	defer f.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(f, maxImportSize+1))
+	if err != nil {
+		return nil, apis.NewBadRequestError("Failed to read upload", err)
+	}
+	if int64(len(raw)) > maxImportSize {
+		return nil, apis.NewBadRequestError("File too large (max 200MB). Please use segmented export with date range filters to create smaller export files, then import them separately.", nil)
+	}
+	return raw, nil
+}
+
+// openImportArchive decrypts a passphrase-encrypted (.diarum.enc) export
+// when needed and reads the ZIP entries
+func openImportArchive(raw []byte, passphrase string) (*importArchive, error) {
+	// 检测并解密加密的导出文件（.diarum.enc）
+	encrypted := encrypt.IsSealed(raw)
+	if encrypted {
+		if passphrase == "" {
+			return nil, fmt.Errorf("this export is encrypted, please provide the passphrase")
+		}
+		var err error
+		raw, err = encrypt.Open(raw, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt export: wrong passphrase or corrupted file")
+		}
+	}
+
+	archive, err := readImportArchive(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ZIP file: %w", err)
+	}
+	archive.Encrypted = encrypted
+	return archive, nil
+}
+
+// readImportArchive reads all entries of an export ZIP into memory,
+// recording entries that had to be skipped
+func readImportArchive(zipBytes []byte) (*importArchive, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &importArchive{
+		MediaFiles: make(map[string][]byte),
+		digests:    make(map[string]manifestFile),
+	}
+	skip := func(name, reason string) {
+		logger.Warn("[Import] skipping %s: %s", name, reason)
+		archive.Skipped = append(archive.Skipped, skippedFile{Path: name, Reason: reason})
+	}
+
+	for _, zf := range zipReader.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+
+		// Path traversal protection
+		if !isValidZipPath(zf.Name) {
+			skip(zf.Name, "invalid path")
+			continue
+		}
+
+		// ZIP bomb protection - check uncompressed size
+		if zf.UncompressedSize64 > maxSingleFileSize {
+			skip(zf.Name, fmt.Sprintf("exceeds size limit (%d bytes)", zf.UncompressedSize64))
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			skip(zf.Name, fmt.Sprintf("failed to open: %v", err))
+			continue
+		}
+
+		// Read with size limit (defense in depth)
+		data, err := io.ReadAll(io.LimitReader(rc, maxSingleFileSize+1))
+		rc.Close()
+		if err != nil {
+			skip(zf.Name, fmt.Sprintf("failed to read: %v", err))
+			continue
+		}
+		if int64(len(data)) > maxSingleFileSize {
+			skip(zf.Name, "exceeded size limit during read")
+			continue
+		}
+
+		switch {
+		case zf.Name == manifestFileName:
+			var manifest exportManifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				skip(zf.Name, fmt.Sprintf("invalid manifest: %v", err))
+				continue
+			}
+			archive.Manifest = &manifest
+			continue
+		case zf.Name == "diarum_export.json":
+			archive.ExportJSON = data
+		case strings.HasPrefix(zf.Name, "media/"):
+			name := strings.TrimPrefix(zf.Name, "media/")
+			if name != "" {
+				archive.MediaFiles[name] = data
+			}
+		}
+		archive.digests[zf.Name] = digest(data)
+	}
+
+	return archive, nil
+}
+
+// skippedReason returns why a file was skipped, or "" if it was read
+func (a *importArchive) skippedReason(path string) string {
+	for _, s := range a.Skipped {
+		if s.Path == path {
+			return s.Reason
+		}
+	}
+	return ""
+}
+
+// ---------- Verification ----------
+
+// verify checks the archive against its manifest. data may be nil when
+// diarum_export.json could not be parsed, in which case counts are not compared.
+func (a *importArchive) verify(data *exportData) archiveVerification {
+	result := archiveVerification{
+		Encrypted:   a.Encrypted,
+		HasManifest: a.Manifest != nil,
+		Files:       len(a.digests),
+	}
+
+	if data != nil {
+		counts := countExportRecords(data)
+		result.Counts = &counts
+	}
+
+	// Skipped files are always reported, even for archives without a manifest
+	for _, s := range a.Skipped {
+		result.Issues = append(result.Issues, verificationIssue{Path: s.Path, Problem: "skipped", Actual: s.Reason})
+	}
+
+	if a.Manifest == nil {
+		result.Valid = len(result.Issues) == 0
+		return result
+	}
+
+	paths := make([]string, 0, len(a.Manifest.Files))
+	for path := range a.Manifest.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		expected := a.Manifest.Files[path]
+		actual, ok := a.digests[path]
+		if !ok {
+			if a.skippedReason(path) == "" {
+				result.Issues = append(result.Issues, verificationIssue{Path: path, Problem: "missing"})
+			}
+			continue
+		}
+		if actual.Size != expected.Size {
+			result.Issues = append(result.Issues, verificationIssue{
+				Path:     path,
+				Problem:  "size_mismatch",
+				Expected: fmt.Sprintf("%d", expected.Size),
+				Actual:   fmt.Sprintf("%d", actual.Size),
+			})
+			continue
+		}
+		if actual.SHA256 != expected.SHA256 {
+			result.Issues = append(result.Issues, verificationIssue{
+				Path:     path,
+				Problem:  "hash_mismatch",
+				Expected: expected.SHA256,
+				Actual:   actual.SHA256,
+			})
+		}
+	}
+
+	for path := range a.digests {
+		if _, ok := a.Manifest.Files[path]; !ok {
+			result.Issues = append(result.Issues, verificationIssue{Path: path, Problem: "unexpected_file"})
+		}
+	}
+
+	if result.Counts != nil {
+		expected, actual := a.Manifest.Counts, *result.Counts
+		checkCount := func(name string, want, got int) {
+			if want != got {
+				result.Issues = append(result.Issues, verificationIssue{
+					Path:     name,
+					Problem:  "count_mismatch",
+					Expected: fmt.Sprintf("%d", want),
+					Actual:   fmt.Sprintf("%d", got),
+				})
+			}
+		}
+		checkCount("diaries", expected.Diaries, actual.Diaries)
+		checkCount("media", expected.Media, actual.Media)
+		checkCount("conversations", expected.Conversations, actual.Conversations)
+		checkCount("messages", expected.Messages, actual.Messages)
+		checkCount("settings", expected.Settings, actual.Settings)
+	}
+
+	result.Valid = len(result.Issues) == 0
+	return result
+}
+
+// countExportRecords counts the records contained in the export data
+func countExportRecords(data *exportData) manifestCounts {
+	counts := manifestCounts{
+		Diaries:       len(data.Diaries),
+		Media:         len(data.Media),
+		Conversations: len(data.Conversations),
+		Settings:      len(data.Settings),
+	}
+	for _, conv := range data.Conversations {
+		counts.Messages += len(conv.Messages)
+	}
+	return counts
+}
+
+// digest returns the size and SHA-256 hash of data
+func digest(data []byte) manifestFile {
+	sum := sha256.Sum256(data)
+	return manifestFile{Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+}
+
+// ---------- Verify Handler ----------
+
+// handleImportVerify checks an export archive against its manifest without importing anything
+func handleImportVerify(c echo.Context) error {
+	raw, err := readUploadBytes(c)
+	if err != nil {
+		return err
+	}
+	archive, err := openImportArchive(raw, c.FormValue("passphrase"))
+	if err != nil {
+		return apis.NewBadRequestError(err.Error(), nil)
+	}
+
+	var data *exportData
+	if archive.ExportJSON != nil {
+		var parsed exportData
+		if err := json.Unmarshal(archive.ExportJSON, &parsed); err == nil {
+			data = &parsed
+		}
+	}
+
+	result := archive.verify(data)
+	if data == nil {
+		result.Issues = append(result.Issues, verificationIssue{Path: "diarum_export.json", Problem: "invalid"})
+		result.Valid = false
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
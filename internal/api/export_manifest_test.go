@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/songtianlun/diarum/internal/encrypt"
+)
+
+// buildTestArchive writes an export ZIP with one diary and one media file
+func buildTestArchive(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	data := exportData{
+		Version:    exportVersion,
+		ExportedAt: "2026-10-18T00:00:00Z",
+		Diaries: []exportDiary{
+			{ID: "d1", Date: "2026-10-17", Content: "<p>Hello</p>", Tags: []string{"work"}},
+		},
+		Media: []exportMedia{
+			{ID: "m1", File: "photo.png", Name: "photo", Diary: []string{"d1"}},
+		},
+	}
+	exportJSON, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("marshal export: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w := newManifestZipWriter(&buf)
+	if err := w.Add("diarum_export.json", exportJSON); err != nil {
+		t.Fatalf("add export: %v", err)
+	}
+	if err := w.Add("media/photo.png", []byte("\x89PNG fake")); err != nil {
+		t.Fatalf("add media: %v", err)
+	}
+	if err := w.Close(countExportRecords(&data)); err != nil {
+		t.Fatalf("close archive: %v", err)
+	}
+	return buf.Bytes(), exportJSON
+}
+
+func TestOpenImportArchiveEncrypted(t *testing.T) {
+	zipBytes, exportJSON := buildTestArchive(t)
+	sealed, err := encrypt.Seal(zipBytes, "export pass")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+
+	archive, err := openImportArchive(sealed, "export pass")
+	if err != nil {
+		t.Fatalf("openImportArchive: %v", err)
+	}
+	if !archive.Encrypted {
+		t.Error("archive is not marked as encrypted")
+	}
+	if !bytes.Equal(archive.ExportJSON, exportJSON) {
+		t.Errorf("ExportJSON = %q, want %q", archive.ExportJSON, exportJSON)
+	}
+	if got := string(archive.MediaFiles["photo.png"]); got != "\x89PNG fake" {
+		t.Errorf("media photo.png = %q", got)
+	}
+
+	var data exportData
+	if err := json.Unmarshal(archive.ExportJSON, &data); err != nil {
+		t.Fatalf("unmarshal export: %v", err)
+	}
+	if v := archive.verify(&data); !v.Valid || !v.Encrypted || !v.HasManifest {
+		t.Errorf("verify = %+v, want a valid encrypted archive with a manifest", v)
+	}
+}
+
+func TestOpenImportArchivePlain(t *testing.T) {
+	zipBytes, _ := buildTestArchive(t)
+
+	// The passphrase is ignored for archives that are not encrypted
+	archive, err := openImportArchive(zipBytes, "unused")
+	if err != nil {
+		t.Fatalf("openImportArchive: %v", err)
+	}
+	if archive.Encrypted {
+		t.Error("plain archive is marked as encrypted")
+	}
+}
+
+func TestOpenImportArchiveErrors(t *testing.T) {
+	zipBytes, _ := buildTestArchive(t)
+	sealed, err := encrypt.Seal(zipBytes, "export pass")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)/2] ^= 0x01
+
+	tests := []struct {
+		name       string
+		raw        []byte
+		passphrase string
+		want       string
+	}{
+		{"missing passphrase", sealed, "", "please provide the passphrase"},
+		{"wrong passphrase", sealed, "wrong pass", "wrong passphrase or corrupted file"},
+		{"truncated", sealed[:len(sealed)-10], "export pass", "wrong passphrase or corrupted file"},
+		{"tampered", tampered, "export pass", "wrong passphrase or corrupted file"},
+		{"not a zip", []byte("not a zip file"), "", "failed to read ZIP file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := openImportArchive(tt.raw, tt.passphrase)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("openImportArchive = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
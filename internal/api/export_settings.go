@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/encrypt"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// buildExportSettings collects the user's exportable settings.
+// Secrets (AI API key, API token) are never written in plaintext: they are only
+// included, sealed with the passphrase, when one is provided.
+func buildExportSettings(app *pocketbase.PocketBase, userID, passphrase string) (map[string]any, string, error) {
+	configService := config.NewConfigService(app)
+	all, err := configService.GetBatch(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	settings := make(map[string]any)
+	secrets := make(map[string]any)
+	for key, value := range all {
+		if !config.IsExportable(key) {
+			continue
+		}
+		if config.IsSecret(key) {
+			secrets[key] = value
+			continue
+		}
+		settings[key] = value
+	}
+
+	if passphrase == "" || len(secrets) == 0 {
+		return settings, "", nil
+	}
+
+	secretsJSON, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to serialize secrets: %w", err)
+	}
+	sealed, err := encrypt.Seal(secretsJSON, passphrase)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encrypt secrets: %w", err)
+	}
+
+	return settings, base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openExportSecrets decrypts the secrets section of an export.
+// Returns nil without error when the export has no secrets or no passphrase was supplied.
+func openExportSecrets(encoded, passphrase string) (map[string]any, error) {
+	if encoded == "" || passphrase == "" {
+		return nil, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted secrets: %w", err)
+	}
+	plaintext, err := encrypt.Open(sealed, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets map[string]any
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("invalid encrypted secrets: %w", err)
+	}
+	return secrets, nil
+}
+
+// importSettings restores settings (and decrypted secrets) for a user.
+// Only keys registered in ConfigRegistry are restored, and keys the user
+// has already configured on this server are left untouched.
+func importSettings(app *pocketbase.PocketBase, userID string, settings, secrets map[string]any, counters *importCounters) {
+	configService := config.NewConfigService(app)
+	existing, _ := configService.GetBatch(userID)
+
+	toSave := make(map[string]any)
+	restore := func(key string, value any, secret bool) {
+		counters.Total++
+		if _, ok := config.GetConfigMeta(key); !ok || !config.IsExportable(key) || config.IsSecret(key) != secret {
+			logger.Warn("[Import] skipping unknown or non-importable setting: %s", key)
+			counters.Skipped++
+			return
+		}
+		if current, ok := existing[key]; ok && !isEmptySetting(current) {
+			counters.Skipped++
+			return
+		}
+		if key == "api.token" && isTokenInUse(app, userID, value) {
+			logger.Warn("[Import] API token already used by another account, skipping")
+			counters.Skipped++
+			return
+		}
+		toSave[key] = value
+	}
+
+	for key, value := range settings {
+		restore(key, value, false)
+	}
+	for key, value := range secrets {
+		restore(key, value, true)
+	}
+
+	if len(toSave) == 0 {
+		return
+	}
+	if err := configService.SetBatch(userID, toSave); err != nil {
+		logger.Error("[Import] failed to save settings: %v", err)
+		counters.Failed += len(toSave)
+		return
+	}
+	counters.Imported += len(toSave)
+}
+
+// isEmptySetting reports whether a stored setting value is unset (null or empty string)
+func isEmptySetting(value any) bool {
+	if raw, ok := value.(types.JsonRaw); ok {
+		var v any
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return true
+		}
+		value = v
+	}
+	return value == nil || value == ""
+}
+
+// isTokenInUse checks whether another user already owns the given API token.
+// Malformed tokens are reported as in use so they are never restored.
+func isTokenInUse(app *pocketbase.PocketBase, userID string, token any) bool {
+	str, ok := token.(string)
+	if !ok || str == "" {
+		return true
+	}
+	record, err := app.Dao().FindFirstRecordByFilter(
+		"user_settings",
+		"key = 'api.token' && value = {:token} && user != {:user}",
+		map[string]any{"token": str, "user": userID},
+	)
+	return err == nil && record != nil
+}
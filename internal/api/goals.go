@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+
+	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/goals"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// RegisterGoalRoutes registers the goal progress endpoint. Goals and vacations
+// themselves are managed through their collections.
+func RegisterGoalRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, service *goals.Service) {
+	configService := config.NewConfigService(app)
+
+	// Get the progress of the goals in the current and previous periods
+	e.Router.GET("/api/goals/progress", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		userId := authRecord.Id
+
+		loc := requestLocation(c, configService, userId)
+
+		periods := 1
+		if value := c.QueryParam("periods"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > goals.MaxPeriods {
+				return apis.NewBadRequestError("periods must be between 1 and "+strconv.Itoa(goals.MaxPeriods), nil)
+			}
+			periods = n
+		}
+
+		progress, err := service.Progress(userId, time.Now().In(loc), periods)
+		if err != nil {
+			logger.Error("[GET /api/goals/progress] failed for user %s: %v", userId, err)
+			return apis.NewBadRequestError("Failed to evaluate goals", err)
+		}
+
+		return c.JSON(http.StatusOK, map[string]any{
+			"goals": progress,
+		})
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/migrate"
+
+	_ "github.com/songtianlun/diarum/internal/migrations"
+)
+
+// newTestApp creates a migrated app in a temporary directory
+func newTestApp(t *testing.T) *pocketbase.PocketBase {
+	t.Helper()
+	app := pocketbase.NewWithConfig(pocketbase.Config{DefaultDataDir: t.TempDir(), HideStartBanner: true})
+	if err := app.Bootstrap(); err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+	t.Cleanup(func() { app.ResetBootstrapState() })
+
+	runner, err := migrate.NewRunner(app.DB(), migrations.AppMigrations)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	if _, err := runner.Up(); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	return app
+}
+
+// saveRecord saves a record with fields in a collection
+func saveRecord(t *testing.T, app *pocketbase.PocketBase, collection, id string, fields map[string]any) *models.Record {
+	t.Helper()
+	c, err := app.Dao().FindCollectionByNameOrId(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := models.NewRecord(c)
+	if id != "" {
+		record.SetId(id)
+	}
+	for key, value := range fields {
+		record.Set(key, value)
+	}
+	if err := app.Dao().SaveRecord(record); err != nil {
+		t.Fatalf("failed to save %s record: %v", collection, err)
+	}
+	return record
+}
+
+// conversationArchive writes an export ZIP with a conversation whose answer was
+// regenerated: the question has two answers and the second one is active
+func conversationArchive(t *testing.T) []byte {
+	t.Helper()
+	data := exportData{
+		Version:    exportVersion,
+		ExportedAt: "2026-10-18T00:00:00Z",
+		Conversations: []exportConversation{{
+			ID:    "convexported001",
+			Title: "Weekend",
+			Messages: []exportMessage{
+				{ID: "msgquestion0001", Role: "user", Content: "What did I do last weekend?"},
+				{ID: "msganswerfirst1", Role: "assistant", Content: "You went hiking.", Parent: "msgquestion0001"},
+				{ID: "msganswersecnd1", Role: "assistant", Content: "You hiked and cooked.", Parent: "msgquestion0001"},
+				{ID: "msgfollowup0001", Role: "user", Content: "Where?", Parent: "msganswersecnd1"},
+			},
+			ActiveMessage: "msgfollowup0001",
+		}},
+	}
+	exportJSON, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("marshal export: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w := newManifestZipWriter(&buf)
+	if err := w.Add("diarum_export.json", exportJSON); err != nil {
+		t.Fatalf("add export: %v", err)
+	}
+	if err := w.Close(countExportRecords(&data)); err != nil {
+		t.Fatalf("close archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestImportConversationKeepsBranches(t *testing.T) {
+	app := newTestApp(t)
+
+	stats, err := ImportArchive(app, "user00000000001", conversationArchive(t), ImportOptions{})
+	if err != nil {
+		t.Fatalf("ImportArchive: %v", err)
+	}
+	if stats.Conversations.Imported != 1 {
+		t.Fatalf("conversations = %+v, want 1 imported", stats.Conversations)
+	}
+
+	conv, err := app.Dao().FindFirstRecordByData("ai_conversations", "title", "Weekend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := app.Dao().FindRecordsByFilter("ai_messages", "conversation = {:conv}", "", 0, 0, map[string]any{"conv": conv.Id})
+	if err != nil || len(messages) != 4 {
+		t.Fatalf("imported %d messages, %v", len(messages), err)
+	}
+	byContent := make(map[string]*models.Record)
+	for _, m := range messages {
+		byContent[m.GetString("content")] = m
+	}
+
+	question := byContent["What did I do last weekend?"]
+	parents := map[string]string{
+		"What did I do last weekend?": "",
+		"You went hiking.":            question.Id,
+		"You hiked and cooked.":       question.Id,
+		"Where?":                      byContent["You hiked and cooked."].Id,
+	}
+	for content, want := range parents {
+		if got := byContent[content].GetString("parent"); got != want {
+			t.Errorf("parent of %q = %q, want %q", content, got, want)
+		}
+	}
+	if got := conv.GetString("active_message"); got != byContent["Where?"].Id {
+		t.Errorf("active_message = %q, want the follow-up question", got)
+	}
+}
+
+func TestImportSkipsConversationWithExistingMessage(t *testing.T) {
+	app := newTestApp(t)
+
+	// One answer of the archive already exists, e.g. in a conversation kept from an earlier import
+	existing := saveRecord(t, app, "ai_conversations", "", map[string]any{"title": "Kept", "owner": "user00000000001"})
+	saveRecord(t, app, "ai_messages", "msganswersecnd1", map[string]any{
+		"conversation": existing.Id,
+		"role":         "assistant",
+		"content":      "You hiked and cooked.",
+		"owner":        "user00000000001",
+	})
+
+	stats, err := ImportArchive(app, "user00000000001", conversationArchive(t), ImportOptions{})
+	if err != nil {
+		t.Fatalf("ImportArchive: %v", err)
+	}
+	if stats.Conversations.Skipped != 1 || stats.Conversations.Imported != 0 {
+		t.Fatalf("conversations = %+v, want 1 skipped", stats.Conversations)
+	}
+
+	// Nothing of the skipped conversation is imported, so no message is left without its parent
+	if _, err := app.Dao().FindFirstRecordByData("ai_conversations", "title", "Weekend"); err == nil {
+		t.Error("the conversation was imported")
+	}
+	messages, err := app.Dao().FindRecordsByFilter("ai_messages", "id != ''", "", 0, 0)
+	if err != nil || len(messages) != 1 {
+		t.Errorf("%d messages after the import, want only the existing one (%v)", len(messages), err)
+	}
+}
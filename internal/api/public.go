@@ -17,18 +17,9 @@ func RegisterPublicRoutes(app *pocketbase.PocketBase, e *core.ServeEvent) {
 
 	// Get diaries by date or date range using API token
 	e.Router.GET("/api/v1/diaries", func(c echo.Context) error {
-		token := c.QueryParam("token")
-		if token == "" {
-			return apis.NewUnauthorizedError("API token is required", nil)
-		}
-
-		// Validate token and get owner using ConfigService
-		userId, err := configService.ValidateTokenAndGetUser(token)
-		if err == config.ErrAPIDisabled {
-			return apis.NewUnauthorizedError("API is disabled for this user", nil)
-		}
-		if err != nil || userId == "" {
-			return apis.NewUnauthorizedError("Invalid API token", nil)
+		userId, err := tokenUser(c, configService)
+		if err != nil {
+			return err
 		}
 
 		// Check query parameters
@@ -120,3 +111,21 @@ func RegisterPublicRoutes(app *pocketbase.PocketBase, e *core.ServeEvent) {
 		return apis.NewBadRequestError("Either 'date' or both 'start' and 'end' query parameters are required", nil)
 	}, apis.ActivityLogger(app))
 }
+
+// tokenUser validates the API token of a public request and returns its owner
+func tokenUser(c echo.Context, configService *config.ConfigService) (string, error) {
+	token := c.QueryParam("token")
+	if token == "" {
+		return "", apis.NewUnauthorizedError("API token is required", nil)
+	}
+
+	// Validate token and get owner using ConfigService
+	userId, err := configService.ValidateTokenAndGetUser(token)
+	if err == config.ErrAPIDisabled {
+		return "", apis.NewUnauthorizedError("API is disabled for this user", nil)
+	}
+	if err != nil || userId == "" {
+		return "", apis.NewUnauthorizedError("Invalid API token", nil)
+	}
+	return userId, nil
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+
+	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/embedding"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+const (
+	defaultRelatedLimit = 5
+	maxRelatedLimit     = 20
+	// sameTimeWindow is how many days around an anniversary count as the same time of year
+	sameTimeWindow = 7
+)
+
+// RegisterRelatedRoutes registers the related entries and on this day endpoints,
+// for signed-in users and through the public token API
+func RegisterRelatedRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, embeddingService *embedding.EmbeddingService) {
+	configService := config.NewConfigService(app)
+
+	// related returns the entries nearest to a diary by its stored embedding
+	related := func(c echo.Context, userId string) error {
+		diary, err := app.Dao().FindRecordById("diaries", c.PathParam("id"))
+		if err != nil || diary.GetString("owner") != userId {
+			return apis.NewNotFoundError("Diary not found", nil)
+		}
+		if embeddingService == nil {
+			return apis.NewBadRequestError("Vector database is not available", nil)
+		}
+		if enabled, _ := configService.GetBool(userId, "ai.enabled"); !enabled {
+			return apis.NewBadRequestError("AI features are not enabled", nil)
+		}
+
+		results, err := embeddingService.QueryRelated(c.Request().Context(), userId, diary.Id, queryLimit(c))
+		if errors.Is(err, embedding.ErrNotIndexed) {
+			return c.JSON(http.StatusOK, map[string]any{
+				"id":      diary.Id,
+				"indexed": false,
+				"related": []map[string]any{},
+			})
+		}
+		if err != nil {
+			logger.Error("[GET /api/diaries/:id/related] failed for user %s: %v", userId, err)
+			return apis.NewBadRequestError("Failed to find related diaries", err)
+		}
+
+		return c.JSON(http.StatusOK, map[string]any{
+			"id":      diary.Id,
+			"indexed": true,
+			"related": formatScoredDiaries(app, userId, results, ""),
+		})
+	}
+
+	// onThisDay returns the entries of the same calendar day in previous years and,
+	// with semantic=true, the most similar entries of the same time of year
+	onThisDay := func(c echo.Context, userId string) error {
+		date := c.QueryParam("date")
+		if date == "" {
+			date = time.Now().In(configService.Location(userId)).Format("2006-01-02")
+		}
+		day, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return apis.NewBadRequestError("date must be in YYYY-MM-DD format", nil)
+		}
+
+		var ids []string
+		err = app.Dao().DB().
+			NewQuery("SELECT id FROM diaries WHERE owner = {:owner} AND substr(date, 6, 5) = {:day} AND date < {:before} ORDER BY date DESC").
+			Bind(map[string]any{"owner": userId, "day": date[5:], "before": date + " 00:00:00.000Z"}).
+			Column(&ids)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to query diaries", err)
+		}
+		records, err := findDiariesInOrder(app, userId, ids)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to query diaries", err)
+		}
+
+		entries := make([]map[string]any, 0, len(records))
+		for _, record := range records {
+			entry := formatDiaryEntry(record)
+			entry["years_ago"] = yearsAgo(date, entry["date"].(string))
+			entries = append(entries, entry)
+		}
+		result := map[string]any{
+			"date":    date,
+			"entries": entries,
+		}
+
+		if c.QueryParam("semantic") == "true" {
+			similar, indexed, err := sameTimeMatches(c, app, embeddingService, configService, userId, day, ids)
+			if err != nil {
+				logger.Error("[GET /api/diaries/on-this-day] semantic matches failed for user %s: %v", userId, err)
+				return apis.NewBadRequestError("Failed to find similar diaries", err)
+			}
+			result["similar"] = similar
+			result["indexed"] = indexed
+		}
+
+		return c.JSON(http.StatusOK, result)
+	}
+
+	withAuth := func(handler func(echo.Context, string) error) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+			}
+			return handler(c, authRecord.Id)
+		}
+	}
+	withToken := func(handler func(echo.Context, string) error) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userId, err := tokenUser(c, configService)
+			if err != nil {
+				return err
+			}
+			return handler(c, userId)
+		}
+	}
+
+	e.Router.GET("/api/diaries/:id/related", withAuth(related), apis.ActivityLogger(app), apis.RequireRecordAuth())
+	e.Router.GET("/api/diaries/on-this-day", withAuth(onThisDay), apis.ActivityLogger(app), apis.RequireRecordAuth())
+	e.Router.GET("/api/v1/diaries/:id/related", withToken(related), apis.ActivityLogger(app))
+	e.Router.GET("/api/v1/diaries/on-this-day", withToken(onThisDay), apis.ActivityLogger(app))
+}
+
+// sameTimeMatches ranks the entries within sameTimeWindow days of the date's anniversaries
+// by similarity to the date's own entry. Entries of the exact day (exclude) are left out.
+// indexed is false when the date has no indexed entry to compare with.
+func sameTimeMatches(c echo.Context, app *pocketbase.PocketBase, embeddingService *embedding.EmbeddingService, configService *config.ConfigService, userId string, day time.Time, exclude []string) ([]map[string]any, bool, error) {
+	similar := []map[string]any{}
+	if embeddingService == nil {
+		return similar, false, nil
+	}
+	if enabled, _ := configService.GetBool(userId, "ai.enabled"); !enabled {
+		return similar, false, nil
+	}
+
+	date := day.Format("2006-01-02")
+	diary, err := app.Dao().FindFirstRecordByFilter(
+		"diaries",
+		"owner = {:owner} && date >= {:start} && date <= {:end}",
+		map[string]any{"owner": userId, "start": date + " 00:00:00.000Z", "end": date + " 23:59:59.999Z"},
+	)
+	if err != nil {
+		return similar, false, nil
+	}
+
+	var first string
+	app.Dao().DB().
+		NewQuery("SELECT MIN(date) FROM diaries WHERE owner = {:owner}").
+		Bind(map[string]any{"owner": userId}).
+		Row(&first)
+	firstDay, err := time.Parse("2006-01-02", extractExportDate(first))
+	if err != nil {
+		return similar, true, nil
+	}
+
+	excluded := make(map[string]bool, len(exclude))
+	for _, id := range exclude {
+		excluded[id] = true
+	}
+	var candidates []string
+	for years := 1; ; years++ {
+		anniversary := day.AddDate(-years, 0, 0)
+		if anniversary.AddDate(0, 0, sameTimeWindow).Before(firstDay) {
+			break
+		}
+		records, err := app.Dao().FindRecordsByFilter(
+			"diaries",
+			"owner = {:owner} && date >= {:start} && date <= {:end}",
+			"date",
+			0,
+			0,
+			map[string]any{
+				"owner": userId,
+				"start": anniversary.AddDate(0, 0, -sameTimeWindow).Format("2006-01-02") + " 00:00:00.000Z",
+				"end":   anniversary.AddDate(0, 0, sameTimeWindow).Format("2006-01-02") + " 23:59:59.999Z",
+			},
+		)
+		if err != nil {
+			return nil, true, err
+		}
+		for _, record := range records {
+			if !excluded[record.Id] {
+				candidates = append(candidates, record.Id)
+			}
+		}
+	}
+
+	ranked, err := embeddingService.RankByDiary(c.Request().Context(), userId, diary.Id, candidates, queryLimit(c))
+	if errors.Is(err, embedding.ErrNotIndexed) {
+		return similar, false, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	return formatScoredDiaries(app, userId, ranked, date), true, nil
+}
+
+// formatScoredDiaries formats vector search results with the current diary records,
+// leaving out diaries that no longer exist. With a date, years_ago is added.
+func formatScoredDiaries(app *pocketbase.PocketBase, userId string, results []embedding.DiarySearchResult, date string) []map[string]any {
+	ids := make([]string, 0, len(results))
+	for _, result := range results {
+		ids = append(ids, result.ID)
+	}
+	records, err := findDiariesInOrder(app, userId, ids)
+	if err != nil {
+		return []map[string]any{}
+	}
+	scores := make(map[string]float32, len(results))
+	for _, result := range results {
+		scores[result.ID] = result.Score
+	}
+
+	formatted := make([]map[string]any, 0, len(records))
+	for _, record := range records {
+		entry := formatDiaryEntry(record)
+		entry["score"] = scores[record.Id]
+		if date != "" {
+			entry["years_ago"] = yearsAgo(date, entry["date"].(string))
+		}
+		formatted = append(formatted, entry)
+	}
+	return formatted
+}
+
+// findDiariesInOrder returns the user's diaries with the given IDs, in the same order
+func findDiariesInOrder(app *pocketbase.PocketBase, userId string, ids []string) ([]*models.Record, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	records, err := app.Dao().FindRecordsByIds("diaries", ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*models.Record, len(records))
+	for _, record := range records {
+		if record.GetString("owner") == userId {
+			byID[record.Id] = record
+		}
+	}
+	ordered := make([]*models.Record, 0, len(ids))
+	for _, id := range ids {
+		if record, ok := byID[id]; ok {
+			ordered = append(ordered, record)
+		}
+	}
+	return ordered, nil
+}
+
+// formatDiaryEntry formats a diary like the public diaries API
+func formatDiaryEntry(record *models.Record) map[string]any {
+	return map[string]any{
+		"id":      record.Id,
+		"date":    extractExportDate(record.GetString("date")),
+		"content": record.GetString("content"),
+		"mood":    record.GetString("mood"),
+		"weather": record.GetString("weather"),
+	}
+}
+
+// yearsAgo returns how many calendar years lie between two YYYY-MM-DD dates
+func yearsAgo(date, past string) int {
+	year, _ := strconv.Atoi(date[:4])
+	pastYear, _ := strconv.Atoi(past[:min(4, len(past))])
+	return year - pastYear
+}
+
+// queryLimit reads the limit query parameter
+func queryLimit(c echo.Context) int {
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit < 1 {
+		return defaultRelatedLimit
+	}
+	return min(limit, maxRelatedLimit)
+}
+
+// requestLocation returns the time zone of the tz query param, then the user's settings
+func requestLocation(c echo.Context, configService *config.ConfigService, userId string) *time.Location {
+	if tz := c.QueryParam("tz"); tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+	}
+	return configService.Location(userId)
+}
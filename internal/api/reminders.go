@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+
+	"github.com/songtianlun/diarum/internal/reminder"
+)
+
+// RegisterReminderRoutes registers the endpoint to try a reminder. Reminders
+// themselves are managed through their collection.
+func RegisterReminderRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, service *reminder.Service) {
+	// Send a reminder now, even if today's diary is written
+	e.Router.POST("/api/reminders/:id/test", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		record, err := app.Dao().FindRecordById("reminders", c.PathParam("id"))
+		if err != nil || record.GetString("owner") != authRecord.Id {
+			return apis.NewNotFoundError("Reminder not found", nil)
+		}
+
+		if err := service.Test(c.Request().Context(), record); err != nil {
+			return apis.NewBadRequestError("Failed to send reminder: "+err.Error(), nil)
+		}
+
+		return c.JSON(http.StatusOK, map[string]any{
+			"success":   true,
+			"last_sent": record.GetDateTime("last_sent").String(),
+		})
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+}
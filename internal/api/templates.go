@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+
+	"github.com/songtianlun/diarum/internal/logger"
+	"github.com/songtianlun/diarum/internal/templates"
+)
+
+// RegisterTemplateRoutes registers the diary template and daily prompt endpoints.
+// The user's own templates are managed through their collection.
+func RegisterTemplateRoutes(app *pocketbase.PocketBase, e *core.ServeEvent, service *templates.Service) {
+	// List the user's templates and the built-in ones
+	e.Router.GET("/api/templates", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		list, err := service.List(authRecord.Id)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to list templates", err)
+		}
+
+		return c.JSON(http.StatusOK, map[string]any{
+			"templates": list,
+		})
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Create a day's diary from a template, today's by default
+	e.Router.POST("/api/diaries/from-template", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		userId := authRecord.Id
+
+		var body struct {
+			Template string `json:"template"`
+			Date     string `json:"date"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return apis.NewBadRequestError("Invalid request body", err)
+		}
+		if body.Template == "" {
+			return apis.NewBadRequestError("template is required", nil)
+		}
+		if body.Date == "" {
+			body.Date = service.Today(userId)
+		} else if _, err := time.Parse("2006-01-02", body.Date); err != nil {
+			return apis.NewBadRequestError("date must be in YYYY-MM-DD format", nil)
+		}
+
+		diary, err := service.CreateDiary(c.Request().Context(), userId, body.Template, body.Date)
+		if errors.Is(err, templates.ErrTemplateNotFound) {
+			return apis.NewNotFoundError("Template not found", nil)
+		}
+		if errors.Is(err, templates.ErrDiaryExists) {
+			return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+		}
+		if err != nil {
+			logger.Error("[POST /api/diaries/from-template] failed for user %s: %v", userId, err)
+			return apis.NewBadRequestError("Failed to create diary from template", err)
+		}
+
+		return c.JSON(http.StatusOK, formatDiaryEntry(diary))
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+
+	// Get the writing prompt of a day, today's by default
+	e.Router.GET("/api/prompts/daily", func(c echo.Context) error {
+		authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("The request requires valid authorization token.", nil)
+		}
+
+		userId := authRecord.Id
+
+		date := c.QueryParam("date")
+		if date == "" {
+			date = service.Today(userId)
+		} else if _, err := time.Parse("2006-01-02", date); err != nil {
+			return apis.NewBadRequestError("date must be in YYYY-MM-DD format", nil)
+		}
+
+		// A slow model falls back to the library instead of holding the request
+		ctx, cancel := context.WithTimeout(c.Request().Context(), time.Minute)
+		defer cancel()
+
+		prompt, err := service.DailyPrompt(ctx, userId, date)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to get the daily prompt", err)
+		}
+
+		return c.JSON(http.StatusOK, prompt)
+	}, apis.ActivityLogger(app), apis.RequireRecordAuth())
+}
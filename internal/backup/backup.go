@@ -0,0 +1,353 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/tools/cron"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+const (
+	snapshotPrefix = "snapshot/"
+	usersPrefix    = "users/"
+	timeLayout     = "20060102-150405"
+	cronJobID      = "diarum_backup"
+)
+
+// ErrBackupRunning is returned when a backup or restore is already in progress
+var ErrBackupRunning = fmt.Errorf("a backup or restore is already running")
+
+var backupTimePattern = regexp.MustCompile(`(\d{8}-\d{6})`)
+
+// ExportFunc builds the export archive of one user and returns its bytes and file name
+type ExportFunc func(userID string) (data []byte, filename string, err error)
+
+// ImportFunc imports an export archive into a user's account
+type ImportFunc func(userID string, data []byte) error
+
+// Entry describes a stored backup
+type Entry struct {
+	Key       string    `json:"key"`
+	Kind      string    `json:"kind"` // "snapshot" or "users"
+	UserID    string    `json:"user_id,omitempty"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RunResult summarizes a backup run
+type RunResult struct {
+	Created []Entry       `json:"created"`
+	Deleted []string      `json:"deleted"`
+	Failed  []FailedEntry `json:"failed"`
+}
+
+// FailedEntry is a backup that could not be created or pruned
+type FailedEntry struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// Service creates, lists, prunes and restores backups
+type Service struct {
+	app      *pocketbase.PocketBase
+	cfg      Config
+	exporter ExportFunc
+	importer ImportFunc
+	cron     *cron.Cron
+	mu       sync.Mutex
+}
+
+// NewService creates a new backup service
+func NewService(app *pocketbase.PocketBase, cfg Config, exporter ExportFunc, importer ImportFunc) *Service {
+	return &Service{
+		app:      app,
+		cfg:      cfg,
+		exporter: exporter,
+		importer: importer,
+	}
+}
+
+// Config returns the service config
+func (s *Service) Config() Config {
+	return s.cfg
+}
+
+// Start schedules backups on the configured cron expression (evaluated in UTC).
+// It does nothing when no expression is configured.
+func (s *Service) Start() error {
+	if s.cfg.Cron == "" {
+		return nil
+	}
+	c := cron.New()
+	if err := c.Add(cronJobID, s.cfg.Cron, func() {
+		result, err := s.Run(s.cfg.Mode)
+		if err != nil {
+			logger.Error("[Backup] scheduled backup failed: %v", err)
+			return
+		}
+		logger.Info("[Backup] scheduled backup completed: %d created, %d deleted, %d failed",
+			len(result.Created), len(result.Deleted), len(result.Failed))
+	}); err != nil {
+		return fmt.Errorf("invalid backup cron expression %q: %w", s.cfg.Cron, err)
+	}
+	c.Start()
+	s.cron = c
+	logger.Info("[Backup] scheduled %s backups with cron %q", s.cfg.Mode, s.cfg.Cron)
+	return nil
+}
+
+// Stop stops the scheduler
+func (s *Service) Stop() {
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+}
+
+// target opens the configured backup filesystem
+func (s *Service) target() (*filesystem.System, error) {
+	if s.cfg.UsesS3() {
+		return filesystem.NewS3(
+			s.cfg.S3.Bucket,
+			s.cfg.S3.Region,
+			s.cfg.S3.Endpoint,
+			s.cfg.S3.AccessKey,
+			s.cfg.S3.SecretKey,
+			s.cfg.S3.ForcePathStyle,
+		)
+	}
+	return filesystem.NewLocal(s.cfg.Dir)
+}
+
+// Run creates backups for the given mode and then applies retention
+func (s *Service) Run(mode string) (*RunResult, error) {
+	if !s.mu.TryLock() {
+		return nil, ErrBackupRunning
+	}
+	defer s.mu.Unlock()
+
+	fsys, err := s.target()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup target: %w", err)
+	}
+	defer fsys.Close()
+
+	result := &RunResult{
+		Created: make([]Entry, 0),
+		Deleted: make([]string, 0),
+		Failed:  make([]FailedEntry, 0),
+	}
+	now := time.Now().UTC()
+
+	if mode == ModeSnapshot || mode == ModeBoth {
+		key := snapshotPrefix + "diarum-snapshot-" + now.Format(timeLayout) + ".zip"
+		size, err := s.uploadSnapshot(fsys, key)
+		if err != nil {
+			logger.Error("[Backup] snapshot failed: %v", err)
+			result.Failed = append(result.Failed, FailedEntry{Key: key, Reason: err.Error()})
+		} else {
+			result.Created = append(result.Created, Entry{Key: key, Kind: ModeSnapshot, Size: size, CreatedAt: now})
+		}
+	}
+
+	if mode == ModeUsers || mode == ModeBoth {
+		users, err := s.app.Dao().FindRecordsByFilter("users", "id != ''", "created", -1, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %w", err)
+		}
+		for _, user := range users {
+			data, filename, err := s.exporter(user.Id)
+			key := usersPrefix + user.Id + "/diarum-export-" + now.Format(timeLayout) + strings.TrimPrefix(filename, "diarum_export")
+			if err == nil {
+				err = fsys.Upload(data, key)
+			}
+			if err != nil {
+				logger.Error("[Backup] export failed for user %s: %v", user.Id, err)
+				result.Failed = append(result.Failed, FailedEntry{Key: key, Reason: err.Error()})
+				continue
+			}
+			result.Created = append(result.Created, Entry{Key: key, Kind: ModeUsers, UserID: user.Id, Size: int64(len(data)), CreatedAt: now})
+		}
+	}
+
+	s.prune(fsys, result)
+	return result, nil
+}
+
+// uploadSnapshot writes a snapshot to a temp file and uploads it to the target
+func (s *Service) uploadSnapshot(fsys *filesystem.System, key string) (int64, error) {
+	tmp, err := os.CreateTemp(s.app.DataDir(), ".diarum_snapshot_*.zip")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	if err := writeSnapshot(s.app, tmpPath); err != nil {
+		return 0, err
+	}
+
+	file, err := filesystem.NewFileFromPath(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	if err := fsys.UploadFile(file, key); err != nil {
+		return 0, fmt.Errorf("failed to upload snapshot: %w", err)
+	}
+	return file.Size, nil
+}
+
+// prune deletes backups that fall outside the retention policy, per series
+func (s *Service) prune(fsys *filesystem.System, result *RunResult) {
+	entries, err := listEntries(fsys)
+	if err != nil {
+		logger.Error("[Backup] failed to list backups for retention: %v", err)
+		return
+	}
+
+	series := make(map[string][]Entry)
+	for _, e := range entries {
+		series[e.Kind+"/"+e.UserID] = append(series[e.Kind+"/"+e.UserID], e)
+	}
+	for _, group := range series {
+		for _, e := range selectExpired(group, s.cfg) {
+			if err := fsys.Delete(e.Key); err != nil {
+				result.Failed = append(result.Failed, FailedEntry{Key: e.Key, Reason: "failed to delete: " + err.Error()})
+				continue
+			}
+			result.Deleted = append(result.Deleted, e.Key)
+		}
+	}
+}
+
+// List returns all stored backups, newest first
+func (s *Service) List() ([]Entry, error) {
+	fsys, err := s.target()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup target: %w", err)
+	}
+	defer fsys.Close()
+
+	return listEntries(fsys)
+}
+
+// listEntries lists the backups on the target, ignoring unrelated files
+func listEntries(fsys *filesystem.System) ([]Entry, error) {
+	objects, err := fsys.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(objects))
+	for _, obj := range objects {
+		if obj.IsDir {
+			continue
+		}
+		entry, ok := parseKey(obj.Key)
+		if !ok {
+			continue
+		}
+		entry.Size = obj.Size
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = obj.ModTime.UTC()
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	return entries, nil
+}
+
+// parseKey recognizes "snapshot/<name>" and "users/<id>/<name>" keys
+func parseKey(key string) (Entry, bool) {
+	entry := Entry{Key: key}
+	parts := strings.Split(key, "/")
+	switch {
+	case len(parts) == 2 && parts[0]+"/" == snapshotPrefix:
+		entry.Kind = ModeSnapshot
+	case len(parts) == 3 && parts[0]+"/" == usersPrefix && parts[1] != "":
+		entry.Kind = ModeUsers
+		entry.UserID = parts[1]
+	default:
+		return entry, false
+	}
+
+	if m := backupTimePattern.FindString(path.Base(key)); m != "" {
+		if t, err := time.Parse(timeLayout, m); err == nil {
+			entry.CreatedAt = t
+		}
+	}
+	return entry, true
+}
+
+// Restore restores a backup. A user archive is imported into that user's account
+// right away (existing data is kept, see api.ImportArchive). A snapshot is staged
+// and replaces the instance data on the next restart; pendingRestart reports this.
+func (s *Service) Restore(key string) (pendingRestart bool, err error) {
+	entry, ok := parseKey(key)
+	if !ok {
+		return false, fmt.Errorf("invalid backup key: %s", key)
+	}
+
+	if !s.mu.TryLock() {
+		return false, ErrBackupRunning
+	}
+	defer s.mu.Unlock()
+
+	fsys, err := s.target()
+	if err != nil {
+		return false, fmt.Errorf("failed to open backup target: %w", err)
+	}
+	defer fsys.Close()
+
+	reader, err := fsys.GetFile(key)
+	if err != nil {
+		return false, fmt.Errorf("backup not found: %w", err)
+	}
+	defer reader.Close()
+
+	if entry.Kind == ModeUsers {
+		if _, err := s.app.Dao().FindRecordById("users", entry.UserID); err != nil {
+			return false, fmt.Errorf("user %s no longer exists", entry.UserID)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return false, fmt.Errorf("failed to read backup: %w", err)
+		}
+		if err := s.importer(entry.UserID, data); err != nil {
+			return false, err
+		}
+		logger.Info("[Backup] restored %s into user %s", key, entry.UserID)
+		return false, nil
+	}
+
+	tmp, err := os.CreateTemp(s.app.DataDir(), ".diarum_restore_*.zip")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		return false, fmt.Errorf("failed to download backup: %w", err)
+	}
+	tmp.Close()
+
+	if err := stageSnapshot(s.app.DataDir(), tmp.Name()); err != nil {
+		return false, err
+	}
+	logger.Info("[Backup] staged snapshot %s, it will be applied on the next restart", key)
+	return true, nil
+}
@@ -0,0 +1,258 @@
+package backup
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a minimal path-style S3 server standing in for MinIO. It supports the
+// requests made by the backup target: PUT, GET, HEAD and DELETE of objects and
+// ListObjectsV2 of the bucket.
+type fakeS3 struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+type listBucketResult struct {
+	XMLName     xml.Name         `xml:"ListBucketResult"`
+	Name        string           `xml:"Name"`
+	Prefix      string           `xml:"Prefix"`
+	KeyCount    int              `xml:"KeyCount"`
+	MaxKeys     int              `xml:"MaxKeys"`
+	IsTruncated bool             `xml:"IsTruncated"`
+	Contents    []listBucketItem `xml:"Contents"`
+}
+
+type listBucketItem struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+func newFakeS3(t *testing.T, bucket string) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{bucket: bucket, objects: make(map[string]fakeObject)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+// put stores an object directly, as if it was uploaded at modTime
+func (f *fakeS3) put(key string, data []byte, modTime time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = fakeObject{data: data, modTime: modTime}
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		http.Error(w, "missing signature", http.StatusForbidden)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if key == "" && r.Method == http.MethodGet {
+		f.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = fakeObject{data: data, modTime: time.Now().UTC()}
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	result := listBucketResult{Name: f.bucket, Prefix: prefix, MaxKeys: 1000}
+	for key, obj := range f.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		result.Contents = append(result.Contents, listBucketItem{
+			Key:          key,
+			LastModified: obj.modTime.Format(time.RFC3339),
+			ETag:         `"etag"`,
+			Size:         len(obj.data),
+			StorageClass: "STANDARD",
+		})
+	}
+	sort.Slice(result.Contents, func(i, j int) bool {
+		return result.Contents[i].Key < result.Contents[j].Key
+	})
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(result)
+}
+
+func TestS3Target(t *testing.T) {
+	fake, srv := newFakeS3(t, "diarum-backups")
+	s := NewService(nil, Config{
+		Mode:      ModeBoth,
+		KeepDaily: 2,
+		S3: S3Config{
+			Bucket:         "diarum-backups",
+			Region:         "us-east-1",
+			Endpoint:       srv.URL,
+			AccessKey:      "minio",
+			SecretKey:      "minio123",
+			ForcePathStyle: true,
+		},
+	}, nil, nil)
+
+	fsys, err := s.target()
+	if err != nil {
+		t.Fatalf("target: %v", err)
+	}
+	defer fsys.Close()
+
+	// Backups written through the target
+	uploads := map[string]string{
+		"snapshot/diarum-snapshot-20261018-030000.zip":      "snapshot 18",
+		"snapshot/diarum-snapshot-20261017-030000.zip":      "snapshot 17",
+		"users/u1/diarum-export-20261018-030000.diarum.enc": "user archive",
+		"users/u1/diarum-export-20261010-030000.zip":        "old user archive",
+		"snapshot/diarum-snapshot-20261016-030000.zip":      "snapshot 16",
+	}
+	for key, data := range uploads {
+		if err := fsys.Upload([]byte(data), key); err != nil {
+			t.Fatalf("Upload %s: %v", key, err)
+		}
+	}
+	// A snapshot without a time in its name falls back to the object time, and
+	// unrelated objects are ignored
+	fake.put("snapshot/manual.zip", []byte("manual"), time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	fake.put("notes.txt", []byte("not a backup"), time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC))
+
+	entries, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var listed []string
+	for _, e := range entries {
+		listed = append(listed, e.Key)
+	}
+	wantListed := []string{
+		"snapshot/diarum-snapshot-20261018-030000.zip",
+		"users/u1/diarum-export-20261018-030000.diarum.enc",
+		"snapshot/diarum-snapshot-20261017-030000.zip",
+		"snapshot/manual.zip",
+		"snapshot/diarum-snapshot-20261016-030000.zip",
+		"users/u1/diarum-export-20261010-030000.zip",
+	}
+	// Entries created at the same time have no fixed order
+	sort.SliceStable(listed[:2], func(i, j int) bool { return listed[i] < listed[j] })
+	if !reflect.DeepEqual(listed, wantListed) {
+		t.Fatalf("List = %v, want %v", listed, wantListed)
+	}
+	for _, e := range entries {
+		switch e.Key {
+		case "snapshot/manual.zip":
+			if want := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC); !e.CreatedAt.Equal(want) || e.Size != int64(len("manual")) {
+				t.Errorf("manual.zip = %+v, want created at %v with size %d", e, want, len("manual"))
+			}
+		case "users/u1/diarum-export-20261018-030000.diarum.enc":
+			if e.Kind != ModeUsers || e.UserID != "u1" || e.Size != int64(len("user archive")) {
+				t.Errorf("user archive = %+v", e)
+			}
+		}
+	}
+
+	// Retention runs per series: two days of snapshots, two days of u1 archives
+	result := &RunResult{}
+	s.prune(fsys, result)
+	sort.Strings(result.Deleted)
+	wantDeleted := []string{
+		"snapshot/diarum-snapshot-20261016-030000.zip",
+		"snapshot/manual.zip",
+	}
+	if !reflect.DeepEqual(result.Deleted, wantDeleted) || len(result.Failed) > 0 {
+		t.Fatalf("prune deleted %v (failed %v), want %v", result.Deleted, result.Failed, wantDeleted)
+	}
+	wantKeys := []string{
+		"notes.txt",
+		"snapshot/diarum-snapshot-20261017-030000.zip",
+		"snapshot/diarum-snapshot-20261018-030000.zip",
+		"users/u1/diarum-export-20261010-030000.zip",
+		"users/u1/diarum-export-20261018-030000.diarum.enc",
+	}
+	if keys := fake.keys(); !reflect.DeepEqual(keys, wantKeys) {
+		t.Fatalf("objects after prune = %v, want %v", keys, wantKeys)
+	}
+
+	// Restores download the archive from the target
+	reader, err := fsys.GetFile("users/u1/diarum-export-20261018-030000.diarum.enc")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("read backup: %v", err)
+	}
+	if string(data) != "user archive" {
+		t.Fatalf("downloaded %q, want %q", data, "user archive")
+	}
+}
@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Backup modes
+const (
+	ModeSnapshot = "snapshot" // consistent instance snapshot (SQLite DB, vectors, storage)
+	ModeUsers    = "users"    // one export archive per user
+	ModeBoth     = "both"
+)
+
+// Config holds the backup settings, read from DIARUM_BACKUP_* environment variables
+type Config struct {
+	// Cron is the schedule expression, e.g. "0 3 * * *". Empty disables scheduled backups.
+	Cron string
+	Mode string
+
+	// Dir is the local target directory, used when no S3 bucket is configured
+	Dir string
+	S3  S3Config
+
+	// Passphrase encrypts per-user archives (and lets them include secrets)
+	Passphrase string
+
+	// GFS retention: number of daily, weekly and monthly backups to keep
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+}
+
+// S3Config describes an S3-compatible backup target (AWS, MinIO, R2, ...)
+type S3Config struct {
+	Bucket         string
+	Region         string
+	Endpoint       string
+	AccessKey      string
+	SecretKey      string
+	ForcePathStyle bool
+}
+
+// LoadConfig reads the backup config from the environment
+func LoadConfig(dataDir string) Config {
+	cfg := Config{
+		Cron:        strings.TrimSpace(os.Getenv("DIARUM_BACKUP_CRON")),
+		Mode:        strings.ToLower(strings.TrimSpace(os.Getenv("DIARUM_BACKUP_MODE"))),
+		Dir:         os.Getenv("DIARUM_BACKUP_DIR"),
+		Passphrase:  os.Getenv("DIARUM_BACKUP_PASSPHRASE"),
+		KeepDaily:   envInt("DIARUM_BACKUP_KEEP_DAILY", 7),
+		KeepWeekly:  envInt("DIARUM_BACKUP_KEEP_WEEKLY", 4),
+		KeepMonthly: envInt("DIARUM_BACKUP_KEEP_MONTHLY", 6),
+		S3: S3Config{
+			Bucket:         os.Getenv("DIARUM_BACKUP_S3_BUCKET"),
+			Region:         os.Getenv("DIARUM_BACKUP_S3_REGION"),
+			Endpoint:       os.Getenv("DIARUM_BACKUP_S3_ENDPOINT"),
+			AccessKey:      os.Getenv("DIARUM_BACKUP_S3_ACCESS_KEY"),
+			SecretKey:      os.Getenv("DIARUM_BACKUP_S3_SECRET_KEY"),
+			ForcePathStyle: os.Getenv("DIARUM_BACKUP_S3_FORCE_PATH_STYLE") == "true",
+		},
+	}
+
+	if cfg.Mode != ModeUsers && cfg.Mode != ModeBoth {
+		cfg.Mode = ModeSnapshot
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = filepath.Join(dataDir, "diarum_backups")
+	}
+	if cfg.S3.Region == "" {
+		cfg.S3.Region = "us-east-1"
+	}
+
+	return cfg
+}
+
+// UsesS3 reports whether backups are written to an S3-compatible target
+func (c Config) UsesS3() bool {
+	return c.S3.Bucket != ""
+}
+
+// envInt reads a non-negative integer from the environment
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v < 0 {
+		return def
+	}
+	return v
+}
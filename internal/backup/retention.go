@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// selectExpired applies grandfather-father-son retention to one series of backups
+// and returns the ones to delete. The newest backup of each of the last KeepDaily
+// days, KeepWeekly ISO weeks and KeepMonthly months is kept, and the newest
+// backup overall is always kept.
+func selectExpired(entries []Entry, cfg Config) []Entry {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	sorted := make([]Entry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+
+	keep := map[string]bool{sorted[0].Key: true}
+	keepPeriods := func(limit int, period func(time.Time) string) {
+		seen := make(map[string]bool)
+		for _, e := range sorted {
+			if len(seen) >= limit {
+				return
+			}
+			p := period(e.CreatedAt)
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			keep[e.Key] = true
+		}
+	}
+
+	keepPeriods(cfg.KeepDaily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	keepPeriods(cfg.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepPeriods(cfg.KeepMonthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+
+	var expired []Entry
+	for _, e := range sorted {
+		if !keep[e.Key] {
+			expired = append(expired, e)
+		}
+	}
+	return expired
+}
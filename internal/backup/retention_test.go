@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSelectExpired(t *testing.T) {
+	// at creates an entry keyed by its creation time
+	at := func(s string) Entry {
+		created, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatalf("invalid time %q: %v", s, err)
+		}
+		return Entry{Key: s, CreatedAt: created}
+	}
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		entries []string
+		expired []string
+	}{
+		{
+			name:    "no backups",
+			cfg:     Config{KeepDaily: 7},
+			entries: nil,
+			expired: nil,
+		},
+		{
+			name:    "newest is always kept",
+			cfg:     Config{},
+			entries: []string{"2026-10-16 03:00", "2026-10-18 03:00", "2026-10-17 03:00"},
+			expired: []string{"2026-10-16 03:00", "2026-10-17 03:00"},
+		},
+		{
+			name: "daily keeps the newest of each day",
+			cfg:  Config{KeepDaily: 3},
+			entries: []string{
+				"2026-10-15 03:00", "2026-10-18 03:00", "2026-10-16 03:00",
+				"2026-10-18 09:00", "2026-10-17 03:00",
+			},
+			expired: []string{"2026-10-15 03:00", "2026-10-18 03:00"},
+		},
+		{
+			name: "weekly keeps the newest of each ISO week",
+			cfg:  Config{KeepWeekly: 2},
+			entries: []string{
+				"2026-10-18 03:00", // Sunday
+				"2026-10-14 03:00",
+				"2026-10-12 03:00", // Monday
+				"2026-10-11 03:00", // Sunday of the week before
+				"2026-10-05 03:00",
+				"2026-10-04 03:00",
+			},
+			expired: []string{"2026-10-04 03:00", "2026-10-05 03:00", "2026-10-12 03:00", "2026-10-14 03:00"},
+		},
+		{
+			name: "ISO weeks span the turn of the year",
+			cfg:  Config{KeepWeekly: 2},
+			entries: []string{
+				"2026-01-01 03:00", // 2026-W01
+				"2025-12-29 03:00", // also 2026-W01
+				"2025-12-28 03:00", // 2025-W52
+				"2025-12-22 03:00",
+			},
+			expired: []string{"2025-12-22 03:00", "2025-12-29 03:00"},
+		},
+		{
+			name: "monthly keeps the newest of each month",
+			cfg:  Config{KeepMonthly: 2},
+			entries: []string{
+				"2026-10-18 03:00", "2026-10-01 03:00", "2026-09-30 03:00",
+				"2026-09-01 03:00", "2026-08-31 03:00",
+			},
+			expired: []string{"2026-08-31 03:00", "2026-09-01 03:00", "2026-10-01 03:00"},
+		},
+		{
+			name: "tiers overlap",
+			cfg:  Config{KeepDaily: 1, KeepWeekly: 1, KeepMonthly: 2},
+			entries: []string{
+				"2026-10-18 03:00", "2026-10-17 03:00", "2026-10-12 03:00",
+				"2026-09-30 03:00", "2026-09-15 03:00",
+			},
+			expired: []string{"2026-09-15 03:00", "2026-10-12 03:00", "2026-10-17 03:00"},
+		},
+		{
+			name: "default policy keeps everything recent",
+			cfg:  Config{KeepDaily: 7, KeepWeekly: 4, KeepMonthly: 6},
+			entries: []string{
+				"2026-10-18 03:00", "2026-10-17 03:00", "2026-10-16 03:00",
+			},
+			expired: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := make([]Entry, 0, len(tt.entries))
+			for _, s := range tt.entries {
+				entries = append(entries, at(s))
+			}
+
+			var expired []string
+			for _, e := range selectExpired(entries, tt.cfg) {
+				expired = append(expired, e.Key)
+			}
+			sort.Strings(expired)
+
+			if !reflect.DeepEqual(expired, tt.expired) {
+				t.Fatalf("expired = %v, want %v", expired, tt.expired)
+			}
+		})
+	}
+}
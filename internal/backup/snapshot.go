@@ -0,0 +1,248 @@
+package backup
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+)
+
+const (
+	snapshotDBFile = "data.db"
+	// restorePendingDir holds an extracted snapshot until the next start
+	restorePendingDir = "restore_pending"
+	// restoredOldDir keeps the replaced data so a bad restore can be undone by hand
+	restoredOldDir = "pre_restore"
+)
+
+// snapshotDirs are the data dir entries bundled with the database
+var snapshotDirs = []string{"vectors", "storage"}
+
+// writeSnapshot writes a ZIP snapshot of the instance to path.
+// The SQLite database is copied with VACUUM INTO, which produces a consistent
+// copy without blocking writers. The vectors dir can always be rebuilt from
+// the diaries, and local storage is skipped when PocketBase stores files in S3.
+func writeSnapshot(app *pocketbase.PocketBase, path string) error {
+	tempDir, err := os.MkdirTemp(app.DataDir(), ".diarum_snapshot_")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dbCopy := filepath.Join(tempDir, snapshotDBFile)
+	if _, err := app.Dao().DB().NewQuery("VACUUM INTO {:path}").Bind(map[string]any{"path": dbCopy}).Execute(); err != nil {
+		return fmt.Errorf("failed to copy database: %w", err)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	if err := addFileToZip(zw, dbCopy, snapshotDBFile); err != nil {
+		return err
+	}
+
+	for _, dir := range snapshotDirs {
+		if dir == "storage" && app.Settings().S3.Enabled {
+			continue
+		}
+		if err := addDirToZip(zw, filepath.Join(app.DataDir(), dir), dir); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finalize snapshot: %w", err)
+	}
+	return out.Close()
+}
+
+// addDirToZip adds every regular file under root to the archive below prefix.
+// A missing root is not an error.
+func addDirToZip(zw *zip.Writer, root, prefix string) error {
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return addFileToZip(zw, path, prefix+"/"+filepath.ToSlash(rel))
+	})
+}
+
+// addFileToZip copies a file from disk into the archive
+func addFileToZip(zw *zip.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer f.Close()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// stageSnapshot extracts a snapshot ZIP into <dataDir>/restore_pending.
+// The snapshot is applied by ApplyPendingRestore on the next start, because
+// the database cannot be replaced while the server has it open.
+func stageSnapshot(dataDir, zipPath string) error {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer zr.Close()
+
+	hasDB := false
+	for _, zf := range zr.File {
+		if zf.Name == snapshotDBFile {
+			hasDB = true
+			break
+		}
+	}
+	if !hasDB {
+		return fmt.Errorf("snapshot does not contain %s", snapshotDBFile)
+	}
+
+	staging := filepath.Join(dataDir, restorePendingDir+".tmp")
+	os.RemoveAll(staging)
+	if err := os.MkdirAll(staging, 0o755); err != nil {
+		return fmt.Errorf("failed to create staging dir: %w", err)
+	}
+
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		if !isAllowedSnapshotPath(zf.Name) {
+			os.RemoveAll(staging)
+			return fmt.Errorf("snapshot contains unexpected path: %s", zf.Name)
+		}
+		if err := extractZipFile(zf, filepath.Join(staging, filepath.FromSlash(zf.Name))); err != nil {
+			os.RemoveAll(staging)
+			return err
+		}
+	}
+
+	pending := filepath.Join(dataDir, restorePendingDir)
+	if err := os.RemoveAll(pending); err != nil {
+		return fmt.Errorf("failed to clear previous pending restore: %w", err)
+	}
+	return os.Rename(staging, pending)
+}
+
+// isAllowedSnapshotPath only accepts the database and files below the snapshot dirs
+func isAllowedSnapshotPath(name string) bool {
+	if name == snapshotDBFile {
+		return true
+	}
+	if strings.Contains(name, "..") || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return false
+	}
+	for _, dir := range snapshotDirs {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// extractZipFile writes a single ZIP entry to dst
+func extractZipFile(zf *zip.File, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("failed to create dir for %s: %w", zf.Name, err)
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", zf.Name, err)
+	}
+	defer rc.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", zf.Name, err)
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to extract %s: %w", zf.Name, err)
+	}
+	return out.Close()
+}
+
+// HasPendingRestore reports whether a staged snapshot is waiting for a restart
+func HasPendingRestore(dataDir string) bool {
+	_, err := os.Stat(filepath.Join(dataDir, restorePendingDir, snapshotDBFile))
+	return err == nil
+}
+
+// ApplyPendingRestore replaces the database, vectors and storage with a staged
+// snapshot. It must run before the database is opened. The replaced files are
+// moved to <dataDir>/pre_restore/<timestamp>.
+func ApplyPendingRestore(dataDir string) error {
+	if !HasPendingRestore(dataDir) {
+		return nil
+	}
+	pending := filepath.Join(dataDir, restorePendingDir)
+	oldDir := filepath.Join(dataDir, restoredOldDir, time.Now().UTC().Format("20060102-150405"))
+	if err := os.MkdirAll(oldDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", oldDir, err)
+	}
+
+	// Stale WAL files belong to the old database and must not be replayed on the new one
+	for _, name := range []string{snapshotDBFile, snapshotDBFile + "-wal", snapshotDBFile + "-shm"} {
+		if err := moveIfExists(filepath.Join(dataDir, name), filepath.Join(oldDir, name)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(filepath.Join(pending, snapshotDBFile), filepath.Join(dataDir, snapshotDBFile)); err != nil {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+
+	for _, dir := range snapshotDirs {
+		src := filepath.Join(pending, dir)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			// Not in the snapshot (e.g. storage lives in S3): keep the current one
+			continue
+		}
+		if err := moveIfExists(filepath.Join(dataDir, dir), filepath.Join(oldDir, dir)); err != nil {
+			return err
+		}
+		if err := os.Rename(src, filepath.Join(dataDir, dir)); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", dir, err)
+		}
+	}
+
+	return os.RemoveAll(pending)
+}
+
+// moveIfExists renames src to dst when src exists
+func moveIfExists(src, dst string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("failed to move %s: %w", src, err)
+	}
+	return nil
+}
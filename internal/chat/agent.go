@@ -0,0 +1,210 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// Agent loop limits
+const (
+	defaultMaxSteps = 5
+	maxStepsLimit   = 20
+)
+
+// ToolResult is the outcome of a single tool call
+type ToolResult struct {
+	ToolCallID string
+	Name       string
+	Content    string
+	DiaryIDs   []string
+	Err        error
+}
+
+// getMaxSteps returns the user's configured number of model calls per chat turn
+func (s *ChatService) getMaxSteps(userID string) int {
+	steps, err := s.configService.GetInt(userID, "ai.max_steps")
+	if err != nil || steps <= 0 {
+		return defaultMaxSteps
+	}
+	if steps > maxStepsLimit {
+		return maxStepsLimit
+	}
+	return steps
+}
+
+// runToolCalls executes the tool calls of one step concurrently and returns the results in call order.
+// Each result is limited to about maxTokens.
+func (s *ChatService) runToolCalls(ctx context.Context, userID, conversationID string, calls []ToolCall, maxTokens int) []ToolResult {
+	results := make([]ToolResult, len(calls))
+
+	var wg sync.WaitGroup
+	for i, tc := range calls {
+		wg.Add(1)
+		go func(i int, tc ToolCall) {
+			defer wg.Done()
+			content, ids, err := s.executeTool(ctx, userID, conversationID, tc, maxTokens)
+			if err != nil {
+				logger.Error("[ChatService] %s failed: %v", tc.Function.Name, err)
+				content = "Error: " + err.Error()
+			}
+			results[i] = ToolResult{
+				ToolCallID: tc.ID,
+				Name:       tc.Function.Name,
+				Content:    truncateToTokens(content, maxTokens),
+				DiaryIDs:   ids,
+				Err:        err,
+			}
+		}(i, tc)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// writeToolCallEvent streams a tool call to the client
+func writeToolCallEvent(writer StreamWriter, step int, tc ToolCall) {
+	WriteEvent(writer, EventToolCall, ToolCallEvent{
+		ID:        tc.ID,
+		Name:      tc.Function.Name,
+		Arguments: tc.Function.Arguments,
+		Step:      step,
+	})
+}
+
+// writeToolResultEvent streams a tool result to the client
+func writeToolResultEvent(writer StreamWriter, step int, r ToolResult) {
+	event := ToolResultEvent{
+		ID:                r.ToolCallID,
+		Name:              r.Name,
+		Step:              step,
+		Content:           truncateRunes(r.Content, 500),
+		ReferencedDiaries: r.DiaryIDs,
+	}
+	if r.Err != nil {
+		event.Error = r.Err.Error()
+	}
+	WriteEvent(writer, EventToolResult, event)
+}
+
+// writeCitationEvent streams a validated citation to the client
+func writeCitationEvent(writer StreamWriter, c Citation) {
+	WriteEvent(writer, EventCitation, c)
+}
+
+// saveChatMessage persists a chat message including its tool call fields
+// and appends it to the conversation's active branch
+func (s *ChatService) saveChatMessage(userID, conversationID string, msg ChatMessage, referencedDiaries []string) (*models.Record, error) {
+	collection, err := s.app.Dao().FindCollectionByNameOrId("ai_messages")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find messages collection: %w", err)
+	}
+	conv, err := s.app.Dao().FindRecordById("ai_conversations", conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find conversation: %w", err)
+	}
+
+	record := models.NewRecord(collection)
+	record.Set("conversation", conversationID)
+	record.Set("parent", conv.GetString("active_message"))
+	record.Set("role", msg.Role)
+	record.Set("content", msg.Content)
+	record.Set("owner", userID)
+	if len(referencedDiaries) > 0 {
+		record.Set("referenced_diaries", referencedDiaries)
+	}
+	if len(msg.ToolCalls) > 0 {
+		record.Set("tool_calls", msg.ToolCalls)
+	}
+	if msg.ToolCallID != "" {
+		record.Set("tool_call_id", msg.ToolCallID)
+	}
+
+	if err := s.app.Dao().SaveRecord(record); err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+
+	conv.Set("active_message", record.Id)
+	if err := s.app.Dao().SaveRecord(conv); err != nil {
+		return nil, fmt.Errorf("failed to update active branch: %w", err)
+	}
+
+	return record, nil
+}
+
+// recordToChatMessage converts a stored message into a model message
+func recordToChatMessage(record *models.Record) ChatMessage {
+	msg := ChatMessage{
+		Role:       record.GetString("role"),
+		Content:    record.GetString("content"),
+		ToolCallID: record.GetString("tool_call_id"),
+	}
+	if raw := record.GetString("tool_calls"); raw != "" && raw != "null" {
+		if err := json.Unmarshal([]byte(raw), &msg.ToolCalls); err != nil {
+			logger.Warn("[ChatService] invalid tool_calls on message %s: %v", record.Id, err)
+		}
+	}
+	return msg
+}
+
+// pairToolMessages places every tool result right after the assistant message that requested it
+// and drops tool calls whose results are incomplete, which the API would reject
+func pairToolMessages(messages []ChatMessage) []ChatMessage {
+	results := make(map[string]ChatMessage)
+	for _, msg := range messages {
+		if msg.Role == "tool" && msg.ToolCallID != "" {
+			results[msg.ToolCallID] = msg
+		}
+	}
+
+	paired := make([]ChatMessage, 0, len(messages))
+	for _, msg := range messages {
+		switch {
+		case msg.Role == "tool":
+			// Emitted together with its assistant message
+			continue
+		case msg.Role == "assistant" && len(msg.ToolCalls) > 0:
+			complete := true
+			for _, tc := range msg.ToolCalls {
+				if _, ok := results[tc.ID]; !ok {
+					complete = false
+					break
+				}
+			}
+			if !complete {
+				if msg.Content != "" {
+					paired = append(paired, ChatMessage{Role: msg.Role, Content: msg.Content})
+				}
+				continue
+			}
+			paired = append(paired, msg)
+			for _, tc := range msg.ToolCalls {
+				paired = append(paired, results[tc.ID])
+			}
+		default:
+			paired = append(paired, msg)
+		}
+	}
+	return paired
+}
+
+// appendUnique appends the IDs that are not yet in the list
+func appendUnique(list []string, ids ...string) []string {
+	for _, id := range ids {
+		exists := false
+		for _, existing := range list {
+			if existing == id {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			list = append(list, id)
+		}
+	}
+	return list
+}
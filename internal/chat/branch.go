@@ -0,0 +1,267 @@
+package chat
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// BranchInfo describes the alternative branches at a message of the active branch
+type BranchInfo struct {
+	// Index is the 1-based position of the active alternative
+	Index int `json:"index"`
+	Count int `json:"count"`
+	// Siblings are the first messages of the alternatives, oldest first
+	Siblings []string `json:"siblings"`
+}
+
+// conversationTree holds all messages of a conversation
+type conversationTree struct {
+	messages []*models.Record
+	byID     map[string]*models.Record
+	children map[string][]*models.Record
+}
+
+// loadTree loads all messages of a conversation, oldest first
+func (s *ChatService) loadTree(conversationID string) (*conversationTree, error) {
+	messages, err := s.app.Dao().FindRecordsByFilter(
+		"ai_messages",
+		"conversation = {:conv}",
+		"created",
+		0,
+		0,
+		map[string]any{"conv": conversationID},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	tree := &conversationTree{
+		messages: messages,
+		byID:     make(map[string]*models.Record, len(messages)),
+		children: make(map[string][]*models.Record),
+	}
+	for _, msg := range messages {
+		tree.byID[msg.Id] = msg
+		parent := msg.GetString("parent")
+		tree.children[parent] = append(tree.children[parent], msg)
+	}
+	return tree, nil
+}
+
+// branch returns the messages from the root to leaf, oldest first
+func (t *conversationTree) branch(leaf string) []*models.Record {
+	var path []*models.Record
+	for id := leaf; id != "" && len(path) <= len(t.messages); {
+		msg, ok := t.byID[id]
+		if !ok {
+			break
+		}
+		path = append(path, msg)
+		id = msg.GetString("parent")
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// activeLeaf returns the last message of the conversation's active branch.
+// Without a valid pointer the newest message is used.
+func (t *conversationTree) activeLeaf(conv *models.Record) string {
+	if leaf := conv.GetString("active_message"); t.byID[leaf] != nil {
+		return leaf
+	}
+	if n := len(t.messages); n > 0 {
+		return t.messages[n-1].Id
+	}
+	return ""
+}
+
+// latestLeaf follows the newest children from a message down to a leaf
+func (t *conversationTree) latestLeaf(id string) string {
+	for i := 0; i <= len(t.messages); i++ {
+		children := t.children[id]
+		if len(children) == 0 {
+			break
+		}
+		id = children[len(children)-1].Id
+	}
+	return id
+}
+
+// activeBranch returns the messages of the conversation's active branch, oldest first
+func (s *ChatService) activeBranch(conv *models.Record) ([]*models.Record, error) {
+	tree, err := s.loadTree(conv.Id)
+	if err != nil {
+		return nil, err
+	}
+	return tree.branch(tree.activeLeaf(conv)), nil
+}
+
+// GetActiveBranch returns the messages of the conversation's active branch, oldest first,
+// and its alternative branches keyed by the ID of the visible message they belong to
+func (s *ChatService) GetActiveBranch(conversationID string) ([]*models.Record, map[string]BranchInfo, error) {
+	conv, err := s.app.Dao().FindRecordById("ai_conversations", conversationID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("conversation not found: %w", err)
+	}
+	tree, err := s.loadTree(conv.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path := tree.branch(tree.activeLeaf(conv))
+	branches := make(map[string]BranchInfo)
+	for i, msg := range path {
+		siblings := tree.children[msg.GetString("parent")]
+		if len(siblings) < 2 {
+			continue
+		}
+		info := BranchInfo{Count: len(siblings)}
+		for j, sibling := range siblings {
+			info.Siblings = append(info.Siblings, sibling.Id)
+			if sibling.Id == msg.Id {
+				info.Index = j + 1
+			}
+		}
+
+		// A regenerated answer may start with hidden tool steps,
+		// the alternatives are shown on its first visible message
+		for _, next := range path[i:] {
+			if next != msg && next.GetString("role") == "user" {
+				break
+			}
+			if next.GetString("role") != "tool" && next.GetString("content") != "" {
+				branches[next.Id] = info
+				break
+			}
+		}
+	}
+	return path, branches, nil
+}
+
+// setActiveMessage moves the conversation's active branch to end at leaf and drops the
+// summary if it covers messages that are no longer part of the branch. fork is the last
+// message shared with the previous branch, nil if they share none.
+func (s *ChatService) setActiveMessage(conv *models.Record, leaf string, fork *models.Record) error {
+	conv.Set("active_message", leaf)
+
+	if until := conv.GetDateTime("summarized_until"); !until.IsZero() {
+		if fork == nil || until.Time().After(fork.Created.Time()) {
+			logger.Info("[ChatService] branch of conversation %s changed before its summary, dropping the summary", conv.Id)
+			conv.Set("summary", "")
+			conv.Set("summarized_until", "")
+		}
+	}
+
+	if err := s.app.Dao().SaveRecord(conv); err != nil {
+		return fmt.Errorf("failed to update active branch: %w", err)
+	}
+	return nil
+}
+
+// findConversationMessage returns a message of the conversation
+func (s *ChatService) findConversationMessage(conversationID, messageID string) (*models.Record, error) {
+	msg, err := s.app.Dao().FindRecordById("ai_messages", messageID)
+	if err != nil || msg.GetString("conversation") != conversationID {
+		return nil, fmt.Errorf("message not found")
+	}
+	return msg, nil
+}
+
+// PrepareRegenerate starts a new branch for another answer to a message. For an assistant
+// or tool message the user message it answers is used. The user message is returned and
+// becomes the end of the active branch, so the next saved messages form the new answer.
+func (s *ChatService) PrepareRegenerate(conversationID, messageID string) (*models.Record, error) {
+	conv, err := s.app.Dao().FindRecordById("ai_conversations", conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("conversation not found: %w", err)
+	}
+	tree, err := s.loadTree(conv.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, ok := tree.byID[messageID]
+	if !ok {
+		return nil, fmt.Errorf("message not found")
+	}
+	for msg.GetString("role") != "user" {
+		parent, ok := tree.byID[msg.GetString("parent")]
+		if !ok {
+			return nil, fmt.Errorf("no user message to answer")
+		}
+		msg = parent
+	}
+
+	if err := s.setActiveMessage(conv, msg.Id, msg); err != nil {
+		return nil, err
+	}
+
+	logger.Info("[ChatService] regenerating answer to message %s in conversation %s", msg.Id, conv.Id)
+	return msg, nil
+}
+
+// EditMessage saves an edited copy of a user message as a new branch next to the original
+// and makes it the end of the active branch
+func (s *ChatService) EditMessage(userID, conversationID, messageID, content string) (*models.Record, error) {
+	conv, err := s.app.Dao().FindRecordById("ai_conversations", conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("conversation not found: %w", err)
+	}
+	msg, err := s.findConversationMessage(conv.Id, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.GetString("role") != "user" {
+		return nil, fmt.Errorf("only user messages can be edited")
+	}
+
+	var fork *models.Record
+	if parentID := msg.GetString("parent"); parentID != "" {
+		if fork, err = s.findConversationMessage(conv.Id, parentID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.setActiveMessage(conv, msg.GetString("parent"), fork); err != nil {
+		return nil, err
+	}
+
+	logger.Info("[ChatService] editing message %s in conversation %s", msg.Id, conv.Id)
+	return s.saveChatMessage(userID, conv.Id, ChatMessage{Role: "user", Content: content}, nil)
+}
+
+// SwitchBranch makes the branch through a message active, following its newest continuation
+func (s *ChatService) SwitchBranch(conversationID, messageID string) error {
+	conv, err := s.app.Dao().FindRecordById("ai_conversations", conversationID)
+	if err != nil {
+		return fmt.Errorf("conversation not found: %w", err)
+	}
+	tree, err := s.loadTree(conv.Id)
+	if err != nil {
+		return err
+	}
+	if _, ok := tree.byID[messageID]; !ok {
+		return fmt.Errorf("message not found")
+	}
+
+	current := make(map[string]bool)
+	for _, msg := range tree.branch(tree.activeLeaf(conv)) {
+		current[msg.Id] = true
+	}
+
+	// The fork is the newest message the branches share
+	leaf := tree.latestLeaf(messageID)
+	var fork *models.Record
+	path := tree.branch(leaf)
+	for i := len(path) - 1; i >= 0; i-- {
+		if current[path[i].Id] {
+			fork = path[i]
+			break
+		}
+	}
+
+	return s.setActiveMessage(conv, leaf, fork)
+}
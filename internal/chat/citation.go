@@ -0,0 +1,243 @@
+package chat
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/songtianlun/diarum/internal/logger"
+	"github.com/songtianlun/diarum/internal/markdown"
+)
+
+// citationPattern matches [[2026-03-14]] and [[2026-03-14|quoted words]]
+var citationPattern = regexp.MustCompile(`\[\[(\d{4}-\d{2}-\d{2})(?:\|([^\]\n]{1,` + strconv.Itoa(maxMarkerQuoteRunes) + `}))?\]\]`)
+
+// Citation limits
+const (
+	// maxMarkerQuoteRunes is the longest quote citationPattern matches in a marker
+	maxMarkerQuoteRunes = 300
+	// maxMarkerLen is the longest citation marker in bytes, used to limit rescanning.
+	// Quotes are counted in runes, which take up to utf8.UTFMax bytes, e.g. 3 for Chinese.
+	maxMarkerLen = len("[[2006-01-02|") + maxMarkerQuoteRunes*utf8.UTFMax + len("]]")
+	// maxQuoteRunes is the longest quote attached to a citation
+	maxQuoteRunes = 200
+	// claimRunes is how much of the answer before a marker is taken as the cited statement
+	claimRunes = 200
+)
+
+// Citation links a statement in an answer to the diary entry it is based on
+type Citation struct {
+	Index   int    `json:"index"`
+	Marker  string `json:"marker"`
+	DiaryID string `json:"diary_id"`
+	Date    string `json:"date"`
+	Quote   string `json:"quote"`
+}
+
+// citationSource is a diary the model may cite
+type citationSource struct {
+	id   string
+	date string
+	text string
+}
+
+// citationTracker finds citation markers in streamed answer content and
+// validates them against the diaries retrieved by tools during the turn
+type citationTracker struct {
+	sources   map[string]citationSource
+	cited     map[string]bool
+	citations []Citation
+	content   strings.Builder
+	scanned   int
+}
+
+func newCitationTracker() *citationTracker {
+	return &citationTracker{
+		sources: make(map[string]citationSource),
+		cited:   make(map[string]bool),
+	}
+}
+
+// addDiaries registers diary records as citable
+func (t *citationTracker) addDiaries(records []*models.Record) {
+	for _, record := range records {
+		date := record.GetString("date")
+		if len(date) >= 10 {
+			date = date[:10]
+		}
+		t.sources[date] = citationSource{
+			id:   record.Id,
+			date: date,
+			text: strings.TrimSpace(markdown.FromHTML(record.GetString("content"), markdown.Options{})),
+		}
+	}
+}
+
+// newStep starts the content of a new model call, markers do not span calls
+func (t *citationTracker) newStep() {
+	t.content.Reset()
+	t.scanned = 0
+}
+
+// write adds streamed content and returns the new valid citations it completes
+func (t *citationTracker) write(delta string) []Citation {
+	t.content.WriteString(delta)
+	// String does not copy the builder's buffer
+	content := t.content.String()
+
+	var found []Citation
+	base := t.scanned
+	for _, loc := range citationPattern.FindAllStringSubmatchIndex(content[base:], -1) {
+		start, end := base+loc[0], base+loc[1]
+		date := content[base+loc[2] : base+loc[3]]
+		quote := ""
+		if loc[4] >= 0 {
+			quote = content[base+loc[4] : base+loc[5]]
+		}
+		if c, ok := t.cite(content[start:end], date, quote, content[:start]); ok {
+			found = append(found, c)
+		}
+		t.scanned = end
+	}
+
+	// Only the tail can still become part of a marker
+	if tail := len(content) - maxMarkerLen; tail > t.scanned {
+		t.scanned = tail
+	}
+	return found
+}
+
+// cite validates a marker and records the citation the first time a diary is cited
+func (t *citationTracker) cite(marker, date, quote, before string) (Citation, bool) {
+	source, ok := t.sources[date]
+	if !ok {
+		logger.Debug("[ChatService] ignoring citation of %s, not among the retrieved diaries", date)
+		return Citation{}, false
+	}
+	if t.cited[source.id] {
+		return Citation{}, false
+	}
+
+	// A quote given by the model is kept only if the diary really contains it
+	if !containsQuote(source.text, quote) {
+		quote = bestQuote(source.text, lastStatement(before))
+	}
+
+	c := Citation{
+		Index:   len(t.citations) + 1,
+		Marker:  marker,
+		DiaryID: source.id,
+		Date:    date,
+		Quote:   truncateRunes(quote, maxQuoteRunes),
+	}
+	t.cited[source.id] = true
+	t.citations = append(t.citations, c)
+	return c, true
+}
+
+// diaryIDs returns the IDs of the cited diaries in citation order
+func (t *citationTracker) diaryIDs() []string {
+	ids := make([]string, 0, len(t.citations))
+	for _, c := range t.citations {
+		ids = append(ids, c.DiaryID)
+	}
+	return ids
+}
+
+// containsQuote reports whether text contains quote, ignoring case, spacing and quotation marks
+func containsQuote(text, quote string) bool {
+	quote = normalizeQuote(strings.Trim(quote, " \"'“”‘’「」『』"))
+	if quote == "" {
+		return false
+	}
+	return strings.Contains(normalizeQuote(text), quote)
+}
+
+func normalizeQuote(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// lastStatement returns the sentence of the answer right before a citation marker
+func lastStatement(before string) string {
+	before = citationPattern.ReplaceAllString(before, "")
+	if utf8.RuneCountInString(before) > claimRunes {
+		runes := []rune(before)
+		before = string(runes[len(runes)-claimRunes:])
+	}
+	before = strings.TrimRightFunc(before, func(r rune) bool {
+		return unicode.IsSpace(r) || isSentenceEnd(r)
+	})
+	if i := strings.LastIndexFunc(before, isSentenceEnd); i >= 0 {
+		before = before[i:]
+		_, size := utf8.DecodeRuneInString(before)
+		before = before[size:]
+	}
+	return strings.TrimSpace(before)
+}
+
+// bestQuote returns the sentence of a diary that shares the most terms with the cited statement
+func bestQuote(text, statement string) string {
+	terms := statementTerms(statement)
+	if len(terms) == 0 {
+		return ""
+	}
+
+	best, bestScore := "", 0
+	for _, sentence := range splitSentences(text) {
+		if score := termScore(sentence, terms); score > bestScore {
+			best, bestScore = sentence, score
+		}
+	}
+	return best
+}
+
+// statementTerms returns the search terms of a statement without punctuation and short words
+func statementTerms(statement string) []string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return ' '
+		}
+		return r
+	}, statement)
+
+	var terms []string
+	for _, term := range queryTerms(cleaned) {
+		runes := []rune(term)
+		if !isCJK(runes[0]) && len(runes) < 3 {
+			continue
+		}
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// splitSentences splits text into trimmed sentences, keeping their end punctuation
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	for i, r := range text {
+		if !isSentenceEnd(r) {
+			continue
+		}
+		end := i + utf8.RuneLen(r)
+		if s := strings.TrimSpace(text[start:end]); s != "" {
+			sentences = append(sentences, s)
+		}
+		start = end
+	}
+	if s := strings.TrimSpace(text[start:]); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+func isSentenceEnd(r rune) bool {
+	switch r {
+	case '.', '!', '?', '。', '！', '？', '\n':
+		return true
+	}
+	return false
+}
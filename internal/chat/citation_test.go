@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+// streamRunes writes content to the tracker a few runes at a time, like a model's
+// deltas, and returns the citations found
+func streamRunes(t *citationTracker, content string, runesPerDelta int) []Citation {
+	var found []Citation
+	runes := []rune(content)
+	for start := 0; start < len(runes); start += runesPerDelta {
+		end := min(start+runesPerDelta, len(runes))
+		found = append(found, t.write(string(runes[start:end]))...)
+	}
+	return found
+}
+
+func TestCitationWithLongCJKQuote(t *testing.T) {
+	tests := []struct {
+		name       string
+		quoteRunes int
+	}{
+		{"beyond the old byte limit", 120},
+		{"longest quote", maxMarkerQuoteRunes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote := strings.Repeat("今天和朋友去爬山，", tt.quoteRunes/9+1)
+			quote = string([]rune(quote)[:tt.quoteRunes])
+
+			tracker := newCitationTracker()
+			tracker.sources["2026-03-14"] = citationSource{
+				id:   "d1",
+				date: "2026-03-14",
+				text: "周六。" + quote + "晚上很累。",
+			}
+
+			marker := "[[2026-03-14|" + quote + "]]"
+			found := streamRunes(tracker, "你上周末去爬山了"+marker+"，心情很好。", 3)
+
+			if len(found) != 1 {
+				t.Fatalf("found %d citations, want 1", len(found))
+			}
+			c := found[0]
+			if c.DiaryID != "d1" || c.Marker != marker {
+				t.Errorf("citation = %+v", c)
+			}
+			if want := truncateRunes(quote, maxQuoteRunes); c.Quote != want {
+				t.Errorf("quote = %q, want %q", c.Quote, want)
+			}
+		})
+	}
+}
+
+func TestCitationMarkerTooLong(t *testing.T) {
+	tracker := newCitationTracker()
+	tracker.sources["2026-03-14"] = citationSource{id: "d1", date: "2026-03-14", text: "周六去爬山。"}
+
+	quote := strings.Repeat("山", maxMarkerQuoteRunes+1)
+	if found := streamRunes(tracker, "[[2026-03-14|"+quote+"]]", 3); len(found) != 0 {
+		t.Fatalf("found %+v in a marker with a quote over the limit", found)
+	}
+	// Later markers are still found
+	if found := streamRunes(tracker, "爬山[[2026-03-14]]", 3); len(found) != 1 {
+		t.Fatalf("found %d citations after the long marker, want 1", len(found))
+	}
+}
@@ -0,0 +1,209 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// clientResumeAttempts is how often Client reattaches to an answer after the stream dropped
+const clientResumeAttempts = 3
+
+// StreamEvent is an event read from a chat stream
+type StreamEvent struct {
+	// ID is used to resume the stream after the event, empty if the server sent none
+	ID   string
+	Name string
+	Data json.RawMessage
+}
+
+// Decode unmarshals the payload of the event into v
+func (e StreamEvent) Decode(v any) error {
+	if err := json.Unmarshal(e.Data, v); err != nil {
+		return fmt.Errorf("failed to decode %s event: %w", e.Name, err)
+	}
+	return nil
+}
+
+// EventReader reads the events of a chat stream, skipping keep-alive comments
+type EventReader struct {
+	r *bufio.Reader
+}
+
+// NewEventReader creates a reader for a chat stream
+func NewEventReader(r io.Reader) *EventReader {
+	return &EventReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next event, or io.EOF at the end of the stream
+func (r *EventReader) Next() (*StreamEvent, error) {
+	event := &StreamEvent{}
+	var data []string
+	for {
+		line, err := r.r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err == io.EOF && len(data) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			// A blank line ends the event, comments alone do not make one
+			if len(data) == 0 {
+				continue
+			}
+			event.Data = json.RawMessage(strings.Join(data, "\n"))
+			if event.Name == "" {
+				event.Name = "message"
+			}
+			return event, nil
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			// Comment, e.g. keep-alive
+		case "event":
+			event.Name = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			event.ID = value
+		}
+	}
+}
+
+// Client calls the chat API of a Diarum server
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient creates a chat client authenticated with a user's auth token
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{},
+	}
+}
+
+// Chat sends a message to a conversation and calls fn for each event of the answer until
+// the done or error event. A dropped stream is resumed, the answer keeps being generated
+// on the server meanwhile. An error returned by fn stops reading and is returned.
+func (c *Client) Chat(ctx context.Context, conversationID, content string, fn func(StreamEvent) error) error {
+	body, _ := json.Marshal(map[string]string{
+		"conversation_id": conversationID,
+		"content":         content,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/api/ai/chat", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	lastEventID, err := c.stream(req, fn)
+	for attempt := 0; errors.Is(err, errStreamDropped) && attempt < clientResumeAttempts && ctx.Err() == nil; attempt++ {
+		lastEventID, err = c.resume(ctx, conversationID, lastEventID, fn)
+	}
+	return err
+}
+
+// Resume reattaches to the answer being generated for a conversation and calls fn
+// for each event after lastEventID
+func (c *Client) Resume(ctx context.Context, conversationID string, lastEventID string, fn func(StreamEvent) error) error {
+	_, err := c.resume(ctx, conversationID, lastEventID, fn)
+	return err
+}
+
+func (c *Client) resume(ctx context.Context, conversationID string, lastEventID string, fn func(StreamEvent) error) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/api/ai/conversations/"+conversationID+"/stream", nil)
+	if err != nil {
+		return lastEventID, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Last-Event-ID", lastEventID)
+
+	id, err := c.stream(req, fn)
+	if id == "" {
+		id = lastEventID
+	}
+	return id, err
+}
+
+// Stop stops the answer being generated for a conversation, the partial answer is saved
+func (c *Client) Stop(ctx context.Context, conversationID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/api/ai/conversations/"+conversationID+"/stop", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// errStreamDropped is returned when a stream ended before its done or error event
+var errStreamDropped = errors.New("chat stream ended unexpectedly")
+
+// stream sends a request for a chat stream and passes its events to fn.
+// It returns the ID of the last event received.
+func (c *Client) stream(req *http.Request, fn func(StreamEvent) error) (string, error) {
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if version := resp.Header.Get(ProtocolHeader); version != "" && version != ProtocolVersion {
+		return "", fmt.Errorf("unsupported chat protocol version %s", version)
+	}
+
+	lastEventID := ""
+	reader := NewEventReader(resp.Body)
+	for {
+		event, err := reader.Next()
+		if err != nil {
+			if req.Context().Err() != nil {
+				return lastEventID, req.Context().Err()
+			}
+			return lastEventID, fmt.Errorf("%w: %v", errStreamDropped, err)
+		}
+		if event.ID != "" {
+			lastEventID = event.ID
+		}
+		if err := fn(*event); err != nil {
+			return lastEventID, err
+		}
+		if event.Name == EventDone || event.Name == EventError {
+			return lastEventID, nil
+		}
+	}
+}
+
+// do sends an authenticated request and turns error responses into errors
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", c.Token)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	}
+	return resp, nil
+}
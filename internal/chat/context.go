@@ -0,0 +1,508 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/songtianlun/diarum/internal/embedding"
+	"github.com/songtianlun/diarum/internal/markdown"
+)
+
+// Context budget settings
+const (
+	defaultContextWindow = 8192
+	// ollamaMaxContext caps num_ctx, larger windows make Ollama allocate a lot of memory
+	ollamaMaxContext = 8192
+	// maxOutputReserve is the most tokens kept free for the model's answer
+	maxOutputReserve = 4096
+	// historyToolResultTokens is how much of an earlier turn's tool result is kept
+	historyToolResultTokens = 300
+	// minDiaryTokens is the smallest useful excerpt of a diary
+	minDiaryTokens = 120
+	// minToolResultTokens is the smallest budget a tool result gets
+	minToolResultTokens = 200
+	// historyFetchLimit is how many recent messages are considered for history
+	historyFetchLimit = 100
+)
+
+// modelContextWindows maps model name prefixes to context window sizes, more specific prefixes first
+var modelContextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-5", 400000},
+	{"gpt-4.1", 1047576},
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4-32k", 32768},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"o4", 200000},
+	{"claude", 200000},
+	{"gemini", 1000000},
+	{"deepseek", 64000},
+	{"qwen", 32768},
+	{"glm-4", 128000},
+	{"moonshot-v1-8k", 8192},
+	{"moonshot-v1-32k", 32768},
+	{"moonshot-v1-128k", 128000},
+	{"kimi", 128000},
+	{"llama3.1", 128000},
+	{"llama3.2", 128000},
+	{"llama3.3", 128000},
+	{"llama-3.1", 128000},
+	{"llama-3.2", 128000},
+	{"llama-3.3", 128000},
+	{"llama3", 8192},
+	{"mistral", 32768},
+	{"mixtral", 32768},
+}
+
+// ContextUsage reports the estimated token usage of a chat turn
+type ContextUsage struct {
+	ContextWindow    int  `json:"context_window"`
+	Budget           int  `json:"budget"`
+	PromptTokens     int  `json:"prompt_tokens"`
+	CompletionTokens int  `json:"completion_tokens"`
+	TotalTokens      int  `json:"total_tokens"`
+	SystemTokens     int  `json:"system_tokens"`
+	SummaryTokens    int  `json:"summary_tokens"`
+	ToolsTokens      int  `json:"tools_tokens"`
+	HistoryTokens    int  `json:"history_tokens"`
+	HistoryMessages  int  `json:"history_messages"`
+	OmittedMessages  int  `json:"omitted_messages"`
+	ToolResultTokens int  `json:"tool_result_tokens"`
+	Steps            int  `json:"steps"`
+	Estimated        bool `json:"estimated"`
+}
+
+// contextWindowFor returns the context window of a model, an override > 0 wins
+func contextWindowFor(provider, model string, override int) int {
+	window := override
+	if window <= 0 {
+		window = defaultContextWindow
+		name := strings.ToLower(model)
+		// Strip router prefixes such as "openai/gpt-4o"
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		for _, m := range modelContextWindows {
+			if strings.HasPrefix(name, m.prefix) {
+				window = m.tokens
+				break
+			}
+		}
+	}
+	if provider == ProviderOllama && window > ollamaMaxContext && override <= 0 {
+		window = ollamaMaxContext
+	}
+	return window
+}
+
+// estimateTokens roughly estimates the token count of a text: one token per
+// CJK character and about four characters per token otherwise
+func estimateTokens(s string) int {
+	cjk, other := 0, 0
+	for _, r := range s {
+		if isCJK(r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// estimateMessageTokens estimates a message including its formatting overhead
+func estimateMessageTokens(msg ChatMessage) int {
+	tokens := 4 + estimateTokens(msg.Content)
+	for _, tc := range msg.ToolCalls {
+		tokens += 4 + estimateTokens(tc.Function.Name) + estimateTokens(tc.Function.Arguments)
+	}
+	return tokens
+}
+
+// estimateMessagesTokens estimates a list of messages
+func estimateMessagesTokens(messages []ChatMessage) int {
+	total := 0
+	for _, msg := range messages {
+		total += estimateMessageTokens(msg)
+	}
+	return total
+}
+
+// estimateToolsTokens estimates the tool definitions sent with a request
+func estimateToolsTokens(tools []Tool) int {
+	if len(tools) == 0 {
+		return 0
+	}
+	data, _ := json.Marshal(tools)
+	return estimateTokens(string(data))
+}
+
+// truncateToTokens cuts text to about maxTokens
+func truncateToTokens(s string, maxTokens int) string {
+	if estimateTokens(s) <= maxTokens {
+		return s
+	}
+	// Count in quarter tokens to match estimateTokens
+	limit := maxTokens * 4
+	cost := 0
+	for i, r := range s {
+		if isCJK(r) {
+			cost += 4
+		} else {
+			cost++
+		}
+		if cost > limit {
+			return strings.TrimSpace(s[:i]) + " …[truncated]"
+		}
+	}
+	return s
+}
+
+// contextBuilder assembles the prompt of a chat turn within the model's context window
+type contextBuilder struct {
+	window int
+	budget int
+	usage  ContextUsage
+}
+
+// newContextBuilder creates a builder for a context window, reserving room for the answer.
+// maxOutput is the configured answer limit, 0 if there is none.
+func newContextBuilder(window, maxOutput int) *contextBuilder {
+	reserve := window / 4
+	if reserve > maxOutputReserve {
+		reserve = maxOutputReserve
+	}
+	// A configured answer limit is kept free, up to half of the window
+	if maxOutput > 0 {
+		reserve = min(maxOutput, window/2)
+	}
+	b := &contextBuilder{window: window, budget: window - reserve}
+	b.usage = ContextUsage{ContextWindow: window, Budget: b.budget, Estimated: true}
+	return b
+}
+
+// build returns the initial messages of a turn: the system prompt, the conversation
+// summary, as much recent history as fits in half of the free budget, and the user's
+// message. Older history that does not fit is replaced by a short note of what the
+// user asked earlier.
+func (b *contextBuilder) build(systemPrompt, summary string, tools []Tool, history []ChatMessage, message string) []ChatMessage {
+	system := ChatMessage{Role: "system", Content: systemPrompt}
+	user := ChatMessage{Role: "user", Content: message}
+
+	b.usage.SystemTokens = estimateMessageTokens(system)
+	b.usage.ToolsTokens = estimateToolsTokens(tools)
+	fixed := b.usage.SystemTokens + b.usage.ToolsTokens + estimateMessageTokens(user)
+
+	messages := []ChatMessage{system}
+	if summary != "" {
+		// The summary may take a quarter of the free budget
+		summaryMsg := ChatMessage{
+			Role:    "system",
+			Content: "Summary of the earlier part of this conversation:\n" + truncateToTokens(summary, (b.budget-fixed)/4),
+		}
+		messages = append(messages, summaryMsg)
+		b.usage.SummaryTokens = estimateMessageTokens(summaryMsg)
+		fixed += b.usage.SummaryTokens
+	}
+
+	historyBudget := (b.budget - fixed) / 2
+	units := groupHistory(history)
+
+	// Keep the most recent units that fit
+	kept := len(units)
+	used := 0
+	for i := len(units) - 1; i >= 0; i-- {
+		tokens := estimateMessagesTokens(units[i])
+		if used+tokens > historyBudget {
+			break
+		}
+		used += tokens
+		kept = i
+	}
+	// History has to start with a user message for some providers
+	for kept < len(units) && units[kept][0].Role != "user" {
+		used -= estimateMessagesTokens(units[kept])
+		kept++
+	}
+
+	if kept > 0 {
+		omitted := 0
+		for _, unit := range units[:kept] {
+			omitted += len(unit)
+		}
+		b.usage.OmittedMessages = omitted
+		if note := summarizeOmitted(units[:kept], historyBudget/4); note != "" {
+			noteMsg := ChatMessage{Role: "system", Content: note}
+			messages = append(messages, noteMsg)
+			used += estimateMessageTokens(noteMsg)
+		}
+	}
+	for _, unit := range units[kept:] {
+		messages = append(messages, unit...)
+		b.usage.HistoryMessages += len(unit)
+	}
+	b.usage.HistoryTokens = used
+
+	return append(messages, user)
+}
+
+// remaining returns the budget left after the messages and tools
+func (b *contextBuilder) remaining(messages []ChatMessage, tools []Tool) int {
+	return b.budget - estimateMessagesTokens(messages) - estimateToolsTokens(tools)
+}
+
+// toolResultBudget splits the remaining budget between the tool calls of a step
+func (b *contextBuilder) toolResultBudget(messages []ChatMessage, tools []Tool, calls int) int {
+	if calls <= 0 {
+		calls = 1
+	}
+	// Keep some room for the next steps of the agent loop
+	budget := b.remaining(messages, tools) * 2 / 3 / calls
+	if budget < minToolResultTokens {
+		budget = minToolResultTokens
+	}
+	return budget
+}
+
+// addStep records a model call and its output
+func (b *contextBuilder) addStep(messages []ChatMessage, tools []Tool, content string, toolCalls []ToolCall) {
+	b.usage.Steps++
+	b.usage.PromptTokens = estimateMessagesTokens(messages) + estimateToolsTokens(tools)
+	b.usage.CompletionTokens += estimateMessageTokens(ChatMessage{Content: content, ToolCalls: toolCalls})
+	b.usage.TotalTokens = b.usage.PromptTokens + b.usage.CompletionTokens
+}
+
+// addToolResult records the size of a tool result added to the context
+func (b *contextBuilder) addToolResult(msg ChatMessage) {
+	b.usage.ToolResultTokens += estimateMessageTokens(msg)
+}
+
+// groupHistory splits history into units that are kept or dropped together:
+// an assistant tool call with its results, or a single message. Tool results of
+// earlier turns have been answered already and are shortened.
+func groupHistory(history []ChatMessage) [][]ChatMessage {
+	var units [][]ChatMessage
+	for _, msg := range history {
+		if msg.Role == "tool" {
+			msg.Content = truncateToTokens(msg.Content, historyToolResultTokens)
+			if n := len(units); n > 0 {
+				units[n-1] = append(units[n-1], msg)
+				continue
+			}
+			// Orphaned result, cannot be sent without its call
+			continue
+		}
+		units = append(units, []ChatMessage{msg})
+	}
+	return units
+}
+
+// summarizeOmitted lists the user questions of omitted history within maxTokens
+func summarizeOmitted(units [][]ChatMessage, maxTokens int) string {
+	header := "Earlier messages of this conversation were omitted to fit the context window. The user previously asked:\n"
+	used := estimateTokens(header)
+
+	var lines []string
+	// Newest first so the most recent questions survive the budget
+	for i := len(units) - 1; i >= 0; i-- {
+		msg := units[i][0]
+		if msg.Role != "user" || strings.TrimSpace(msg.Content) == "" {
+			continue
+		}
+		line := "- " + truncateRunes(strings.Join(strings.Fields(msg.Content), " "), 100)
+		tokens := estimateTokens(line) + 1
+		if used+tokens > maxTokens {
+			break
+		}
+		used += tokens
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	// Back to chronological order
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return header + strings.Join(lines, "\n")
+}
+
+// formatDiariesForContext formats diaries for tool result context within maxTokens
+// and returns the IDs of the diaries it included. Each diary is cut to the passages
+// most relevant to the query, and entries that do not fit at a useful length are
+// left out, preferring those that mention the query.
+func (s *ChatService) formatDiariesForContext(diaries []embedding.DiarySearchResult, query string, maxTokens int) (string, []string) {
+	if len(diaries) == 0 {
+		return "No diary entries found for the specified criteria.", nil
+	}
+
+	header := fmt.Sprintf("Found %d diary entries:\n\n", len(diaries))
+	available := maxTokens - estimateTokens(header) - 40
+
+	texts := make([]string, len(diaries))
+	for i, diary := range diaries {
+		texts[i] = strings.TrimSpace(markdown.FromHTML(diary.Content, markdown.Options{}))
+	}
+
+	shown := make([]int, 0, len(diaries))
+	for i := range diaries {
+		shown = append(shown, i)
+	}
+	if available/len(diaries) < minDiaryTokens {
+		limit := available / minDiaryTokens
+		if limit < 1 {
+			limit = 1
+		}
+		// Keep the entries that mention the query, then restore the original order
+		terms := queryTerms(query)
+		sort.SliceStable(shown, func(a, b int) bool {
+			return termScore(texts[shown[a]], terms) > termScore(texts[shown[b]], terms)
+		})
+		shown = shown[:limit]
+		sort.Ints(shown)
+	}
+	perDiary := available / len(shown)
+
+	var sb strings.Builder
+	sb.WriteString(header)
+
+	ids := make([]string, 0, len(shown))
+	for n, i := range shown {
+		diary := diaries[i]
+		ids = append(ids, diary.ID)
+
+		var entry strings.Builder
+		entry.WriteString(fmt.Sprintf("--- Diary Entry %d (Date: %s) ---\n", n+1, diary.Date))
+		if diary.Mood != "" {
+			entry.WriteString(fmt.Sprintf("Mood: %s\n", diary.Mood))
+		}
+		if diary.Weather != "" {
+			entry.WriteString(fmt.Sprintf("Weather: %s\n", diary.Weather))
+		}
+		contentBudget := perDiary - estimateTokens(entry.String()) - 4
+		entry.WriteString(fmt.Sprintf("Content:\n%s\n\n", excerpt(texts[i], query, contentBudget)))
+		sb.WriteString(entry.String())
+	}
+
+	if len(shown) < len(diaries) {
+		sb.WriteString(fmt.Sprintf("(%d more entries omitted to fit the context window. Narrow the date range or query to see them.)\n", len(diaries)-len(shown)))
+	}
+
+	return sb.String(), ids
+}
+
+// termScore counts the occurrences of the query terms in text
+func termScore(text string, terms []string) int {
+	lower := strings.ToLower(text)
+	score := 0
+	for _, term := range terms {
+		score += strings.Count(lower, term)
+	}
+	return score
+}
+
+// excerpt returns the paragraphs of text most relevant to the query within maxTokens,
+// in their original order. Without a query or matches, the beginning is kept.
+func excerpt(text, query string, maxTokens int) string {
+	if maxTokens < minDiaryTokens/2 {
+		maxTokens = minDiaryTokens / 2
+	}
+	if estimateTokens(text) <= maxTokens {
+		return text
+	}
+
+	var paragraphs []string
+	for _, p := range strings.Split(text, "\n") {
+		if strings.TrimSpace(p) != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+
+	terms := queryTerms(query)
+	type scored struct {
+		index int
+		score int
+	}
+	ranked := make([]scored, len(paragraphs))
+	hasMatch := false
+	for i, p := range paragraphs {
+		score := termScore(p, terms)
+		if score > 0 {
+			hasMatch = true
+		}
+		ranked[i] = scored{index: i, score: score}
+	}
+	if !hasMatch {
+		return truncateToTokens(text, maxTokens)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+
+	selected := make(map[int]string)
+	used := 0
+	for _, r := range ranked {
+		if r.score == 0 {
+			break
+		}
+		p := paragraphs[r.index]
+		tokens := estimateTokens(p) + 1
+		if used+tokens > maxTokens {
+			if used == 0 {
+				selected[r.index] = truncateToTokens(p, maxTokens)
+			}
+			break
+		}
+		used += tokens
+		selected[r.index] = p
+	}
+
+	var parts []string
+	last := -1
+	for i := range paragraphs {
+		p, ok := selected[i]
+		if !ok {
+			continue
+		}
+		if i != last+1 {
+			parts = append(parts, "…")
+		}
+		parts = append(parts, p)
+		last = i
+	}
+	if last != len(paragraphs)-1 {
+		parts = append(parts, "…")
+	}
+	return strings.Join(parts, "\n")
+}
+
+// queryTerms splits a query into lowercase search terms. Text without spaces,
+// as is common in Chinese and Japanese, is split into character pairs.
+func queryTerms(query string) []string {
+	var terms []string
+	for _, field := range strings.Fields(strings.ToLower(query)) {
+		runes := []rune(field)
+		if len(runes) > 2 && isCJK(runes[0]) {
+			for i := 0; i+1 < len(runes); i++ {
+				terms = append(terms, string(runes[i:i+2]))
+			}
+			continue
+		}
+		terms = append(terms, field)
+	}
+	return terms
+}
@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/models"
+
+	"github.com/songtianlun/diarum/internal/markdown"
+)
+
+// Daily prompt limits
+const (
+	dailyPromptMaxTokens = 200
+	// dailyPromptDiaryTokens is the most of each diary passed to the model
+	dailyPromptDiaryTokens = 400
+	// dailyPromptMaxLength is the most characters of a generated prompt that are kept
+	dailyPromptMaxLength = 300
+)
+
+// GenerateDailyPrompt asks the user's chat model for a writing prompt for date that
+// follows up on the recent diaries, oldest first. Without diaries a general prompt is written.
+func (s *ChatService) GenerateDailyPrompt(ctx context.Context, userID, date string, diaries []*models.Record) (string, error) {
+	cfg, err := s.getProviderConfig(userID)
+	if err != nil {
+		return "", err
+	}
+	provider, err := NewChatProvider(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	var entries strings.Builder
+	for _, diary := range diaries {
+		day := diary.GetString("date")
+		if len(day) >= 10 {
+			day = day[:10]
+		}
+		entries.WriteString("--- " + day)
+		if mood := diary.GetString("mood"); mood != "" {
+			entries.WriteString(" (mood: " + mood + ")")
+		}
+		entries.WriteString(" ---\n")
+		content := strings.TrimSpace(markdown.FromHTML(diary.GetString("content"), markdown.Options{}))
+		entries.WriteString(truncateToTokens(content, dailyPromptDiaryTokens) + "\n\n")
+	}
+	if entries.Len() == 0 {
+		entries.WriteString("(no recent entries)\n")
+	}
+
+	language := s.getPromptSettings(userID).diaryLanguageInstruction("the question")
+
+	messages := []ChatMessage{
+		{
+			Role: "system",
+			Content: `You write daily journaling prompts for the user of the personal diary app Diarum, addressing the user as "you".
+Based on the recent diary entries, write ONE open question that invites reflection for today's entry, for example following up on something the user was looking forward to, struggling with or curious about.
+Respond with only the question in a single sentence, without quotes or any other text. ` + language,
+		},
+		{
+			Role:    "user",
+			Content: fmt.Sprintf("Today is %s. Recent diary entries:\n\n%s", date, entries.String()),
+		},
+	}
+
+	raw, err := provider.Complete(ctx, messages, dailyPromptMaxTokens)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate daily prompt: %w", err)
+	}
+
+	prompt, _, _ := strings.Cut(strings.TrimSpace(raw), "\n")
+	prompt = strings.TrimSpace(strings.Trim(prompt, "\"“”"))
+	if prompt == "" {
+		return "", fmt.Errorf("empty daily prompt from API")
+	}
+	if runes := []rune(prompt); len(runes) > dailyPromptMaxLength {
+		prompt = string(runes[:dailyPromptMaxLength])
+	}
+	return prompt, nil
+}
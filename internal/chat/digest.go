@@ -0,0 +1,117 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/songtianlun/diarum/internal/logger"
+	"github.com/songtianlun/diarum/internal/markdown"
+)
+
+// Digest generation limits
+const (
+	// digestMaxTokens limits the length of a generated digest
+	digestMaxTokens = 1500
+	// digestDiaryTokens is the most of each diary passed to the model
+	digestDiaryTokens = 800
+)
+
+// DigestContent is the model's reflection on the diaries of a period
+type DigestContent struct {
+	Summary   string   `json:"summary"`
+	Themes    []string `json:"themes"`
+	MoodTrend string   `json:"mood_trend"`
+}
+
+// GenerateDigest asks the user's chat model to reflect on the diaries of a period,
+// oldest first. label names the period in the prompt, e.g. "the week of 2026-03-09".
+func (s *ChatService) GenerateDigest(ctx context.Context, userID, label string, diaries []*models.Record) (*DigestContent, error) {
+	if len(diaries) == 0 {
+		return nil, fmt.Errorf("no diaries to digest")
+	}
+
+	cfg, err := s.getProviderConfig(userID)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := NewChatProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The diaries share half of the context window
+	perDiary := (cfg.ContextWindow/2 - digestMaxTokens) / len(diaries)
+	if perDiary > digestDiaryTokens {
+		perDiary = digestDiaryTokens
+	}
+	if perDiary < 50 {
+		perDiary = 50
+	}
+
+	var entries strings.Builder
+	for _, diary := range diaries {
+		date := diary.GetString("date")
+		if len(date) >= 10 {
+			date = date[:10]
+		}
+		entries.WriteString("--- " + date)
+		if mood := diary.GetString("mood"); mood != "" {
+			entries.WriteString(" (mood: " + mood + ")")
+		}
+		entries.WriteString(" ---\n")
+		content := strings.TrimSpace(markdown.FromHTML(diary.GetString("content"), markdown.Options{}))
+		entries.WriteString(truncateToTokens(content, perDiary) + "\n\n")
+	}
+
+	language := s.getPromptSettings(userID).diaryLanguageInstruction("the reflection")
+
+	messages := []ChatMessage{
+		{
+			Role: "system",
+			Content: `You write periodic reflections for the user of the personal diary app Diarum, addressing the user as "you".
+Read the diary entries of the period and respond with ONLY a JSON object with these fields:
+- "summary": a warm, concise reflection on the period in 2-4 short paragraphs, mentioning dates of notable entries
+- "themes": 3-6 short recurring themes or topics
+- "mood_trend": 1-3 sentences on how the user's mood developed over the period
+` + language,
+		},
+		{
+			Role:    "user",
+			Content: fmt.Sprintf("Diary entries of %s:\n\n%s", label, entries.String()),
+		},
+	}
+
+	raw, err := provider.Complete(ctx, messages, digestMaxTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate digest: %w", err)
+	}
+
+	digest := parseDigest(raw)
+	if digest.Summary == "" {
+		return nil, fmt.Errorf("empty digest from API")
+	}
+	logger.Info("[ChatService] generated digest of %s for user %s from %d diaries", label, userID, len(diaries))
+	return digest, nil
+}
+
+// parseDigest decodes the model's JSON answer, taking the whole answer as the summary
+// if it is not valid JSON
+func parseDigest(raw string) *DigestContent {
+	raw = strings.TrimSpace(raw)
+	digest := &DigestContent{}
+
+	// Models sometimes wrap the object in a code block or add a sentence around it
+	if start, end := strings.Index(raw, "{"), strings.LastIndex(raw, "}"); start >= 0 && end > start {
+		if err := json.Unmarshal([]byte(raw[start:end+1]), digest); err == nil {
+			digest.Summary = strings.TrimSpace(digest.Summary)
+			digest.MoodTrend = strings.TrimSpace(digest.MoodTrend)
+			return digest
+		}
+	}
+
+	logger.Warn("[ChatService] digest is not valid JSON, keeping it as the summary")
+	return &DigestContent{Summary: raw}
+}
@@ -0,0 +1,153 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/songtianlun/diarum/internal/markdown"
+)
+
+// Enrichment limits
+const (
+	enrichMaxTokens = 500
+	// enrichDiaryTokens is the most of the diary passed to the model
+	enrichDiaryTokens = 3000
+	// enrichMaxItems limits each suggested list
+	enrichMaxItems = 8
+)
+
+// Enrichment is the model's structured reading of a diary
+type Enrichment struct {
+	Mood string `json:"mood"`
+	// Sentiment ranges from -1 (very negative) to 1 (very positive)
+	Sentiment float64  `json:"sentiment"`
+	Topics    []string `json:"topics"`
+	People    []string `json:"people"`
+	Places    []string `json:"places"`
+}
+
+// enrichmentSchema is the response schema of SuggestEnrichment
+var enrichmentSchema = ResponseSchema{
+	Name:        "diary_enrichment",
+	Description: "Record the mood, sentiment, topics, people and places of the diary entry",
+	Schema: map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"mood", "sentiment", "topics", "people", "places"},
+		"properties": map[string]interface{}{
+			"mood": map[string]interface{}{
+				"type":        "string",
+				"description": "The writer's overall mood in one or two lowercase words, e.g. happy, anxious, calm",
+			},
+			"sentiment": map[string]interface{}{
+				"type":        "number",
+				"description": "Overall sentiment from -1 (very negative) to 1 (very positive)",
+			},
+			"topics": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "1-5 short lowercase topics usable as tags, e.g. work, family, running",
+			},
+			"people": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Names of people mentioned, empty if none",
+			},
+			"places": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Named places mentioned, empty if none",
+			},
+		},
+	},
+}
+
+// SuggestEnrichment asks the user's chat model for the mood, sentiment, topics,
+// people and places of a diary. content is the diary's HTML.
+func (s *ChatService) SuggestEnrichment(ctx context.Context, userID, date, content string) (*Enrichment, error) {
+	text := strings.TrimSpace(markdown.FromHTML(content, markdown.Options{}))
+	if text == "" {
+		return nil, fmt.Errorf("diary is empty")
+	}
+
+	cfg, err := s.getProviderConfig(userID)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := NewChatProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	language := s.getPromptSettings(userID).diaryLanguageInstruction("mood and topics")
+
+	messages := []ChatMessage{
+		{
+			Role: "system",
+			Content: `You analyze entries of the personal diary app Diarum. Read the diary entry and respond with ONLY a JSON object with these fields:
+- "mood": the writer's overall mood in one or two lowercase words
+- "sentiment": a number from -1 (very negative) to 1 (very positive)
+- "topics": 1-5 short lowercase topics usable as tags
+- "people": names of people mentioned, empty if none
+- "places": named places mentioned, empty if none
+Only include people and places that are explicitly named. ` + language,
+		},
+		{
+			Role:    "user",
+			Content: fmt.Sprintf("Diary entry of %s:\n\n%s", date, truncateToTokens(text, enrichDiaryTokens)),
+		},
+	}
+
+	raw, err := provider.CompleteJSON(ctx, messages, enrichmentSchema, enrichMaxTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enrich diary: %w", err)
+	}
+
+	// Some OpenAI-compatible servers ignore the response format and wrap the object in text
+	raw = strings.TrimSpace(raw)
+	if start, end := strings.Index(raw, "{"), strings.LastIndex(raw, "}"); start >= 0 && end > start {
+		raw = raw[start : end+1]
+	}
+	enrichment := &Enrichment{}
+	if err := json.Unmarshal([]byte(raw), enrichment); err != nil {
+		return nil, fmt.Errorf("invalid enrichment from API: %w", err)
+	}
+	enrichment.normalize()
+	return enrichment, nil
+}
+
+// normalize trims and deduplicates the suggestions and clamps the sentiment
+func (e *Enrichment) normalize() {
+	e.Mood = strings.ToLower(strings.TrimSpace(e.Mood))
+	if len([]rune(e.Mood)) > 50 {
+		e.Mood = string([]rune(e.Mood)[:50])
+	}
+	e.Sentiment = min(max(e.Sentiment, -1), 1)
+	e.Topics = cleanList(e.Topics, true)
+	e.People = cleanList(e.People, false)
+	e.Places = cleanList(e.Places, false)
+}
+
+// cleanList trims, deduplicates and limits suggested names to tag length
+func cleanList(items []string, lower bool) []string {
+	seen := make(map[string]bool)
+	cleaned := make([]string, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if lower {
+			item = strings.ToLower(item)
+		}
+		key := strings.ToLower(item)
+		if item == "" || len([]rune(item)) > 50 || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, item)
+		if len(cleaned) == enrichMaxItems {
+			break
+		}
+	}
+	return cleaned
+}
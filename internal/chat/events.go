@@ -0,0 +1,105 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ProtocolVersion is the version of the chat event protocol. It changes when events
+// are renamed or their payloads change incompatibly.
+const ProtocolVersion = "1"
+
+// ProtocolHeader is the response header carrying the protocol version of a chat stream
+const ProtocolHeader = "X-Diarum-Chat-Protocol"
+
+// KeepAliveInterval is how often a comment is sent on an idle chat stream,
+// so that proxies do not close the connection
+const KeepAliveInterval = 15 * time.Second
+
+// Chat stream event names
+const (
+	// EventDelta carries a piece of the answer, DeltaEvent
+	EventDelta = "delta"
+	// EventTitle carries the generated conversation title, TitleEvent
+	EventTitle = "title"
+	// EventToolCall announces a tool call of the agent, ToolCallEvent
+	EventToolCall = "tool_call"
+	// EventToolResult carries the outcome of a tool call, ToolResultEvent
+	EventToolResult = "tool_result"
+	// EventCitation carries a validated diary citation, Citation
+	EventCitation = "citation"
+	// EventUsage carries the context usage of the turn, ContextUsage
+	EventUsage = "usage"
+	// EventError ends a stream that failed, ErrorEvent
+	EventError = "error"
+	// EventDone ends a stream whose answer was saved, DoneEvent
+	EventDone = "done"
+)
+
+// DeltaEvent is the payload of a delta event
+type DeltaEvent struct {
+	Content string `json:"content"`
+}
+
+// TitleEvent is the payload of a title event
+type TitleEvent struct {
+	Title string `json:"title"`
+}
+
+// ToolCallEvent is the payload of a tool_call event
+type ToolCallEvent struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Step      int    `json:"step"`
+}
+
+// ToolResultEvent is the payload of a tool_result event
+type ToolResultEvent struct {
+	ID                string   `json:"id"`
+	Name              string   `json:"name"`
+	Step              int      `json:"step"`
+	Content           string   `json:"content"`
+	ReferencedDiaries []string `json:"referenced_diaries"`
+	Error             string   `json:"error,omitempty"`
+}
+
+// ErrorEvent is the payload of an error event
+type ErrorEvent struct {
+	Message string `json:"message"`
+}
+
+// DoneEvent is the payload of a done event
+type DoneEvent struct {
+	// MessageID is the saved answer, empty if a stopped answer had no content yet
+	MessageID string `json:"message_id,omitempty"`
+	// UserMessageID is the user message that was answered
+	UserMessageID     string     `json:"user_message_id,omitempty"`
+	ReferencedDiaries []string   `json:"referenced_diaries"`
+	Citations         []Citation `json:"citations"`
+	Title             string     `json:"title,omitempty"`
+	Stopped           bool       `json:"stopped"`
+}
+
+// WriteEvent writes a named event with a JSON payload. Each call writes one complete event.
+func WriteEvent(w StreamWriter, name string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", name, err)
+	}
+	if _, err := w.Write([]byte("event: " + name + "\ndata: " + string(data) + "\n\n")); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// WriteKeepAlive writes a comment that clients ignore
+func WriteKeepAlive(w StreamWriter) error {
+	if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder is a StreamWriter that records what is written and how often it is flushed.
+// firstWrite is closed on the first write.
+type recorder struct {
+	buf        bytes.Buffer
+	flushes    int
+	once       sync.Once
+	firstWrite chan struct{}
+}
+
+func newRecorder() *recorder {
+	return &recorder{firstWrite: make(chan struct{})}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.once.Do(func() { close(r.firstWrite) })
+	return r.buf.Write(p)
+}
+
+func (r *recorder) Flush() {
+	r.flushes++
+}
+
+// events parses the recorded stream
+func (r *recorder) events(t *testing.T) []StreamEvent {
+	t.Helper()
+	reader := NewEventReader(bytes.NewReader(r.buf.Bytes()))
+	var events []StreamEvent
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("failed to read stream %q: %v", r.buf.String(), err)
+		}
+		events = append(events, *event)
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	w := newRecorder()
+	if err := WriteEvent(w, EventDelta, DeltaEvent{Content: "line one\nline two"}); err != nil {
+		t.Fatalf("WriteEvent: %v", err)
+	}
+
+	// One complete event: the payload stays on a single data line and a blank line ends it
+	want := "event: delta\ndata: {\"content\":\"line one\\nline two\"}\n\n"
+	if got := w.buf.String(); got != want {
+		t.Fatalf("WriteEvent wrote %q, want %q", got, want)
+	}
+	if w.flushes != 1 {
+		t.Errorf("flushed %d times, want 1", w.flushes)
+	}
+
+	events := w.events(t)
+	if len(events) != 1 || events[0].Name != EventDelta {
+		t.Fatalf("events = %+v, want one delta", events)
+	}
+	var delta DeltaEvent
+	if err := events[0].Decode(&delta); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if delta.Content != "line one\nline two" {
+		t.Errorf("content = %q", delta.Content)
+	}
+}
+
+func TestWriteEventEncodeError(t *testing.T) {
+	w := newRecorder()
+	err := WriteEvent(w, EventDone, make(chan int))
+	if err == nil || !strings.Contains(err.Error(), "failed to encode done event") {
+		t.Fatalf("WriteEvent = %v, want an encode error", err)
+	}
+	if w.buf.Len() != 0 || w.flushes != 0 {
+		t.Errorf("a failed event wrote %q", w.buf.String())
+	}
+}
+
+func TestKeepAliveIsSkipped(t *testing.T) {
+	w := newRecorder()
+	WriteKeepAlive(w)
+	WriteEvent(w, EventTitle, TitleEvent{Title: "Monday"})
+	WriteKeepAlive(w)
+	WriteEvent(w, EventDone, DoneEvent{MessageID: "m1"})
+
+	events := w.events(t)
+	if len(events) != 2 || events[0].Name != EventTitle || events[1].Name != EventDone {
+		t.Fatalf("events = %+v, want title and done", events)
+	}
+}
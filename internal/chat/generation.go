@@ -0,0 +1,256 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// Generation limits
+const (
+	// generationTimeout bounds a single generation
+	generationTimeout = 5 * time.Minute
+	// generationRetention is how long a finished generation stays available to reconnecting clients
+	generationRetention = 2 * time.Minute
+)
+
+// ErrGenerationRunning is returned when a conversation already has a running generation
+var ErrGenerationRunning = errors.New("a response is already being generated for this conversation")
+
+// ErrNoGeneration is returned when a conversation has no generation to attach to
+var ErrNoGeneration = errors.New("no response is being generated for this conversation")
+
+// generationEvent is a buffered SSE event and its sequence number
+type generationEvent struct {
+	id   int
+	data []byte
+}
+
+// Generation answers a chat message independently of the HTTP request that started it.
+// Its events are buffered with sequence numbers so clients can reconnect and resume.
+type Generation struct {
+	// ID distinguishes the generation from earlier ones of the conversation in event IDs
+	ID             string
+	ConversationID string
+	UserID         string
+
+	manager *generations
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	events  []generationEvent
+	notify  chan struct{}
+	started bool
+	done    chan struct{}
+}
+
+// generations holds the generation of each conversation
+type generations struct {
+	mu     sync.Mutex
+	byConv map[string]*Generation
+}
+
+// BeginGeneration reserves the conversation for a new generation. The caller prepares
+// the turn and then calls Run, or Discard if preparing fails.
+func (s *ChatService) BeginGeneration(userID, conversationID string) (*Generation, error) {
+	m := &s.generations
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.byConv == nil {
+		m.byConv = make(map[string]*Generation)
+	}
+	if g, ok := m.byConv[conversationID]; ok && !g.finished() {
+		return nil, ErrGenerationRunning
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), generationTimeout)
+	g := &Generation{
+		ID:             security.RandomString(10),
+		ConversationID: conversationID,
+		UserID:         userID,
+		manager:        m,
+		ctx:            ctx,
+		cancel:         cancel,
+		notify:         make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	m.byConv[conversationID] = g
+	return g, nil
+}
+
+// GetGeneration returns the running or recently finished generation of a conversation
+func (s *ChatService) GetGeneration(conversationID string) (*Generation, error) {
+	m := &s.generations
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g, ok := m.byConv[conversationID]
+	if !ok || !g.isStarted() {
+		return nil, ErrNoGeneration
+	}
+	return g, nil
+}
+
+// IsGenerating reports whether a response is being generated for the conversation
+func (s *ChatService) IsGenerating(conversationID string) bool {
+	g, err := s.GetGeneration(conversationID)
+	return err == nil && !g.finished()
+}
+
+// Run starts the generation in the background. fn writes its events to the generation,
+// its context is cancelled by Stop and not by clients disconnecting.
+func (g *Generation) Run(fn func(ctx context.Context, w StreamWriter)) {
+	g.mu.Lock()
+	g.started = true
+	g.mu.Unlock()
+
+	go func() {
+		defer g.finish()
+		fn(g.ctx, g)
+	}()
+}
+
+// Discard releases a generation that was never run
+func (g *Generation) Discard() {
+	g.cancel()
+	g.manager.remove(g)
+}
+
+// Stop cancels the generation and waits until it has saved what it produced
+func (g *Generation) Stop(timeout time.Duration) error {
+	g.cancel()
+	select {
+	case <-g.done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("generation did not stop within %s", timeout)
+	}
+}
+
+// Write buffers one SSE event, each call is expected to hold a complete event
+func (g *Generation) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+
+	g.mu.Lock()
+	g.events = append(g.events, generationEvent{id: len(g.events) + 1, data: data})
+	close(g.notify)
+	g.notify = make(chan struct{})
+	g.mu.Unlock()
+	return len(p), nil
+}
+
+// Flush is a no-op, events are delivered by Subscribe
+func (g *Generation) Flush() {}
+
+// EventID returns the SSE id of the event with a sequence number
+func (g *Generation) EventID(seq int) string {
+	return g.ID + "-" + strconv.Itoa(seq)
+}
+
+// resumeAfter returns the sequence number of the last event a client received. IDs of
+// another generation, e.g. the conversation's previous answer, and invalid IDs replay
+// the whole generation.
+func (g *Generation) resumeAfter(lastEventID string) int {
+	id, seq, ok := strings.Cut(lastEventID, "-")
+	if !ok || id != g.ID {
+		return 0
+	}
+	n, err := strconv.Atoi(seq)
+	if err != nil || n < 0 {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return min(n, len(g.events))
+}
+
+// Subscribe writes the events after lastEventID to w as they arrive, each with its
+// EventID as the SSE id, and keep-alive comments while none arrive. An empty
+// lastEventID or one of another generation starts at the first event. It returns when
+// the generation has finished and all events are written, or when ctx is cancelled
+// because the client went away.
+func (g *Generation) Subscribe(ctx context.Context, lastEventID string, w StreamWriter) {
+	keepAlive := time.NewTicker(KeepAliveInterval)
+	defer keepAlive.Stop()
+
+	next := g.resumeAfter(lastEventID)
+	for {
+		g.mu.Lock()
+		pending := g.events[next:]
+		notify := g.notify
+		g.mu.Unlock()
+
+		for _, event := range pending {
+			w.Write([]byte("id: " + g.EventID(event.id) + "\n"))
+			w.Write(event.data)
+			next = event.id
+		}
+		if len(pending) > 0 {
+			w.Flush()
+			keepAlive.Reset(KeepAliveInterval)
+		}
+
+		select {
+		case <-keepAlive.C:
+			WriteKeepAlive(w)
+		case <-notify:
+		case <-g.done:
+			// Deliver events written right before finishing
+			g.mu.Lock()
+			remaining := len(g.events) > next
+			g.mu.Unlock()
+			if !remaining {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// finish marks the generation as done and keeps it for reconnecting clients for a while
+func (g *Generation) finish() {
+	g.cancel()
+	g.mu.Lock()
+	count := len(g.events)
+	g.mu.Unlock()
+	close(g.done)
+	logger.Info("[ChatService] generation for conversation %s finished with %d events", g.ConversationID, count)
+	time.AfterFunc(generationRetention, func() {
+		g.manager.remove(g)
+	})
+}
+
+func (g *Generation) finished() bool {
+	select {
+	case <-g.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (g *Generation) isStarted() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.started
+}
+
+// remove forgets a generation unless a newer one replaced it
+func (m *generations) remove(g *Generation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.byConv[g.ConversationID] == g {
+		delete(m.byConv, g.ConversationID)
+	}
+}
@@ -0,0 +1,247 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventSummary lists the sequence numbers and names of events, IDs of another
+// generation than g are kept whole
+func eventSummary(g *Generation, events []StreamEvent) []string {
+	summary := make([]string, 0, len(events))
+	for _, e := range events {
+		summary = append(summary, fmt.Sprintf("%s:%s", strings.TrimPrefix(e.ID, g.ID+"-"), e.Name))
+	}
+	return summary
+}
+
+// waitFinished waits until the generation has finished
+func waitFinished(t *testing.T, g *Generation) {
+	t.Helper()
+	select {
+	case <-g.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("generation did not finish")
+	}
+}
+
+func TestGenerationResume(t *testing.T) {
+	s := &ChatService{}
+	g, err := s.BeginGeneration("u1", "c1")
+	if err != nil {
+		t.Fatalf("BeginGeneration: %v", err)
+	}
+	// Clients cannot attach before the generation runs
+	if _, err := s.GetGeneration("c1"); !errors.Is(err, ErrNoGeneration) {
+		t.Fatalf("GetGeneration before Run = %v, want ErrNoGeneration", err)
+	}
+
+	g.Run(func(ctx context.Context, w StreamWriter) {
+		WriteEvent(w, EventDelta, DeltaEvent{Content: "Dear "})
+		WriteEvent(w, EventDelta, DeltaEvent{Content: "diary"})
+		WriteEvent(w, EventCitation, Citation{})
+		WriteEvent(w, EventDone, DoneEvent{MessageID: "m1"})
+	})
+	waitFinished(t, g)
+
+	attached, err := s.GetGeneration("c1")
+	if err != nil || attached != g {
+		t.Fatalf("GetGeneration = %v, %v", attached, err)
+	}
+	if s.IsGenerating("c1") {
+		t.Error("IsGenerating is true for a finished generation")
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        []string
+	}{
+		{"from the start", "", []string{"1:delta", "2:delta", "3:citation", "4:done"}},
+		{"after the second event", g.EventID(2), []string{"3:citation", "4:done"}},
+		{"after the last event", g.EventID(4), []string{}},
+		{"beyond the last event", g.EventID(9), []string{}},
+		{"invalid ID", g.ID + "-x", []string{"1:delta", "2:delta", "3:citation", "4:done"}},
+		{"bare sequence number", "2", []string{"1:delta", "2:delta", "3:citation", "4:done"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newRecorder()
+			g.Subscribe(context.Background(), tt.lastEventID, w)
+			if got := eventSummary(g, w.events(t)); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerationIgnoresStaleEventIDs(t *testing.T) {
+	s := &ChatService{}
+	previous, _ := s.BeginGeneration("u1", "c1")
+	previous.Run(func(ctx context.Context, w StreamWriter) {
+		for _, word := range []string{"One ", "two ", "three ", "four"} {
+			WriteEvent(w, EventDelta, DeltaEvent{Content: word})
+		}
+		WriteEvent(w, EventDone, DoneEvent{MessageID: "m1"})
+	})
+	waitFinished(t, previous)
+
+	// The next answer of the conversation is shorter than the previous one
+	g, err := s.BeginGeneration("u1", "c1")
+	if err != nil {
+		t.Fatalf("BeginGeneration: %v", err)
+	}
+	g.Run(func(ctx context.Context, w StreamWriter) {
+		WriteEvent(w, EventDelta, DeltaEvent{Content: "Five"})
+		WriteEvent(w, EventDone, DoneEvent{MessageID: "m2"})
+	})
+	waitFinished(t, g)
+	if g.EventID(1) == previous.EventID(1) {
+		t.Fatalf("both generations use the event ID %s", g.EventID(1))
+	}
+
+	// A client reconnecting with an ID of the previous answer gets the whole new one
+	for _, lastEventID := range []string{previous.EventID(1), previous.EventID(5)} {
+		w := newRecorder()
+		g.Subscribe(context.Background(), lastEventID, w)
+		if got := eventSummary(g, w.events(t)); !reflect.DeepEqual(got, []string{"1:delta", "2:done"}) {
+			t.Fatalf("events after %s = %v, want the whole answer", lastEventID, got)
+		}
+	}
+}
+
+func TestBeginGenerationWhileRunning(t *testing.T) {
+	s := &ChatService{}
+	g, _ := s.BeginGeneration("u1", "c1")
+	release := make(chan struct{})
+	g.Run(func(ctx context.Context, w StreamWriter) {
+		<-release
+	})
+
+	if _, err := s.BeginGeneration("u1", "c1"); !errors.Is(err, ErrGenerationRunning) {
+		t.Fatalf("second BeginGeneration = %v, want ErrGenerationRunning", err)
+	}
+	if !s.IsGenerating("c1") {
+		t.Error("IsGenerating is false for a running generation")
+	}
+	// Other conversations are independent
+	other, err := s.BeginGeneration("u1", "c2")
+	if err != nil {
+		t.Fatalf("BeginGeneration of another conversation: %v", err)
+	}
+	other.Discard()
+
+	close(release)
+	waitFinished(t, g)
+	next, err := s.BeginGeneration("u1", "c1")
+	if err != nil {
+		t.Fatalf("BeginGeneration after finishing: %v", err)
+	}
+	next.Discard()
+}
+
+func TestGenerationStopFansOutToSubscribers(t *testing.T) {
+	s := &ChatService{}
+	g, _ := s.BeginGeneration("u1", "c1")
+	g.Run(func(ctx context.Context, w StreamWriter) {
+		WriteEvent(w, EventDelta, DeltaEvent{Content: "Partial"})
+		// Wait for Stop, then save what was produced like the agent does
+		<-ctx.Done()
+		WriteEvent(w, EventDone, DoneEvent{MessageID: "m1", Stopped: true})
+	})
+
+	// Two clients, e.g. two tabs, follow the running generation
+	var wg sync.WaitGroup
+	subscribers := []*recorder{newRecorder(), newRecorder()}
+	for _, w := range subscribers {
+		w := w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Subscribe(context.Background(), "", w)
+		}()
+	}
+	for _, w := range subscribers {
+		select {
+		case <-w.firstWrite:
+		case <-time.After(2 * time.Second):
+			t.Fatal("subscriber received no event")
+		}
+	}
+
+	if err := g.Stop(2 * time.Second); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	wg.Wait()
+
+	want := []string{"1:delta", "2:done"}
+	for i, w := range subscribers {
+		events := w.events(t)
+		if got := eventSummary(g, events); !reflect.DeepEqual(got, want) {
+			t.Fatalf("subscriber %d events = %v, want %v", i, got, want)
+		}
+		var done DoneEvent
+		if err := events[1].Decode(&done); err != nil || !done.Stopped {
+			t.Errorf("subscriber %d done = %+v, %v, want a stopped answer", i, done, err)
+		}
+	}
+
+	// A client reconnecting after Stop gets the whole answer and returns
+	late := newRecorder()
+	g.Subscribe(context.Background(), g.EventID(1), late)
+	if got := eventSummary(g, late.events(t)); !reflect.DeepEqual(got, []string{"2:done"}) {
+		t.Fatalf("late subscriber events = %v", got)
+	}
+}
+
+func TestGenerationStopTimeout(t *testing.T) {
+	s := &ChatService{}
+	g, _ := s.BeginGeneration("u1", "c1")
+	release := make(chan struct{})
+	g.Run(func(ctx context.Context, w StreamWriter) {
+		// Ignores cancellation
+		<-release
+	})
+
+	if err := g.Stop(20 * time.Millisecond); err == nil {
+		t.Fatal("Stop returned before the generation finished")
+	}
+	close(release)
+	waitFinished(t, g)
+}
+
+func TestSubscribeReturnsWhenClientLeaves(t *testing.T) {
+	s := &ChatService{}
+	g, _ := s.BeginGeneration("u1", "c1")
+	release := make(chan struct{})
+	defer close(release)
+	g.Run(func(ctx context.Context, w StreamWriter) {
+		WriteEvent(w, EventDelta, DeltaEvent{Content: "Partial"})
+		<-release
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newRecorder()
+	returned := make(chan struct{})
+	go func() {
+		g.Subscribe(ctx, "", w)
+		close(returned)
+	}()
+	<-w.firstWrite
+	cancel()
+
+	select {
+	case <-returned:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Subscribe did not return after the client went away")
+	}
+	if g.finished() {
+		t.Error("a client leaving stopped the generation")
+	}
+}
@@ -0,0 +1,161 @@
+package chat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LanguageAuto answers in the language of the user's message
+const LanguageAuto = "auto"
+
+// PersonaDefault is the persona used when none is configured
+const PersonaDefault = "default"
+
+// personas are the preset instructions describing the assistant's tone and role
+var personas = map[string]string{
+	PersonaDefault: `You are a helpful AI assistant for a personal diary application called Diarum.
+You help users reflect on their diary entries, summarize their experiences, and provide insights based on their personal journal.`,
+	"friend": `You are a warm, supportive friend inside the personal diary application Diarum.
+Talk casually and kindly, celebrate good moments, show empathy for hard ones, and remember what the user has shared in their diary.`,
+	"coach": `You are a personal growth coach inside the personal diary application Diarum.
+Help the user notice patterns in their diary, set concrete goals and follow through. Be encouraging but direct, ask focused questions and suggest small, actionable next steps.`,
+	"therapist": `You are a reflective, empathetic listener inside the personal diary application Diarum, inspired by the style of a counselor.
+Help the user explore their thoughts and feelings through their diary with open questions, validation and gentle reframing. You are not a substitute for professional help: if the user seems to be in crisis, encourage them to contact a professional or local emergency services.`,
+	"analyst": `You are a precise analyst of the user's personal journal in the diary application Diarum.
+Answer concisely and factually, back statements with dates and numbers, and point out trends and correlations in the diary data.`,
+}
+
+// languageNames are the supported response languages besides LanguageAuto
+var languageNames = map[string]string{
+	"en":    "English",
+	"zh":    "Simplified Chinese",
+	"zh-TW": "Traditional Chinese",
+	"ja":    "Japanese",
+	"ko":    "Korean",
+	"fr":    "French",
+	"de":    "German",
+	"es":    "Spanish",
+}
+
+// Personas returns the names of the persona presets
+func Personas() []string {
+	names := make([]string, 0, len(personas))
+	for name := range personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsValidPersona reports whether name is a persona preset
+func IsValidPersona(name string) bool {
+	_, ok := personas[name]
+	return ok
+}
+
+// Languages returns the supported response language codes, LanguageAuto first
+func Languages() []string {
+	codes := make([]string, 0, len(languageNames))
+	for code := range languageNames {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return append([]string{LanguageAuto}, codes...)
+}
+
+// IsValidLanguage reports whether code is a supported response language
+func IsValidLanguage(code string) bool {
+	_, ok := languageNames[code]
+	return ok || code == LanguageAuto
+}
+
+// IsValidTimezone reports whether name is an IANA time zone, empty means the server's
+func IsValidTimezone(name string) bool {
+	if name == "" {
+		return true
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// promptSettings are the user's settings that shape the system prompt
+type promptSettings struct {
+	SystemPrompt string
+	Persona      string
+	Language     string
+	Timezone     string
+	Location     *time.Location
+	UserName     string
+}
+
+// getPromptSettings reads the user's prompt settings
+func (s *ChatService) getPromptSettings(userID string) promptSettings {
+	settings := promptSettings{}
+	settings.SystemPrompt, _ = s.configService.GetString(userID, "ai.system_prompt")
+	settings.Persona, _ = s.configService.GetString(userID, "ai.persona")
+	settings.Language, _ = s.configService.GetString(userID, "ai.language")
+	settings.Timezone, _ = s.configService.GetString(userID, "user.timezone")
+	settings.Location = s.configService.Location(userID)
+
+	if !IsValidPersona(settings.Persona) {
+		settings.Persona = PersonaDefault
+	}
+	if !IsValidLanguage(settings.Language) {
+		settings.Language = LanguageAuto
+	}
+	if user, err := s.app.Dao().FindRecordById("users", userID); err == nil {
+		settings.UserName = user.GetString("name")
+		if settings.UserName == "" {
+			settings.UserName = user.GetString("username")
+		}
+	}
+	return settings
+}
+
+// variables returns the values of the prompt template variables at the given time
+func (p promptSettings) variables(now time.Time) map[string]string {
+	now = now.In(p.Location)
+	timezone := p.Timezone
+	if timezone == "" {
+		timezone = "UTC" + now.Format("-07:00")
+	}
+	language := languageNames[p.Language]
+	if language == "" {
+		language = "the language of the user's message"
+	}
+	return map[string]string{
+		"today":     now.Format("2006-01-02"),
+		"weekday":   now.Weekday().String(),
+		"time":      now.Format("15:04"),
+		"timezone":  timezone,
+		"user_name": p.UserName,
+		"language":  language,
+	}
+}
+
+// diaryLanguageInstruction tells the model which language to write what in when
+// it writes about the user's diaries
+func (p promptSettings) diaryLanguageInstruction(what string) string {
+	if name, ok := languageNames[p.Language]; ok {
+		return "Write " + what + " in " + name + "."
+	}
+	return "Write " + what + " in the same language as the diary entries."
+}
+
+// languageInstruction tells the model which language to answer in
+func (p promptSettings) languageInstruction() string {
+	if name, ok := languageNames[p.Language]; ok {
+		return fmt.Sprintf("Always respond in %s, unless the user explicitly asks for another language.", name)
+	}
+	return "Respond in the same language as the user."
+}
+
+// intro returns the user's custom system prompt, or the instructions of their persona
+func (p promptSettings) intro() string {
+	if custom := strings.TrimSpace(p.SystemPrompt); custom != "" {
+		return custom
+	}
+	return personas[p.Persona]
+}
@@ -0,0 +1,173 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Supported chat providers
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderOllama    = "ollama"
+)
+
+// ChatProvider is a chat completion backend
+type ChatProvider interface {
+	// StreamChat streams a completion, passing content deltas to onContent,
+	// and returns the full content and the tool calls requested by the model.
+	// toolChoice "none" asks the model to answer without calling tools.
+	StreamChat(ctx context.Context, messages []ChatMessage, tools []Tool, toolChoice string, onContent func(string)) (string, []ToolCall, error)
+	// Complete returns a short non-streaming completion, used for titles
+	Complete(ctx context.Context, messages []ChatMessage, maxTokens int) (string, error)
+	// CompleteJSON returns a non-streaming completion constrained to a JSON object matching schema
+	CompleteJSON(ctx context.Context, messages []ChatMessage, schema ResponseSchema, maxTokens int) (string, error)
+}
+
+// ResponseSchema is the JSON schema of a structured completion. For strict providers every
+// property must be required and additionalProperties false.
+type ResponseSchema struct {
+	Name        string
+	Description string
+	Schema      map[string]interface{}
+}
+
+// ProviderConfig holds the connection settings of a chat provider
+type ProviderConfig struct {
+	Provider string
+	BaseURL  string
+	APIKey   string
+	Model    string
+	// ContextWindow is the model's context size in tokens
+	ContextWindow int
+	// Temperature and MaxTokens apply to chat answers, nil and 0 use the provider's defaults
+	Temperature *float64
+	MaxTokens   int
+}
+
+// IsValidProvider reports whether name is a supported chat provider
+func IsValidProvider(name string) bool {
+	switch name {
+	case ProviderOpenAI, ProviderAnthropic, ProviderOllama:
+		return true
+	}
+	return false
+}
+
+// NewChatProvider creates the provider adapter for the config
+func NewChatProvider(cfg ProviderConfig) (ChatProvider, error) {
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	switch cfg.Provider {
+	case "", ProviderOpenAI:
+		return &openAIProvider{cfg: cfg}, nil
+	case ProviderAnthropic:
+		return &anthropicProvider{cfg: cfg}, nil
+	case ProviderOllama:
+		return &ollamaProvider{cfg: cfg}, nil
+	}
+	return nil, fmt.Errorf("unknown chat provider: %s", cfg.Provider)
+}
+
+// getProvider creates the chat provider configured by the user
+func (s *ChatService) getProvider(userID string) (ChatProvider, error) {
+	cfg, err := s.getProviderConfig(userID)
+	if err != nil {
+		return nil, err
+	}
+	return NewChatProvider(cfg)
+}
+
+// getProviderConfig reads the user's chat provider settings.
+// ai.chat_base_url and ai.chat_api_key override the shared AI settings,
+// so chat can use a different backend than embeddings.
+func (s *ChatService) getProviderConfig(userID string) (ProviderConfig, error) {
+	provider, _ := s.configService.GetString(userID, "ai.chat_provider")
+
+	baseURL, _ := s.configService.GetString(userID, "ai.chat_base_url")
+	if baseURL == "" {
+		baseURL, _ = s.configService.GetString(userID, "ai.base_url")
+	}
+	if baseURL == "" {
+		return ProviderConfig{}, fmt.Errorf("AI base URL not configured")
+	}
+
+	apiKey, _ := s.configService.GetString(userID, "ai.chat_api_key")
+	if apiKey == "" {
+		apiKey, _ = s.configService.GetString(userID, "ai.api_key")
+	}
+	// A local Ollama server needs no key
+	if apiKey == "" && provider != ProviderOllama {
+		return ProviderConfig{}, fmt.Errorf("AI API key not configured")
+	}
+
+	chatModel, _ := s.configService.GetString(userID, "ai.chat_model")
+	if chatModel == "" {
+		return ProviderConfig{}, fmt.Errorf("chat model not configured")
+	}
+
+	contextWindow, _ := s.configService.GetInt(userID, "ai.context_window")
+	maxTokens, _ := s.configService.GetInt(userID, "ai.max_tokens")
+
+	cfg := ProviderConfig{
+		Provider:      provider,
+		BaseURL:       baseURL,
+		APIKey:        apiKey,
+		Model:         chatModel,
+		ContextWindow: contextWindowFor(provider, chatModel, contextWindow),
+		MaxTokens:     maxTokens,
+	}
+	if temperature, _ := s.configService.GetFloat(userID, "ai.temperature"); temperature >= 0 {
+		cfg.Temperature = &temperature
+	}
+	return cfg, nil
+}
+
+// postJSON sends a JSON request and returns the response, or an error for non-200 statuses.
+// The request is bounded by ctx only, as answers may stream for as long as the caller allows.
+func postJSON(ctx context.Context, url string, headers map[string]string, body any) (*http.Response, error) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return resp, nil
+}
+
+// splitSystemPrompt separates system messages from the conversation
+func splitSystemPrompt(messages []ChatMessage) (string, []ChatMessage) {
+	var system []string
+	rest := make([]ChatMessage, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+			continue
+		}
+		rest = append(rest, msg)
+	}
+	return strings.Join(system, "\n\n"), rest
+}
@@ -0,0 +1,315 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// anthropicVersion is the Messages API version sent with every request
+const anthropicVersion = "2023-06-01"
+
+// anthropicMaxTokens is the output limit of chat responses, which the Messages API requires
+const anthropicMaxTokens = 4096
+
+// anthropicProvider talks to the Anthropic Messages API at /v1/messages
+type anthropicProvider struct {
+	cfg ProviderConfig
+}
+
+// anthropicMessage is a message in Messages API format
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock is a content block of a message
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+// anthropicTool is a tool definition in Messages API format
+type anthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+// anthropicRequest is a Messages API request
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	Tools       []anthropicTool    `json:"tools,omitempty"`
+	ToolChoice  map[string]string  `json:"tool_choice,omitempty"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	Stream      bool               `json:"stream"`
+}
+
+// anthropicStreamEvent is a streaming event, only the fields used are decoded
+type anthropicStreamEvent struct {
+	Type         string `json:"type"`
+	Index        int    `json:"index"`
+	ContentBlock struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *anthropicProvider) headers() map[string]string {
+	return map[string]string{
+		"x-api-key":         p.cfg.APIKey,
+		"anthropic-version": anthropicVersion,
+	}
+}
+
+// convertMessages converts chat messages to Messages API format.
+// Tool results become tool_result blocks of a user message, and consecutive
+// messages of the same role are merged since the API expects alternating turns.
+func (p *anthropicProvider) convertMessages(messages []ChatMessage) (string, []anthropicMessage) {
+	system, rest := splitSystemPrompt(messages)
+
+	var converted []anthropicMessage
+	for _, msg := range rest {
+		role := msg.Role
+		var blocks []anthropicBlock
+		switch msg.Role {
+		case "tool":
+			role = "user"
+			blocks = append(blocks, anthropicBlock{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content})
+		case "assistant":
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+			}
+			for _, tc := range msg.ToolCalls {
+				input := json.RawMessage(tc.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: tc.ID, Name: tc.Function.Name, Input: input})
+			}
+		default:
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+
+		if n := len(converted); n > 0 && converted[n-1].Role == role {
+			converted[n-1].Content = append(converted[n-1].Content, blocks...)
+			continue
+		}
+		converted = append(converted, anthropicMessage{Role: role, Content: blocks})
+	}
+	return system, converted
+}
+
+// StreamChat calls the Messages API with tool support
+func (p *anthropicProvider) StreamChat(ctx context.Context, messages []ChatMessage, tools []Tool, toolChoice string, onContent func(string)) (string, []ToolCall, error) {
+	system, converted := p.convertMessages(messages)
+	reqBody := anthropicRequest{
+		Model:       p.cfg.Model,
+		System:      system,
+		Messages:    converted,
+		MaxTokens:   anthropicMaxTokens,
+		Temperature: p.cfg.Temperature,
+		Stream:      true,
+	}
+	if p.cfg.MaxTokens > 0 {
+		reqBody.MaxTokens = p.cfg.MaxTokens
+	}
+	// The Messages API accepts temperatures up to 1
+	if t := p.cfg.Temperature; t != nil && *t > 1 {
+		maxTemperature := 1.0
+		reqBody.Temperature = &maxTemperature
+	}
+	for _, t := range tools {
+		reqBody.Tools = append(reqBody.Tools, anthropicTool{
+			Name:        t.Function.Name,
+			Description: t.Function.Description,
+			InputSchema: t.Function.Parameters,
+		})
+	}
+	// Tools stay defined so earlier tool_use blocks remain valid
+	if len(tools) > 0 && toolChoice == "none" {
+		reqBody.ToolChoice = map[string]string{"type": "none"}
+	}
+
+	logger.Debug("[ChatService] Anthropic request: model=%s, messages=%d, tools=%d", p.cfg.Model, len(converted), len(tools))
+
+	resp, err := postJSON(ctx, p.cfg.BaseURL+"/v1/messages", p.headers(), reqBody)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	return p.processStream(resp.Body, onContent)
+}
+
+// processStream processes the Messages API event stream
+func (p *anthropicProvider) processStream(body io.Reader, onContent func(string)) (string, []ToolCall, error) {
+	scanner := bufio.NewScanner(body)
+	var fullResponse strings.Builder
+	toolCallsMap := make(map[int]*ToolCall) // Use content block index as key
+	var order []int
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Event names are repeated in the payload type, so only data lines are read
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			logger.Warn("[ChatService] failed to parse stream event: %v", err)
+			continue
+		}
+
+		switch event.Type {
+		case "content_block_start":
+			if event.ContentBlock.Type == "tool_use" {
+				tc := &ToolCall{Index: len(order), ID: event.ContentBlock.ID, Type: "function"}
+				tc.Function.Name = event.ContentBlock.Name
+				toolCallsMap[event.Index] = tc
+				order = append(order, event.Index)
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				if event.Delta.Text != "" {
+					fullResponse.WriteString(event.Delta.Text)
+					onContent(event.Delta.Text)
+				}
+			case "input_json_delta":
+				if tc, ok := toolCallsMap[event.Index]; ok {
+					tc.Function.Arguments += event.Delta.PartialJSON
+				}
+			}
+		case "error":
+			return fullResponse.String(), nil, fmt.Errorf("API stream error: %s: %s", event.Error.Type, event.Error.Message)
+		case "message_stop":
+			// Stop reading even if the server keeps the connection open
+			return fullResponse.String(), collectToolCalls(toolCallsMap, order), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fullResponse.String(), nil, fmt.Errorf("error reading stream: %w", err)
+	}
+
+	return fullResponse.String(), collectToolCalls(toolCallsMap, order), nil
+}
+
+// collectToolCalls returns the accumulated tool calls in stream order
+func collectToolCalls(toolCallsMap map[int]*ToolCall, order []int) []ToolCall {
+	toolCalls := make([]ToolCall, 0, len(order))
+	for _, idx := range order {
+		tc := toolCallsMap[idx]
+		// Tools without parameters stream no input
+		if strings.TrimSpace(tc.Function.Arguments) == "" {
+			tc.Function.Arguments = "{}"
+		}
+		toolCalls = append(toolCalls, *tc)
+	}
+	logger.Debug("[ChatService] parsed %d tool calls", len(toolCalls))
+	return toolCalls
+}
+
+// Complete calls the Messages API without streaming
+func (p *anthropicProvider) Complete(ctx context.Context, messages []ChatMessage, maxTokens int) (string, error) {
+	system, converted := p.convertMessages(messages)
+	reqBody := anthropicRequest{
+		Model:     p.cfg.Model,
+		System:    system,
+		Messages:  converted,
+		MaxTokens: maxTokens,
+	}
+
+	resp, err := postJSON(ctx, p.cfg.BaseURL+"/v1/messages", p.headers(), reqBody)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	var sb strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			sb.WriteString(block.Text)
+		}
+	}
+	if sb.Len() == 0 {
+		return "", fmt.Errorf("no response from API")
+	}
+	return sb.String(), nil
+}
+
+// CompleteJSON forces a call of a tool whose input schema is the response schema,
+// since the Messages API has no JSON response format
+func (p *anthropicProvider) CompleteJSON(ctx context.Context, messages []ChatMessage, schema ResponseSchema, maxTokens int) (string, error) {
+	system, converted := p.convertMessages(messages)
+	reqBody := anthropicRequest{
+		Model:      p.cfg.Model,
+		System:     system,
+		Messages:   converted,
+		Tools:      []anthropicTool{{Name: schema.Name, Description: schema.Description, InputSchema: schema.Schema}},
+		ToolChoice: map[string]string{"type": "tool", "name": schema.Name},
+		MaxTokens:  maxTokens,
+	}
+
+	resp, err := postJSON(ctx, p.cfg.BaseURL+"/v1/messages", p.headers(), reqBody)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Content []anthropicBlock `json:"content"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	for _, block := range result.Content {
+		if block.Type == "tool_use" && block.Name == schema.Name {
+			return string(block.Input), nil
+		}
+	}
+	return "", fmt.Errorf("no response from API")
+}
@@ -0,0 +1,213 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// ollamaProvider talks to the native Ollama API at /api/chat
+type ollamaProvider struct {
+	cfg ProviderConfig
+}
+
+// ollamaMessage is a message in Ollama format
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+// ollamaToolCall is a tool call, Ollama passes arguments as an object and has no call IDs
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// ollamaRequest is an /api/chat request
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []Tool          `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	Options  map[string]any  `json:"options,omitempty"`
+	// Format is a JSON schema the response must match
+	Format map[string]interface{} `json:"format,omitempty"`
+}
+
+// ollamaResponse is a streamed line or a complete /api/chat response
+type ollamaResponse struct {
+	Message ollamaMessage `json:"message"`
+	Done    bool          `json:"done"`
+	Error   string        `json:"error"`
+}
+
+func (p *ollamaProvider) headers() map[string]string {
+	// Ollama needs no key, but one is sent for servers behind an authenticating proxy
+	if p.cfg.APIKey == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + p.cfg.APIKey}
+}
+
+// convertMessages converts chat messages to Ollama format
+func (p *ollamaProvider) convertMessages(messages []ChatMessage) []ollamaMessage {
+	toolNames := make(map[string]string)
+	converted := make([]ollamaMessage, 0, len(messages))
+	for _, msg := range messages {
+		om := ollamaMessage{Role: msg.Role, Content: msg.Content}
+		for _, tc := range msg.ToolCalls {
+			toolNames[tc.ID] = tc.Function.Name
+			var call ollamaToolCall
+			call.Function.Name = tc.Function.Name
+			call.Function.Arguments = json.RawMessage(tc.Function.Arguments)
+			if !json.Valid(call.Function.Arguments) {
+				call.Function.Arguments = json.RawMessage("{}")
+			}
+			om.ToolCalls = append(om.ToolCalls, call)
+		}
+		if msg.Role == "tool" {
+			om.ToolName = toolNames[msg.ToolCallID]
+		}
+		converted = append(converted, om)
+	}
+	return converted
+}
+
+// StreamChat calls /api/chat with tool support
+func (p *ollamaProvider) StreamChat(ctx context.Context, messages []ChatMessage, tools []Tool, toolChoice string, onContent func(string)) (string, []ToolCall, error) {
+	reqBody := ollamaRequest{
+		Model:    p.cfg.Model,
+		Messages: p.convertMessages(messages),
+		Stream:   true,
+	}
+	// Ollama has no tool_choice, so tools are withheld instead
+	if toolChoice != "none" {
+		reqBody.Tools = tools
+	}
+	options := map[string]any{}
+	// Ollama's default context is smaller than the window the prompt was sized for
+	if p.cfg.ContextWindow > 0 {
+		options["num_ctx"] = p.cfg.ContextWindow
+	}
+	if p.cfg.Temperature != nil {
+		options["temperature"] = *p.cfg.Temperature
+	}
+	if p.cfg.MaxTokens > 0 {
+		options["num_predict"] = p.cfg.MaxTokens
+	}
+	if len(options) > 0 {
+		reqBody.Options = options
+	}
+
+	logger.Debug("[ChatService] Ollama request: model=%s, messages=%d, tools=%d", p.cfg.Model, len(messages), len(reqBody.Tools))
+
+	resp, err := postJSON(ctx, p.cfg.BaseURL+"/api/chat", p.headers(), reqBody)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	return p.processStream(resp.Body, onContent)
+}
+
+// processStream processes the newline-delimited JSON stream
+func (p *ollamaProvider) processStream(body io.Reader, onContent func(string)) (string, []ToolCall, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var fullResponse strings.Builder
+	var toolCalls []ToolCall
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var chunk ollamaResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			logger.Warn("[ChatService] failed to parse stream chunk: %v", err)
+			continue
+		}
+		if chunk.Error != "" {
+			return fullResponse.String(), nil, fmt.Errorf("API stream error: %s", chunk.Error)
+		}
+
+		if chunk.Message.Content != "" {
+			fullResponse.WriteString(chunk.Message.Content)
+			onContent(chunk.Message.Content)
+		}
+
+		// Tool calls arrive complete, not as deltas
+		for _, call := range chunk.Message.ToolCalls {
+			tc := ToolCall{Index: len(toolCalls), Type: "function"}
+			tc.Function.Name = call.Function.Name
+			tc.Function.Arguments = string(call.Function.Arguments)
+			toolCalls = append(toolCalls, tc)
+		}
+
+		if chunk.Done {
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fullResponse.String(), toolCalls, fmt.Errorf("error reading stream: %w", err)
+	}
+
+	logger.Debug("[ChatService] parsed %d tool calls", len(toolCalls))
+	return fullResponse.String(), toolCalls, nil
+}
+
+// Complete calls /api/chat without streaming
+func (p *ollamaProvider) Complete(ctx context.Context, messages []ChatMessage, maxTokens int) (string, error) {
+	reqBody := ollamaRequest{
+		Model:    p.cfg.Model,
+		Messages: p.convertMessages(messages),
+		Stream:   false,
+		Options:  map[string]any{"num_predict": maxTokens},
+	}
+	return p.complete(ctx, reqBody)
+}
+
+// CompleteJSON calls /api/chat with the schema as structured output format
+func (p *ollamaProvider) CompleteJSON(ctx context.Context, messages []ChatMessage, schema ResponseSchema, maxTokens int) (string, error) {
+	reqBody := ollamaRequest{
+		Model:    p.cfg.Model,
+		Messages: p.convertMessages(messages),
+		Stream:   false,
+		Options:  map[string]any{"num_predict": maxTokens},
+		Format:   schema.Schema,
+	}
+	return p.complete(ctx, reqBody)
+}
+
+// complete sends a non-streaming request and returns the message content
+func (p *ollamaProvider) complete(ctx context.Context, reqBody ollamaRequest) (string, error) {
+	resp, err := postJSON(ctx, p.cfg.BaseURL+"/api/chat", p.headers(), reqBody)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result ollamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	if result.Error != "" {
+		return "", fmt.Errorf("API error: %s", result.Error)
+	}
+	if result.Message.Content == "" {
+		return "", fmt.Errorf("no response from API")
+	}
+
+	return result.Message.Content, nil
+}
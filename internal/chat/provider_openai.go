@@ -0,0 +1,190 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// openAIProvider talks to OpenAI-compatible /v1/chat/completions APIs
+type openAIProvider struct {
+	cfg ProviderConfig
+}
+
+func (p *openAIProvider) headers() map[string]string {
+	return map[string]string{
+		"Authorization": "Bearer " + p.cfg.APIKey,
+		"Accept":        "text/event-stream",
+	}
+}
+
+// StreamChat calls the API with tool support
+func (p *openAIProvider) StreamChat(ctx context.Context, messages []ChatMessage, tools []Tool, toolChoice string, onContent func(string)) (string, []ToolCall, error) {
+	reqBody := ChatRequest{
+		Model:       p.cfg.Model,
+		Messages:    messages,
+		Tools:       tools,
+		Temperature: p.cfg.Temperature,
+		MaxTokens:   p.cfg.MaxTokens,
+		Stream:      true,
+	}
+	if len(tools) > 0 {
+		reqBody.ToolChoice = toolChoice
+	}
+
+	logger.Debug("[ChatService] OpenAI request: model=%s, messages=%d, tools=%d", p.cfg.Model, len(messages), len(tools))
+
+	resp, err := postJSON(ctx, p.cfg.BaseURL+"/v1/chat/completions", p.headers(), reqBody)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	return p.processStream(resp.Body, onContent)
+}
+
+// processStream processes the SSE stream with tool call support
+func (p *openAIProvider) processStream(body io.Reader, onContent func(string)) (string, []ToolCall, error) {
+	scanner := bufio.NewScanner(body)
+	var fullResponse strings.Builder
+	toolCallsMap := make(map[int]*ToolCall) // Use index as key
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		data := strings.TrimPrefix(line, "data: ")
+		if data == "[DONE]" {
+			break
+		}
+
+		var streamResp ChatStreamResponse
+		if err := json.Unmarshal([]byte(data), &streamResp); err != nil {
+			logger.Warn("[ChatService] failed to parse stream chunk: %v", err)
+			continue
+		}
+		if streamResp.Error != nil {
+			return fullResponse.String(), nil, fmt.Errorf("API stream error: %s: %s", streamResp.Error.Type, streamResp.Error.Message)
+		}
+
+		if len(streamResp.Choices) > 0 {
+			choice := streamResp.Choices[0]
+
+			// Handle content
+			if choice.Delta.Content != "" {
+				fullResponse.WriteString(choice.Delta.Content)
+				onContent(choice.Delta.Content)
+			}
+
+			// Handle tool calls - accumulate by index
+			for _, tc := range choice.Delta.ToolCalls {
+				idx := tc.Index
+				if _, exists := toolCallsMap[idx]; !exists {
+					toolCallsMap[idx] = &ToolCall{Index: idx}
+				}
+				toolCall := toolCallsMap[idx]
+				if tc.ID != "" {
+					toolCall.ID = tc.ID
+				}
+				if tc.Type != "" {
+					toolCall.Type = tc.Type
+				}
+				if tc.Function.Name != "" {
+					toolCall.Function.Name = tc.Function.Name
+				}
+				if tc.Function.Arguments != "" {
+					toolCall.Function.Arguments += tc.Function.Arguments
+				}
+			}
+		}
+	}
+
+	// Convert map to slice in index order; indices need not start at 0 or be contiguous
+	indices := make([]int, 0, len(toolCallsMap))
+	for idx := range toolCallsMap {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+	toolCalls := make([]ToolCall, 0, len(indices))
+	for _, idx := range indices {
+		toolCalls = append(toolCalls, *toolCallsMap[idx])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fullResponse.String(), toolCalls, fmt.Errorf("error reading stream: %w", err)
+	}
+
+	logger.Debug("[ChatService] parsed %d tool calls", len(toolCalls))
+	for i, tc := range toolCalls {
+		logger.Debug("[ChatService] tool call %d: id=%s, name=%s, args=%s", i, tc.ID, tc.Function.Name, tc.Function.Arguments)
+	}
+
+	return fullResponse.String(), toolCalls, nil
+}
+
+// Complete calls the API without streaming
+func (p *openAIProvider) Complete(ctx context.Context, messages []ChatMessage, maxTokens int) (string, error) {
+	reqBody := map[string]interface{}{
+		"model":      p.cfg.Model,
+		"messages":   messages,
+		"max_tokens": maxTokens,
+		"stream":     false,
+	}
+	return p.complete(ctx, reqBody)
+}
+
+// CompleteJSON calls the API with a json_schema response format
+func (p *openAIProvider) CompleteJSON(ctx context.Context, messages []ChatMessage, schema ResponseSchema, maxTokens int) (string, error) {
+	reqBody := map[string]interface{}{
+		"model":      p.cfg.Model,
+		"messages":   messages,
+		"max_tokens": maxTokens,
+		"stream":     false,
+		"response_format": map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":        schema.Name,
+				"description": schema.Description,
+				"strict":      true,
+				"schema":      schema.Schema,
+			},
+		},
+	}
+	return p.complete(ctx, reqBody)
+}
+
+// complete sends a non-streaming request and returns the content of the first choice
+func (p *openAIProvider) complete(ctx context.Context, reqBody map[string]interface{}) (string, error) {
+	resp, err := postJSON(ctx, p.cfg.BaseURL+"/v1/chat/completions", map[string]string{"Authorization": "Bearer " + p.cfg.APIKey}, reqBody)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("no response from API")
+	}
+
+	return result.Choices[0].Message.Content, nil
+}
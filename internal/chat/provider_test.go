@@ -0,0 +1,452 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordedServer replays a recorded response body line by line, flushing after
+// each line like a streaming API. With hold set the connection is kept open after
+// the last line until the client goes away.
+type recordedServer struct {
+	path    string
+	status  int
+	lines   []string
+	hold    bool
+	request *http.Request
+	body    map[string]any
+}
+
+func (s *recordedServer) start(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != s.path {
+			t.Errorf("request path = %s, want %s", r.URL.Path, s.path)
+		}
+		raw, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(raw, &s.body); err != nil {
+			t.Errorf("request body is not JSON: %v", err)
+		}
+		s.request = r
+
+		if s.status != 0 {
+			w.WriteHeader(s.status)
+		}
+		flusher := w.(http.Flusher)
+		for _, line := range s.lines {
+			io.WriteString(w, line+"\n")
+			flusher.Flush()
+		}
+		if s.hold {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// streamChat runs StreamChat against the server and collects the content deltas
+func streamChat(t *testing.T, cfg ProviderConfig, tools []Tool) (string, []string, []ToolCall, error) {
+	t.Helper()
+	provider, err := NewChatProvider(cfg)
+	if err != nil {
+		t.Fatalf("NewChatProvider: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var deltas []string
+	content, toolCalls, err := provider.StreamChat(ctx, []ChatMessage{
+		{Role: "system", Content: "You are a diary assistant."},
+		{Role: "user", Content: "What did I do on Monday?"},
+	}, tools, "auto", func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	return content, deltas, toolCalls, err
+}
+
+var searchTool = Tool{
+	Type: "function",
+	Function: ToolFunction{
+		Name:        "search_diaries",
+		Description: "Search the diaries",
+		Parameters:  map[string]interface{}{"type": "object"},
+	},
+}
+
+// toolCall builds an expected tool call
+func toolCall(index int, id, name, arguments string) ToolCall {
+	tc := ToolCall{Index: index, ID: id, Type: "function"}
+	tc.Function.Name = name
+	tc.Function.Arguments = arguments
+	return tc
+}
+
+func TestOpenAIStreamChat(t *testing.T) {
+	srv := &recordedServer{
+		path: "/v1/chat/completions",
+		lines: []string{
+			`data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}`,
+			``,
+			`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"Let me "}}]}`,
+			``,
+			`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"check."}}]}`,
+			``,
+			`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"search_diaries","arguments":""}}]}}]}`,
+			``,
+			`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"que"}}]}}]}`,
+			``,
+			`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"get_diary","arguments":"{\"date\":"}}]}}]}`,
+			``,
+			`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"ry\":\"Monday\"}"}}]}}]}`,
+			``,
+			`data: {"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"\"2026-10-12\"}"}}]}}]}`,
+			``,
+			`data: {"id":"c1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+			``,
+			`data: [DONE]`,
+			``,
+			// Nothing after [DONE] is read
+			`data: {"id":"c1","choices":[{"index":0,"delta":{"content":"ignored"}}]}`,
+		},
+	}
+	url := srv.start(t)
+
+	content, deltas, toolCalls, err := streamChat(t, ProviderConfig{Provider: ProviderOpenAI, BaseURL: url + "/", APIKey: "sk-test", Model: "gpt-test"}, []Tool{searchTool})
+	if err != nil {
+		t.Fatalf("StreamChat: %v", err)
+	}
+	if content != "Let me check." {
+		t.Errorf("content = %q", content)
+	}
+	if want := []string{"Let me ", "check."}; !reflect.DeepEqual(deltas, want) {
+		t.Errorf("deltas = %q, want %q", deltas, want)
+	}
+	want := []ToolCall{
+		toolCall(0, "call_a", "search_diaries", `{"query":"Monday"}`),
+		toolCall(1, "call_b", "get_diary", `{"date":"2026-10-12"}`),
+	}
+	if !reflect.DeepEqual(toolCalls, want) {
+		t.Errorf("tool calls = %+v, want %+v", toolCalls, want)
+	}
+
+	if got := srv.request.Header.Get("Authorization"); got != "Bearer sk-test" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if srv.body["stream"] != true || srv.body["model"] != "gpt-test" || srv.body["tool_choice"] != "auto" {
+		t.Errorf("request body = %v", srv.body)
+	}
+}
+
+func TestOpenAIStreamChatSparseToolIndices(t *testing.T) {
+	// Some compatible servers number tool calls from 1 or skip indices
+	srv := &recordedServer{
+		path: "/v1/chat/completions",
+		lines: []string{
+			`data: {"choices":[{"delta":{"tool_calls":[{"index":3,"id":"call_b","type":"function","function":{"name":"get_diary","arguments":"{}"}}]}}]}`,
+			`data: {"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_a","type":"function","function":{"name":"search_diaries","arguments":"{}"}}]}}]}`,
+			`data: [DONE]`,
+		},
+	}
+	url := srv.start(t)
+
+	_, _, toolCalls, err := streamChat(t, ProviderConfig{Provider: ProviderOpenAI, BaseURL: url, APIKey: "sk-test", Model: "gpt-test"}, []Tool{searchTool})
+	if err != nil {
+		t.Fatalf("StreamChat: %v", err)
+	}
+	want := []ToolCall{
+		toolCall(1, "call_a", "search_diaries", `{}`),
+		toolCall(3, "call_b", "get_diary", `{}`),
+	}
+	if !reflect.DeepEqual(toolCalls, want) {
+		t.Errorf("tool calls = %+v, want %+v", toolCalls, want)
+	}
+}
+
+func TestOpenAIStreamChatErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		lines  []string
+		want   string
+	}{
+		{
+			name: "error event",
+			lines: []string{
+				`data: {"choices":[{"delta":{"content":"Partial"}}]}`,
+				`data: {"error":{"type":"server_error","message":"The server is overloaded"}}`,
+			},
+			want: "API stream error: server_error: The server is overloaded",
+		},
+		{
+			name:   "error status",
+			status: http.StatusUnauthorized,
+			lines:  []string{`{"error":{"message":"Incorrect API key provided"}}`},
+			want:   "API returned status 401",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &recordedServer{path: "/v1/chat/completions", status: tt.status, lines: tt.lines}
+			url := srv.start(t)
+
+			_, _, _, err := streamChat(t, ProviderConfig{Provider: ProviderOpenAI, BaseURL: url, APIKey: "sk-test", Model: "gpt-test"}, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("StreamChat = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAIComplete(t *testing.T) {
+	srv := &recordedServer{
+		path:  "/v1/chat/completions",
+		lines: []string{`{"choices":[{"message":{"role":"assistant","content":"Monday at the lake"}}]}`},
+	}
+	url := srv.start(t)
+
+	provider, _ := NewChatProvider(ProviderConfig{Provider: ProviderOpenAI, BaseURL: url, APIKey: "sk-test", Model: "gpt-test"})
+	content, err := provider.Complete(context.Background(), []ChatMessage{{Role: "user", Content: "Title?"}}, 60)
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if content != "Monday at the lake" {
+		t.Errorf("content = %q", content)
+	}
+	if srv.body["stream"] != false || srv.body["max_tokens"] != float64(60) {
+		t.Errorf("request body = %v", srv.body)
+	}
+}
+
+func TestCompleteUsesCallerDeadline(t *testing.T) {
+	// The server never answers, so only the caller's context ends the request
+	srv := &recordedServer{path: "/v1/chat/completions", hold: true}
+	url := srv.start(t)
+
+	provider, _ := NewChatProvider(ProviderConfig{Provider: ProviderOpenAI, BaseURL: url, APIKey: "sk-test", Model: "gpt-test"})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := provider.Complete(ctx, []ChatMessage{{Role: "user", Content: "Hi"}}, 60); err == nil {
+		t.Fatal("Complete succeeded without a response")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Complete returned after %v, want it to stop at the caller's deadline", elapsed)
+	}
+}
+
+func TestAnthropicStreamChat(t *testing.T) {
+	srv := &recordedServer{
+		path: "/v1/messages",
+		hold: true,
+		lines: []string{
+			`event: message_start`,
+			`data: {"type":"message_start","message":{"id":"msg_1","role":"assistant","content":[]}}`,
+			``,
+			`event: content_block_start`,
+			`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			``,
+			`event: ping`,
+			`data: {"type":"ping"}`,
+			``,
+			`event: content_block_delta`,
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me "}}`,
+			``,
+			`event: content_block_delta`,
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"check."}}`,
+			``,
+			`event: content_block_stop`,
+			`data: {"type":"content_block_stop","index":0}`,
+			``,
+			`event: content_block_start`,
+			`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_a","name":"search_diaries","input":{}}}`,
+			``,
+			`event: content_block_delta`,
+			`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":""}}`,
+			``,
+			`event: content_block_delta`,
+			`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"query\": \"Mon"}}`,
+			``,
+			`event: content_block_delta`,
+			`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"day\"}"}}`,
+			``,
+			`event: content_block_stop`,
+			`data: {"type":"content_block_stop","index":1}`,
+			``,
+			// A tool without parameters streams no input
+			`event: content_block_start`,
+			`data: {"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_b","name":"get_stats","input":{}}}`,
+			``,
+			`event: content_block_stop`,
+			`data: {"type":"content_block_stop","index":2}`,
+			``,
+			`event: message_delta`,
+			`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"}}`,
+			``,
+			`event: message_stop`,
+			`data: {"type":"message_stop"}`,
+			``,
+		},
+	}
+	url := srv.start(t)
+
+	content, deltas, toolCalls, err := streamChat(t, ProviderConfig{Provider: ProviderAnthropic, BaseURL: url, APIKey: "sk-ant-test", Model: "claude-test"}, []Tool{searchTool})
+	if err != nil {
+		t.Fatalf("StreamChat: %v", err)
+	}
+	if content != "Let me check." {
+		t.Errorf("content = %q", content)
+	}
+	if want := []string{"Let me ", "check."}; !reflect.DeepEqual(deltas, want) {
+		t.Errorf("deltas = %q, want %q", deltas, want)
+	}
+	want := []ToolCall{
+		toolCall(0, "toolu_a", "search_diaries", `{"query": "Monday"}`),
+		toolCall(1, "toolu_b", "get_stats", `{}`),
+	}
+	if !reflect.DeepEqual(toolCalls, want) {
+		t.Errorf("tool calls = %+v, want %+v", toolCalls, want)
+	}
+
+	if got := srv.request.Header.Get("x-api-key"); got != "sk-ant-test" {
+		t.Errorf("x-api-key = %q", got)
+	}
+	if got := srv.request.Header.Get("anthropic-version"); got != anthropicVersion {
+		t.Errorf("anthropic-version = %q", got)
+	}
+	if srv.body["system"] != "You are a diary assistant." || srv.body["stream"] != true {
+		t.Errorf("request body = %v", srv.body)
+	}
+}
+
+func TestAnthropicStreamChatError(t *testing.T) {
+	srv := &recordedServer{
+		path: "/v1/messages",
+		lines: []string{
+			`event: content_block_delta`,
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Partial"}}`,
+			``,
+			`event: error`,
+			`data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			``,
+		},
+	}
+	url := srv.start(t)
+
+	content, _, _, err := streamChat(t, ProviderConfig{Provider: ProviderAnthropic, BaseURL: url, APIKey: "sk-ant-test", Model: "claude-test"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "overloaded_error: Overloaded") {
+		t.Fatalf("StreamChat = %v, want the overloaded error", err)
+	}
+	if content != "Partial" {
+		t.Errorf("content = %q, want the partial answer", content)
+	}
+}
+
+func TestAnthropicCompleteJSON(t *testing.T) {
+	srv := &recordedServer{
+		path:  "/v1/messages",
+		lines: []string{`{"content":[{"type":"tool_use","id":"toolu_a","name":"enrichment","input":{"mood":"happy","topics":["lake"]}}]}`},
+	}
+	url := srv.start(t)
+
+	provider, _ := NewChatProvider(ProviderConfig{Provider: ProviderAnthropic, BaseURL: url, APIKey: "sk-ant-test", Model: "claude-test"})
+	raw, err := provider.CompleteJSON(context.Background(), []ChatMessage{{Role: "user", Content: "Enrich"}}, ResponseSchema{
+		Name:   "enrichment",
+		Schema: map[string]interface{}{"type": "object"},
+	}, 200)
+	if err != nil {
+		t.Fatalf("CompleteJSON: %v", err)
+	}
+	if raw != `{"mood":"happy","topics":["lake"]}` {
+		t.Errorf("CompleteJSON = %s", raw)
+	}
+	choice, _ := srv.body["tool_choice"].(map[string]any)
+	if choice["type"] != "tool" || choice["name"] != "enrichment" {
+		t.Errorf("tool_choice = %v", srv.body["tool_choice"])
+	}
+}
+
+func TestOllamaStreamChat(t *testing.T) {
+	srv := &recordedServer{
+		path: "/api/chat",
+		lines: []string{
+			`{"model":"llama","message":{"role":"assistant","content":"Let me "},"done":false}`,
+			`{"model":"llama","message":{"role":"assistant","content":"check."},"done":false}`,
+			``,
+			`{"model":"llama","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"search_diaries","arguments":{"query":"Monday"}}},{"function":{"name":"get_stats","arguments":{}}}]},"done":false}`,
+			`{"model":"llama","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`,
+			// Nothing after the done chunk is read
+			`{"model":"llama","message":{"role":"assistant","content":"ignored"},"done":false}`,
+		},
+	}
+	url := srv.start(t)
+
+	content, deltas, toolCalls, err := streamChat(t, ProviderConfig{Provider: ProviderOllama, BaseURL: url, Model: "llama", ContextWindow: 8192}, []Tool{searchTool})
+	if err != nil {
+		t.Fatalf("StreamChat: %v", err)
+	}
+	if content != "Let me check." {
+		t.Errorf("content = %q", content)
+	}
+	if want := []string{"Let me ", "check."}; !reflect.DeepEqual(deltas, want) {
+		t.Errorf("deltas = %q, want %q", deltas, want)
+	}
+	want := []ToolCall{
+		toolCall(0, "", "search_diaries", `{"query":"Monday"}`),
+		toolCall(1, "", "get_stats", `{}`),
+	}
+	if !reflect.DeepEqual(toolCalls, want) {
+		t.Errorf("tool calls = %+v, want %+v", toolCalls, want)
+	}
+
+	options, _ := srv.body["options"].(map[string]any)
+	if srv.body["stream"] != true || options["num_ctx"] != float64(8192) {
+		t.Errorf("request body = %v", srv.body)
+	}
+}
+
+func TestOllamaStreamChatError(t *testing.T) {
+	srv := &recordedServer{
+		path:  "/api/chat",
+		lines: []string{`{"error":"model \"llama\" not found, try pulling it first"}`},
+	}
+	url := srv.start(t)
+
+	_, _, _, err := streamChat(t, ProviderConfig{Provider: ProviderOllama, BaseURL: url, Model: "llama"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "not found, try pulling it first") {
+		t.Fatalf("StreamChat = %v, want the model error", err)
+	}
+}
+
+func TestOllamaComplete(t *testing.T) {
+	srv := &recordedServer{
+		path:  "/api/chat",
+		lines: []string{`{"model":"llama","message":{"role":"assistant","content":"Monday at the lake"},"done":true}`},
+	}
+	url := srv.start(t)
+
+	provider, _ := NewChatProvider(ProviderConfig{Provider: ProviderOllama, BaseURL: url, Model: "llama"})
+	content, err := provider.Complete(context.Background(), []ChatMessage{{Role: "user", Content: "Title?"}}, 60)
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if content != "Monday at the lake" {
+		t.Errorf("content = %q", content)
+	}
+	options, _ := srv.body["options"].(map[string]any)
+	if srv.body["stream"] != false || options["num_predict"] != float64(60) {
+		t.Errorf("request body = %v", srv.body)
+	}
+}
@@ -1,28 +1,31 @@
 package chat
 
 import (
-	"bufio"
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/songtianlun/diarum/internal/analytics"
 	"github.com/songtianlun/diarum/internal/config"
 	"github.com/songtianlun/diarum/internal/embedding"
 	"github.com/songtianlun/diarum/internal/logger"
+	"github.com/songtianlun/diarum/internal/placeholder"
 )
 
 // ChatService handles AI chat operations with RAG
 type ChatService struct {
 	app              *pocketbase.PocketBase
 	embeddingService *embedding.EmbeddingService
+	analyticsService *analytics.Service
 	configService    *config.ConfigService
+	// summarizing holds the IDs of conversations whose summary is being generated
+	summarizing sync.Map
+	generations generations
 }
 
 // ChatMessage represents a message in the chat
@@ -68,10 +71,13 @@ type SearchDiariesArgs struct {
 
 // ChatRequest represents a request to the chat API
 type ChatRequest struct {
-	Model    string        `json:"model"`
-	Messages []ChatMessage `json:"messages"`
-	Tools    []Tool        `json:"tools,omitempty"`
-	Stream   bool          `json:"stream"`
+	Model       string        `json:"model"`
+	Messages    []ChatMessage `json:"messages"`
+	Tools       []Tool        `json:"tools,omitempty"`
+	ToolChoice  string        `json:"tool_choice,omitempty"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Stream      bool          `json:"stream"`
 }
 
 // ChatStreamResponse represents a streaming response chunk
@@ -89,6 +95,11 @@ type ChatStreamResponse struct {
 		} `json:"delta"`
 		FinishReason *string `json:"finish_reason"`
 	} `json:"choices"`
+	// Error is sent instead of choices when the API fails mid-stream
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
 }
 
 // StreamWriter is an interface for writing streaming responses
@@ -98,49 +109,15 @@ type StreamWriter interface {
 }
 
 // NewChatService creates a new ChatService
-func NewChatService(app *pocketbase.PocketBase, embeddingService *embedding.EmbeddingService) *ChatService {
+func NewChatService(app *pocketbase.PocketBase, embeddingService *embedding.EmbeddingService, analyticsService *analytics.Service) *ChatService {
 	return &ChatService{
 		app:              app,
 		embeddingService: embeddingService,
+		analyticsService: analyticsService,
 		configService:    config.NewConfigService(app),
 	}
 }
 
-// getTools returns the available tools for the chat
-func (s *ChatService) getTools() []Tool {
-	return []Tool{
-		{
-			Type: "function",
-			Function: ToolFunction{
-				Name:        "search_diaries",
-				Description: "搜索用户的日记。可以按时间范围筛选，也可以按语义相似度搜索。用于回答关于用户日记内容的问题，如总结、回顾、分析等。",
-				Parameters: map[string]interface{}{
-					"type": "object",
-					"properties": map[string]interface{}{
-						"start_date": map[string]interface{}{
-							"type":        "string",
-							"description": "开始日期，格式 YYYY-MM-DD。用于筛选该日期之后的日记。",
-						},
-						"end_date": map[string]interface{}{
-							"type":        "string",
-							"description": "结束日期，格式 YYYY-MM-DD。用于筛选该日期之前的日记。",
-						},
-						"query": map[string]interface{}{
-							"type":        "string",
-							"description": "语义搜索关键词。用于查找与该主题相关的日记。",
-						},
-						"limit": map[string]interface{}{
-							"type":        "integer",
-							"description": "返回的最大日记数量，默认10，最大100。",
-						},
-					},
-					"required": []string{},
-				},
-			},
-		},
-	}
-}
-
 // QueryRelevantDiaries retrieves diaries relevant to the query
 func (s *ChatService) QueryRelevantDiaries(ctx context.Context, userID, query string, limit int) ([]embedding.DiarySearchResult, error) {
 	if s.embeddingService == nil {
@@ -262,399 +239,212 @@ func (s *ChatService) buildSystemPrompt(diaries []embedding.DiarySearchResult) s
 	return sb.String()
 }
 
-// buildAgentSystemPrompt creates the system prompt for the agent with tools
-func (s *ChatService) buildAgentSystemPrompt() string {
-	today := time.Now().Format("2006-01-02")
-	return fmt.Sprintf(`You are a helpful AI assistant for a personal diary application called Diarum.
-You help users reflect on their diary entries, summarize their experiences, and provide insights based on their personal journal.
+// agentInstructions describes the tools and how to cite diaries, it is appended to
+// the persona or custom prompt of every agent system prompt
+const agentInstructions = `Today's date is: {{today}} ({{weekday}}), time zone {{timezone}}
 
-Today's date is: %s
+You have access to these tools:
+- search_diaries: find diary entries by date range and/or topic
+- get_diary_by_date: read the full entry of a specific day
+- diary_stats: exact counts, streaks, mood and weather distributions over a date range
+- list_tags: the user's tags and how often each is used
+- get_conversation_summary: recap this conversation (or another one by ID)
 
-You have access to the search_diaries tool to find relevant diary entries. Use it when:
+Use them when:
 - User asks about their diary content, memories, or experiences
 - User wants a summary or analysis of a time period (e.g., "summarize this month", "what happened last week")
 - User asks about specific topics they may have written about
+- User asks quantitative questions (e.g., "how many days did I write in March", "what was my most common mood") - always use diary_stats instead of estimating
 
 When using search_diaries:
 - For time-based queries (e.g., "this year", "last month"), set appropriate start_date and end_date
 - For topic-based queries (e.g., "about travel"), use the query parameter
 - Adjust limit based on the scope: use higher limits (30-50) for summaries, lower (5-10) for specific questions
 
-Always reference specific dates when discussing diary entries. Respond in the same language as the user.`, today)
-}
+Always reference specific dates when discussing diary entries.
+When a statement is based on a diary entry returned by a tool, cite it right after the statement as [[YYYY-MM-DD]] with the entry's date,
+optionally with a short exact quote from the entry: [[YYYY-MM-DD|quoted words]]. Only cite entries the tools returned, and cite each statement's own source.`
 
-// GetConversationHistory retrieves message history for a conversation
-func (s *ChatService) GetConversationHistory(conversationID string, limit int) ([]ChatMessage, error) {
-	messages, err := s.app.Dao().FindRecordsByFilter(
-		"ai_messages",
-		"conversation = {:conv}",
-		"created",
-		limit,
-		0,
-		map[string]any{"conv": conversationID},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch messages: %w", err)
-	}
-
-	history := make([]ChatMessage, 0, len(messages))
-	for _, msg := range messages {
-		history = append(history, ChatMessage{
-			Role:    msg.GetString("role"),
-			Content: msg.GetString("content"),
-		})
+// buildAgentSystemPrompt creates the system prompt for the agent with tools from the
+// user's persona or custom prompt, the tool instructions and the response language
+func (s *ChatService) buildAgentSystemPrompt(settings promptSettings) string {
+	parts := []string{settings.intro()}
+	if settings.UserName != "" {
+		parts = append(parts, "The user's name is {{user_name}}.")
 	}
-	return history, nil
+	parts = append(parts, agentInstructions, settings.languageInstruction())
+	return placeholder.Render(strings.Join(parts, "\n\n"), settings.variables(time.Now()), nil)
 }
 
-// SaveMessage saves a message to the database
-func (s *ChatService) SaveMessage(userID, conversationID, role, content string, referencedDiaries []string) (*models.Record, error) {
-	collection, err := s.app.Dao().FindCollectionByNameOrId("ai_messages")
+// GetConversationHistory retrieves the most recent messages of a conversation's active branch.
+// If after is set, only messages created later are returned.
+func (s *ChatService) GetConversationHistory(conversationID string, after types.DateTime, limit int) ([]ChatMessage, error) {
+	conv, err := s.app.Dao().FindRecordById("ai_conversations", conversationID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find messages collection: %w", err)
-	}
-
-	record := models.NewRecord(collection)
-	record.Set("conversation", conversationID)
-	record.Set("role", role)
-	record.Set("content", content)
-	record.Set("owner", userID)
-	if len(referencedDiaries) > 0 {
-		record.Set("referenced_diaries", referencedDiaries)
+		return nil, fmt.Errorf("conversation not found: %w", err)
 	}
-
-	if err := s.app.Dao().SaveRecord(record); err != nil {
-		return nil, fmt.Errorf("failed to save message: %w", err)
-	}
-
-	return record, nil
-}
-
-// StreamChat performs streaming chat with RAG context
-func (s *ChatService) StreamChat(ctx context.Context, userID, conversationID, message string, writer StreamWriter) (string, []string, error) {
-	logger.Info("[ChatService] starting stream chat for user: %s, conversation: %s", userID, conversationID)
-
-	// Get AI configuration
-	apiKey, err := s.configService.GetString(userID, "ai.api_key")
-	if err != nil || apiKey == "" {
-		return "", nil, fmt.Errorf("AI API key not configured")
-	}
-
-	baseURL, err := s.configService.GetString(userID, "ai.base_url")
-	if err != nil || baseURL == "" {
-		return "", nil, fmt.Errorf("AI base URL not configured")
-	}
-
-	chatModel, err := s.configService.GetString(userID, "ai.chat_model")
-	if err != nil || chatModel == "" {
-		return "", nil, fmt.Errorf("chat model not configured")
-	}
-
-	// Build initial messages with system prompt
-	systemPrompt := s.buildAgentSystemPrompt()
-	messages := []ChatMessage{
-		{Role: "system", Content: systemPrompt},
-	}
-
-	// Add conversation history
-	history, err := s.GetConversationHistory(conversationID, 20)
+	branch, err := s.activeBranch(conv)
 	if err != nil {
-		logger.Warn("[ChatService] failed to get conversation history: %v", err)
-	} else {
-		messages = append(messages, history...)
+		return nil, err
 	}
 
-	// Add current message
-	messages = append(messages, ChatMessage{Role: "user", Content: message})
-
-	// Get tools
-	tools := s.getTools()
-
-	// Call API with tools (first round)
-	var referencedDiaryIDs []string
-	fullResponse, toolCalls, err := s.callAPIWithTools(ctx, baseURL, apiKey, chatModel, messages, tools, writer)
-	if err != nil {
-		return "", nil, err
-	}
-
-	// Handle tool calls if any
-	if len(toolCalls) > 0 {
-		// Process tool calls and collect results
-		var toolResults []string
-		for _, tc := range toolCalls {
-			if tc.Function.Name == "search_diaries" {
-				var args SearchDiariesArgs
-				if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
-					logger.Error("[ChatService] failed to parse tool arguments: %v", err)
-					continue
-				}
-
-				// Execute search
-				diaries, err := s.SearchDiariesByDateRange(ctx, userID, args)
-				if err != nil {
-					logger.Error("[ChatService] search_diaries failed: %v", err)
-					continue
-				}
-
-				// Collect diary IDs
-				for _, d := range diaries {
-					referencedDiaryIDs = append(referencedDiaryIDs, d.ID)
-				}
-
-				// Build tool result
-				toolResult := s.formatDiariesForContext(diaries)
-				toolResults = append(toolResults, toolResult)
-			}
-		}
-
-		// Build new messages with tool results as context
-		// Use a simpler format that's more compatible with various models
-		if len(toolResults) > 0 {
-			contextMsg := "Based on the diary search results:\n\n" + strings.Join(toolResults, "\n\n")
-			messages = append(messages, ChatMessage{
-				Role:    "user",
-				Content: contextMsg + "\n\nPlease summarize the above diary entries for me.",
-			})
-		}
-
-		// Call API again without tools to get final response
-		fullResponse, _, err = s.callAPIWithTools(ctx, baseURL, apiKey, chatModel, messages, nil, writer)
-		if err != nil {
-			return "", nil, err
+	start := 0
+	if !after.IsZero() {
+		for start < len(branch) && !branch[start].Created.Time().After(after.Time()) {
+			start++
 		}
 	}
-
-	return fullResponse, referencedDiaryIDs, nil
-}
-
-// callStreamingAPI calls the OpenAI-compatible streaming API
-func (s *ChatService) callStreamingAPI(ctx context.Context, baseURL, apiKey, model string, messages []ChatMessage, writer StreamWriter) (string, error) {
-	baseURL = strings.TrimSuffix(baseURL, "/")
-	url := baseURL + "/v1/chat/completions"
-
-	reqBody := ChatRequest{
-		Model:    model,
-		Messages: messages,
-		Stream:   true,
-	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "text/event-stream")
-
-	client := &http.Client{Timeout: 5 * time.Minute}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+	if limit > 0 && len(branch)-start > limit {
+		start = len(branch) - limit
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	history := make([]ChatMessage, 0, len(branch)-start)
+	for _, msg := range branch[start:] {
+		history = append(history, recordToChatMessage(msg))
 	}
+	return pairToolMessages(history), nil
+}
 
-	return s.processStreamResponse(resp.Body, writer)
+// SaveMessage saves a message to the database
+func (s *ChatService) SaveMessage(userID, conversationID, role, content string, referencedDiaries []string) (*models.Record, error) {
+	return s.saveChatMessage(userID, conversationID, ChatMessage{Role: role, Content: content}, referencedDiaries)
 }
 
-// callAPIWithTools calls the API with tool support
-func (s *ChatService) callAPIWithTools(ctx context.Context, baseURL, apiKey, model string, messages []ChatMessage, tools []Tool, writer StreamWriter) (string, []ToolCall, error) {
-	baseURL = strings.TrimSuffix(baseURL, "/")
-	url := baseURL + "/v1/chat/completions"
+// ChatResult is the outcome of a chat turn
+type ChatResult struct {
+	Content           string
+	ReferencedDiaries []string
+	Citations         []Citation
+	Usage             ContextUsage
+	// Stopped is set when the turn was cancelled, Content then holds the partial answer
+	Stopped bool
+}
 
-	reqBody := ChatRequest{
-		Model:    model,
-		Messages: messages,
-		Tools:    tools,
-		Stream:   true,
-	}
+// StreamChat performs streaming chat with RAG context
+func (s *ChatService) StreamChat(ctx context.Context, userID, conversationID, message string, writer StreamWriter) (*ChatResult, error) {
+	logger.Info("[ChatService] starting stream chat for user: %s, conversation: %s", userID, conversationID)
 
-	jsonBody, err := json.Marshal(reqBody)
+	cfg, err := s.getProviderConfig(userID)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to marshal request: %w", err)
+		return nil, err
 	}
-
-	// Debug log the request
-	logger.Debug("[ChatService] API request: %s", string(jsonBody))
-
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
+	provider, err := NewChatProvider(cfg)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "text/event-stream")
-
-	client := &http.Client{Timeout: 5 * time.Minute}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to send request: %w", err)
+	// Messages covered by the conversation summary are replaced by it
+	var summary string
+	var summarizedUntil types.DateTime
+	if conv, err := s.app.Dao().FindRecordById("ai_conversations", conversationID); err == nil {
+		summary = conv.GetString("summary")
+		summarizedUntil = conv.GetDateTime("summarized_until")
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	// Conversation history, without the current message which the caller has already saved
+	history, err := s.GetConversationHistory(conversationID, summarizedUntil, historyFetchLimit)
+	if err != nil {
+		logger.Warn("[ChatService] failed to get conversation history: %v", err)
 	}
-
-	return s.processStreamResponseWithTools(resp.Body, writer)
-}
-
-// processStreamResponse processes the SSE stream and writes to the client
-func (s *ChatService) processStreamResponse(body io.Reader, writer StreamWriter) (string, error) {
-	scanner := bufio.NewScanner(body)
-	var fullResponse strings.Builder
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		if !strings.HasPrefix(line, "data: ") {
-			continue
+	if n := len(history); n > 0 && history[n-1].Role == "user" && history[n-1].Content == message {
+		history = history[:n-1]
+	}
+
+	// Fit system prompt, history and the current message into the context window
+	settings := s.getPromptSettings(userID)
+	tools := s.getTools(settings.Language)
+	builder := newContextBuilder(cfg.ContextWindow, cfg.MaxTokens)
+	messages := builder.build(s.buildAgentSystemPrompt(settings), summary, tools, history, message)
+	logger.Info("[ChatService] context: window=%d, history=%d messages (%d omitted), ~%d tokens",
+		cfg.ContextWindow, builder.usage.HistoryMessages, builder.usage.OmittedMessages, builder.usage.HistoryTokens)
+
+	// Agent loop: the model may call tools for up to maxSteps-1 rounds,
+	// the last step withholds tools so it has to answer
+	maxSteps := s.getMaxSteps(userID)
+	citations := newCitationTracker()
+	for step := 1; ; step++ {
+		toolChoice := ""
+		// Also answer when too little budget is left for more tool results
+		if step >= maxSteps || builder.remaining(messages, tools) < minToolResultTokens {
+			toolChoice = "none"
 		}
 
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "[DONE]" {
-			break
-		}
-
-		var streamResp ChatStreamResponse
-		if err := json.Unmarshal([]byte(data), &streamResp); err != nil {
-			logger.Warn("[ChatService] failed to parse stream chunk: %v", err)
-			continue
-		}
-
-		if len(streamResp.Choices) > 0 {
-			content := streamResp.Choices[0].Delta.Content
-			if content != "" {
-				fullResponse.WriteString(content)
-
-				// Write SSE event to client
-				sseData := map[string]string{"content": content}
-				jsonData, _ := json.Marshal(sseData)
-				writer.Write([]byte("data: " + string(jsonData) + "\n\n"))
-				writer.Flush()
+		citations.newStep()
+		var streamed strings.Builder
+		content, toolCalls, err := provider.StreamChat(ctx, messages, tools, toolChoice, func(delta string) {
+			streamed.WriteString(delta)
+			WriteEvent(writer, EventDelta, DeltaEvent{Content: delta})
+			for _, c := range citations.write(delta) {
+				writeCitationEvent(writer, c)
 			}
+		})
+		if ctx.Err() != nil {
+			// Stopped or timed out, keep what was streamed so far
+			logger.Info("[ChatService] chat for conversation %s stopped at step %d: %v", conversationID, step, ctx.Err())
+			return &ChatResult{
+				Content:           streamed.String(),
+				ReferencedDiaries: citations.diaryIDs(),
+				Citations:         citations.citations,
+				Usage:             builder.usage,
+				Stopped:           true,
+			}, nil
 		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fullResponse.String(), fmt.Errorf("error reading stream: %w", err)
-	}
-
-	return fullResponse.String(), nil
-}
-
-// processStreamResponseWithTools processes SSE stream with tool call support
-func (s *ChatService) processStreamResponseWithTools(body io.Reader, writer StreamWriter) (string, []ToolCall, error) {
-	scanner := bufio.NewScanner(body)
-	var fullResponse strings.Builder
-	toolCallsMap := make(map[int]*ToolCall) // Use index as key
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "[DONE]" {
-			break
+		if err != nil {
+			return nil, err
 		}
-
-		var streamResp ChatStreamResponse
-		if err := json.Unmarshal([]byte(data), &streamResp); err != nil {
-			logger.Warn("[ChatService] failed to parse stream chunk: %v", err)
-			continue
+		builder.addStep(messages, tools, content, toolCalls)
+		if len(toolCalls) == 0 || toolChoice == "none" {
+			return &ChatResult{
+				Content:           content,
+				ReferencedDiaries: citations.diaryIDs(),
+				Citations:         citations.citations,
+				Usage:             builder.usage,
+			}, nil
 		}
 
-		if len(streamResp.Choices) > 0 {
-			choice := streamResp.Choices[0]
-
-			// Handle content
-			if choice.Delta.Content != "" {
-				fullResponse.WriteString(choice.Delta.Content)
-				sseData := map[string]string{"content": choice.Delta.Content}
-				jsonData, _ := json.Marshal(sseData)
-				writer.Write([]byte("data: " + string(jsonData) + "\n\n"))
-				writer.Flush()
+		// Some OpenAI-compatible servers omit call IDs, which tool messages need
+		for i := range toolCalls {
+			if toolCalls[i].ID == "" {
+				toolCalls[i].ID = fmt.Sprintf("call_%d_%d", step, i)
 			}
-
-			// Handle tool calls - accumulate by index
-			for _, tc := range choice.Delta.ToolCalls {
-				idx := tc.Index
-				if _, exists := toolCallsMap[idx]; !exists {
-					toolCallsMap[idx] = &ToolCall{Index: idx}
-				}
-				toolCall := toolCallsMap[idx]
-				if tc.ID != "" {
-					toolCall.ID = tc.ID
-				}
-				if tc.Type != "" {
-					toolCall.Type = tc.Type
-				}
-				if tc.Function.Name != "" {
-					toolCall.Function.Name = tc.Function.Name
-				}
-				if tc.Function.Arguments != "" {
-					toolCall.Function.Arguments += tc.Function.Arguments
-				}
+			if toolCalls[i].Type == "" {
+				toolCalls[i].Type = "function"
 			}
 		}
-	}
 
-	// Convert map to slice
-	toolCalls := make([]ToolCall, 0, len(toolCallsMap))
-	for i := 0; i < len(toolCallsMap); i++ {
-		if tc, exists := toolCallsMap[i]; exists {
-			toolCalls = append(toolCalls, *tc)
+		callMsg := ChatMessage{Role: "assistant", Content: content, ToolCalls: toolCalls}
+		messages = append(messages, callMsg)
+		if _, err := s.saveChatMessage(userID, conversationID, callMsg, nil); err != nil {
+			logger.Warn("[ChatService] failed to save tool call message: %v", err)
+		}
+		for _, tc := range toolCalls {
+			writeToolCallEvent(writer, step, tc)
 		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fullResponse.String(), toolCalls, fmt.Errorf("error reading stream: %w", err)
-	}
-
-	logger.Debug("[ChatService] parsed %d tool calls", len(toolCalls))
-	for i, tc := range toolCalls {
-		logger.Debug("[ChatService] tool call %d: id=%s, name=%s, args=%s", i, tc.ID, tc.Function.Name, tc.Function.Arguments)
-	}
-
-	return fullResponse.String(), toolCalls, nil
-}
-
-// formatDiariesForContext formats diaries for tool result context
-func (s *ChatService) formatDiariesForContext(diaries []embedding.DiarySearchResult) string {
-	if len(diaries) == 0 {
-		return "No diary entries found for the specified criteria."
-	}
-
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("Found %d diary entries:\n\n", len(diaries)))
 
-	for i, diary := range diaries {
-		sb.WriteString(fmt.Sprintf("--- Diary Entry %d (Date: %s) ---\n", i+1, diary.Date))
-		if diary.Mood != "" {
-			sb.WriteString(fmt.Sprintf("Mood: %s\n", diary.Mood))
+		budget := builder.toolResultBudget(messages, tools, len(toolCalls))
+		logger.Info("[ChatService] step %d: running %d tool call(s), ~%d tokens each", step, len(toolCalls), budget)
+		var retrieved []string
+		for _, result := range s.runToolCalls(ctx, userID, conversationID, toolCalls, budget) {
+			resultMsg := ChatMessage{Role: "tool", Content: result.Content, ToolCallID: result.ToolCallID}
+			messages = append(messages, resultMsg)
+			builder.addToolResult(resultMsg)
+			// Retrieved diaries are not persisted, only the ones the answer cites
+			if _, err := s.saveChatMessage(userID, conversationID, resultMsg, nil); err != nil {
+				logger.Warn("[ChatService] failed to save tool result message: %v", err)
+			}
+			writeToolResultEvent(writer, step, result)
+			retrieved = appendUnique(retrieved, result.DiaryIDs...)
 		}
-		if diary.Weather != "" {
-			sb.WriteString(fmt.Sprintf("Weather: %s\n", diary.Weather))
+
+		// The diaries shown to the model can be cited in its answer
+		if len(retrieved) > 0 {
+			records, err := s.app.Dao().FindRecordsByIds("diaries", retrieved)
+			if err != nil {
+				logger.Warn("[ChatService] failed to load retrieved diaries: %v", err)
+			}
+			citations.addDiaries(records)
 		}
-		sb.WriteString(fmt.Sprintf("Content:\n%s\n\n", diary.Content))
 	}
-
-	return sb.String()
 }
 
 // GenerateTitleFromUserMessage generates a title based only on the user's message
@@ -727,20 +517,9 @@ func stripHTMLTags(s string) string {
 
 // GenerateTitle generates a title for a conversation based on the first message
 func (s *ChatService) GenerateTitle(ctx context.Context, userID, userMessage, assistantResponse string) (string, error) {
-	// Get AI configuration
-	apiKey, err := s.configService.GetString(userID, "ai.api_key")
-	if err != nil || apiKey == "" {
-		return "", fmt.Errorf("AI API key not configured")
-	}
-
-	baseURL, err := s.configService.GetString(userID, "ai.base_url")
-	if err != nil || baseURL == "" {
-		return "", fmt.Errorf("AI base URL not configured")
-	}
-
-	chatModel, err := s.configService.GetString(userID, "ai.chat_model")
-	if err != nil || chatModel == "" {
-		return "", fmt.Errorf("chat model not configured")
+	provider, err := s.getProvider(userID)
+	if err != nil {
+		return "", err
 	}
 
 	// Build messages for title generation
@@ -758,59 +537,12 @@ Use the same language as the user's message.`,
 		},
 	}
 
-	// Call API without streaming
-	baseURL = strings.TrimSuffix(baseURL, "/")
-	url := baseURL + "/v1/chat/completions"
-
-	reqBody := map[string]interface{}{
-		"model":      chatModel,
-		"messages":   messages,
-		"max_tokens": 60,
-		"stream":     false,
-	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	content, err := provider.Complete(ctx, messages, 60)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var result struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("no response from API")
+		return "", err
 	}
 
-	title := strings.TrimSpace(result.Choices[0].Message.Content)
+	title := strings.TrimSpace(content)
 	// Ensure title is not too long
 	if len(title) > 100 {
 		title = title[:100]
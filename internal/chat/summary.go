@@ -0,0 +1,240 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// Conversation summary settings
+const (
+	// summaryThreshold is how many messages after the summary trigger summarization
+	summaryThreshold = 20
+	// summaryKeepRecent is how many recent messages are left out of the summary
+	summaryKeepRecent = 10
+	// summaryMaxTokens limits the length of a generated summary
+	summaryMaxTokens = 800
+	// summaryMessageTokens is how much of each message is passed to the summarizer
+	summaryMessageTokens = 500
+)
+
+// ErrSummaryInProgress is returned while the summary of the conversation is being generated
+var ErrSummaryInProgress = errors.New("summary is already being generated")
+
+// ConversationSummary is the stored summary of a conversation
+type ConversationSummary struct {
+	ConversationID  string `json:"conversation_id"`
+	Summary         string `json:"summary"`
+	SummarizedUntil string `json:"summarized_until"`
+	// PendingMessages is the number of messages not covered by the summary
+	PendingMessages int `json:"pending_messages"`
+}
+
+// GetSummary returns the stored summary of a conversation
+func (s *ChatService) GetSummary(conversationID string) (*ConversationSummary, error) {
+	conv, err := s.app.Dao().FindRecordById("ai_conversations", conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("conversation not found: %w", err)
+	}
+	return s.conversationSummary(conv)
+}
+
+// conversationSummary describes the summary stored on a conversation record
+func (s *ChatService) conversationSummary(conv *models.Record) (*ConversationSummary, error) {
+	pending, err := s.messagesAfter(conv, conv.GetDateTime("summarized_until"))
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &ConversationSummary{
+		ConversationID:  conv.Id,
+		Summary:         conv.GetString("summary"),
+		PendingMessages: len(pending),
+	}
+	if until := conv.GetDateTime("summarized_until"); !until.IsZero() {
+		summary.SummarizedUntil = until.String()
+	}
+	return summary, nil
+}
+
+// MaybeSummarize condenses the older messages of a conversation into its summary
+// once more than summaryThreshold messages are not covered by it
+func (s *ChatService) MaybeSummarize(ctx context.Context, userID, conversationID string) error {
+	conv, err := s.app.Dao().FindRecordById("ai_conversations", conversationID)
+	if err != nil {
+		return fmt.Errorf("conversation not found: %w", err)
+	}
+
+	messages, err := s.messagesAfter(conv, conv.GetDateTime("summarized_until"))
+	if err != nil {
+		return err
+	}
+	if len(messages) <= summaryThreshold {
+		return nil
+	}
+
+	logger.Info("[ChatService] summarizing conversation %s: %d messages after the summary", conv.Id, len(messages))
+	if err := s.summarize(ctx, userID, conv, messages); err != nil {
+		if errors.Is(err, ErrSummaryInProgress) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// RegenerateSummary rebuilds the summary of a conversation from all but its most recent messages
+func (s *ChatService) RegenerateSummary(ctx context.Context, userID, conversationID string) (*ConversationSummary, error) {
+	conv, err := s.app.Dao().FindRecordById("ai_conversations", conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("conversation not found: %w", err)
+	}
+
+	messages, err := s.messagesAfter(conv, types.DateTime{})
+	if err != nil {
+		return nil, err
+	}
+
+	conv.Set("summary", "")
+	conv.Set("summarized_until", "")
+	if err := s.summarize(ctx, userID, conv, messages); err != nil {
+		return nil, err
+	}
+
+	logger.Info("[ChatService] regenerated summary of conversation %s", conv.Id)
+	return s.conversationSummary(conv)
+}
+
+// messagesAfter returns the user and assistant messages of the active branch created
+// after the given time, oldest first. Tool steps are left out, the final answers carry their outcome.
+func (s *ChatService) messagesAfter(conv *models.Record, after types.DateTime) ([]*models.Record, error) {
+	branch, err := s.activeBranch(conv)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*models.Record
+	for _, msg := range branch {
+		if msg.GetString("role") == "tool" || msg.GetString("content") == "" {
+			continue
+		}
+		if !after.IsZero() && !msg.Created.Time().After(after.Time()) {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// summarize folds messages, except the most recent ones, into the conversation's summary
+// and saves it. Long stretches of messages are condensed in several passes.
+func (s *ChatService) summarize(ctx context.Context, userID string, conv *models.Record, messages []*models.Record) error {
+	if _, busy := s.summarizing.LoadOrStore(conv.Id, true); busy {
+		return ErrSummaryInProgress
+	}
+	defer s.summarizing.Delete(conv.Id)
+
+	// The remaining history has to start with a user message
+	cut := len(messages) - summaryKeepRecent
+	for cut > 0 && messages[cut].GetString("role") != "user" {
+		cut--
+	}
+
+	summary := conv.GetString("summary")
+	if cut > 0 {
+		cfg, err := s.getProviderConfig(userID)
+		if err != nil {
+			return err
+		}
+		provider, err := NewChatProvider(cfg)
+		if err != nil {
+			return err
+		}
+
+		var chunk []string
+		used := 0
+		for _, msg := range messages[:cut] {
+			content := msg.GetString("content")
+			if msg.GetString("role") == "user" {
+				content = stripHTMLTags(content)
+			}
+			line := fmt.Sprintf("[%s] %s: %s",
+				msg.Created.Time().Format("2006-01-02 15:04"),
+				msg.GetString("role"),
+				truncateToTokens(strings.TrimSpace(content), summaryMessageTokens),
+			)
+			tokens := estimateTokens(line)
+
+			// Each pass gets about half of the context window next to the summary so far
+			budget := cfg.ContextWindow/2 - estimateTokens(summary)
+			if budget < summaryMessageTokens {
+				budget = summaryMessageTokens
+			}
+			if len(chunk) > 0 && used+tokens > budget {
+				if summary, err = condenseSummary(ctx, provider, summary, chunk); err != nil {
+					return err
+				}
+				chunk, used = nil, 0
+			}
+			chunk = append(chunk, line)
+			used += tokens
+		}
+		if summary, err = condenseSummary(ctx, provider, summary, chunk); err != nil {
+			return err
+		}
+
+		conv.Set("summary", summary)
+		conv.Set("summarized_until", messages[cut-1].Created)
+	}
+
+	// Messages may have been added meanwhile, only the summary fields are updated
+	fresh, err := s.app.Dao().FindRecordById("ai_conversations", conv.Id)
+	if err != nil {
+		return fmt.Errorf("conversation not found: %w", err)
+	}
+	fresh.Set("summary", conv.GetString("summary"))
+	fresh.Set("summarized_until", conv.Get("summarized_until"))
+	if err := s.app.Dao().SaveRecord(fresh); err != nil {
+		return fmt.Errorf("failed to save summary: %w", err)
+	}
+
+	logger.Debug("[ChatService] summary of conversation %s covers %d messages, ~%d tokens", conv.Id, cut, estimateTokens(summary))
+	return nil
+}
+
+// condenseSummary asks the model to fold new messages into the summary
+func condenseSummary(ctx context.Context, provider ChatProvider, summary string, lines []string) (string, error) {
+	if summary == "" {
+		summary = "(none)"
+	}
+
+	messages := []ChatMessage{
+		{
+			Role: "system",
+			Content: `You maintain a running summary of a conversation between a user and the AI assistant of their personal diary app Diarum.
+Update the current summary with the new messages. Keep facts about the user, the dates and diary entries discussed, the questions asked with their answers and conclusions, and anything the user asked to remember. Drop greetings and repetition.
+Write concise notes in the same language as the conversation, under 400 words.
+Respond with ONLY the updated summary.`,
+		},
+		{
+			Role:    "user",
+			Content: fmt.Sprintf("Current summary:\n%s\n\nNew messages:\n%s", summary, strings.Join(lines, "\n")),
+		},
+	}
+
+	updated, err := provider.Complete(ctx, messages, summaryMaxTokens)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate summary: %w", err)
+	}
+
+	updated = strings.TrimSpace(updated)
+	if updated == "" {
+		return "", fmt.Errorf("empty summary from API")
+	}
+	return updated, nil
+}
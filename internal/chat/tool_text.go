@@ -0,0 +1,56 @@
+package chat
+
+import "strings"
+
+// toolDescriptions holds the tool and parameter descriptions by language,
+// keyed by tool name or "tool.parameter"
+var toolDescriptions = map[string]map[string]string{
+	"en": {
+		toolSearchDiaries:                               "Search the user's diaries. Filters by date range and/or finds entries semantically similar to a topic. Use it to answer questions about what the user wrote, such as summaries, reviews and analyses.",
+		toolSearchDiaries + ".start_date":               "Start date, YYYY-MM-DD. Only diaries on or after this date.",
+		toolSearchDiaries + ".end_date":                 "End date, YYYY-MM-DD. Only diaries on or before this date.",
+		toolSearchDiaries + ".query":                    "Semantic search terms, finds diaries about this topic.",
+		toolSearchDiaries + ".limit":                    "Maximum number of diaries to return, default 10, at most 100.",
+		toolGetDiaryByDate:                              "Get the user's full diary of one day. Use it for questions like \"what did I write on ...\".",
+		toolGetDiaryByDate + ".date":                    "Date, YYYY-MM-DD.",
+		toolDiaryStats:                                  "Statistics of the user's diaries: number of entries, days written, words and characters, writing streaks (streak freezes and vacations keep a streak), mood and weather distributions, entries by month and weekday. Use it for quantitative questions like \"how many days did I write in March\" or \"what was my most common mood this year\" instead of estimating.",
+		toolDiaryStats + ".start_date":                  "Start date, YYYY-MM-DD. Defaults to the first diary.",
+		toolDiaryStats + ".end_date":                    "End date, YYYY-MM-DD. Defaults to today.",
+		toolListTags:                                    "List all of the user's tags and how many diaries use each of them.",
+		toolGetConversationSummary:                      "Get an overview of an AI conversation: title, message count, dates and the most recent messages. Defaults to the current conversation, use it to recall what was discussed before.",
+		toolGetConversationSummary + ".conversation_id": "Conversation ID, defaults to the current conversation.",
+		toolGetConversationSummary + ".limit":           "Number of recent messages to return, default 20, at most 50.",
+	},
+	"zh": {
+		toolSearchDiaries:                               "搜索用户的日记。可以按时间范围筛选，也可以按语义相似度搜索。用于回答关于用户日记内容的问题，如总结、回顾、分析等。",
+		toolSearchDiaries + ".start_date":               "开始日期，格式 YYYY-MM-DD。用于筛选该日期之后的日记。",
+		toolSearchDiaries + ".end_date":                 "结束日期，格式 YYYY-MM-DD。用于筛选该日期之前的日记。",
+		toolSearchDiaries + ".query":                    "语义搜索关键词。用于查找与该主题相关的日记。",
+		toolSearchDiaries + ".limit":                    "返回的最大日记数量，默认10，最大100。",
+		toolGetDiaryByDate:                              "获取用户某一天的完整日记。用于回答“某天写了什么”之类的问题。",
+		toolGetDiaryByDate + ".date":                    "日期，格式 YYYY-MM-DD。",
+		toolDiaryStats:                                  "统计用户日记的数据：篇数、写作天数、词数和字数、连续写作天数（streak，补签和假期不会中断）、心情和天气分布、按月和按星期的分布。用于回答“三月写了几天”“今年最常见的心情是什么”等统计类问题，不要自己估算。",
+		toolDiaryStats + ".start_date":                  "开始日期，格式 YYYY-MM-DD。不填则从第一篇日记开始。",
+		toolDiaryStats + ".end_date":                    "结束日期，格式 YYYY-MM-DD。不填则到今天为止。",
+		toolListTags:                                    "列出用户的所有标签以及每个标签被多少篇日记使用。",
+		toolGetConversationSummary:                      "获取 AI 对话的概要：标题、消息数量、时间以及最近的消息内容。默认是当前对话，用于回顾之前聊过的内容。",
+		toolGetConversationSummary + ".conversation_id": "对话 ID，不填则为当前对话。",
+		toolGetConversationSummary + ".limit":           "返回的最近消息数量，默认20，最大50。",
+	},
+}
+
+// toolText returns a tool description in the language, falling back to English
+func toolText(language, key string) string {
+	if text, ok := toolDescriptions[toolLanguage(language)][key]; ok {
+		return text
+	}
+	return toolDescriptions["en"][key]
+}
+
+// toolLanguage maps a response language to the language of the tool descriptions
+func toolLanguage(language string) string {
+	if strings.HasPrefix(language, "zh") {
+		return "zh"
+	}
+	return "en"
+}
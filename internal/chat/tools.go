@@ -0,0 +1,441 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"github.com/songtianlun/diarum/internal/analytics"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// Tool names
+const (
+	toolSearchDiaries          = "search_diaries"
+	toolGetDiaryByDate         = "get_diary_by_date"
+	toolDiaryStats             = "diary_stats"
+	toolListTags               = "list_tags"
+	toolGetConversationSummary = "get_conversation_summary"
+)
+
+// GetDiaryByDateArgs represents arguments for get_diary_by_date function
+type GetDiaryByDateArgs struct {
+	Date string `json:"date"`
+}
+
+// DiaryStatsArgs represents arguments for diary_stats function
+type DiaryStatsArgs struct {
+	StartDate string `json:"start_date,omitempty"`
+	EndDate   string `json:"end_date,omitempty"`
+}
+
+// ConversationSummaryArgs represents arguments for get_conversation_summary function
+type ConversationSummaryArgs struct {
+	ConversationID string `json:"conversation_id,omitempty"`
+	Limit          int    `json:"limit,omitempty"`
+}
+
+// DiaryStats is the result of the diary_stats tool
+type DiaryStats struct {
+	StartDate          string `json:"start_date,omitempty"`
+	EndDate            string `json:"end_date,omitempty"`
+	TotalEntries       int    `json:"total_entries"`
+	DaysWritten        int    `json:"days_written"`
+	TotalWords         int    `json:"total_words"`
+	TotalCharacters    int    `json:"total_characters"`
+	AverageCharacters  int    `json:"average_characters"`
+	LongestStreak      int    `json:"longest_streak"`
+	LongestStreakStart string `json:"longest_streak_start,omitempty"`
+	LongestStreakEnd   string `json:"longest_streak_end,omitempty"`
+	CurrentStreak      int    `json:"current_streak"`
+	// FreezesUsed counts the missed days of the current streak forgiven by streak freezes
+	FreezesUsed      int            `json:"freezes_used"`
+	FirstEntry       string         `json:"first_entry,omitempty"`
+	LastEntry        string         `json:"last_entry,omitempty"`
+	Moods            map[string]int `json:"moods"`
+	Weather          map[string]int `json:"weather"`
+	EntriesByMonth   map[string]int `json:"entries_by_month"`
+	EntriesByWeekday map[string]int `json:"entries_by_weekday"`
+}
+
+// dateProperty is the JSON schema of a YYYY-MM-DD date parameter
+func dateProperty(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "string",
+		"description": description,
+	}
+}
+
+// getTools returns the available tools for the chat, described in the given response language
+func (s *ChatService) getTools(language string) []Tool {
+	return []Tool{
+		{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        toolSearchDiaries,
+				Description: toolText(language, toolSearchDiaries),
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"start_date": dateProperty(toolText(language, toolSearchDiaries+".start_date")),
+						"end_date":   dateProperty(toolText(language, toolSearchDiaries+".end_date")),
+						"query": map[string]interface{}{
+							"type":        "string",
+							"description": toolText(language, toolSearchDiaries+".query"),
+						},
+						"limit": map[string]interface{}{
+							"type":        "integer",
+							"description": toolText(language, toolSearchDiaries+".limit"),
+						},
+					},
+					"required": []string{},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        toolGetDiaryByDate,
+				Description: toolText(language, toolGetDiaryByDate),
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"date": dateProperty(toolText(language, toolGetDiaryByDate+".date")),
+					},
+					"required": []string{"date"},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        toolDiaryStats,
+				Description: toolText(language, toolDiaryStats),
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"start_date": dateProperty(toolText(language, toolDiaryStats+".start_date")),
+						"end_date":   dateProperty(toolText(language, toolDiaryStats+".end_date")),
+					},
+					"required": []string{},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        toolListTags,
+				Description: toolText(language, toolListTags),
+				Parameters: map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{},
+					"required":   []string{},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        toolGetConversationSummary,
+				Description: toolText(language, toolGetConversationSummary),
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"conversation_id": map[string]interface{}{
+							"type":        "string",
+							"description": toolText(language, toolGetConversationSummary+".conversation_id"),
+						},
+						"limit": map[string]interface{}{
+							"type":        "integer",
+							"description": toolText(language, toolGetConversationSummary+".limit"),
+						},
+					},
+					"required": []string{},
+				},
+			},
+		},
+	}
+}
+
+// executeTool runs a tool call for the user and returns the result for the model,
+// sized to about maxTokens, together with the IDs of the diaries it returned
+func (s *ChatService) executeTool(ctx context.Context, userID, conversationID string, tc ToolCall, maxTokens int) (string, []string, error) {
+	args := tc.Function.Arguments
+	if strings.TrimSpace(args) == "" {
+		args = "{}"
+	}
+
+	switch tc.Function.Name {
+	case toolSearchDiaries:
+		var a SearchDiariesArgs
+		if err := json.Unmarshal([]byte(args), &a); err != nil {
+			return "", nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		diaries, err := s.SearchDiariesByDateRange(ctx, userID, a)
+		if err != nil {
+			return "", nil, err
+		}
+		result, ids := s.formatDiariesForContext(diaries, a.Query, maxTokens)
+		return result, ids, nil
+
+	case toolGetDiaryByDate:
+		var a GetDiaryByDateArgs
+		if err := json.Unmarshal([]byte(args), &a); err != nil {
+			return "", nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		if _, err := time.Parse("2006-01-02", a.Date); err != nil {
+			return "", nil, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", a.Date)
+		}
+		diaries, err := s.SearchDiariesByDateRange(ctx, userID, SearchDiariesArgs{StartDate: a.Date, EndDate: a.Date, Limit: 1})
+		if err != nil {
+			return "", nil, err
+		}
+		if len(diaries) == 0 {
+			return fmt.Sprintf("No diary entry on %s.", a.Date), nil, nil
+		}
+		result, ids := s.formatDiariesForContext(diaries, "", maxTokens)
+		return result, ids, nil
+
+	case toolDiaryStats:
+		var a DiaryStatsArgs
+		if err := json.Unmarshal([]byte(args), &a); err != nil {
+			return "", nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		stats, err := s.GetDiaryStats(userID, a)
+		if err != nil {
+			return "", nil, err
+		}
+		data, _ := json.Marshal(stats)
+		return string(data), nil, nil
+
+	case toolListTags:
+		tags, err := s.ListTags(userID)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(tags) == 0 {
+			return "The user has no tags.", nil, nil
+		}
+		data, _ := json.Marshal(tags)
+		return string(data), nil, nil
+
+	case toolGetConversationSummary:
+		var a ConversationSummaryArgs
+		if err := json.Unmarshal([]byte(args), &a); err != nil {
+			return "", nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		if a.ConversationID == "" {
+			a.ConversationID = conversationID
+		}
+		summary, err := s.GetConversationSummary(userID, a)
+		if err != nil {
+			return "", nil, err
+		}
+		return summary, nil, nil
+	}
+
+	return "", nil, fmt.Errorf("unknown tool: %s", tc.Function.Name)
+}
+
+// GetDiaryStats computes writing statistics for the user's diaries in a date range
+func (s *ChatService) GetDiaryStats(userID string, args DiaryStatsArgs) (*DiaryStats, error) {
+	filter := "owner = {:owner}"
+	params := map[string]any{"owner": userID}
+	if args.StartDate != "" {
+		filter += " && date >= {:start_date}"
+		params["start_date"] = args.StartDate
+	}
+	if args.EndDate != "" {
+		filter += " && date <= {:end_date}"
+		params["end_date"] = args.EndDate + " 23:59:59"
+	}
+
+	diaries, err := s.app.Dao().FindRecordsByFilter("diaries", filter, "date", -1, 0, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch diaries: %w", err)
+	}
+
+	stats := &DiaryStats{
+		StartDate:        args.StartDate,
+		EndDate:          args.EndDate,
+		TotalEntries:     len(diaries),
+		Moods:            make(map[string]int),
+		Weather:          make(map[string]int),
+		EntriesByMonth:   make(map[string]int),
+		EntriesByWeekday: make(map[string]int),
+	}
+
+	var dates []string
+	for _, diary := range diaries {
+		date := diary.GetString("date")
+		if len(date) >= 10 {
+			date = date[:10]
+		}
+		if len(dates) == 0 || dates[len(dates)-1] != date {
+			dates = append(dates, date)
+		}
+
+		words, chars := analytics.CountText(diary.GetString("content"))
+		stats.TotalWords += words
+		stats.TotalCharacters += chars
+		if mood := diary.GetString("mood"); mood != "" {
+			stats.Moods[mood]++
+		}
+		if weather := diary.GetString("weather"); weather != "" {
+			stats.Weather[weather]++
+		}
+		if t, err := time.Parse("2006-01-02", date); err == nil {
+			stats.EntriesByMonth[t.Format("2006-01")]++
+			stats.EntriesByWeekday[t.Weekday().String()]++
+		}
+	}
+
+	stats.DaysWritten = len(dates)
+	if stats.TotalEntries > 0 {
+		stats.AverageCharacters = stats.TotalCharacters / stats.TotalEntries
+	}
+	if len(dates) == 0 {
+		return stats, nil
+	}
+	stats.FirstEntry = dates[0]
+	stats.LastEntry = dates[len(dates)-1]
+
+	// Streaks follow the same rules as the stats page: streak freezes, vacations and
+	// the user's time zone. The current streak ends today or at the end of the range.
+	rules, err := s.analyticsService.StreakRules(userID)
+	if err != nil {
+		return nil, err
+	}
+	end := time.Now().In(s.configService.Location(userID))
+	if args.EndDate != "" {
+		if t, err := time.Parse("2006-01-02", args.EndDate); err == nil && t.Format("2006-01-02") < end.Format("2006-01-02") {
+			end = t
+		}
+	}
+	_, longest := analytics.Streaks(dates, end, rules)
+	stats.LongestStreak = longest.Days
+	stats.LongestStreakStart = longest.Start
+	stats.LongestStreakEnd = longest.End
+
+	allDates, err := analytics.Dates(s.app.Dao(), userID)
+	if err != nil {
+		return nil, err
+	}
+	current, _ := analytics.Streaks(allDates, end, rules)
+	stats.CurrentStreak = current.Days
+	stats.FreezesUsed = current.FreezesUsed
+
+	return stats, nil
+}
+
+// TagUsage is a tag with the number of diaries using it
+type TagUsage struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Diaries int    `json:"diaries"`
+}
+
+// ListTags returns the user's tags with their usage counts
+func (s *ChatService) ListTags(userID string) ([]TagUsage, error) {
+	tags, err := s.app.Dao().FindRecordsByFilter(
+		"tags", "owner = {:owner}", "name", -1, 0,
+		map[string]any{"owner": userID},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tags: %w", err)
+	}
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	diaries, err := s.app.Dao().FindRecordsByFilter(
+		"diaries", "owner = {:owner}", "", -1, 0,
+		map[string]any{"owner": userID},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch diaries: %w", err)
+	}
+	counts := make(map[string]int)
+	for _, diary := range diaries {
+		for _, id := range diary.GetStringSlice("tags") {
+			counts[id]++
+		}
+	}
+
+	result := make([]TagUsage, 0, len(tags))
+	for _, tag := range tags {
+		result = append(result, TagUsage{ID: tag.Id, Name: tag.GetString("name"), Diaries: counts[tag.Id]})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Diaries > result[j].Diaries
+	})
+	return result, nil
+}
+
+// GetConversationSummary describes a conversation owned by the user and its recent messages
+func (s *ChatService) GetConversationSummary(userID string, args ConversationSummaryArgs) (string, error) {
+	if args.ConversationID == "" {
+		return "", fmt.Errorf("conversation_id is required")
+	}
+	if args.Limit <= 0 {
+		args.Limit = 20
+	}
+	if args.Limit > 50 {
+		args.Limit = 50
+	}
+
+	conv, err := s.app.Dao().FindRecordById("ai_conversations", args.ConversationID)
+	if err != nil || conv.GetString("owner") != userID {
+		return "", fmt.Errorf("conversation not found")
+	}
+
+	// Agent tool steps and other branches are not part of the visible conversation
+	all, err := s.messagesAfter(conv, types.DateTime{})
+	if err != nil {
+		return "", err
+	}
+	total := len(all)
+	messages := all
+	if len(messages) > args.Limit {
+		messages = messages[len(messages)-args.Limit:]
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Conversation: %s\n", conv.GetString("title")))
+	sb.WriteString(fmt.Sprintf("Started: %s\n", conv.Created.Time().Format("2006-01-02 15:04")))
+	sb.WriteString(fmt.Sprintf("Last updated: %s\n", conv.Updated.Time().Format("2006-01-02 15:04")))
+	sb.WriteString(fmt.Sprintf("Messages: %d\n", total))
+	if summary := conv.GetString("summary"); summary != "" {
+		sb.WriteString(fmt.Sprintf("\nSummary of earlier messages:\n%s\n", summary))
+	}
+	if len(messages) == 0 {
+		return sb.String(), nil
+	}
+
+	sb.WriteString(fmt.Sprintf("\nLast %d messages:\n", len(messages)))
+	for _, msg := range messages {
+		sb.WriteString(fmt.Sprintf("[%s] %s: %s\n",
+			msg.Created.Time().Format("2006-01-02 15:04"),
+			msg.GetString("role"),
+			truncateRunes(msg.GetString("content"), 300),
+		))
+	}
+
+	logger.Debug("[ChatService] conversation summary for %s: %d messages", conv.Id, total)
+	return sb.String(), nil
+}
+
+// truncateRunes truncates a string to maxLen characters without splitting UTF-8 sequences
+func truncateRunes(s string, maxLen int) string {
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) <= maxLen {
+		return s
+	}
+	return string([]rune(s)[:maxLen]) + "..."
+}
@@ -0,0 +1,200 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/spf13/cobra"
+
+	"github.com/songtianlun/diarum/internal/api"
+	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/embedding"
+)
+
+// Register adds the maintenance subcommands (export, import, vectors, doctor) to the root command.
+// They work directly on the data dir; the server does not need to be running.
+func Register(app *pocketbase.PocketBase) {
+	app.RootCmd.AddCommand(
+		newExportCommand(app),
+		newImportCommand(app),
+		newVectorsCommand(app),
+		newDoctorCommand(app),
+	)
+}
+
+// findUser resolves a user by email, username or record ID
+func findUser(app *pocketbase.PocketBase, value string) (*models.Record, error) {
+	if value == "" {
+		return nil, fmt.Errorf("--user is required")
+	}
+	if record, err := app.Dao().FindAuthRecordByEmail("users", value); err == nil {
+		return record, nil
+	}
+	if record, err := app.Dao().FindAuthRecordByUsername("users", value); err == nil {
+		return record, nil
+	}
+	if record, err := app.Dao().FindRecordById("users", value); err == nil {
+		return record, nil
+	}
+	return nil, fmt.Errorf("user not found: %s", value)
+}
+
+// newEmbeddingService opens the vector database in the data dir
+func newEmbeddingService(app *pocketbase.PocketBase) (*embedding.EmbeddingService, error) {
+	vectorDB, err := embedding.NewVectorDB(app.DataDir())
+	if err != nil {
+		return nil, err
+	}
+	return embedding.NewEmbeddingService(app, vectorDB), nil
+}
+
+func newExportCommand(app *pocketbase.PocketBase) *cobra.Command {
+	var (
+		user, dateRange, start, end   string
+		output, passphrase, secretsPP string
+		noMedia, noConversations      bool
+		noSettings                    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export a user's diaries to an archive",
+		Example: "  diarum export --user me@example.com --range all -o out.zip\n" +
+			"  diarum export --user me --range custom --start 2024-01-01 --end 2024-12-31 --passphrase secret",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			record, err := findUser(app, user)
+			if err != nil {
+				return err
+			}
+
+			result, err := api.ExportArchive(app, record.Id, api.ExportRequest{
+				DateRange:            dateRange,
+				StartDate:            start,
+				EndDate:              end,
+				IncludeDiaries:       true,
+				IncludeMedia:         !noMedia,
+				IncludeConversations: !noConversations,
+				IncludeSettings:      !noSettings,
+				Passphrase:           passphrase,
+				SecretsPassphrase:    secretsPP,
+			})
+			if err != nil {
+				return err
+			}
+
+			if output == "" {
+				output = result.Filename
+			}
+			if err := os.WriteFile(output, result.Data, 0o600); err != nil {
+				return fmt.Errorf("failed to write %s: %w", output, err)
+			}
+
+			s := result.Stats
+			fmt.Printf("Exported %s – %s to %s\n", s.StartDate, s.EndDate, output)
+			fmt.Printf("  diaries:       %d\n", s.Diaries.ActualExported)
+			fmt.Printf("  media:         %d\n", s.Media.ActualExported)
+			fmt.Printf("  conversations: %d\n", s.Conversations.ActualExported)
+			fmt.Printf("  settings:      %d\n", s.Settings)
+			for _, item := range s.FailedItems {
+				fmt.Printf("  failed %s %s: %s\n", item.Type, item.ID, item.Reason)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&user, "user", "", "user email, username or ID")
+	cmd.Flags().StringVar(&dateRange, "range", "all", "date range: 1m, 3m, 6m, 1y, all or custom")
+	cmd.Flags().StringVar(&start, "start", "", "start date (YYYY-MM-DD) for --range custom")
+	cmd.Flags().StringVar(&end, "end", "", "end date (YYYY-MM-DD) for --range custom")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "output file (default diarum_export.zip)")
+	cmd.Flags().StringVar(&passphrase, "passphrase", "", "encrypt the archive (and secrets) with this passphrase")
+	cmd.Flags().StringVar(&secretsPP, "secrets-passphrase", "", "include secrets encrypted with this passphrase")
+	cmd.Flags().BoolVar(&noMedia, "no-media", false, "skip media files")
+	cmd.Flags().BoolVar(&noConversations, "no-conversations", false, "skip AI conversations")
+	cmd.Flags().BoolVar(&noSettings, "no-settings", false, "skip user settings")
+
+	return cmd
+}
+
+func newImportCommand(app *pocketbase.PocketBase) *cobra.Command {
+	var (
+		user, passphrase, secretsPP string
+		force, skipVectors          bool
+	)
+
+	cmd := &cobra.Command{
+		Use:          "import <file>",
+		Short:        "Import an export archive into a user's account",
+		Example:      "  diarum import --user me@example.com diarum_export.zip",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			record, err := findUser(app, user)
+			if err != nil {
+				return err
+			}
+
+			raw, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+
+			stats, err := api.ImportArchive(app, record.Id, raw, api.ImportOptions{
+				Passphrase:        passphrase,
+				SecretsPassphrase: secretsPP,
+				Force:             force,
+			})
+			var verifyErr *api.VerificationError
+			if errors.As(err, &verifyErr) {
+				for _, issue := range verifyErr.Result.Issues {
+					fmt.Printf("  %s %s (expected %s, got %s)\n", issue.Problem, issue.Path, issue.Expected, issue.Actual)
+				}
+				return fmt.Errorf("%w; use --force to import anyway", err)
+			}
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Imported %s into %s\n", args[0], record.Email())
+			fmt.Printf("  diaries:       %d imported, %d skipped, %d failed\n", stats.Diaries.Imported, stats.Diaries.Skipped, stats.Diaries.Failed)
+			fmt.Printf("  media:         %d imported, %d skipped, %d failed\n", stats.Media.Imported, stats.Media.Skipped, stats.Media.Failed)
+			fmt.Printf("  conversations: %d imported, %d skipped, %d failed\n", stats.Conversations.Imported, stats.Conversations.Skipped, stats.Conversations.Failed)
+			fmt.Printf("  settings:      %d imported, %d skipped, %d failed\n", stats.Settings.Imported, stats.Settings.Skipped, stats.Settings.Failed)
+
+			if skipVectors {
+				return nil
+			}
+			enabled, _ := config.NewConfigService(app).GetBool(record.Id, "ai.enabled")
+			if !enabled {
+				return nil
+			}
+			service, err := newEmbeddingService(app)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			defer cancel()
+			result, err := service.BuildIncrementalVectors(ctx, record.Id)
+			if err != nil {
+				return fmt.Errorf("vector rebuild failed: %w", err)
+			}
+			fmt.Printf("  vectors:       %d built, %d failed\n", result.Success, result.Failed)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&user, "user", "", "user email, username or ID")
+	cmd.Flags().StringVar(&passphrase, "passphrase", "", "passphrase of an encrypted archive")
+	cmd.Flags().StringVar(&secretsPP, "secrets-passphrase", "", "passphrase of the encrypted secrets (defaults to --passphrase)")
+	cmd.Flags().BoolVar(&force, "force", false, "import even if the archive fails integrity verification")
+	cmd.Flags().BoolVar(&skipVectors, "skip-vectors", false, "do not rebuild vectors after the import")
+
+	return cmd
+}
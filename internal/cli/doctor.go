@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/spf13/cobra"
+
+	"github.com/songtianlun/diarum/internal/doctor"
+	"github.com/songtianlun/diarum/internal/embedding"
+)
+
+func newDoctorCommand(app *pocketbase.PocketBase) *cobra.Command {
+	var fix, asJSON, exitCode, verbose bool
+
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check media, references and vectors for inconsistencies",
+		Long: "Scans for media pointing at missing files or deleted diaries, orphaned storage files,\n" +
+			"dangling tag and referenced_diaries IDs, and orphaned vectors.\n\n" +
+			"With --fix the fixable issues are repaired. Stop the server before fixing vectors,\n" +
+			"otherwise the running server keeps its own copy of the vector index.",
+		Example:      "  diarum doctor\n  diarum doctor --fix\n  diarum doctor --json --exit-code",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vectorDB, err := embedding.NewVectorDB(app.DataDir())
+			if err != nil {
+				return err
+			}
+
+			report, err := doctor.NewDoctor(app, vectorDB).Run(context.Background(), fix)
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(report); err != nil {
+					return err
+				}
+			} else {
+				printReport(report, verbose)
+			}
+
+			// PocketBase ignores command errors, so exit explicitly for monitoring
+			if exitCode && !report.Healthy {
+				os.Exit(2)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&fix, "fix", false, "repair fixable issues")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the report as JSON")
+	cmd.Flags().BoolVar(&exitCode, "exit-code", false, "exit with status 2 when unfixed issues remain")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "list every issue")
+
+	return cmd
+}
+
+// printReport prints a human readable summary of the report
+func printReport(report *doctor.Report, verbose bool) {
+	for _, c := range report.Categories {
+		status := "ok"
+		if c.Count > 0 {
+			status = fmt.Sprintf("%d issue(s)", c.Count)
+			if c.Fixed > 0 {
+				status += fmt.Sprintf(", %d fixed", c.Fixed)
+			}
+		}
+		fmt.Printf("%-28s %s\n", c.Name, status)
+		if c.Count == 0 {
+			continue
+		}
+		fmt.Printf("  %s\n", c.Description)
+
+		limit := len(c.Issues)
+		if !verbose && limit > 5 {
+			limit = 5
+		}
+		for _, issue := range c.Issues[:limit] {
+			line := fmt.Sprintf("  - %s: %s", issue.ID, issue.Detail)
+			if issue.Error != "" {
+				line += " (fix failed: " + issue.Error + ")"
+			}
+			fmt.Println(line)
+		}
+		if limit < len(c.Issues) {
+			fmt.Printf("  ... and %d more (use -v to list all)\n", len(c.Issues)-limit)
+		}
+	}
+
+	for _, e := range report.Errors {
+		fmt.Printf("error: %s\n", e)
+	}
+
+	switch {
+	case report.Total == 0 && len(report.Errors) == 0:
+		fmt.Println("\nNo issues found.")
+	case !report.Fix && report.Total > 0:
+		fmt.Printf("\n%d issue(s) found. Run with --fix to repair fixable issues.\n", report.Total)
+	default:
+		fmt.Printf("\n%d issue(s) found, %d fixed.\n", report.Total, report.Fixed)
+	}
+}
@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/spf13/cobra"
+
+	"github.com/songtianlun/diarum/internal/config"
+)
+
+func newVectorsCommand(app *pocketbase.PocketBase) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "vectors",
+		Short: "Manage the diary vector index",
+	}
+	cmd.AddCommand(newVectorsRebuildCommand(app), newVectorsStatsCommand(app))
+	return cmd
+}
+
+// targetUsers returns the user given by --user, or every user when all is set
+func targetUsers(app *pocketbase.PocketBase, user string, all bool) ([]*models.Record, error) {
+	if all {
+		return app.Dao().FindRecordsByFilter("users", "id != ''", "created", -1, 0)
+	}
+	if user == "" {
+		return nil, fmt.Errorf("either --user or --all is required")
+	}
+	record, err := findUser(app, user)
+	if err != nil {
+		return nil, err
+	}
+	return []*models.Record{record}, nil
+}
+
+func newVectorsRebuildCommand(app *pocketbase.PocketBase) *cobra.Command {
+	var (
+		user             string
+		all, incremental bool
+	)
+
+	cmd := &cobra.Command{
+		Use:          "rebuild",
+		Short:        "Rebuild diary vectors for a user or all users",
+		Example:      "  diarum vectors rebuild --user me@example.com\n  diarum vectors rebuild --all --incremental",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			users, err := targetUsers(app, user, all)
+			if err != nil {
+				return err
+			}
+			service, err := newEmbeddingService(app)
+			if err != nil {
+				return err
+			}
+			configService := config.NewConfigService(app)
+
+			failed := 0
+			for _, u := range users {
+				// With --all, users without AI are skipped silently
+				if enabled, _ := configService.GetBool(u.Id, "ai.enabled"); !enabled && all {
+					continue
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+				build := service.BuildAllVectors
+				if incremental {
+					build = service.BuildIncrementalVectors
+				}
+				result, err := build(ctx, u.Id)
+				cancel()
+				if err != nil {
+					failed++
+					fmt.Printf("%s: %v\n", u.Email(), err)
+					continue
+				}
+				fmt.Printf("%s: %d built, %d failed (of %d)\n", u.Email(), result.Success, result.Failed, result.Total)
+				for _, detail := range result.ErrorDetails {
+					fmt.Printf("  %s\n", detail)
+				}
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("vector rebuild failed for %d user(s)", failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&user, "user", "", "user email, username or ID")
+	cmd.Flags().BoolVar(&all, "all", false, "rebuild for every user with AI enabled")
+	cmd.Flags().BoolVar(&incremental, "incremental", false, "only build new and outdated vectors")
+
+	return cmd
+}
+
+func newVectorsStatsCommand(app *pocketbase.PocketBase) *cobra.Command {
+	var (
+		user   string
+		asJSON bool
+	)
+
+	cmd := &cobra.Command{
+		Use:          "stats",
+		Short:        "Show vector index statistics (all users unless --user is given)",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			users, err := targetUsers(app, user, user == "")
+			if err != nil {
+				return err
+			}
+			service, err := newEmbeddingService(app)
+			if err != nil {
+				return err
+			}
+
+			type userStats struct {
+				User          string `json:"user"`
+				Email         string `json:"email"`
+				DiaryCount    int    `json:"diary_count"`
+				IndexedCount  int    `json:"indexed_count"`
+				OutdatedCount int    `json:"outdated_count"`
+				PendingCount  int    `json:"pending_count"`
+			}
+			rows := make([]userStats, 0, len(users))
+			for _, u := range users {
+				stats, err := service.GetVectorStats(context.Background(), u.Id)
+				if err != nil {
+					return fmt.Errorf("failed to get stats for %s: %w", u.Email(), err)
+				}
+				rows = append(rows, userStats{
+					User:          u.Id,
+					Email:         u.Email(),
+					DiaryCount:    stats.DiaryCount,
+					IndexedCount:  stats.IndexedCount,
+					OutdatedCount: stats.OutdatedCount,
+					PendingCount:  stats.PendingCount,
+				})
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(rows)
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "USER\tEMAIL\tDIARIES\tINDEXED\tOUTDATED\tPENDING")
+			for _, r := range rows {
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\n", r.User, r.Email, r.DiaryCount, r.IndexedCount, r.OutdatedCount, r.PendingCount)
+			}
+			return tw.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&user, "user", "", "user email, username or ID")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON")
+
+	return cmd
+}
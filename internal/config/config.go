@@ -4,6 +4,8 @@ import (
 	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"strconv"
+	"time"
 
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/daos"
@@ -113,6 +115,81 @@ func (s *ConfigService) GetBool(userId, key string) (bool, error) {
 	return false, nil
 }
 
+// GetInt retrieves an integer configuration value
+func (s *ConfigService) GetInt(userId, key string) (int, error) {
+	value, err := s.Get(userId, key)
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return 0, nil
+	}
+
+	// Handle types.JsonRaw
+	if raw, ok := value.(types.JsonRaw); ok {
+		var n float64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return 0, nil
+		}
+		return int(n), nil
+	}
+
+	// Handle different types that JSON might return
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case float64:
+		return int(v), nil
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n, nil
+	}
+	return 0, nil
+}
+
+// GetFloat retrieves a float configuration value
+func (s *ConfigService) GetFloat(userId, key string) (float64, error) {
+	value, err := s.Get(userId, key)
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return 0, nil
+	}
+
+	// Handle types.JsonRaw
+	if raw, ok := value.(types.JsonRaw); ok {
+		var n float64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return 0, nil
+		}
+		return n, nil
+	}
+
+	// Handle different types that JSON might return
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		n, _ := strconv.ParseFloat(v, 64)
+		return n, nil
+	}
+	return 0, nil
+}
+
+// Location returns the user's time zone, the server's if none or an invalid one is set
+func (s *ConfigService) Location(userId string) *time.Location {
+	if name, _ := s.GetString(userId, "user.timezone"); name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+		logger.Warn("[ConfigService.Location] invalid time zone %q of user %s, using the server time zone", name, userId)
+	}
+	return time.Local
+}
+
 // Set stores a configuration value for a user
 func (s *ConfigService) Set(userId, key string, value any) error {
 	// Validate key against registry
@@ -242,7 +319,7 @@ func maskSensitiveValue(value string) string {
 
 // isSensitiveKey checks if a key contains sensitive data that should be masked in logs
 func isSensitiveKey(key string) bool {
-	return IsEncrypted(key) || key == "api.token"
+	return IsSecret(key)
 }
 
 // ValidateTokenAndGetUser validates an API token and returns the user ID
@@ -5,6 +5,7 @@ type ConfigMeta struct {
 	Type      string // "string", "bool", "int", "float", "json"
 	Default   any
 	Encrypted bool
+	NoExport  bool // derived, instance-specific state that is not carried in exports
 }
 
 // ConfigRegistry defines all available configuration items
@@ -19,7 +20,40 @@ var ConfigRegistry = map[string]ConfigMeta{
 	"ai.base_url":         {Type: "string", Default: "", Encrypted: false},
 	"ai.chat_model":       {Type: "string", Default: "", Encrypted: false},
 	"ai.embedding_model":  {Type: "string", Default: "", Encrypted: false},
-	"ai.vectors_built_at": {Type: "string", Default: "", Encrypted: false},
+	"ai.vectors_built_at": {Type: "string", Default: "", Encrypted: false, NoExport: true},
+	"ai.max_steps":        {Type: "int", Default: 5, Encrypted: false},
+	"ai.context_window":   {Type: "int", Default: 0, Encrypted: false}, // 0 = detect from the model name
+
+	// Chat provider; base URL and key fall back to the shared AI settings when empty
+	"ai.chat_provider": {Type: "string", Default: "openai", Encrypted: false},
+	"ai.chat_base_url": {Type: "string", Default: "", Encrypted: false},
+	"ai.chat_api_key":  {Type: "string", Default: "", Encrypted: true},
+
+	// Chat assistant behavior
+	"ai.system_prompt": {Type: "string", Default: "", Encrypted: false}, // replaces the persona's instructions when set
+	"ai.persona":       {Type: "string", Default: "default", Encrypted: false},
+	"ai.language":      {Type: "string", Default: "auto", Encrypted: false}, // auto = the language of the user's message
+	"ai.temperature":   {Type: "float", Default: -1.0, Encrypted: false},    // negative = provider default
+	"ai.max_tokens":    {Type: "int", Default: 0, Encrypted: false},         // 0 = provider default
+
+	// Diary enrichment: AI suggestions of mood, sentiment, tags, people and places after saving
+	"ai.enrichment": {Type: "bool", Default: false, Encrypted: false},
+
+	// Daily writing prompts: AI prompts based on recent diaries instead of the built-in library
+	"ai.daily_prompt":      {Type: "bool", Default: false, Encrypted: false},
+	"ai.daily_prompt_date": {Type: "string", Default: "", Encrypted: false, NoExport: true}, // day of the cached AI prompt
+	"ai.daily_prompt_text": {Type: "string", Default: "", Encrypted: false, NoExport: true},
+
+	// Reflection digests
+	"ai.digest_weekly":  {Type: "bool", Default: false, Encrypted: false},
+	"ai.digest_monthly": {Type: "bool", Default: false, Encrypted: false},
+	"ai.digest_email":   {Type: "bool", Default: false, Encrypted: false}, // also deliver digests by email
+
+	// Writing streaks
+	"streak.freezes": {Type: "int", Default: 0, Encrypted: false}, // missed days per calendar month that keep a streak
+
+	// User profile
+	"user.timezone": {Type: "string", Default: "", Encrypted: false}, // IANA name, empty = server time zone
 }
 
 // GetConfigMeta returns the metadata for a configuration key
@@ -36,6 +70,19 @@ func IsEncrypted(key string) bool {
 	return false
 }
 
+// IsSecret checks if a configuration key holds a credential that must never be exported in plaintext
+func IsSecret(key string) bool {
+	return IsEncrypted(key) || key == "api.token"
+}
+
+// IsExportable checks if a configuration key is carried in data exports
+func IsExportable(key string) bool {
+	if meta, ok := ConfigRegistry[key]; ok {
+		return !meta.NoExport
+	}
+	return false
+}
+
 // GetDefault returns the default value for a configuration key
 func GetDefault(key string) any {
 	if meta, ok := ConfigRegistry[key]; ok {
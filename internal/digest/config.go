@@ -0,0 +1,39 @@
+package digest
+
+import (
+	"os"
+	"strings"
+
+	"github.com/songtianlun/diarum/internal/email"
+)
+
+// defaultCron checks for finished periods every hour
+const defaultCron = "15 * * * *"
+
+// Config holds the digest settings, read from DIARUM_DIGEST_* and DIARUM_SMTP_* environment variables
+type Config struct {
+	// Cron is the schedule on which finished periods are digested. "off" disables scheduled digests.
+	Cron string
+
+	// SMTP delivers digests by email. Without a host PocketBase's mail settings are used.
+	SMTP email.SMTPConfig
+}
+
+// LoadConfig reads the digest config from the environment
+func LoadConfig() Config {
+	cfg := Config{
+		Cron: strings.TrimSpace(os.Getenv("DIARUM_DIGEST_CRON")),
+		SMTP: email.LoadSMTPConfig(),
+	}
+
+	if cfg.Cron == "" {
+		cfg.Cron = defaultCron
+	}
+
+	return cfg
+}
+
+// Enabled reports whether digests are generated on a schedule
+func (c Config) Enabled() bool {
+	return c.Cron != "off"
+}
@@ -0,0 +1,327 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/cron"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"github.com/songtianlun/diarum/internal/chat"
+	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// Digest periods
+const (
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+const (
+	cronJobID  = "diarum_digests"
+	dateLayout = "2006-01-02"
+	// generateTimeout bounds the generation of one digest
+	generateTimeout = 3 * time.Minute
+)
+
+// ErrDigestRunning is returned while digests are already being generated for the user
+var ErrDigestRunning = errors.New("digests are already being generated")
+
+// ErrPeriodNotFinished is returned for periods that have not ended yet
+var ErrPeriodNotFinished = errors.New("the period has not ended yet")
+
+// ErrNoDiaries is returned for periods without diaries
+var ErrNoDiaries = errors.New("no diaries in this period")
+
+// Service generates, stores and delivers the periodic reflection digests of users
+type Service struct {
+	app           *pocketbase.PocketBase
+	chatService   *chat.ChatService
+	configService *config.ConfigService
+	cfg           Config
+	cron          *cron.Cron
+	running       sync.Map
+}
+
+// NewService creates a new digest service
+func NewService(app *pocketbase.PocketBase, chatService *chat.ChatService, cfg Config) *Service {
+	return &Service{
+		app:           app,
+		chatService:   chatService,
+		configService: config.NewConfigService(app),
+		cfg:           cfg,
+	}
+}
+
+// IsValidPeriod reports whether period is a digest period
+func IsValidPeriod(period string) bool {
+	return period == PeriodWeek || period == PeriodMonth
+}
+
+// Start schedules digest generation on the configured cron expression (evaluated in UTC).
+// Each run digests the last finished periods of the users who enabled them.
+func (s *Service) Start() error {
+	if !s.cfg.Enabled() {
+		return nil
+	}
+	c := cron.New()
+	if err := c.Add(cronJobID, s.cfg.Cron, func() {
+		s.RunAll(context.Background())
+	}); err != nil {
+		return fmt.Errorf("invalid digest cron expression %q: %w", s.cfg.Cron, err)
+	}
+	c.Start()
+	s.cron = c
+	logger.Info("[Digest] scheduled digests with cron %q", s.cfg.Cron)
+	return nil
+}
+
+// Stop stops the scheduler
+func (s *Service) Stop() {
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+}
+
+// RunAll digests the last finished periods of every user who enabled digests
+func (s *Service) RunAll(ctx context.Context) {
+	users, err := s.app.Dao().FindRecordsByFilter("users", "id != ''", "created", 0, 0)
+	if err != nil {
+		logger.Error("[Digest] failed to list users: %v", err)
+		return
+	}
+
+	for _, user := range users {
+		if len(s.enabledPeriods(user.Id)) == 0 {
+			continue
+		}
+		created, err := s.RunForUser(ctx, user.Id)
+		if err != nil && !errors.Is(err, ErrDigestRunning) {
+			logger.Error("[Digest] failed for user %s: %v", user.Id, err)
+		}
+		if len(created) > 0 {
+			logger.Info("[Digest] created %d digest(s) for user %s", len(created), user.Id)
+		}
+	}
+}
+
+// enabledPeriods returns the periods the user wants digests for, none if AI is disabled
+func (s *Service) enabledPeriods(userID string) []string {
+	if enabled, _ := s.configService.GetBool(userID, "ai.enabled"); !enabled {
+		return nil
+	}
+	var periods []string
+	if weekly, _ := s.configService.GetBool(userID, "ai.digest_weekly"); weekly {
+		periods = append(periods, PeriodWeek)
+	}
+	if monthly, _ := s.configService.GetBool(userID, "ai.digest_monthly"); monthly {
+		periods = append(periods, PeriodMonth)
+	}
+	return periods
+}
+
+// RunForUser digests the last finished week and month the user enabled digests for,
+// skipping periods that already have one or have no diaries. It returns the new digests.
+func (s *Service) RunForUser(ctx context.Context, userID string) ([]*models.Record, error) {
+	if _, busy := s.running.LoadOrStore(userID, true); busy {
+		return nil, ErrDigestRunning
+	}
+	defer s.running.Delete(userID)
+
+	now := time.Now().In(s.configService.Location(userID))
+	var created []*models.Record
+	for _, period := range s.enabledPeriods(userID) {
+		start, _ := periodBounds(period, now)
+		digest, isNew, err := s.generate(ctx, userID, period, start.AddDate(0, 0, -1))
+		if errors.Is(err, ErrNoDiaries) {
+			continue
+		}
+		if err != nil {
+			return created, err
+		}
+		if isNew {
+			created = append(created, digest)
+		}
+	}
+	return created, nil
+}
+
+// Generate digests the finished period containing date, or returns its existing digest
+func (s *Service) Generate(ctx context.Context, userID, period string, date time.Time) (*models.Record, error) {
+	if _, busy := s.running.LoadOrStore(userID, true); busy {
+		return nil, ErrDigestRunning
+	}
+	defer s.running.Delete(userID)
+
+	digest, _, err := s.generate(ctx, userID, period, date)
+	return digest, err
+}
+
+// LastFinished returns a day of the user's last finished period
+func (s *Service) LastFinished(userID, period string) time.Time {
+	start, _ := periodBounds(period, time.Now().In(s.configService.Location(userID)))
+	return start.AddDate(0, 0, -1)
+}
+
+// generate digests the period containing date and reports whether a new digest was created
+func (s *Service) generate(ctx context.Context, userID, period string, date time.Time) (*models.Record, bool, error) {
+	if !IsValidPeriod(period) {
+		return nil, false, fmt.Errorf("unknown digest period: %s", period)
+	}
+
+	// Periods are days of the user's calendar
+	loc := s.configService.Location(userID)
+	y, m, d := date.Date()
+	start, end := periodBounds(period, time.Date(y, m, d, 0, 0, 0, 0, loc))
+	if today := time.Now().In(loc); !end.Before(startOfDay(today)) {
+		return nil, false, ErrPeriodNotFinished
+	}
+
+	// Finished periods are digested once
+	if existing, err := s.find(userID, period, start); err == nil {
+		return existing, false, nil
+	}
+
+	diaries, err := s.app.Dao().FindRecordsByFilter(
+		"diaries",
+		"owner = {:owner} && date >= {:start} && date <= {:end}",
+		"date",
+		0,
+		0,
+		map[string]any{
+			"owner": userID,
+			"start": start.Format(dateLayout) + " 00:00:00.000Z",
+			"end":   end.Format(dateLayout) + " 23:59:59.999Z",
+		},
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch diaries: %w", err)
+	}
+	if len(diaries) == 0 {
+		return nil, false, ErrNoDiaries
+	}
+
+	genCtx, cancel := context.WithTimeout(ctx, generateTimeout)
+	defer cancel()
+	content, err := s.chatService.GenerateDigest(genCtx, userID, periodLabel(period, start, end), diaries)
+	if err != nil {
+		return nil, false, err
+	}
+
+	collection, err := s.app.Dao().FindCollectionByNameOrId("digests")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to find digests collection: %w", err)
+	}
+
+	moods := make(map[string]int)
+	ids := make([]string, 0, len(diaries))
+	for _, diary := range diaries {
+		ids = append(ids, diary.Id)
+		if mood := diary.GetString("mood"); mood != "" {
+			moods[mood]++
+		}
+	}
+	themes := content.Themes
+	if themes == nil {
+		themes = []string{}
+	}
+
+	record := models.NewRecord(collection)
+	record.Set("owner", userID)
+	record.Set("period", period)
+	record.Set("period_start", start.Format(dateLayout))
+	record.Set("period_end", end.Format(dateLayout))
+	record.Set("summary", content.Summary)
+	record.Set("themes", themes)
+	record.Set("moods", moods)
+	record.Set("mood_trend", content.MoodTrend)
+	record.Set("diaries", ids)
+	if err := s.app.Dao().SaveRecord(record); err != nil {
+		// Another run may have digested the period meanwhile
+		if existing, findErr := s.find(userID, period, start); findErr == nil {
+			return existing, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to save digest: %w", err)
+	}
+	logger.Info("[Digest] saved %s digest %s of %s for user %s", period, record.Id, start.Format(dateLayout), userID)
+
+	if emailEnabled, _ := s.configService.GetBool(userID, "ai.digest_email"); emailEnabled {
+		if err := s.sendEmail(userID, record, diaries); err != nil {
+			logger.Error("[Digest] failed to email digest %s: %v", record.Id, err)
+		} else {
+			record.Set("emailed", types.NowDateTime())
+			if err := s.app.Dao().SaveRecord(record); err != nil {
+				logger.Warn("[Digest] failed to mark digest %s as emailed: %v", record.Id, err)
+			}
+		}
+	}
+
+	return record, true, nil
+}
+
+// find returns the user's digest of a period
+func (s *Service) find(userID, period string, start time.Time) (*models.Record, error) {
+	return s.app.Dao().FindFirstRecordByFilter(
+		"digests",
+		"owner = {:owner} && period = {:period} && period_start = {:start}",
+		map[string]any{"owner": userID, "period": period, "start": start.Format(dateLayout)},
+	)
+}
+
+// List returns a page of the user's digests, newest period first. period may be empty for all.
+func (s *Service) List(userID, period string, page, perPage int) ([]*models.Record, int, error) {
+	filter := "owner = {:owner}"
+	params := map[string]any{"owner": userID}
+	if period != "" {
+		filter += " && period = {:period}"
+		params["period"] = period
+	}
+
+	all, err := s.app.Dao().FindRecordsByFilter("digests", filter, "-period_start,period", 0, 0, params)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch digests: %w", err)
+	}
+
+	from := (page - 1) * perPage
+	if from > len(all) {
+		from = len(all)
+	}
+	to := min(from+perPage, len(all))
+	return all[from:to], len(all), nil
+}
+
+// periodBounds returns the first and last day of the week (Monday to Sunday) or month containing t
+func periodBounds(period string, t time.Time) (time.Time, time.Time) {
+	day := startOfDay(t)
+	if period == PeriodMonth {
+		start := day.AddDate(0, 0, 1-day.Day())
+		return start, start.AddDate(0, 1, -1)
+	}
+	offset := (int(day.Weekday()) + 6) % 7
+	start := day.AddDate(0, 0, -offset)
+	return start, start.AddDate(0, 0, 6)
+}
+
+// periodLabel names a period for the prompt and email subject
+func periodLabel(period string, start, end time.Time) string {
+	if period == PeriodMonth {
+		return start.Format("January 2006")
+	}
+	return fmt.Sprintf("the week of %s to %s", start.Format(dateLayout), end.Format(dateLayout))
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// parseDay parses a YYYY-MM-DD date
+func parseDay(s string) (time.Time, error) {
+	return time.Parse(dateLayout, s)
+}
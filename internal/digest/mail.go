@@ -0,0 +1,95 @@
+package digest
+
+import (
+	"fmt"
+	"html"
+	"net/mail"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+
+	"github.com/songtianlun/diarum/internal/email"
+)
+
+// sendEmail mails a digest to its owner
+func (s *Service) sendEmail(userID string, digest *models.Record, diaries []*models.Record) error {
+	to, err := email.UserAddress(s.app, userID)
+	if err != nil {
+		return err
+	}
+
+	client, from, err := email.Client(s.app, s.cfg.SMTP)
+	if err != nil {
+		return err
+	}
+
+	subject, htmlBody, textBody := s.renderEmail(digest, diaries)
+	return client.Send(&mailer.Message{
+		From:    from,
+		To:      []mail.Address{to},
+		Subject: subject,
+		HTML:    htmlBody,
+		Text:    textBody,
+	})
+}
+
+// renderEmail returns the subject, HTML and plain text body of a digest email
+func (s *Service) renderEmail(digest *models.Record, diaries []*models.Record) (string, string, string) {
+	period := digest.GetString("period")
+	start, _ := parseDay(digest.GetString("period_start"))
+	end, _ := parseDay(digest.GetString("period_end"))
+	label := periodLabel(period, start, end)
+	subject := "Your weekly reflection: " + label
+	if period == PeriodMonth {
+		subject = "Your monthly reflection: " + label
+	}
+
+	var themes []string
+	digest.UnmarshalJSONField("themes", &themes)
+	summary := digest.GetString("summary")
+	moodTrend := digest.GetString("mood_trend")
+	appURL := strings.TrimSuffix(s.app.Settings().Meta.AppUrl, "/")
+
+	var h, t strings.Builder
+	h.WriteString("<h2>" + html.EscapeString(subject) + "</h2>\n")
+	t.WriteString(subject + "\n\n")
+
+	for _, paragraph := range strings.Split(summary, "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+			h.WriteString("<p>" + strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br>") + "</p>\n")
+		}
+	}
+	t.WriteString(summary + "\n\n")
+
+	if len(themes) > 0 {
+		h.WriteString("<h3>Themes</h3>\n<ul>\n")
+		t.WriteString("Themes:\n")
+		for _, theme := range themes {
+			h.WriteString("<li>" + html.EscapeString(theme) + "</li>\n")
+			t.WriteString("- " + theme + "\n")
+		}
+		h.WriteString("</ul>\n")
+		t.WriteString("\n")
+	}
+
+	if moodTrend != "" {
+		h.WriteString("<h3>Mood</h3>\n<p>" + html.EscapeString(moodTrend) + "</p>\n")
+		t.WriteString("Mood:\n" + moodTrend + "\n\n")
+	}
+
+	h.WriteString("<h3>Diaries</h3>\n<ul>\n")
+	t.WriteString("Diaries:\n")
+	for _, diary := range diaries {
+		date := diary.GetString("date")
+		if len(date) >= 10 {
+			date = date[:10]
+		}
+		link := appURL + "/diary/" + date
+		h.WriteString(fmt.Sprintf("<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(link), date))
+		t.WriteString("- " + date + ": " + link + "\n")
+	}
+	h.WriteString("</ul>\n")
+
+	return subject, h.String(), t.String()
+}
@@ -0,0 +1,589 @@
+package doctor
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+
+	"github.com/songtianlun/diarum/internal/embedding"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// Issue categories
+const (
+	CategoryMediaMissingFile      = "media_missing_file"
+	CategoryMediaDanglingDiary    = "media_dangling_diary"
+	CategoryMediaUnlinked         = "media_unlinked"
+	CategoryStorageOrphanedFiles  = "storage_orphaned_files"
+	CategoryDiaryDanglingTag      = "diary_dangling_tag"
+	CategoryMessageDanglingDiary  = "message_dangling_diary"
+	CategoryVectorOrphanedDoc     = "vector_orphaned_document"
+	CategoryVectorOrphanedCollect = "vector_orphaned_collection"
+)
+
+// mediaURLPattern matches PocketBase file URLs: /api/files/{collection}/{recordId}/{filename}
+var mediaURLPattern = regexp.MustCompile(`/api/files/[^/]+/([^/]+)/([^/?#]+)`)
+
+// categoryInfo describes each category and whether --fix can repair it
+var categoryInfo = []struct {
+	Name        string
+	Description string
+	Fixable     bool
+}{
+	{CategoryMediaMissingFile, "media records whose file is missing from storage (fix: delete the record)", true},
+	{CategoryMediaDanglingDiary, "media records linked to deleted diaries (fix: unlink them)", true},
+	{CategoryMediaUnlinked, "media not linked to any diary nor used in diary content (report only)", false},
+	{CategoryStorageOrphanedFiles, "files in media storage without a media record (fix: delete the files)", true},
+	{CategoryDiaryDanglingTag, "diaries referencing deleted tags (fix: remove the tags)", true},
+	{CategoryMessageDanglingDiary, "AI messages whose referenced_diaries point at deleted diaries (fix: remove the references)", true},
+	{CategoryVectorOrphanedDoc, "vectors of deleted diaries (fix: delete the vectors)", true},
+	{CategoryVectorOrphanedCollect, "vector collections of deleted users (fix: delete the collection)", true},
+}
+
+// Issue is a single inconsistency
+type Issue struct {
+	ID     string `json:"id"`
+	Owner  string `json:"owner,omitempty"`
+	Detail string `json:"detail"`
+	Fixed  bool   `json:"fixed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Category groups the issues of one kind
+type Category struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Fixable     bool    `json:"fixable"`
+	Count       int     `json:"count"`
+	Fixed       int     `json:"fixed"`
+	Issues      []Issue `json:"issues"`
+}
+
+// Report is the result of a doctor run
+type Report struct {
+	CheckedAt  time.Time   `json:"checked_at"`
+	Fix        bool        `json:"fix"`
+	Healthy    bool        `json:"healthy"` // no unfixed issues in fixable categories
+	Total      int         `json:"total"`
+	Fixed      int         `json:"fixed"`
+	Categories []*Category `json:"categories"`
+	Errors     []string    `json:"errors,omitempty"`
+}
+
+// Doctor scans the data for inconsistencies and optionally repairs them
+type Doctor struct {
+	app      *pocketbase.PocketBase
+	vectorDB *embedding.VectorDB
+}
+
+// NewDoctor creates a new Doctor. vectorDB may be nil, in which case vectors are not checked.
+func NewDoctor(app *pocketbase.PocketBase, vectorDB *embedding.VectorDB) *Doctor {
+	return &Doctor{app: app, vectorDB: vectorDB}
+}
+
+// run holds the state of one doctor run
+type run struct {
+	*Doctor
+	ctx        context.Context
+	fix        bool
+	report     *Report
+	categories map[string]*Category
+	users      map[string]bool
+	diaries    map[string]string // diary ID -> owner
+	tags       map[string]bool
+}
+
+// Run checks every category. With fix set, fixable issues are repaired.
+func (d *Doctor) Run(ctx context.Context, fix bool) (*Report, error) {
+	r := &run{
+		Doctor:     d,
+		ctx:        ctx,
+		fix:        fix,
+		report:     &Report{CheckedAt: time.Now().UTC(), Fix: fix, Categories: make([]*Category, 0, len(categoryInfo))},
+		categories: make(map[string]*Category),
+	}
+	for _, info := range categoryInfo {
+		c := &Category{Name: info.Name, Description: info.Description, Fixable: info.Fixable, Issues: make([]Issue, 0)}
+		r.categories[info.Name] = c
+		r.report.Categories = append(r.report.Categories, c)
+	}
+
+	if err := r.loadIDs(); err != nil {
+		return nil, err
+	}
+
+	fsys, err := d.app.NewFilesystem()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize filesystem: %w", err)
+	}
+	defer fsys.Close()
+
+	r.checkMedia(fsys)
+	r.checkStorage(fsys)
+	r.checkDiaryTags()
+	r.checkMessages()
+	r.checkVectors()
+
+	// Report-only categories do not make the data unhealthy
+	outstanding := 0
+	for _, c := range r.report.Categories {
+		c.Count = len(c.Issues)
+		for _, issue := range c.Issues {
+			if issue.Fixed {
+				c.Fixed++
+			}
+		}
+		r.report.Total += c.Count
+		r.report.Fixed += c.Fixed
+		if c.Fixable {
+			outstanding += c.Count - c.Fixed
+		}
+	}
+	r.report.Healthy = outstanding == 0 && len(r.report.Errors) == 0
+
+	logger.Info("[Doctor] completed: %d issues, %d fixed", r.report.Total, r.report.Fixed)
+	return r.report, nil
+}
+
+// loadIDs loads the IDs of users, diaries and tags
+func (r *run) loadIDs() error {
+	var userIDs []string
+	if err := r.app.Dao().DB().NewQuery("SELECT id FROM users").Column(&userIDs); err != nil {
+		return fmt.Errorf("failed to load users: %w", err)
+	}
+	r.users = make(map[string]bool, len(userIDs))
+	for _, id := range userIDs {
+		r.users[id] = true
+	}
+
+	var diaries []struct {
+		ID    string `db:"id"`
+		Owner string `db:"owner"`
+	}
+	if err := r.app.Dao().DB().NewQuery("SELECT id, owner FROM diaries").All(&diaries); err != nil {
+		return fmt.Errorf("failed to load diaries: %w", err)
+	}
+	r.diaries = make(map[string]string, len(diaries))
+	for _, d := range diaries {
+		r.diaries[d.ID] = d.Owner
+	}
+
+	var tagIDs []string
+	if err := r.app.Dao().DB().NewQuery("SELECT id FROM tags").Column(&tagIDs); err != nil {
+		return fmt.Errorf("failed to load tags: %w", err)
+	}
+	r.tags = make(map[string]bool, len(tagIDs))
+	for _, id := range tagIDs {
+		r.tags[id] = true
+	}
+	return nil
+}
+
+// add records an issue; fixFn is only called in fix mode
+func (r *run) add(category string, issue Issue, fixFn func() error) {
+	if r.fix && fixFn != nil {
+		if err := fixFn(); err != nil {
+			issue.Error = err.Error()
+			logger.Warn("[Doctor] failed to fix %s %s: %v", category, issue.ID, err)
+		} else {
+			issue.Fixed = true
+		}
+	}
+	c := r.categories[category]
+	c.Issues = append(c.Issues, issue)
+}
+
+// stillMissing narrows IDs missing from the loaded snapshot down to the records that
+// are still missing, as records may be created while the run is in progress
+func (r *run) stillMissing(collection string, ids []string) ([]string, error) {
+	var missing []string
+	for _, id := range ids {
+		_, err := r.app.Dao().FindRecordById(collection, id)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to find %s %s: %w", collection, id, err)
+		}
+		missing = append(missing, id)
+	}
+	return missing, nil
+}
+
+// without returns ids without the removed ones
+func without(ids, removed []string) []string {
+	drop := make(map[string]bool, len(removed))
+	for _, id := range removed {
+		drop[id] = true
+	}
+	var kept []string
+	for _, id := range ids {
+		if !drop[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// fail records a check that could not run
+func (r *run) fail(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	logger.Error("[Doctor] %s", msg)
+	r.report.Errors = append(r.report.Errors, msg)
+}
+
+// checkMedia checks media files and their diary links
+func (r *run) checkMedia(fsys *filesystem.System) {
+	media, err := r.app.Dao().FindRecordsByFilter("media", "id != ''", "created", -1, 0)
+	if err != nil {
+		r.fail("failed to load media: %v", err)
+		return
+	}
+
+	// Media referenced by URL in any diary content
+	var contents []string
+	if err := r.app.Dao().DB().NewQuery("SELECT content FROM diaries").Column(&contents); err != nil {
+		r.fail("failed to load diary contents: %v", err)
+		return
+	}
+	usedInContent := make(map[string]bool)
+	for _, content := range contents {
+		for _, m := range mediaURLPattern.FindAllStringSubmatch(content, -1) {
+			usedInContent[m[1]] = true
+		}
+	}
+
+	for _, record := range media {
+		record := record
+		owner := record.GetString("owner")
+
+		file := record.GetString("file")
+		exists := false
+		if file != "" {
+			// A storage error says nothing about the file, so the record is left alone
+			exists, err = fsys.Exists(record.BaseFilesPath() + "/" + file)
+			if err != nil {
+				r.fail("failed to check the file of media %s: %v", record.Id, err)
+				continue
+			}
+		}
+		if !exists {
+			r.add(CategoryMediaMissingFile, Issue{
+				ID:     record.Id,
+				Owner:  owner,
+				Detail: fmt.Sprintf("file %q not found", file),
+			}, func() error {
+				return r.app.Dao().DeleteRecord(record)
+			})
+			continue
+		}
+
+		linked := record.GetStringSlice("diary")
+		var dangling []string
+		for _, id := range linked {
+			if _, ok := r.diaries[id]; !ok {
+				dangling = append(dangling, id)
+			}
+		}
+		if dangling, err = r.stillMissing("diaries", dangling); err != nil {
+			r.fail("failed to check the diaries of media %s: %v", record.Id, err)
+			continue
+		}
+		valid := without(linked, dangling)
+		if len(dangling) > 0 {
+			r.add(CategoryMediaDanglingDiary, Issue{
+				ID:     record.Id,
+				Owner:  owner,
+				Detail: "linked to deleted diaries: " + strings.Join(dangling, ", "),
+			}, func() error {
+				record.Set("diary", valid)
+				return r.app.Dao().SaveRecord(record)
+			})
+		}
+
+		if len(valid) == 0 && !usedInContent[record.Id] {
+			r.add(CategoryMediaUnlinked, Issue{
+				ID:     record.Id,
+				Owner:  owner,
+				Detail: fmt.Sprintf("%q is not used by any diary", record.GetString("name")),
+			}, nil)
+		}
+	}
+}
+
+// checkStorage finds media storage dirs that have no media record
+func (r *run) checkStorage(fsys *filesystem.System) {
+	collection, err := r.app.Dao().FindCollectionByNameOrId("media")
+	if err != nil {
+		r.fail("failed to find media collection: %v", err)
+		return
+	}
+
+	var mediaIDs []string
+	if err := r.app.Dao().DB().NewQuery("SELECT id FROM media").Column(&mediaIDs); err != nil {
+		r.fail("failed to load media: %v", err)
+		return
+	}
+	known := make(map[string]bool, len(mediaIDs))
+	for _, id := range mediaIDs {
+		known[id] = true
+	}
+
+	prefix := collection.BaseFilesPath() + "/"
+	objects, err := fsys.List(prefix)
+	if err != nil {
+		r.fail("failed to list media storage: %v", err)
+		return
+	}
+
+	// Keys look like <collection>/<record>/<file> or <collection>/<record>/thumbs_<file>/<thumb>
+	orphaned := make(map[string]int)
+	var order []string
+	for _, obj := range objects {
+		// Files written after the run started may belong to a media record being created
+		if obj.ModTime.After(r.report.CheckedAt) {
+			continue
+		}
+		rest := strings.TrimPrefix(obj.Key, prefix)
+		recordID, _, found := strings.Cut(rest, "/")
+		if !found || known[recordID] {
+			continue
+		}
+		if orphaned[recordID] == 0 {
+			order = append(order, recordID)
+		}
+		orphaned[recordID]++
+	}
+
+	order, err = r.stillMissing("media", order)
+	if err != nil {
+		r.fail("failed to check media storage: %v", err)
+		return
+	}
+
+	for _, recordID := range order {
+		recordID := recordID
+		r.add(CategoryStorageOrphanedFiles, Issue{
+			ID:     recordID,
+			Detail: fmt.Sprintf("%d file(s) under %s%s/", orphaned[recordID], prefix, recordID),
+		}, func() error {
+			if errs := fsys.DeletePrefix(prefix + recordID + "/"); len(errs) > 0 {
+				return errs[0]
+			}
+			return nil
+		})
+	}
+}
+
+// checkDiaryTags finds diaries referencing deleted tags
+func (r *run) checkDiaryTags() {
+	diaries, err := r.app.Dao().FindRecordsByFilter("diaries", "id != ''", "date", -1, 0)
+	if err != nil {
+		r.fail("failed to load diaries: %v", err)
+		return
+	}
+
+	for _, record := range diaries {
+		record := record
+		linked := record.GetStringSlice("tags")
+		var dangling []string
+		for _, id := range linked {
+			if !r.tags[id] {
+				dangling = append(dangling, id)
+			}
+		}
+		if dangling, err = r.stillMissing("tags", dangling); err != nil {
+			r.fail("failed to check the tags of diary %s: %v", record.Id, err)
+			continue
+		}
+		valid := without(linked, dangling)
+		if len(dangling) == 0 {
+			continue
+		}
+		r.add(CategoryDiaryDanglingTag, Issue{
+			ID:     record.Id,
+			Owner:  record.GetString("owner"),
+			Detail: "references deleted tags: " + strings.Join(dangling, ", "),
+		}, func() error {
+			record.Set("tags", valid)
+			return r.app.Dao().SaveRecord(record)
+		})
+	}
+}
+
+// checkMessages finds AI messages referencing deleted diaries
+func (r *run) checkMessages() {
+	messages, err := r.app.Dao().FindRecordsByFilter("ai_messages", "id != ''", "created", -1, 0)
+	if err != nil {
+		r.fail("failed to load AI messages: %v", err)
+		return
+	}
+
+	for _, record := range messages {
+		record := record
+		referenced := referencedDiaries(record)
+		var dangling []string
+		for _, id := range referenced {
+			if _, ok := r.diaries[id]; !ok {
+				dangling = append(dangling, id)
+			}
+		}
+		if dangling, err = r.stillMissing("diaries", dangling); err != nil {
+			r.fail("failed to check the diaries of AI message %s: %v", record.Id, err)
+			continue
+		}
+		valid := without(referenced, dangling)
+		if len(dangling) == 0 {
+			continue
+		}
+		r.add(CategoryMessageDanglingDiary, Issue{
+			ID:     record.Id,
+			Owner:  record.GetString("owner"),
+			Detail: "references deleted diaries: " + strings.Join(dangling, ", "),
+		}, func() error {
+			if len(valid) == 0 {
+				record.Set("referenced_diaries", nil)
+			} else {
+				record.Set("referenced_diaries", valid)
+			}
+			return r.app.Dao().SaveRecord(record)
+		})
+	}
+}
+
+// checkVectors finds vectors of deleted diaries and collections of deleted users.
+// Diaries are reloaded per user, since new diaries are indexed while the run is in progress.
+func (r *run) checkVectors() {
+	if r.vectorDB == nil {
+		return
+	}
+
+	for _, userID := range r.vectorDB.UserIDs() {
+		userID := userID
+		if !r.users[userID] {
+			missing, err := r.stillMissing("users", []string{userID})
+			if err != nil {
+				r.fail("failed to check user %s: %v", userID, err)
+				continue
+			}
+			if len(missing) > 0 {
+				r.add(CategoryVectorOrphanedCollect, Issue{
+					ID:     userID,
+					Owner:  userID,
+					Detail: fmt.Sprintf("%d vector(s) of a deleted user", r.vectorDB.Count(userID)),
+				}, func() error {
+					return r.vectorDB.DeleteCollection(userID)
+				})
+				continue
+			}
+		}
+
+		owned, err := r.ownedDiaries(userID)
+		if err != nil {
+			r.fail("failed to load diaries of user %s: %v", userID, err)
+			continue
+		}
+		ids, ok, err := r.vectorDB.DocumentIDs(r.ctx, userID, owned)
+		if err != nil {
+			r.fail("failed to list vectors of user %s: %v", userID, err)
+			continue
+		}
+		if !ok {
+			// None of the user's diaries is indexed, so every vector is orphaned
+			r.add(CategoryVectorOrphanedCollect, Issue{
+				ID:     userID,
+				Owner:  userID,
+				Detail: fmt.Sprintf("%d vector(s), none belonging to an existing diary", r.vectorDB.Count(userID)),
+			}, func() error {
+				owned, err := r.ownedDiaries(userID)
+				if err != nil {
+					return err
+				}
+				if _, ok, err := r.vectorDB.DocumentIDs(r.ctx, userID, owned); err != nil {
+					return err
+				} else if ok {
+					return fmt.Errorf("diaries of the user were indexed during the run")
+				}
+				return r.vectorDB.DeleteCollection(userID)
+			})
+			continue
+		}
+
+		known := make(map[string]bool, len(owned))
+		for _, id := range owned {
+			known[id] = true
+		}
+		var orphaned []string
+		for _, id := range ids {
+			if !known[id] {
+				orphaned = append(orphaned, id)
+			}
+		}
+
+		for _, id := range orphaned {
+			id := id
+			// Re-check right before reporting, the diary may have been created since
+			if owner, err := r.diaryOwner(id); err != nil {
+				r.fail("failed to check diary %s: %v", id, err)
+				continue
+			} else if owner == userID {
+				continue
+			}
+			r.add(CategoryVectorOrphanedDoc, Issue{
+				ID:     id,
+				Owner:  userID,
+				Detail: "vector of a deleted diary",
+			}, func() error {
+				return r.vectorDB.DeleteDocuments(r.ctx, userID, id)
+			})
+		}
+	}
+}
+
+// ownedDiaries loads the IDs of a user's diaries
+func (r *run) ownedDiaries(userID string) ([]string, error) {
+	var ids []string
+	err := r.app.Dao().DB().
+		NewQuery("SELECT id FROM diaries WHERE owner = {:owner}").
+		Bind(dbx.Params{"owner": userID}).
+		Column(&ids)
+	return ids, err
+}
+
+// diaryOwner returns the owner of a diary, or "" if the diary does not exist
+func (r *run) diaryOwner(id string) (string, error) {
+	record, err := r.app.Dao().FindRecordById("diaries", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return record.GetString("owner"), nil
+}
+
+// referencedDiaries reads the referenced_diaries JSON field of an AI message
+func referencedDiaries(record *models.Record) []string {
+	var ids []string
+	raw := record.Get("referenced_diaries")
+	if raw == nil {
+		return nil
+	}
+	if arr, ok := raw.([]string); ok {
+		return arr
+	}
+	if jsonBytes, err := json.Marshal(raw); err == nil {
+		json.Unmarshal(jsonBytes, &ids)
+	}
+	return ids
+}
@@ -0,0 +1,86 @@
+package email
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+// SMTPConfig describes the mail server Diarum sends its own emails through
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// TLS uses implicit TLS, otherwise STARTTLS is used when the server offers it
+	TLS      bool
+	From     string
+	FromName string
+}
+
+// LoadSMTPConfig reads the SMTP config from the DIARUM_SMTP_* environment variables
+func LoadSMTPConfig() SMTPConfig {
+	cfg := SMTPConfig{
+		Host:     strings.TrimSpace(os.Getenv("DIARUM_SMTP_HOST")),
+		Port:     587,
+		Username: os.Getenv("DIARUM_SMTP_USERNAME"),
+		Password: os.Getenv("DIARUM_SMTP_PASSWORD"),
+		TLS:      os.Getenv("DIARUM_SMTP_TLS") == "true",
+		From:     strings.TrimSpace(os.Getenv("DIARUM_SMTP_FROM")),
+		FromName: os.Getenv("DIARUM_SMTP_FROM_NAME"),
+	}
+
+	if port, err := strconv.Atoi(os.Getenv("DIARUM_SMTP_PORT")); err == nil && port > 0 {
+		cfg.Port = port
+	}
+	if cfg.FromName == "" {
+		cfg.FromName = "Diarum"
+	}
+
+	return cfg
+}
+
+// Client returns the configured SMTP client, or PocketBase's mail client if its SMTP is enabled
+func Client(app *pocketbase.PocketBase, cfg SMTPConfig) (mailer.Mailer, mail.Address, error) {
+	if cfg.Host != "" {
+		from := cfg.From
+		if from == "" {
+			from = cfg.Username
+		}
+		if from == "" {
+			return nil, mail.Address{}, fmt.Errorf("DIARUM_SMTP_FROM is not set")
+		}
+		client := &mailer.SmtpClient{
+			Host:     cfg.Host,
+			Port:     cfg.Port,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			Tls:      cfg.TLS,
+		}
+		return client, mail.Address{Name: cfg.FromName, Address: from}, nil
+	}
+
+	settings := app.Settings()
+	if !settings.Smtp.Enabled {
+		return nil, mail.Address{}, fmt.Errorf("no SMTP server configured")
+	}
+	return app.NewMailClient(), mail.Address{Name: settings.Meta.SenderName, Address: settings.Meta.SenderAddress}, nil
+}
+
+// UserAddress returns the email address of a user
+func UserAddress(app *pocketbase.PocketBase, userID string) (mail.Address, error) {
+	user, err := app.Dao().FindRecordById("users", userID)
+	if err != nil {
+		return mail.Address{}, fmt.Errorf("failed to find user: %w", err)
+	}
+	address := user.GetString("email")
+	if address == "" {
+		return mail.Address{}, fmt.Errorf("user has no email address")
+	}
+	return mail.Address{Name: user.GetString("name"), Address: address}, nil
+}
@@ -0,0 +1,68 @@
+package embedding
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+const (
+	// buildDelay lets a burst of saves, such as an import, share one incremental build
+	buildDelay = 5 * time.Second
+	// buildTimeout bounds one scheduled incremental build
+	buildTimeout = 10 * time.Minute
+)
+
+// buildScheduler debounces the incremental vector builds of each user
+type buildScheduler struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	// running serializes the builds of each user
+	running sync.Map
+}
+
+// ScheduleIncrementalBuild builds the user's new and outdated vectors once their diaries
+// have not been saved for a moment. Nothing is scheduled unless AI is enabled.
+func (s *EmbeddingService) ScheduleIncrementalBuild(userID string) {
+	if enabled, _ := s.configService.GetBool(userID, "ai.enabled"); !enabled {
+		return
+	}
+
+	s.builds.mu.Lock()
+	defer s.builds.mu.Unlock()
+	if timer, ok := s.builds.timers[userID]; ok {
+		timer.Stop()
+	}
+	s.builds.timers[userID] = time.AfterFunc(buildDelay, func() {
+		s.builds.mu.Lock()
+		delete(s.builds.timers, userID)
+		s.builds.mu.Unlock()
+
+		lock, _ := s.builds.running.LoadOrStore(userID, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), buildTimeout)
+		defer cancel()
+
+		logger.Info("[AutoVectorBuild] triggered by diary changes for user: %s", userID)
+		result, err := s.BuildIncrementalVectors(ctx, userID)
+		if err != nil {
+			logger.Error("[AutoVectorBuild] failed for user %s: %v", userID, err)
+			return
+		}
+		logger.Info("[AutoVectorBuild] completed for user %s: %d built, %d failed", userID, result.Success, result.Failed)
+	})
+}
+
+// StopScheduledBuilds cancels the builds that have not started yet
+func (s *EmbeddingService) StopScheduledBuilds() {
+	s.builds.mu.Lock()
+	defer s.builds.mu.Unlock()
+	for userID, timer := range s.builds.timers {
+		timer.Stop()
+		delete(s.builds.timers, userID)
+	}
+}
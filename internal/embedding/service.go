@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
@@ -22,6 +24,7 @@ type EmbeddingService struct {
 	app           *pocketbase.PocketBase
 	vectorDB      *VectorDB
 	configService *config.ConfigService
+	builds        buildScheduler
 }
 
 // BuildResult represents the result of a build operation
@@ -68,6 +71,7 @@ func NewEmbeddingService(app *pocketbase.PocketBase, vectorDB *VectorDB) *Embedd
 		app:           app,
 		vectorDB:      vectorDB,
 		configService: config.NewConfigService(app),
+		builds:        buildScheduler{timers: make(map[string]*time.Timer)},
 	}
 }
 
@@ -438,6 +442,95 @@ func (s *EmbeddingService) QuerySimilar(ctx context.Context, userID, query strin
 	return searchResults, nil
 }
 
+// ErrNotIndexed is returned for diaries that have no stored embedding yet
+var ErrNotIndexed = errors.New("the diary is not indexed yet")
+
+// storedEmbedding returns the stored embedding of a diary
+func (s *EmbeddingService) storedEmbedding(ctx context.Context, userID, diaryID string) (*chromem.Collection, []float32, error) {
+	collection := s.vectorDB.GetCollection(userID)
+	if collection == nil {
+		return nil, nil, ErrNotIndexed
+	}
+	doc, err := collection.GetByID(ctx, diaryID)
+	if err != nil || len(doc.Embedding) == 0 {
+		return nil, nil, ErrNotIndexed
+	}
+	return collection, doc.Embedding, nil
+}
+
+// QueryRelated finds the diaries nearest to a diary by its stored embedding, excluding
+// the diary itself. No embedding API call is made.
+func (s *EmbeddingService) QueryRelated(ctx context.Context, userID, diaryID string, limit int) ([]DiarySearchResult, error) {
+	collection, embedding, err := s.storedEmbedding(ctx, userID, diaryID)
+	if err != nil {
+		return nil, err
+	}
+
+	// One more result than asked for, the diary matches itself best
+	results, err := collection.QueryEmbedding(ctx, embedding, min(limit+1, collection.Count()), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collection: %w", err)
+	}
+
+	related := make([]DiarySearchResult, 0, len(results))
+	for _, result := range results {
+		if result.ID == diaryID {
+			continue
+		}
+		related = append(related, toSearchResult(result.ID, result.Content, result.Metadata, result.Similarity))
+	}
+	if len(related) > limit {
+		related = related[:limit]
+	}
+	return related, nil
+}
+
+// RankByDiary scores the candidate diaries by similarity to a diary's stored embedding,
+// most similar first. Candidates without a stored embedding are left out.
+func (s *EmbeddingService) RankByDiary(ctx context.Context, userID, diaryID string, candidateIDs []string, limit int) ([]DiarySearchResult, error) {
+	collection, embedding, err := s.storedEmbedding(ctx, userID, diaryID)
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := make([]DiarySearchResult, 0, len(candidateIDs))
+	for _, id := range candidateIDs {
+		if id == diaryID {
+			continue
+		}
+		doc, err := collection.GetByID(ctx, id)
+		if err != nil || len(doc.Embedding) != len(embedding) {
+			continue
+		}
+		ranked = append(ranked, toSearchResult(doc.ID, doc.Content, doc.Metadata, dotProduct(embedding, doc.Embedding)))
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked, nil
+}
+
+// dotProduct is the cosine similarity of the normalized vectors stored by chromem-go
+func dotProduct(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func toSearchResult(id, content string, metadata map[string]string, score float32) DiarySearchResult {
+	return DiarySearchResult{
+		ID:      id,
+		Date:    metadata["date"],
+		Content: content,
+		Mood:    metadata["mood"],
+		Weather: metadata["weather"],
+		Score:   score,
+	}
+}
+
 // GetVectorStats returns statistics about the vector index for a user
 func (s *EmbeddingService) GetVectorStats(ctx context.Context, userID string) (*VectorStats, error) {
 	stats := &VectorStats{}
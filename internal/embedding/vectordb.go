@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	chromem "github.com/philippgille/chromem-go"
@@ -85,6 +86,67 @@ func (v *VectorDB) GetCollection(userID string) *chromem.Collection {
 	return v.db.GetCollection(collName, placeholderFunc)
 }
 
+// UserIDs returns the IDs of all users that have a vector collection
+func (v *VectorDB) UserIDs() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	prefix := collectionName + "_"
+	var ids []string
+	for name := range v.db.ListCollections() {
+		if strings.HasPrefix(name, prefix) {
+			ids = append(ids, strings.TrimPrefix(name, prefix))
+		}
+	}
+	return ids
+}
+
+// DocumentIDs returns the IDs of all documents in a user's collection.
+// chromem-go cannot enumerate documents, so the stored embedding of one of
+// knownIDs is used as query vector to fetch every document. ok is false when
+// none of knownIDs is indexed, i.e. every document in the collection is unknown.
+func (v *VectorDB) DocumentIDs(ctx context.Context, userID string, knownIDs []string) (ids []string, ok bool, err error) {
+	collection := v.GetCollection(userID)
+	if collection == nil || collection.Count() == 0 {
+		return nil, true, nil
+	}
+
+	for _, id := range knownIDs {
+		doc, err := collection.GetByID(ctx, id)
+		if err != nil || len(doc.Embedding) == 0 {
+			continue
+		}
+		results, err := collection.QueryEmbedding(ctx, doc.Embedding, collection.Count(), nil, nil)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to list documents: %w", err)
+		}
+		ids = make([]string, 0, len(results))
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		return ids, true, nil
+	}
+	return nil, false, nil
+}
+
+// DeleteDocuments removes documents from a user's collection
+func (v *VectorDB) DeleteDocuments(ctx context.Context, userID string, ids ...string) error {
+	collection := v.GetCollection(userID)
+	if collection == nil || len(ids) == 0 {
+		return nil
+	}
+	return collection.Delete(ctx, nil, nil, ids...)
+}
+
+// Count returns the number of documents in a user's collection
+func (v *VectorDB) Count(userID string) int {
+	collection := v.GetCollection(userID)
+	if collection == nil {
+		return 0
+	}
+	return collection.Count()
+}
+
 // Close closes the vector database
 func (v *VectorDB) Close() error {
 	// chromem-go doesn't have a Close method, but we keep this for future compatibility
@@ -0,0 +1,111 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrNotEncrypted is returned when the data does not start with the sealed header
+var ErrNotEncrypted = errors.New("data is not encrypted")
+
+// ErrInvalidPassphrase is returned when decryption fails (wrong passphrase or tampered data)
+var ErrInvalidPassphrase = errors.New("invalid passphrase or corrupted data")
+
+// magic identifies data sealed by this package (format version 1)
+var magic = []byte("DIARUMENC1")
+
+const (
+	saltSize = 16
+	keySize  = 32 // AES-256
+
+	// scrypt parameters (N=2^15, r=8, p=1 as recommended for interactive use)
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Seal encrypts plaintext with AES-256-GCM using a key derived from passphrase via scrypt.
+// Output layout: magic | salt | nonce | ciphertext+tag
+func Seal(plaintext []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase is required")
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	out := make([]byte, 0, len(magic)+saltSize+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, magic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	// The header is authenticated as additional data
+	return gcm.Seal(out, nonce, plaintext, out[:len(magic)+saltSize]), nil
+}
+
+// Open decrypts data produced by Seal
+func Open(data []byte, passphrase string) ([]byte, error) {
+	if !IsSealed(data) {
+		return nil, ErrNotEncrypted
+	}
+
+	salt := data[len(magic) : len(magic)+saltSize]
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := len(magic) + saltSize
+	if len(data) < headerLen+gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrInvalidPassphrase
+	}
+	nonce := data[headerLen : headerLen+gcm.NonceSize()]
+	ciphertext := data[headerLen+gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, data[:headerLen])
+	if err != nil {
+		return nil, ErrInvalidPassphrase
+	}
+	return plaintext, nil
+}
+
+// IsSealed reports whether data starts with the header written by Seal
+func IsSealed(data []byte) bool {
+	return len(data) >= len(magic)+saltSize && bytes.Equal(data[:len(magic)], magic)
+}
+
+// newGCM derives the key for passphrase/salt and returns an AES-GCM AEAD
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,91 @@
+package encrypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	plaintext := []byte("dear diary, today I wrote a test")
+
+	sealed, err := Seal(plaintext, "correct horse")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if !IsSealed(sealed) {
+		t.Fatal("sealed data is not recognized by IsSealed")
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Fatal("sealed data contains the plaintext")
+	}
+
+	opened, err := Open(sealed, "correct horse")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Fatalf("Open = %q, want %q", opened, plaintext)
+	}
+
+	// Every seal uses a fresh salt and nonce
+	again, err := Seal(plaintext, "correct horse")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if bytes.Equal(again, sealed) {
+		t.Fatal("sealing twice produced the same output")
+	}
+}
+
+func TestSealRequiresPassphrase(t *testing.T) {
+	if _, err := Seal([]byte("data"), ""); err == nil {
+		t.Fatal("Seal with an empty passphrase succeeded")
+	}
+}
+
+func TestOpenWrongPassphrase(t *testing.T) {
+	sealed, err := Seal([]byte("secret"), "right")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if _, err := Open(sealed, "wrong"); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Fatalf("Open with the wrong passphrase = %v, want ErrInvalidPassphrase", err)
+	}
+}
+
+func TestOpenCorrupted(t *testing.T) {
+	sealed, err := Seal([]byte("secret diary entry"), "pass")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	headerLen := len(magic) + saltSize
+
+	flip := func(i int) []byte {
+		data := bytes.Clone(sealed)
+		data[i] ^= 0x01
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"plain data", []byte("PK\x03\x04 not encrypted"), ErrNotEncrypted},
+		{"header only", sealed[:headerLen], ErrInvalidPassphrase},
+		{"truncated nonce", sealed[:headerLen+4], ErrInvalidPassphrase},
+		{"truncated tag", sealed[:len(sealed)-1], ErrInvalidPassphrase},
+		{"tampered salt", flip(len(magic)), ErrInvalidPassphrase},
+		{"tampered nonce", flip(headerLen), ErrInvalidPassphrase},
+		{"tampered ciphertext", flip(len(sealed) - 20), ErrInvalidPassphrase},
+		{"tampered tag", flip(len(sealed) - 1), ErrInvalidPassphrase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Open(tt.data, "pass"); !errors.Is(err, tt.want) {
+				t.Fatalf("Open = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package enrichment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"github.com/songtianlun/diarum/internal/chat"
+	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/logger"
+	"github.com/songtianlun/diarum/internal/tags"
+)
+
+const (
+	// enrichDelay lets the editor's autosaves settle before a diary is enriched
+	enrichDelay = 2 * time.Minute
+	// enrichTimeout bounds the enrichment of one diary
+	enrichTimeout = 2 * time.Minute
+	// enrichConcurrency limits the scheduled enrichments running at once, e.g. after an import
+	enrichConcurrency = 2
+)
+
+// ErrEnrichmentRunning is returned while a diary is already being enriched
+var ErrEnrichmentRunning = errors.New("the diary is already being enriched")
+
+// Selection picks suggestions of a diary to accept or dismiss
+type Selection struct {
+	Mood   bool     `json:"mood"`
+	Topics []string `json:"topics"`
+	People []string `json:"people"`
+	Places []string `json:"places"`
+}
+
+// Service suggests mood, sentiment, topics, people and places for diaries after they are saved.
+// Suggestions are kept in the diary's ai_* fields; the user's mood and tags change only
+// when a suggestion is accepted.
+type Service struct {
+	app           *pocketbase.PocketBase
+	chatService   *chat.ChatService
+	configService *config.ConfigService
+	mu            sync.Mutex
+	timers        map[string]*time.Timer
+	batches       map[string]*batch
+	queued        map[string]bool
+	running       sync.Map
+	slots         chan struct{}
+	done          chan struct{}
+}
+
+// batch collects the diaries of a user saved while the user's enrichments are held back
+type batch struct {
+	holds    int
+	diaryIDs []string
+}
+
+// NewService creates a new enrichment service
+func NewService(app *pocketbase.PocketBase, chatService *chat.ChatService) *Service {
+	return &Service{
+		app:           app,
+		chatService:   chatService,
+		configService: config.NewConfigService(app),
+		timers:        make(map[string]*time.Timer),
+		batches:       make(map[string]*batch),
+		queued:        make(map[string]bool),
+		slots:         make(chan struct{}, enrichConcurrency),
+		done:          make(chan struct{}),
+	}
+}
+
+// Enabled reports whether the user turned on enrichment
+func (s *Service) Enabled(userID string) bool {
+	aiEnabled, _ := s.configService.GetBool(userID, "ai.enabled")
+	enrich, _ := s.configService.GetBool(userID, "ai.enrichment")
+	return aiEnabled && enrich
+}
+
+// Schedule enriches a saved diary once it has not been saved again for a while.
+// While the owner's enrichments are held back by Batch, the diary is queued instead.
+func (s *Service) Schedule(diary *models.Record) {
+	userID := diary.GetString("owner")
+	if userID == "" || !s.Enabled(userID) {
+		return
+	}
+	if contentHash(diary.GetString("content")) == diary.GetString("ai_content_hash") {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped() {
+		return
+	}
+	if b, ok := s.batches[userID]; ok {
+		if !s.queued[diary.Id] {
+			s.queued[diary.Id] = true
+			b.diaryIDs = append(b.diaryIDs, diary.Id)
+		}
+		return
+	}
+	s.schedule(diary.Id)
+}
+
+// schedule (re)arms the timer of a diary, s.mu must be held
+func (s *Service) schedule(diaryID string) {
+	if timer, ok := s.timers[diaryID]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(enrichDelay, func() {
+		// Forget the timer before waiting for a slot, so that Stop and saves made
+		// meanwhile are not mistaken for a timer that has yet to fire
+		s.mu.Lock()
+		if s.timers[diaryID] == timer {
+			delete(s.timers, diaryID)
+		}
+		s.mu.Unlock()
+
+		s.enrichQueued(diaryID)
+	})
+	s.timers[diaryID] = timer
+}
+
+// enrichQueued enriches a diary once a slot is free. It returns false without
+// enriching if the service was stopped meanwhile.
+func (s *Service) enrichQueued(diaryID string) bool {
+	select {
+	case <-s.done:
+		return false
+	case s.slots <- struct{}{}:
+	}
+	defer func() { <-s.slots }()
+	if s.stopped() {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), enrichTimeout)
+	defer cancel()
+	_, err := s.Enrich(ctx, diaryID, false)
+	switch {
+	case errors.Is(err, ErrEnrichmentRunning):
+		// The running enrichment may have read older content, check again later
+		s.mu.Lock()
+		if !s.stopped() {
+			s.schedule(diaryID)
+		}
+		s.mu.Unlock()
+	case err != nil:
+		logger.Error("[Enrichment] failed for diary %s: %v", diaryID, err)
+	}
+	return true
+}
+
+// Batch holds back the enrichment of the user's diaries, e.g. during an import, until
+// the returned release function is called. The diaries saved meanwhile are then
+// enriched one after another instead of each on its own timer.
+func (s *Service) Batch(userID string) (release func()) {
+	s.mu.Lock()
+	b, ok := s.batches[userID]
+	if !ok {
+		b = &batch{}
+		s.batches[userID] = b
+	}
+	b.holds++
+	s.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if b.holds--; b.holds > 0 {
+				return
+			}
+			delete(s.batches, userID)
+			if len(b.diaryIDs) > 0 {
+				go s.enrichBatch(userID, b.diaryIDs)
+			}
+		})
+	}
+}
+
+// enrichBatch enriches the diaries queued by a batch one at a time
+func (s *Service) enrichBatch(userID string, diaryIDs []string) {
+	logger.Info("[Enrichment] enriching %d diaries of user %s", len(diaryIDs), userID)
+	for i, diaryID := range diaryIDs {
+		enriched := s.enrichQueued(diaryID)
+
+		s.mu.Lock()
+		delete(s.queued, diaryID)
+		if !enriched {
+			for _, id := range diaryIDs[i+1:] {
+				delete(s.queued, id)
+			}
+		}
+		s.mu.Unlock()
+		if !enriched {
+			return
+		}
+	}
+}
+
+// Pending reports whether an enrichment of the diary is scheduled, queued or running
+func (s *Service) Pending(diaryID string) bool {
+	if _, running := s.running.Load(diaryID); running {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.timers[diaryID]
+	return ok || s.queued[diaryID]
+}
+
+// Stop cancels the scheduled and queued enrichments, including those waiting for a slot
+func (s *Service) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopped() {
+		close(s.done)
+	}
+	for id, timer := range s.timers {
+		timer.Stop()
+		delete(s.timers, id)
+	}
+}
+
+func (s *Service) stopped() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Enrich stores fresh suggestions for a diary. Unless force is set, a diary whose
+// content did not change since its last enrichment is returned as is.
+func (s *Service) Enrich(ctx context.Context, diaryID string, force bool) (*models.Record, error) {
+	if _, busy := s.running.LoadOrStore(diaryID, true); busy {
+		return nil, ErrEnrichmentRunning
+	}
+	defer s.running.Delete(diaryID)
+
+	diary, err := s.app.Dao().FindRecordById("diaries", diaryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find diary: %w", err)
+	}
+	content := diary.GetString("content")
+	hash := contentHash(content)
+	if !force && hash == diary.GetString("ai_content_hash") {
+		return diary, nil
+	}
+
+	userID := diary.GetString("owner")
+	date := diary.GetString("date")
+	if len(date) >= 10 {
+		date = date[:10]
+	}
+	suggestion, err := s.chatService.SuggestEnrichment(ctx, userID, date, content)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reload so that changes the user made meanwhile are kept
+	diary, err = s.app.Dao().FindRecordById("diaries", diaryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find diary: %w", err)
+	}
+
+	// Suggestions the user already has are left out
+	tagged := lowerSet(tags.Names(s.app.Dao(), diary.GetStringSlice("tags")))
+	mood := suggestion.Mood
+	if strings.EqualFold(mood, strings.TrimSpace(diary.GetString("mood"))) {
+		mood = ""
+	}
+	diary.Set("ai_mood", mood)
+	diary.Set("ai_sentiment", suggestion.Sentiment)
+	diary.Set("ai_topics", withoutNames(suggestion.Topics, tagged))
+	diary.Set("ai_people", withoutNames(suggestion.People, tagged))
+	diary.Set("ai_places", withoutNames(suggestion.Places, tagged))
+	diary.Set("ai_enriched_at", types.NowDateTime())
+	diary.Set("ai_content_hash", hash)
+	if err := s.app.Dao().SaveRecord(diary); err != nil {
+		return nil, fmt.Errorf("failed to save suggestions: %w", err)
+	}
+
+	logger.Info("[Enrichment] enriched diary %s of user %s", diaryID, userID)
+	return diary, nil
+}
+
+// Accept applies the selected suggestions: the suggested mood replaces the diary's mood
+// and suggested topics, people and places are added to its tags
+func (s *Service) Accept(diary *models.Record, sel Selection) (*models.Record, error) {
+	if sel.Mood {
+		if mood := diary.GetString("ai_mood"); mood != "" {
+			diary.Set("mood", mood)
+			diary.Set("ai_mood", "")
+		}
+	}
+
+	var accepted []string
+	for _, field := range []struct {
+		name     string
+		selected []string
+	}{
+		{"ai_topics", sel.Topics},
+		{"ai_people", sel.People},
+		{"ai_places", sel.Places},
+	} {
+		suggested := jsonList(diary, field.name)
+		picked := lowerSet(field.selected)
+		var remaining []string
+		for _, name := range suggested {
+			if picked[strings.ToLower(name)] {
+				accepted = append(accepted, name)
+			} else {
+				remaining = append(remaining, name)
+			}
+		}
+		diary.Set(field.name, nonNil(remaining))
+	}
+
+	if len(accepted) > 0 {
+		ids, err := tags.Ensure(s.app.Dao(), diary.GetString("owner"), accepted)
+		if err != nil {
+			return nil, err
+		}
+		current := diary.GetStringSlice("tags")
+		for _, id := range ids {
+			if !slices.Contains(current, id) {
+				current = append(current, id)
+			}
+		}
+		diary.Set("tags", current)
+	}
+
+	if err := s.app.Dao().SaveRecord(diary); err != nil {
+		return nil, fmt.Errorf("failed to save diary: %w", err)
+	}
+	return diary, nil
+}
+
+// Dismiss discards the selected suggestions, they come back only if the diary content changes
+func (s *Service) Dismiss(diary *models.Record, sel Selection) (*models.Record, error) {
+	if sel.Mood {
+		diary.Set("ai_mood", "")
+	}
+	diary.Set("ai_topics", withoutNames(jsonList(diary, "ai_topics"), lowerSet(sel.Topics)))
+	diary.Set("ai_people", withoutNames(jsonList(diary, "ai_people"), lowerSet(sel.People)))
+	diary.Set("ai_places", withoutNames(jsonList(diary, "ai_places"), lowerSet(sel.Places)))
+
+	if err := s.app.Dao().SaveRecord(diary); err != nil {
+		return nil, fmt.Errorf("failed to save diary: %w", err)
+	}
+	return diary, nil
+}
+
+// Suggestions returns the pending suggestions of a diary, the sentiment is nil until it is enriched
+func Suggestions(diary *models.Record) map[string]any {
+	var sentiment any
+	enrichedAt := ""
+	if t := diary.GetDateTime("ai_enriched_at"); !t.IsZero() {
+		sentiment = diary.GetFloat("ai_sentiment")
+		enrichedAt = t.String()
+	}
+	return map[string]any{
+		"mood":        diary.GetString("ai_mood"),
+		"sentiment":   sentiment,
+		"topics":      nonNil(jsonList(diary, "ai_topics")),
+		"people":      nonNil(jsonList(diary, "ai_people")),
+		"places":      nonNil(jsonList(diary, "ai_places")),
+		"enriched_at": enrichedAt,
+	}
+}
+
+// contentHash identifies the diary content suggestions were made for
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// jsonList reads a JSON list of names field
+func jsonList(diary *models.Record, field string) []string {
+	var list []string
+	diary.UnmarshalJSONField(field, &list)
+	return list
+}
+
+// withoutNames drops the names contained in exclude, compared case-insensitively
+func withoutNames(names []string, exclude map[string]bool) []string {
+	kept := make([]string, 0, len(names))
+	for _, name := range names {
+		if !exclude[strings.ToLower(name)] {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+func lowerSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return set
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
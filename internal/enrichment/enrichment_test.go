@@ -0,0 +1,142 @@
+package enrichment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/migrate"
+
+	_ "github.com/songtianlun/diarum/internal/migrations"
+)
+
+// newTestService creates an enrichment service on a migrated app in a temporary
+// directory. It has no chat service, diaries must not actually be enriched.
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	app := pocketbase.NewWithConfig(pocketbase.Config{DefaultDataDir: t.TempDir(), HideStartBanner: true})
+	if err := app.Bootstrap(); err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+	t.Cleanup(func() { app.ResetBootstrapState() })
+
+	runner, err := migrate.NewRunner(app.DB(), migrations.AppMigrations)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	if _, err := runner.Up(); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	s := NewService(app, nil)
+	t.Cleanup(s.Stop)
+	return s
+}
+
+// newDiary returns an unsaved diary of a user who turned on enrichment
+func newDiary(t *testing.T, s *Service, id, owner string) *models.Record {
+	t.Helper()
+	for _, key := range []string{"ai.enabled", "ai.enrichment"} {
+		if err := s.configService.Set(owner, key, true); err != nil {
+			t.Fatalf("failed to set %s: %v", key, err)
+		}
+	}
+	diaries, err := s.app.Dao().FindCollectionByNameOrId("diaries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diary := models.NewRecord(diaries)
+	diary.Id = id
+	diary.Set("owner", owner)
+	diary.Set("content", "<p>Went hiking.</p>")
+	return diary
+}
+
+func TestBatchQueuesDiariesUntilReleased(t *testing.T) {
+	s := newTestService(t)
+
+	release := s.Batch("u1")
+	for i := 0; i < 3; i++ {
+		// Saving a diary again during the batch queues it once
+		s.Schedule(newDiary(t, s, "diary1", "u1"))
+	}
+	s.Schedule(newDiary(t, s, "diary2", "u1"))
+	// Other users are not held back
+	s.Schedule(newDiary(t, s, "diary3", "u2"))
+
+	s.mu.Lock()
+	timers, queued := len(s.timers), len(s.batches["u1"].diaryIDs)
+	s.mu.Unlock()
+	if timers != 1 || queued != 2 {
+		t.Fatalf("%d timers and %d queued diaries, want 1 timer and 2 queued", timers, queued)
+	}
+	if !s.Pending("diary1") || !s.Pending("diary3") {
+		t.Error("queued and scheduled diaries are not pending")
+	}
+
+	// Stopped before the batch is released, nothing is enriched and nothing stays pending
+	s.Stop()
+	release()
+	release()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Pending("diary1") || s.Pending("diary2") {
+		if time.Now().After(deadline) {
+			t.Fatal("queued diaries are still pending after Stop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.Pending("diary3") {
+		t.Error("the scheduled diary is still pending after Stop")
+	}
+
+	// Nothing is scheduled after Stop
+	s.Schedule(newDiary(t, s, "diary4", "u2"))
+	if s.Pending("diary4") {
+		t.Error("a diary was scheduled after Stop")
+	}
+}
+
+func TestNestedBatches(t *testing.T) {
+	s := newTestService(t)
+
+	first := s.Batch("u1")
+	second := s.Batch("u1")
+	first()
+	s.Schedule(newDiary(t, s, "diary1", "u1"))
+
+	s.mu.Lock()
+	timers := len(s.timers)
+	s.mu.Unlock()
+	if timers != 0 || !s.Pending("diary1") {
+		t.Fatalf("the diary was not queued while the second batch holds")
+	}
+
+	// Keep the released batch from enriching with the missing chat service
+	s.Stop()
+	second()
+}
+
+func TestStopCancelsWaitingEnrichments(t *testing.T) {
+	s := newTestService(t)
+
+	// Every slot is taken by enrichments of other diaries
+	for i := 0; i < enrichConcurrency; i++ {
+		s.slots <- struct{}{}
+	}
+	returned := make(chan bool)
+	go func() {
+		returned <- s.enrichQueued("diary1")
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	s.Stop()
+	select {
+	case enriched := <-returned:
+		if enriched {
+			t.Error("the diary was enriched after Stop")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("an enrichment waiting for a slot did not return after Stop")
+	}
+}
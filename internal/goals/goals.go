@@ -0,0 +1,212 @@
+package goals
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/models"
+
+	"github.com/songtianlun/diarum/internal/analytics"
+)
+
+// Goal metrics and periods
+const (
+	MetricWords   = "words"
+	MetricChars   = "chars"
+	MetricEntries = "entries"
+
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// MaxPeriods is the most periods a goal's progress is evaluated for
+const MaxPeriods = 52
+
+const dateLayout = "2006-01-02"
+
+// PeriodProgress is how far a goal got in one period
+type PeriodProgress struct {
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Value   int    `json:"value"`
+	Target  int    `json:"target"`
+	Percent int    `json:"percent"`
+	Met     bool   `json:"met"`
+	// Excused periods were spent on vacation up to their end or today
+	Excused bool `json:"excused"`
+}
+
+// Progress is a goal with its progress in the current and previous periods
+type Progress struct {
+	ID      string         `json:"id"`
+	Name    string         `json:"name"`
+	Metric  string         `json:"metric"`
+	Target  int            `json:"target"`
+	Period  string         `json:"period"`
+	Current PeriodProgress `json:"current"`
+	// History holds the previous periods, most recent first
+	History []PeriodProgress `json:"history"`
+	// Streak counts the consecutive periods the goal was met, excused periods are skipped
+	Streak int `json:"streak"`
+}
+
+// Service evaluates writing goals against the cached diary aggregates
+type Service struct {
+	app       *pocketbase.PocketBase
+	analytics *analytics.Service
+}
+
+// NewService creates a new goals service
+func NewService(app *pocketbase.PocketBase, analyticsService *analytics.Service) *Service {
+	return &Service{
+		app:       app,
+		analytics: analyticsService,
+	}
+}
+
+// Progress evaluates the user's goals that are not paused for the period containing
+// today and the periods - 1 before it. Weeks start on Monday.
+func (s *Service) Progress(userID string, today time.Time, periods int) ([]Progress, error) {
+	periods = min(max(periods, 1), MaxPeriods)
+
+	records, err := s.app.Dao().FindRecordsByFilter(
+		"goals",
+		"owner = {:owner} && paused = false",
+		"created",
+		0,
+		0,
+		dbx.Params{"owner": userID},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch goals: %w", err)
+	}
+	progress := make([]Progress, 0, len(records))
+	if len(records) == 0 {
+		return progress, nil
+	}
+
+	day, _ := time.Parse(dateLayout, today.Format(dateLayout))
+	earliest := day
+	for _, record := range records {
+		start, _ := periodBounds(record.GetString("period"), day, periods-1)
+		if start.Before(earliest) {
+			earliest = start
+		}
+	}
+
+	rules, err := s.analytics.StreakRules(userID)
+	if err != nil {
+		return nil, err
+	}
+	days, err := s.analytics.DailyTotals(userID, earliest.Format(dateLayout), day.Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+	totals := make(map[string]analytics.DayStats, len(days))
+	for _, d := range days {
+		totals[d.Date] = d
+	}
+
+	for _, record := range records {
+		progress = append(progress, evaluate(record, day, periods, totals, rules))
+	}
+	return progress, nil
+}
+
+// evaluate computes a goal's progress from the daily totals
+func evaluate(record *models.Record, today time.Time, periods int, totals map[string]analytics.DayStats, rules analytics.StreakRules) Progress {
+	goal := Progress{
+		ID:      record.Id,
+		Name:    record.GetString("name"),
+		Metric:  record.GetString("metric"),
+		Target:  record.GetInt("target"),
+		Period:  record.GetString("period"),
+		History: []PeriodProgress{},
+	}
+
+	counting := true
+	for back := 0; back < periods; back++ {
+		start, end := periodBounds(goal.Period, today, back)
+		p := PeriodProgress{
+			Start:   start.Format(dateLayout),
+			End:     end.Format(dateLayout),
+			Target:  goal.Target,
+			Excused: true,
+		}
+		for d := start; !d.After(end) && !d.After(today); d = d.AddDate(0, 0, 1) {
+			date := d.Format(dateLayout)
+			p.Value += metricValue(totals[date], goal.Metric)
+			if !rules.OnVacation(date) {
+				p.Excused = false
+			}
+		}
+		p.Met = goal.Target > 0 && p.Value >= goal.Target
+		if goal.Target > 0 {
+			p.Percent = min(p.Value*100/goal.Target, 100)
+		}
+
+		// The current period does not end the streak while it is still running
+		switch {
+		case p.Met && counting:
+			goal.Streak++
+		case p.Excused || back == 0:
+		default:
+			counting = false
+		}
+
+		if back == 0 {
+			goal.Current = p
+		} else {
+			goal.History = append(goal.History, p)
+		}
+	}
+	return goal
+}
+
+// metricValue returns the value of a goal metric for a day
+func metricValue(day analytics.DayStats, metric string) int {
+	switch metric {
+	case MetricWords:
+		return day.Words
+	case MetricChars:
+		return day.Chars
+	case MetricEntries:
+		return day.Entries
+	}
+	return 0
+}
+
+// periodBounds returns the first and last day of the period back periods before the one containing day
+func periodBounds(period string, day time.Time, back int) (time.Time, time.Time) {
+	switch period {
+	case PeriodMonth:
+		start := day.AddDate(0, 0, 1-day.Day()).AddDate(0, -back, 0)
+		return start, start.AddDate(0, 1, -1)
+	case PeriodWeek:
+		offset := (int(day.Weekday()) + 6) % 7
+		start := day.AddDate(0, 0, -offset-7*back)
+		return start, start.AddDate(0, 0, 6)
+	}
+	start := day.AddDate(0, 0, -back)
+	return start, start
+}
+
+// ValidateVacation checks that a vacation ends on or after its start
+func ValidateVacation(record *models.Record) error {
+	start, err := time.Parse(dateLayout, record.GetString("start"))
+	if err != nil {
+		return errors.New("start must be a valid YYYY-MM-DD date")
+	}
+	end, err := time.Parse(dateLayout, record.GetString("end"))
+	if err != nil {
+		return errors.New("end must be a valid YYYY-MM-DD date")
+	}
+	if end.Before(start) {
+		return errors.New("end must not be before start")
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Options controls how HTML is converted to Markdown
+type Options struct {
+	// RewriteImage maps an <img> src to the path written into the Markdown.
+	// Returning an empty string keeps the original src.
+	RewriteImage func(src string) string
+}
+
+var (
+	blankLinesRe = regexp.MustCompile(`\n{3,}`)
+	spaceRe      = regexp.MustCompile(`[ \t\r\n\f]+`)
+	// Characters that would otherwise be interpreted as Markdown syntax inline
+	inlineEscaper = strings.NewReplacer(
+		`\`, `\\`,
+		`*`, `\*`,
+		`_`, `\_`,
+		"`", "\\`",
+		`[`, `\[`,
+		`]`, `\]`,
+	)
+	// Line prefixes that start a block construct when found at the beginning of a line
+	blockPrefixRe = regexp.MustCompile(`^(\s*)([#>+\-]|\d+\.)(\s)`)
+)
+
+// FromHTML converts editor HTML (as produced by the Tiptap editor) to Markdown.
+// Content without any HTML tags is treated as plain text and returned unchanged.
+func FromHTML(content string, opts Options) string {
+	if !strings.Contains(content, "<") {
+		return strings.TrimSpace(content) + "\n"
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(content), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return strings.TrimSpace(content) + "\n"
+	}
+
+	c := &converter{opts: opts}
+	var sb strings.Builder
+	for _, n := range nodes {
+		sb.WriteString(c.render(n))
+	}
+
+	return normalize(sb.String()) + "\n"
+}
+
+type converter struct {
+	opts Options
+}
+
+// render converts a node and its children to Markdown
+func (c *converter) render(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		text := spaceRe.ReplaceAllString(n.Data, " ")
+		return inlineEscaper.Replace(text)
+	case html.ElementNode:
+		// handled below
+	default:
+		return ""
+	}
+
+	switch n.DataAtom {
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer:
+		return block(escapeBlockStart(strings.TrimSpace(c.children(n))))
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		return block(strings.Repeat("#", level) + " " + strings.TrimSpace(c.children(n)))
+	case atom.Br:
+		return "  \n"
+	case atom.Hr:
+		return block("---")
+	case atom.Strong, atom.B:
+		return wrapInline(c.children(n), "**")
+	case atom.Em, atom.I:
+		return wrapInline(c.children(n), "*")
+	case atom.S, atom.Del, atom.Strike:
+		return wrapInline(c.children(n), "~~")
+	case atom.Mark:
+		return wrapInline(c.children(n), "==")
+	case atom.U:
+		// Markdown has no underline syntax; keep inline HTML which most renderers accept
+		inner := c.children(n)
+		if strings.TrimSpace(inner) == "" {
+			return inner
+		}
+		return "<u>" + inner + "</u>"
+	case atom.Code:
+		return inlineCode(textContent(n))
+	case atom.A:
+		text := strings.TrimSpace(c.children(n))
+		href := attr(n, "href")
+		if href == "" {
+			return text
+		}
+		if text == "" {
+			text = href
+		}
+		return "[" + text + "](" + escapeURL(href) + ")"
+	case atom.Img:
+		return c.image(n)
+	case atom.Pre:
+		return block(codeBlock(n))
+	case atom.Blockquote:
+		inner := normalize(c.children(n))
+		lines := strings.Split(inner, "\n")
+		for i, line := range lines {
+			if line == "" {
+				lines[i] = ">"
+			} else {
+				lines[i] = "> " + line
+			}
+		}
+		return block(strings.Join(lines, "\n"))
+	case atom.Ul, atom.Ol:
+		return block(c.list(n))
+	case atom.Label, atom.Input:
+		// Task item checkboxes are rendered via the list item marker
+		return ""
+	case atom.Script, atom.Style:
+		return ""
+	}
+
+	return c.children(n)
+}
+
+// children renders all child nodes of n
+func (c *converter) children(n *html.Node) string {
+	var sb strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		sb.WriteString(c.render(child))
+	}
+	return sb.String()
+}
+
+// image renders an <img> element, applying the configured src rewrite
+func (c *converter) image(n *html.Node) string {
+	src := attr(n, "src")
+	if src == "" {
+		return ""
+	}
+	if c.opts.RewriteImage != nil {
+		if rewritten := c.opts.RewriteImage(src); rewritten != "" {
+			src = rewritten
+		}
+	}
+	alt := inlineEscaper.Replace(attr(n, "alt"))
+	return "![" + alt + "](" + escapeURL(src) + ")"
+}
+
+// list renders an ordered, unordered or task list
+func (c *converter) list(n *html.Node) string {
+	ordered := n.DataAtom == atom.Ol
+	isTaskList := attr(n, "data-type") == "taskList"
+
+	var items []string
+	index := 1
+	if start := attr(n, "start"); start != "" {
+		if v, err := strconv.Atoi(start); err == nil && v > 0 {
+			index = v
+		}
+	}
+
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.DataAtom != atom.Li {
+			continue
+		}
+
+		marker := "- "
+		if ordered {
+			marker = strconv.Itoa(index) + ". "
+			index++
+		}
+		if isTaskList || attr(li, "data-type") == "taskItem" {
+			if attr(li, "data-checked") == "true" {
+				marker += "[x] "
+			} else {
+				marker += "[ ] "
+			}
+		}
+
+		// List items are rendered tight: paragraphs inside an item are not separated by blank lines
+		content := normalize(c.children(li))
+		content = blankLinesRe.ReplaceAllString(strings.ReplaceAll(content, "\n\n", "\n"), "\n")
+
+		indent := strings.Repeat(" ", len(marker))
+		if isTaskList {
+			// Nested content under a task item aligns with the text after "- "
+			indent = "  "
+		}
+		lines := strings.Split(content, "\n")
+		for i := 1; i < len(lines); i++ {
+			if lines[i] != "" {
+				lines[i] = indent + lines[i]
+			}
+		}
+		items = append(items, marker+strings.Join(lines, "\n"))
+	}
+
+	return strings.Join(items, "\n")
+}
+
+// codeBlock renders a <pre> element as a fenced code block
+func codeBlock(n *html.Node) string {
+	lang := ""
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.DataAtom == atom.Code {
+			for _, class := range strings.Fields(attr(child, "class")) {
+				if strings.HasPrefix(class, "language-") {
+					lang = strings.TrimPrefix(class, "language-")
+				}
+			}
+		}
+	}
+
+	code := strings.TrimRight(textContent(n), "\n")
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return fence + lang + "\n" + code + "\n" + fence
+}
+
+// block surrounds block-level content with blank lines
+func block(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "\n\n" + s + "\n\n"
+}
+
+// wrapInline wraps inline content with a delimiter, keeping surrounding spaces outside
+func wrapInline(s, delim string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	leading := s[:strings.Index(s, trimmed)]
+	trailing := s[len(leading)+len(trimmed):]
+	return leading + delim + trimmed + delim + trailing
+}
+
+// inlineCode renders text as an inline code span
+func inlineCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		return fence + " " + s + " " + fence
+	}
+	return fence + s + fence
+}
+
+// escapeBlockStart escapes characters that would turn a paragraph into another block type
+func escapeBlockStart(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = blockPrefixRe.ReplaceAllStringFunc(line, func(m string) string {
+			parts := blockPrefixRe.FindStringSubmatch(m)
+			marker := parts[2]
+			if strings.HasSuffix(marker, ".") {
+				return parts[1] + marker[:len(marker)-1] + `\.` + parts[3]
+			}
+			return parts[1] + `\` + marker + parts[3]
+		})
+	}
+	return strings.Join(lines, "\n")
+}
+
+// escapeURL escapes characters that would terminate a Markdown link destination
+func escapeURL(s string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(s)
+}
+
+// normalize collapses runs of blank lines and trims surrounding whitespace
+func normalize(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		// Keep the two-space hard line break marker, drop any other trailing whitespace
+		if strings.HasSuffix(line, "  ") && strings.TrimSpace(line) != "" {
+			lines[i] = strings.TrimRight(line, " ") + "  "
+		} else {
+			lines[i] = strings.TrimRight(line, " \t")
+		}
+	}
+	s = strings.Join(lines, "\n")
+	s = blankLinesRe.ReplaceAllString(s, "\n\n")
+	return strings.Trim(s, "\n ")
+}
+
+// textContent returns the raw text of a node and its descendants
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		sb.WriteString(textContent(child))
+	}
+	return sb.String()
+}
+
+// attr returns the value of an attribute, or "" if missing
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("ai_messages")
+		if err != nil {
+			return err
+		}
+
+		// Allow tool result messages
+		if field := collection.Schema.GetFieldByName("role"); field != nil {
+			if opts, ok := field.Options.(*schema.SelectOptions); ok {
+				opts.Values = []string{"user", "assistant", "tool"}
+			}
+		}
+
+		// Assistant messages that only call tools have no content
+		if field := collection.Schema.GetFieldByName("content"); field != nil {
+			field.Required = false
+		}
+
+		// Tool calls requested by the assistant
+		collection.Schema.AddField(&schema.SchemaField{
+			Name:     "tool_calls",
+			Type:     schema.FieldTypeJson,
+			Required: false,
+			Options:  &schema.JsonOptions{},
+		})
+
+		// ID of the tool call a tool message answers
+		collection.Schema.AddField(&schema.SchemaField{
+			Name:     "tool_call_id",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Options:  &schema.TextOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("ai_messages")
+		if err != nil {
+			return err
+		}
+
+		// Tool messages cannot be represented without the new fields
+		if _, err := db.NewQuery("DELETE FROM ai_messages WHERE role = 'tool' OR content = ''").Execute(); err != nil {
+			return err
+		}
+
+		if field := collection.Schema.GetFieldByName("role"); field != nil {
+			if opts, ok := field.Options.(*schema.SelectOptions); ok {
+				opts.Values = []string{"user", "assistant"}
+			}
+		}
+		if field := collection.Schema.GetFieldByName("content"); field != nil {
+			field.Required = true
+		}
+		for _, name := range []string{"tool_calls", "tool_call_id"} {
+			if field := collection.Schema.GetFieldByName(name); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
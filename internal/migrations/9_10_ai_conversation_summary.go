@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("ai_conversations")
+		if err != nil {
+			return err
+		}
+
+		// Condensed summary of the older messages of a long conversation
+		collection.Schema.AddField(&schema.SchemaField{
+			Name:     "summary",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Options:  &schema.TextOptions{},
+		})
+
+		// Creation time of the last message covered by the summary
+		collection.Schema.AddField(&schema.SchemaField{
+			Name:     "summarized_until",
+			Type:     schema.FieldTypeDate,
+			Required: false,
+			Options:  &schema.DateOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("ai_conversations")
+		if err != nil {
+			return err
+		}
+
+		for _, name := range []string{"summary", "summarized_until"} {
+			if field := collection.Schema.GetFieldByName(name); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
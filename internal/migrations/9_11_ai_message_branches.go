@@ -0,0 +1,129 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		messages, err := dao.FindCollectionByNameOrId("ai_messages")
+		if err != nil {
+			return err
+		}
+
+		// Message this one follows, messages with the same parent are alternative branches
+		messages.Schema.AddField(&schema.SchemaField{
+			Name:     "parent",
+			Type:     schema.FieldTypeRelation,
+			Required: false,
+			Options: &schema.RelationOptions{
+				CollectionId:  messages.Id,
+				CascadeDelete: true,
+				MaxSelect:     types.Pointer(1),
+			},
+		})
+		messages.Indexes = append(messages.Indexes, "CREATE INDEX idx_ai_messages_parent ON ai_messages (parent)")
+
+		if err := dao.SaveCollection(messages); err != nil {
+			return err
+		}
+
+		conversations, err := dao.FindCollectionByNameOrId("ai_conversations")
+		if err != nil {
+			return err
+		}
+
+		// Last message of the branch that is shown and continued
+		conversations.Schema.AddField(&schema.SchemaField{
+			Name:     "active_message",
+			Type:     schema.FieldTypeRelation,
+			Required: false,
+			Options: &schema.RelationOptions{
+				CollectionId:  messages.Id,
+				CascadeDelete: false,
+				MaxSelect:     types.Pointer(1),
+			},
+		})
+
+		if err := dao.SaveCollection(conversations); err != nil {
+			return err
+		}
+
+		// Existing conversations become a single branch in creation order
+		var rows []struct {
+			ID           string `db:"id"`
+			Conversation string `db:"conversation"`
+		}
+		if err := db.NewQuery("SELECT id, conversation FROM ai_messages ORDER BY conversation, created, rowid").All(&rows); err != nil {
+			return err
+		}
+
+		last := make(map[string]string)
+		for _, row := range rows {
+			if prev, ok := last[row.Conversation]; ok {
+				if _, err := db.NewQuery("UPDATE ai_messages SET parent = {:parent} WHERE id = {:id}").
+					Bind(dbx.Params{"parent": prev, "id": row.ID}).Execute(); err != nil {
+					return err
+				}
+			}
+			last[row.Conversation] = row.ID
+		}
+		for conv, leaf := range last {
+			if _, err := db.NewQuery("UPDATE ai_conversations SET active_message = {:leaf} WHERE id = {:id}").
+				Bind(dbx.Params{"leaf": leaf, "id": conv}).Execute(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		// Only the active branches can be kept as linear conversations
+		if _, err := db.NewQuery(`DELETE FROM ai_messages WHERE id NOT IN (
+			WITH RECURSIVE branch(id, parent) AS (
+				SELECT m.id, m.parent FROM ai_messages m JOIN ai_conversations c ON c.active_message = m.id
+				UNION ALL
+				SELECT m.id, m.parent FROM ai_messages m JOIN branch b ON m.id = b.parent
+			)
+			SELECT id FROM branch
+		) AND conversation IN (SELECT id FROM ai_conversations WHERE active_message != '')`).Execute(); err != nil {
+			return err
+		}
+
+		conversations, err := dao.FindCollectionByNameOrId("ai_conversations")
+		if err != nil {
+			return err
+		}
+		if field := conversations.Schema.GetFieldByName("active_message"); field != nil {
+			conversations.Schema.RemoveField(field.Id)
+		}
+		if err := dao.SaveCollection(conversations); err != nil {
+			return err
+		}
+
+		messages, err := dao.FindCollectionByNameOrId("ai_messages")
+		if err != nil {
+			return err
+		}
+
+		if field := messages.Schema.GetFieldByName("parent"); field != nil {
+			messages.Schema.RemoveField(field.Id)
+		}
+		indexes := make(types.JsonArray[string], 0, len(messages.Indexes))
+		for _, index := range messages.Indexes {
+			if index != "CREATE INDEX idx_ai_messages_parent ON ai_messages (parent)" {
+				indexes = append(indexes, index)
+			}
+		}
+		messages.Indexes = indexes
+
+		return dao.SaveCollection(messages)
+	})
+}
@@ -0,0 +1,124 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		diaries, err := dao.FindCollectionByNameOrId("diaries")
+		if err != nil {
+			return err
+		}
+
+		// Digests are written by the server only, users can read and delete theirs
+		collection := &models.Collection{
+			Name:       "digests",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			ViewRule:   types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			CreateRule: nil,
+			UpdateRule: nil,
+			DeleteRule: types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "owner",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  "_pb_users_auth_",
+						CascadeDelete: true,
+						MinSelect:     nil,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "period",
+					Type:     schema.FieldTypeSelect,
+					Required: true,
+					Options: &schema.SelectOptions{
+						MaxSelect: 1,
+						Values:    []string{"week", "month"},
+					},
+				},
+				// First and last day of the period, YYYY-MM-DD
+				&schema.SchemaField{
+					Name:     "period_start",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options:  &schema.TextOptions{Max: types.Pointer(10)},
+				},
+				&schema.SchemaField{
+					Name:     "period_end",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options:  &schema.TextOptions{Max: types.Pointer(10)},
+				},
+				&schema.SchemaField{
+					Name:     "summary",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "themes",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{},
+				},
+				// Number of diaries per mood
+				&schema.SchemaField{
+					Name:     "moods",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "mood_trend",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+				// Diaries the digest was written from
+				&schema.SchemaField{
+					Name:     "diaries",
+					Type:     schema.FieldTypeRelation,
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId:  diaries.Id,
+						CascadeDelete: false,
+						MinSelect:     nil,
+						MaxSelect:     nil,
+					},
+				},
+				&schema.SchemaField{
+					Name:     "emailed",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+			),
+		}
+
+		collection.Indexes = types.JsonArray[string]{
+			"CREATE UNIQUE INDEX idx_digests_period ON digests (owner, period, period_start)",
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("digests")
+		if err != nil {
+			return nil
+		}
+		return dao.DeleteCollection(collection)
+	})
+}
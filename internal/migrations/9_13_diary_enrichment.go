@@ -0,0 +1,85 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("diaries")
+		if err != nil {
+			return err
+		}
+
+		// AI suggestions never replace the user's own mood and tags,
+		// which change only when a suggestion is accepted
+		collection.Schema.AddField(&schema.SchemaField{
+			Name:     "ai_mood",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Options: &schema.TextOptions{
+				Min: nil,
+				Max: types.Pointer(50),
+			},
+		})
+
+		// -1 (very negative) to 1 (very positive)
+		collection.Schema.AddField(&schema.SchemaField{
+			Name:     "ai_sentiment",
+			Type:     schema.FieldTypeNumber,
+			Required: false,
+			Options: &schema.NumberOptions{
+				Min: types.Pointer(-1.0),
+				Max: types.Pointer(1.0),
+			},
+		})
+
+		// Suggested topics, people and places, lists of names not yet accepted as tags
+		for _, name := range []string{"ai_topics", "ai_people", "ai_places"} {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     name,
+				Type:     schema.FieldTypeJson,
+				Required: false,
+				Options:  &schema.JsonOptions{},
+			})
+		}
+
+		collection.Schema.AddField(&schema.SchemaField{
+			Name:     "ai_enriched_at",
+			Type:     schema.FieldTypeDate,
+			Required: false,
+			Options:  &schema.DateOptions{},
+		})
+
+		// Hash of the content the suggestions were made for, unchanged diaries are not enriched again
+		collection.Schema.AddField(&schema.SchemaField{
+			Name:     "ai_content_hash",
+			Type:     schema.FieldTypeText,
+			Required: false,
+			Options:  &schema.TextOptions{},
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("diaries")
+		if err != nil {
+			return err
+		}
+
+		for _, name := range []string{"ai_mood", "ai_sentiment", "ai_topics", "ai_people", "ai_places", "ai_enriched_at", "ai_content_hash"} {
+			if field := collection.Schema.GetFieldByName(name); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,116 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		diaries, err := dao.FindCollectionByNameOrId("diaries")
+		if err != nil {
+			return err
+		}
+
+		// Cached per-diary aggregates for writing analytics, maintained by the server only
+		collection := &models.Collection{
+			Name:       "diary_stats",
+			Type:       models.CollectionTypeBase,
+			ListRule:   nil,
+			ViewRule:   nil,
+			CreateRule: nil,
+			UpdateRule: nil,
+			DeleteRule: nil,
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "owner",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  "_pb_users_auth_",
+						CascadeDelete: true,
+						MinSelect:     nil,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "diary",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  diaries.Id,
+						CascadeDelete: true,
+						MinSelect:     nil,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				// Diary date, YYYY-MM-DD
+				&schema.SchemaField{
+					Name:     "date",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options:  &schema.TextOptions{Max: types.Pointer(10)},
+				},
+				&schema.SchemaField{
+					Name:     "words",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{NoDecimal: true},
+				},
+				&schema.SchemaField{
+					Name:     "chars",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{NoDecimal: true},
+				},
+				&schema.SchemaField{
+					Name:     "mood",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "weather",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+				// When the diary was first written
+				&schema.SchemaField{
+					Name:     "written_at",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+				// The diary's updated time the aggregates were computed for
+				&schema.SchemaField{
+					Name:     "diary_updated",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+			),
+		}
+
+		collection.Indexes = types.JsonArray[string]{
+			"CREATE UNIQUE INDEX idx_diary_stats_diary ON diary_stats (diary)",
+			"CREATE INDEX idx_diary_stats_owner_date ON diary_stats (owner, date)",
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("diary_stats")
+		if err != nil {
+			return nil
+		}
+		return dao.DeleteCollection(collection)
+	})
+}
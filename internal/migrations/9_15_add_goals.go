@@ -0,0 +1,136 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		ownerField := func() *schema.SchemaField {
+			return &schema.SchemaField{
+				Name:     "owner",
+				Type:     schema.FieldTypeRelation,
+				Required: true,
+				Options: &schema.RelationOptions{
+					CollectionId:  "_pb_users_auth_",
+					CascadeDelete: true,
+					MinSelect:     nil,
+					MaxSelect:     types.Pointer(1),
+				},
+			}
+		}
+
+		// Writing goals such as 500 words a day or 5 entries a week
+		goals := &models.Collection{
+			Name:       "goals",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			ViewRule:   types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			CreateRule: types.Pointer("@request.auth.id != \"\" && @request.data.owner = @request.auth.id"),
+			UpdateRule: types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id && (@request.data.owner:isset = false || @request.data.owner = @request.auth.id)"),
+			DeleteRule: types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			Schema: schema.NewSchema(
+				ownerField(),
+				&schema.SchemaField{
+					Name:     "name",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{Max: types.Pointer(100)},
+				},
+				&schema.SchemaField{
+					Name:     "metric",
+					Type:     schema.FieldTypeSelect,
+					Required: true,
+					Options: &schema.SelectOptions{
+						MaxSelect: 1,
+						Values:    []string{"words", "chars", "entries"},
+					},
+				},
+				&schema.SchemaField{
+					Name:     "target",
+					Type:     schema.FieldTypeNumber,
+					Required: true,
+					Options: &schema.NumberOptions{
+						Min:       types.Pointer(1.0),
+						NoDecimal: true,
+					},
+				},
+				&schema.SchemaField{
+					Name:     "period",
+					Type:     schema.FieldTypeSelect,
+					Required: true,
+					Options: &schema.SelectOptions{
+						MaxSelect: 1,
+						Values:    []string{"day", "week", "month"},
+					},
+				},
+				&schema.SchemaField{
+					Name:     "paused",
+					Type:     schema.FieldTypeBool,
+					Required: false,
+					Options:  &schema.BoolOptions{},
+				},
+			),
+		}
+		if err := dao.SaveCollection(goals); err != nil {
+			return err
+		}
+
+		// Planned breaks that neither count towards nor break writing streaks
+		vacations := &models.Collection{
+			Name:       "vacations",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			ViewRule:   types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			CreateRule: types.Pointer("@request.auth.id != \"\" && @request.data.owner = @request.auth.id"),
+			UpdateRule: types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id && (@request.data.owner:isset = false || @request.data.owner = @request.auth.id)"),
+			DeleteRule: types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			Schema: schema.NewSchema(
+				ownerField(),
+				// First and last day of the vacation, YYYY-MM-DD
+				&schema.SchemaField{
+					Name:     "start",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options:  &schema.TextOptions{Pattern: `^\d{4}-\d{2}-\d{2}$`},
+				},
+				&schema.SchemaField{
+					Name:     "end",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options:  &schema.TextOptions{Pattern: `^\d{4}-\d{2}-\d{2}$`},
+				},
+				&schema.SchemaField{
+					Name:     "note",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{Max: types.Pointer(200)},
+				},
+			),
+		}
+		vacations.Indexes = types.JsonArray[string]{
+			"CREATE INDEX idx_vacations_owner ON vacations (owner)",
+		}
+		return dao.SaveCollection(vacations)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		for _, name := range []string{"vacations", "goals"} {
+			collection, err := dao.FindCollectionByNameOrId(name)
+			if err != nil {
+				continue
+			}
+			if err := dao.DeleteCollection(collection); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
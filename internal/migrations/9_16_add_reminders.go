@@ -0,0 +1,114 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		// Reminders to write, sent on a cron schedule in the user's time zone
+		collection := &models.Collection{
+			Name:       "reminders",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			ViewRule:   types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			CreateRule: types.Pointer("@request.auth.id != \"\" && @request.data.owner = @request.auth.id"),
+			UpdateRule: types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id && (@request.data.owner:isset = false || @request.data.owner = @request.auth.id)"),
+			DeleteRule: types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "owner",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  "_pb_users_auth_",
+						CascadeDelete: true,
+						MinSelect:     nil,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "name",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{Max: types.Pointer(100)},
+				},
+				// Five-field cron expression, e.g. "0 21 * * *"
+				&schema.SchemaField{
+					Name:     "cron",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options:  &schema.TextOptions{Max: types.Pointer(100)},
+				},
+				&schema.SchemaField{
+					Name:     "channel",
+					Type:     schema.FieldTypeSelect,
+					Required: true,
+					Options: &schema.SelectOptions{
+						MaxSelect: 1,
+						Values:    []string{"email", "webhook", "ntfy", "gotify"},
+					},
+				},
+				// Webhook URL, ntfy topic URL or Gotify server URL
+				&schema.SchemaField{
+					Name:     "url",
+					Type:     schema.FieldTypeUrl,
+					Required: false,
+					Options:  &schema.UrlOptions{},
+				},
+				// Gotify application token, ntfy access token or webhook bearer token
+				&schema.SchemaField{
+					Name:     "token",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{Max: types.Pointer(500)},
+				},
+				&schema.SchemaField{
+					Name:     "message",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{Max: types.Pointer(500)},
+				},
+				&schema.SchemaField{
+					Name:     "paused",
+					Type:     schema.FieldTypeBool,
+					Required: false,
+					Options:  &schema.BoolOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "last_sent",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "last_error",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+			),
+		}
+
+		collection.Indexes = types.JsonArray[string]{
+			"CREATE INDEX idx_reminders_owner ON reminders (owner)",
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("reminders")
+		if err != nil {
+			return nil
+		}
+		return dao.DeleteCollection(collection)
+	})
+}
@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		// Diary templates with {{variable}} placeholders, next to the built-in ones
+		collection := &models.Collection{
+			Name:       "templates",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			ViewRule:   types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			CreateRule: types.Pointer("@request.auth.id != \"\" && @request.data.owner = @request.auth.id"),
+			UpdateRule: types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id && (@request.data.owner:isset = false || @request.data.owner = @request.auth.id)"),
+			DeleteRule: types.Pointer("@request.auth.id != \"\" && owner = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "owner",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  "_pb_users_auth_",
+						CascadeDelete: true,
+						MinSelect:     nil,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "name",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options:  &schema.TextOptions{Max: types.Pointer(100)},
+				},
+				&schema.SchemaField{
+					Name:     "content",
+					Type:     schema.FieldTypeEditor,
+					Required: false,
+					Options:  &schema.EditorOptions{},
+				},
+			),
+		}
+
+		collection.Indexes = types.JsonArray[string]{
+			"CREATE INDEX idx_templates_owner ON templates (owner)",
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("templates")
+		if err != nil {
+			return nil
+		}
+		return dao.DeleteCollection(collection)
+	})
+}
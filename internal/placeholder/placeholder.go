@@ -0,0 +1,31 @@
+package placeholder
+
+import "regexp"
+
+// pattern matches {{variable}} placeholders, spaces inside the braces are allowed
+var pattern = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// Render replaces the {{variable}} placeholders of a template with their values passed
+// through escape, which may be nil. Unknown placeholders are kept.
+func Render(template string, vars map[string]string, escape func(string) string) string {
+	return pattern.ReplaceAllStringFunc(template, func(match string) string {
+		value, ok := vars[pattern.FindStringSubmatch(match)[1]]
+		if !ok {
+			return match
+		}
+		if escape != nil {
+			return escape(value)
+		}
+		return value
+	})
+}
+
+// Uses reports whether a template has a placeholder of a variable
+func Uses(template, name string) bool {
+	for _, match := range pattern.FindAllStringSubmatch(template, -1) {
+		if match[1] == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package publish
+
+import (
+	"archive/zip"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// WriteEPUB writes the book as an EPUB 3 file with one chapter per month
+// and a navigation document listing every month and entry.
+func WriteEPUB(w io.Writer, b Book) error {
+	zw := zip.NewWriter(w)
+	months := groupByMonth(b.Entries)
+	lang := b.Language
+	if lang == "" {
+		lang = "en"
+	}
+
+	// The mimetype file must come first and be stored uncompressed
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return fmt.Errorf("failed to create mimetype: %w", err)
+	}
+	if _, err := mw.Write([]byte("application/epub+zip")); err != nil {
+		return fmt.Errorf("failed to write mimetype: %w", err)
+	}
+
+	if err := writeZipFile(zw, "META-INF/container.xml", []byte(epubContainer)); err != nil {
+		return err
+	}
+	if err := writeZipFile(zw, "OEBPS/content.opf", []byte(epubPackage(b, lang, months))); err != nil {
+		return err
+	}
+	if err := writeZipFile(zw, "OEBPS/nav.xhtml", []byte(epubNav(b, lang, months))); err != nil {
+		return err
+	}
+	if err := writeZipFile(zw, "OEBPS/style.css", []byte(stylesheet)); err != nil {
+		return err
+	}
+
+	rewrite := b.imageRewriter("../media/")
+	for _, m := range months {
+		if err := writeZipFile(zw, "OEBPS/text/"+m.Key+".xhtml", []byte(epubChapter(lang, m, rewrite))); err != nil {
+			return err
+		}
+	}
+
+	for _, img := range b.Images {
+		if err := writeZipFile(zw, "OEBPS/media/"+img.File, img.Data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// epubPackage renders the OPF package document
+func epubPackage(b Book, lang string, months []month) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">` + "\n")
+	sb.WriteString(`<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	sb.WriteString(`<dc:identifier id="book-id">` + html.EscapeString(b.ID) + "</dc:identifier>\n")
+	sb.WriteString("<dc:title>" + html.EscapeString(b.Title) + "</dc:title>\n")
+	sb.WriteString("<dc:language>" + html.EscapeString(lang) + "</dc:language>\n")
+	if b.Author != "" {
+		sb.WriteString("<dc:creator>" + html.EscapeString(b.Author) + "</dc:creator>\n")
+	}
+	sb.WriteString(`<meta property="dcterms:modified">` + time.Now().UTC().Format("2006-01-02T15:04:05Z") + "</meta>\n")
+	sb.WriteString("</metadata>\n<manifest>\n")
+	sb.WriteString(`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+	sb.WriteString(`<item id="css" href="style.css" media-type="text/css"/>` + "\n")
+	for _, m := range months {
+		sb.WriteString(fmt.Sprintf(`<item id="ch-%s" href="text/%s.xhtml" media-type="application/xhtml+xml"/>`+"\n", m.Key, m.Key))
+	}
+	for i, img := range b.Images {
+		sb.WriteString(fmt.Sprintf(`<item id="img-%d" href="media/%s" media-type="%s"/>`+"\n",
+			i+1, html.EscapeString(img.File), html.EscapeString(img.MediaType)))
+	}
+	sb.WriteString("</manifest>\n<spine>\n")
+	sb.WriteString(`<itemref idref="nav"/>` + "\n")
+	for _, m := range months {
+		sb.WriteString(`<itemref idref="ch-` + m.Key + `"/>` + "\n")
+	}
+	sb.WriteString("</spine>\n</package>\n")
+	return sb.String()
+}
+
+// epubNav renders the navigation document (table of contents)
+func epubNav(b Book, lang string, months []month) string {
+	var sb strings.Builder
+	writeXHTMLHeader(&sb, lang, b.Title, "style.css")
+	sb.WriteString("<h1>" + html.EscapeString(b.Title) + "</h1>\n")
+	if b.Author != "" {
+		sb.WriteString(`<p class="meta">` + html.EscapeString(b.Author) + "</p>\n")
+	}
+	sb.WriteString(`<nav epub:type="toc" id="toc">` + "\n<h2>Contents</h2>\n<ol>\n")
+	for _, m := range months {
+		sb.WriteString(`<li><a href="text/` + m.Key + `.xhtml">` + html.EscapeString(m.Title()) + "</a>\n<ol>\n")
+		for _, e := range m.Entries {
+			sb.WriteString(`<li><a href="text/` + m.Key + `.xhtml#d-` + e.Date + `">` + html.EscapeString(entryHeading(e.Date)) + "</a></li>\n")
+		}
+		sb.WriteString("</ol>\n</li>\n")
+	}
+	sb.WriteString("</ol>\n</nav>\n</body>\n</html>\n")
+	return sb.String()
+}
+
+// epubChapter renders one month as an XHTML chapter
+func epubChapter(lang string, m month, rewrite func(string) string) string {
+	var sb strings.Builder
+	writeXHTMLHeader(&sb, lang, m.Title(), "../style.css")
+	sb.WriteString("<section>\n<h1>" + html.EscapeString(m.Title()) + "</h1>\n")
+	for _, e := range m.Entries {
+		writeEntry(&sb, e, rewrite)
+	}
+	sb.WriteString("</section>\n</body>\n</html>\n")
+	return sb.String()
+}
+
+// writeXHTMLHeader writes the XHTML document head used by EPUB content documents
+func writeXHTMLHeader(sb *strings.Builder, lang, title, stylesheetHref string) {
+	lang = html.EscapeString(lang)
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n<!DOCTYPE html>\n")
+	sb.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="` + lang + `" xml:lang="` + lang + `">` + "\n<head>\n")
+	sb.WriteString(`<meta charset="utf-8"/>` + "\n")
+	sb.WriteString("<title>" + html.EscapeString(title) + "</title>\n")
+	sb.WriteString(`<link rel="stylesheet" type="text/css" href="` + stylesheetHref + `"/>` + "\n</head>\n<body>\n")
+}
@@ -0,0 +1,106 @@
+package publish
+
+import (
+	"sort"
+	"time"
+)
+
+// Entry is a single diary entry to be published
+type Entry struct {
+	ID      string
+	Date    string // YYYY-MM-DD
+	Content string // editor HTML
+	Mood    string
+	Weather string
+}
+
+// Image is a media file bundled with the publication
+type Image struct {
+	File      string // file name inside the media/ directory
+	Data      []byte
+	MediaType string
+}
+
+// Book describes a date range of diaries rendered as a static site or EPUB
+type Book struct {
+	ID       string
+	Title    string
+	Author   string
+	Language string
+	Entries  []Entry
+	Images   []Image
+	// ImageFile maps an <img> src found in entry content to a file name in Images.
+	// Returning an empty string keeps the original src.
+	ImageFile func(src string) string
+}
+
+// month groups the entries written in one calendar month
+type month struct {
+	Key     string // YYYY-MM
+	Time    time.Time
+	Entries []Entry
+}
+
+// Title returns the display title of the month, e.g. "March 2026"
+func (m month) Title() string {
+	return m.Time.Format("January 2006")
+}
+
+// groupByMonth sorts entries by date and groups them per month
+func groupByMonth(entries []Entry) []month {
+	sorted := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if len(e.Date) >= 10 {
+			sorted = append(sorted, e)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Date < sorted[j].Date })
+
+	var months []month
+	for _, e := range sorted {
+		key := e.Date[:7]
+		if len(months) == 0 || months[len(months)-1].Key != key {
+			t, err := time.Parse("2006-01", key)
+			if err != nil {
+				continue
+			}
+			months = append(months, month{Key: key, Time: t})
+		}
+		months[len(months)-1].Entries = append(months[len(months)-1].Entries, e)
+	}
+	return months
+}
+
+// entryHeading returns the heading shown above an entry, e.g. "2026-03-14 Saturday"
+func entryHeading(date string) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	return date + " " + t.Weekday().String()
+}
+
+// entryMeta returns the mood/weather line shown below an entry heading
+func entryMeta(e Entry) string {
+	switch {
+	case e.Mood != "" && e.Weather != "":
+		return e.Mood + " · " + e.Weather
+	case e.Mood != "":
+		return e.Mood
+	default:
+		return e.Weather
+	}
+}
+
+// imageRewriter returns a src rewrite function that points bundled images at prefix
+func (b Book) imageRewriter(prefix string) func(string) string {
+	return func(src string) string {
+		if b.ImageFile == nil {
+			return ""
+		}
+		if file := b.ImageFile(src); file != "" {
+			return prefix + file
+		}
+		return ""
+	}
+}
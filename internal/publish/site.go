@@ -0,0 +1,183 @@
+package publish
+
+import (
+	"archive/zip"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+// stylesheet is shared by the static site and the EPUB
+const stylesheet = `body { font-family: Georgia, "Times New Roman", serif; line-height: 1.6; max-width: 46em; margin: 0 auto; padding: 1em; color: #222; }
+h1, h2, h3 { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; }
+article { margin-bottom: 3em; }
+.meta { color: #777; font-style: italic; margin-top: -0.5em; }
+img { max-width: 100%; height: auto; }
+blockquote { border-left: 3px solid #ccc; margin-left: 0; padding-left: 1em; color: #555; }
+pre { background: #f5f5f5; padding: 0.75em; overflow-x: auto; }
+nav.pager { display: flex; justify-content: space-between; margin: 2em 0; }
+table.calendar { border-collapse: collapse; margin: 0 1.5em 1.5em 0; display: inline-table; vertical-align: top; }
+table.calendar caption { font-weight: bold; padding-bottom: 0.3em; }
+table.calendar th, table.calendar td { width: 2em; height: 2em; text-align: center; font-size: 0.9em; }
+table.calendar td.has-entry a { display: block; background: #4a7bd0; color: #fff; border-radius: 50%; text-decoration: none; }
+`
+
+// WriteSite writes the book as a ZIP archive containing a static HTML site:
+// an index page with one calendar per month, a page per month and the bundled images.
+func WriteSite(w io.Writer, b Book) error {
+	zw := zip.NewWriter(w)
+	months := groupByMonth(b.Entries)
+
+	if err := writeZipFile(zw, "style.css", []byte(stylesheet)); err != nil {
+		return err
+	}
+	if err := writeZipFile(zw, "index.html", []byte(siteIndex(b, months))); err != nil {
+		return err
+	}
+
+	rewrite := b.imageRewriter("media/")
+	for i, m := range months {
+		var prev, next *month
+		if i > 0 {
+			prev = &months[i-1]
+		}
+		if i < len(months)-1 {
+			next = &months[i+1]
+		}
+		page := siteMonthPage(b, m, prev, next, rewrite)
+		if err := writeZipFile(zw, m.Key+".html", []byte(page)); err != nil {
+			return err
+		}
+	}
+
+	for _, img := range b.Images {
+		if err := writeZipFile(zw, "media/"+img.File, img.Data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// siteIndex renders the calendar index page
+func siteIndex(b Book, months []month) string {
+	var sb strings.Builder
+	writeSiteHeader(&sb, b.Language, b.Title)
+	sb.WriteString("<h1>" + html.EscapeString(b.Title) + "</h1>\n")
+	if b.Author != "" {
+		sb.WriteString(`<p class="meta">` + html.EscapeString(b.Author) + "</p>\n")
+	}
+	if len(months) == 0 {
+		sb.WriteString("<p>No diary entries in this range.</p>\n")
+	}
+
+	year := 0
+	for _, m := range months {
+		if m.Time.Year() != year {
+			year = m.Time.Year()
+			sb.WriteString(fmt.Sprintf("<h2>%d</h2>\n", year))
+		}
+		writeCalendar(&sb, m)
+	}
+
+	sb.WriteString("</body>\n</html>\n")
+	return sb.String()
+}
+
+// siteMonthPage renders all entries of one month
+func siteMonthPage(b Book, m month, prev, next *month, rewrite func(string) string) string {
+	var sb strings.Builder
+	writeSiteHeader(&sb, b.Language, m.Title()+" - "+b.Title)
+	sb.WriteString(`<p><a href="index.html">` + html.EscapeString(b.Title) + "</a></p>\n")
+	sb.WriteString("<h1>" + html.EscapeString(m.Title()) + "</h1>\n")
+
+	for _, e := range m.Entries {
+		writeEntry(&sb, e, rewrite)
+	}
+
+	sb.WriteString(`<nav class="pager">`)
+	if prev != nil {
+		sb.WriteString(`<a href="` + prev.Key + `.html">&larr; ` + html.EscapeString(prev.Title()) + "</a>")
+	} else {
+		sb.WriteString("<span></span>")
+	}
+	if next != nil {
+		sb.WriteString(`<a href="` + next.Key + `.html">` + html.EscapeString(next.Title()) + " &rarr;</a>")
+	}
+	sb.WriteString("</nav>\n</body>\n</html>\n")
+	return sb.String()
+}
+
+// writeSiteHeader writes the HTML document head
+func writeSiteHeader(sb *strings.Builder, lang, title string) {
+	if lang == "" {
+		lang = "en"
+	}
+	sb.WriteString("<!DOCTYPE html>\n")
+	sb.WriteString(`<html lang="` + html.EscapeString(lang) + `">` + "\n<head>\n")
+	sb.WriteString(`<meta charset="utf-8" />` + "\n")
+	sb.WriteString(`<meta name="viewport" content="width=device-width, initial-scale=1" />` + "\n")
+	sb.WriteString("<title>" + html.EscapeString(title) + "</title>\n")
+	sb.WriteString(`<link rel="stylesheet" href="style.css" />` + "\n</head>\n<body>\n")
+}
+
+// writeEntry renders a single diary entry as an <article>
+func writeEntry(sb *strings.Builder, e Entry, rewrite func(string) string) {
+	sb.WriteString(`<article id="d-` + e.Date + `">` + "\n")
+	sb.WriteString("<h2>" + html.EscapeString(entryHeading(e.Date)) + "</h2>\n")
+	if meta := entryMeta(e); meta != "" {
+		sb.WriteString(`<p class="meta">` + html.EscapeString(meta) + "</p>\n")
+	}
+	sb.WriteString(renderContent(e.Content, rewrite))
+	sb.WriteString("\n</article>\n")
+}
+
+// writeCalendar renders a month calendar linking days with entries to the month page
+func writeCalendar(sb *strings.Builder, m month) {
+	hasEntry := make(map[int]bool, len(m.Entries))
+	for _, e := range m.Entries {
+		if t, err := time.Parse("2006-01-02", e.Date); err == nil {
+			hasEntry[t.Day()] = true
+		}
+	}
+
+	sb.WriteString(`<table class="calendar">` + "\n")
+	sb.WriteString(`<caption><a href="` + m.Key + `.html">` + html.EscapeString(m.Title()) + "</a></caption>\n")
+	sb.WriteString("<tr><th>Mo</th><th>Tu</th><th>We</th><th>Th</th><th>Fr</th><th>Sa</th><th>Su</th></tr>\n<tr>")
+
+	// Monday-first offset of the first day of the month
+	offset := (int(m.Time.Weekday()) + 6) % 7
+	for i := 0; i < offset; i++ {
+		sb.WriteString("<td></td>")
+	}
+	daysInMonth := m.Time.AddDate(0, 1, -1).Day()
+	for day := 1; day <= daysInMonth; day++ {
+		if (offset+day-1)%7 == 0 && day != 1 {
+			sb.WriteString("</tr>\n<tr>")
+		}
+		if hasEntry[day] {
+			date := fmt.Sprintf("%s-%02d", m.Key, day)
+			sb.WriteString(fmt.Sprintf(`<td class="has-entry"><a href="%s.html#d-%s">%d</a></td>`, m.Key, date, day))
+		} else {
+			sb.WriteString(fmt.Sprintf("<td>%d</td>", day))
+		}
+	}
+	for i := (offset + daysInMonth) % 7; i != 0 && i < 7; i++ {
+		sb.WriteString("<td></td>")
+	}
+	sb.WriteString("</tr>\n</table>\n")
+}
+
+// writeZipFile adds a deflated file to the archive
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
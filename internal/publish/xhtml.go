@@ -0,0 +1,147 @@
+package publish
+
+import (
+	"html"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// droppedElements are removed together with their content
+var droppedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Form:     true,
+	atom.Noscript: true,
+}
+
+// voidElements have no content and are self-closed in XHTML
+var voidElements = map[atom.Atom]bool{
+	atom.Br:    true,
+	atom.Hr:    true,
+	atom.Img:   true,
+	atom.Input: true,
+	atom.Wbr:   true,
+}
+
+// allowedAttrs lists attributes kept on rendered elements
+var allowedAttrs = map[string]bool{
+	"href":    true,
+	"src":     true,
+	"alt":     true,
+	"title":   true,
+	"class":   true,
+	"start":   true,
+	"type":    true,
+	"checked": true,
+	"colspan": true,
+	"rowspan": true,
+}
+
+// renderContent converts editor HTML into sanitized, well-formed XHTML.
+// Scripts, event handlers and embedded objects are stripped; image sources
+// are passed through rewriteImage.
+func renderContent(content string, rewriteImage func(string) string) string {
+	if !strings.Contains(content, "<") {
+		// Plain text: keep line breaks as paragraphs
+		var sb strings.Builder
+		for _, para := range strings.Split(strings.TrimSpace(content), "\n\n") {
+			if strings.TrimSpace(para) == "" {
+				continue
+			}
+			lines := strings.Split(para, "\n")
+			for i, line := range lines {
+				lines[i] = html.EscapeString(line)
+			}
+			sb.WriteString("<p>" + strings.Join(lines, "<br />") + "</p>\n")
+		}
+		return sb.String()
+	}
+
+	nodes, err := nethtml.ParseFragment(strings.NewReader(content), &nethtml.Node{
+		Type:     nethtml.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return "<p>" + html.EscapeString(content) + "</p>"
+	}
+
+	var sb strings.Builder
+	for _, n := range nodes {
+		writeNode(&sb, n, rewriteImage)
+	}
+	return sb.String()
+}
+
+func writeNode(sb *strings.Builder, n *nethtml.Node, rewriteImage func(string) string) {
+	switch n.Type {
+	case nethtml.TextNode:
+		sb.WriteString(html.EscapeString(n.Data))
+		return
+	case nethtml.ElementNode:
+		// handled below
+	default:
+		return
+	}
+
+	if droppedElements[n.DataAtom] || n.DataAtom == 0 {
+		// Unknown elements (DataAtom 0) are unwrapped, dropped ones skipped entirely
+		if n.DataAtom == 0 {
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				writeNode(sb, child, rewriteImage)
+			}
+		}
+		return
+	}
+
+	sb.WriteString("<" + n.Data)
+	for _, a := range n.Attr {
+		if !allowedAttrs[a.Key] || a.Namespace != "" {
+			continue
+		}
+		val := a.Val
+		switch a.Key {
+		case "href":
+			if isUnsafeURL(val) {
+				continue
+			}
+		case "src":
+			if isUnsafeURL(val) {
+				continue
+			}
+			if n.DataAtom == atom.Img && rewriteImage != nil {
+				if rewritten := rewriteImage(val); rewritten != "" {
+					val = rewritten
+				}
+			}
+		case "checked":
+			val = "checked"
+		}
+		sb.WriteString(" " + a.Key + `="` + html.EscapeString(val) + `"`)
+	}
+	if n.DataAtom == atom.Input {
+		// Task list checkboxes are read-only in a publication
+		sb.WriteString(` disabled="disabled"`)
+	}
+
+	if voidElements[n.DataAtom] {
+		sb.WriteString(" />")
+		return
+	}
+	sb.WriteString(">")
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		writeNode(sb, child, rewriteImage)
+	}
+	sb.WriteString("</" + n.Data + ">")
+}
+
+// isUnsafeURL reports whether a URL uses a scheme that can execute code
+func isUnsafeURL(u string) bool {
+	lower := strings.ToLower(strings.TrimSpace(u))
+	return strings.HasPrefix(lower, "javascript:") || strings.HasPrefix(lower, "vbscript:")
+}
@@ -0,0 +1,180 @@
+package reminder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+
+	"github.com/songtianlun/diarum/internal/email"
+)
+
+// Delivery channels
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelNtfy    = "ntfy"
+	ChannelGotify  = "gotify"
+)
+
+// sendTimeout bounds the delivery of one notification
+const sendTimeout = 15 * time.Second
+
+// Notification is a reminder to write the diary of Date
+type Notification struct {
+	ReminderID string `json:"reminder_id"`
+	Title      string `json:"title"`
+	Message    string `json:"message"`
+	Date       string `json:"date"`
+	// URL opens the diary of Date, empty without an app URL
+	URL string `json:"url,omitempty"`
+}
+
+// Channel delivers notifications to the target configured on a reminder record
+type Channel interface {
+	Send(ctx context.Context, reminder *models.Record, n Notification) error
+}
+
+// emailChannel mails the reminder's owner
+type emailChannel struct {
+	app  *pocketbase.PocketBase
+	smtp email.SMTPConfig
+}
+
+func (c *emailChannel) Send(ctx context.Context, reminder *models.Record, n Notification) error {
+	to, err := email.UserAddress(c.app, reminder.GetString("owner"))
+	if err != nil {
+		return err
+	}
+	client, from, err := email.Client(c.app, c.smtp)
+	if err != nil {
+		return err
+	}
+
+	text := n.Message + "\n"
+	body := "<p>" + html.EscapeString(n.Message) + "</p>\n"
+	if n.URL != "" {
+		text += "\n" + n.URL + "\n"
+		body += fmt.Sprintf("<p><a href=\"%s\">Write today's diary</a></p>\n", html.EscapeString(n.URL))
+	}
+	return client.Send(&mailer.Message{
+		From:    from,
+		To:      []mail.Address{to},
+		Subject: n.Title,
+		HTML:    body,
+		Text:    text,
+	})
+}
+
+// webhookChannel posts the notification as JSON
+type webhookChannel struct {
+	client *http.Client
+}
+
+func (c webhookChannel) Send(ctx context.Context, reminder *models.Record, n Notification) error {
+	body, err := json.Marshal(map[string]any{
+		"event":       "reminder",
+		"reminder_id": n.ReminderID,
+		"title":       n.Title,
+		"message":     n.Message,
+		"date":        n.Date,
+		"url":         n.URL,
+	})
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"Content-Type": "application/json"}
+	if token := reminder.GetString("token"); token != "" {
+		headers["Authorization"] = "Bearer " + token
+	}
+	return post(ctx, c.client, reminder.GetString("url"), headers, body)
+}
+
+// ntfyChannel publishes to an ntfy topic URL, such as https://ntfy.sh/my-topic
+type ntfyChannel struct {
+	client *http.Client
+}
+
+func (c ntfyChannel) Send(ctx context.Context, reminder *models.Record, n Notification) error {
+	headers := map[string]string{
+		"Title": n.Title,
+		"Tags":  "memo",
+	}
+	if n.URL != "" {
+		headers["Click"] = n.URL
+	}
+	if token := reminder.GetString("token"); token != "" {
+		headers["Authorization"] = "Bearer " + token
+	}
+	return post(ctx, c.client, reminder.GetString("url"), headers, []byte(n.Message))
+}
+
+// gotifyChannel pushes a message through a Gotify server with an application token
+type gotifyChannel struct {
+	client *http.Client
+}
+
+func (c gotifyChannel) Send(ctx context.Context, reminder *models.Record, n Notification) error {
+	token := reminder.GetString("token")
+	if token == "" {
+		return fmt.Errorf("a Gotify application token is required")
+	}
+	message := map[string]any{
+		"title":    n.Title,
+		"message":  n.Message,
+		"priority": 5,
+	}
+	if n.URL != "" {
+		message["extras"] = map[string]any{
+			"client::notification": map[string]any{"click": map[string]string{"url": n.URL}},
+		}
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(reminder.GetString("url"), "/") + "/message"
+	return post(ctx, c.client, url, map[string]string{
+		"Content-Type": "application/json",
+		"X-Gotify-Key": token,
+	}, body)
+}
+
+// post sends a request with client and fails on non-2xx responses
+func post(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte) error {
+	if url == "" {
+		return fmt.Errorf("no URL configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s responded with status %d: %s", url, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package reminder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+// pushRequest is a request received by a push server
+type pushRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// newPushServer starts a server that records requests and answers with status and reply
+func newPushServer(t *testing.T, status int, reply string) (*httptest.Server, chan pushRequest) {
+	t.Helper()
+	requests := make(chan pushRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- pushRequest{method: r.Method, path: r.URL.Path, header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+		io.WriteString(w, reply)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+// newReminderRecord creates an unsaved reminder record
+func newReminderRecord(fields map[string]any) *models.Record {
+	collection := &models.Collection{Name: "reminders"}
+	for _, name := range []string{"owner", "name", "cron", "channel", "url", "token", "message"} {
+		collection.Schema.AddField(&schema.SchemaField{Name: name, Type: schema.FieldTypeText})
+	}
+	record := models.NewRecord(collection)
+	record.Id = "rem1"
+	for key, value := range fields {
+		record.Set(key, value)
+	}
+	return record
+}
+
+// testClient may reach the loopback test servers
+func testClient() *http.Client {
+	return newHTTPClient(parseAllowList([]string{"127.0.0.1"}))
+}
+
+var testNotification = Notification{
+	ReminderID: "rem1",
+	Title:      "Evening diary",
+	Message:    "How was your day?",
+	Date:       "2026-10-18",
+	URL:        "https://diary.example.com/diary/2026-10-18",
+}
+
+func receive(t *testing.T, requests chan pushRequest) pushRequest {
+	t.Helper()
+	select {
+	case r := <-requests:
+		return r
+	default:
+		t.Fatal("the server received no request")
+		return pushRequest{}
+	}
+}
+
+func TestWebhookChannel(t *testing.T) {
+	srv, requests := newPushServer(t, http.StatusNoContent, "")
+	reminder := newReminderRecord(map[string]any{"url": srv.URL + "/hooks/diary", "token": "s3cret"})
+
+	if err := (webhookChannel{client: testClient()}).Send(context.Background(), reminder, testNotification); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	r := receive(t, requests)
+	if r.method != http.MethodPost || r.path != "/hooks/diary" {
+		t.Errorf("request = %s %s", r.method, r.path)
+	}
+	if got := r.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := r.header.Get("Authorization"); got != "Bearer s3cret" {
+		t.Errorf("Authorization = %q", got)
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(r.body, &payload); err != nil {
+		t.Fatalf("payload is not JSON: %v", err)
+	}
+	want := map[string]any{
+		"event":       "reminder",
+		"reminder_id": "rem1",
+		"title":       "Evening diary",
+		"message":     "How was your day?",
+		"date":        "2026-10-18",
+		"url":         "https://diary.example.com/diary/2026-10-18",
+	}
+	for key, value := range want {
+		if payload[key] != value {
+			t.Errorf("payload[%s] = %v, want %v", key, payload[key], value)
+		}
+	}
+}
+
+func TestNtfyChannel(t *testing.T) {
+	srv, requests := newPushServer(t, http.StatusOK, `{"id":"abc"}`)
+
+	reminder := newReminderRecord(map[string]any{"url": srv.URL + "/diary-topic", "token": "tk_ntfy"})
+	if err := (ntfyChannel{client: testClient()}).Send(context.Background(), reminder, testNotification); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	r := receive(t, requests)
+	if r.path != "/diary-topic" || string(r.body) != "How was your day?" {
+		t.Errorf("request = %s %q", r.path, r.body)
+	}
+	headers := map[string]string{
+		"Title":         "Evening diary",
+		"Tags":          "memo",
+		"Click":         "https://diary.example.com/diary/2026-10-18",
+		"Authorization": "Bearer tk_ntfy",
+	}
+	for key, value := range headers {
+		if got := r.header.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+
+	// Without an app URL and token the optional headers are left out
+	reminder = newReminderRecord(map[string]any{"url": srv.URL + "/diary-topic"})
+	n := testNotification
+	n.URL = ""
+	if err := (ntfyChannel{client: testClient()}).Send(context.Background(), reminder, n); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	r = receive(t, requests)
+	if r.header.Get("Click") != "" || r.header.Get("Authorization") != "" {
+		t.Errorf("unexpected headers %v", r.header)
+	}
+}
+
+func TestGotifyChannel(t *testing.T) {
+	srv, requests := newPushServer(t, http.StatusOK, `{"id":1}`)
+
+	reminder := newReminderRecord(map[string]any{"url": srv.URL + "/", "token": "AppToken"})
+	if err := (gotifyChannel{client: testClient()}).Send(context.Background(), reminder, testNotification); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	r := receive(t, requests)
+	if r.path != "/message" {
+		t.Errorf("path = %s, want /message", r.path)
+	}
+	if got := r.header.Get("X-Gotify-Key"); got != "AppToken" {
+		t.Errorf("X-Gotify-Key = %q", got)
+	}
+
+	var payload struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+		Extras   struct {
+			Notification struct {
+				Click struct {
+					URL string `json:"url"`
+				} `json:"click"`
+			} `json:"client::notification"`
+		} `json:"extras"`
+	}
+	if err := json.Unmarshal(r.body, &payload); err != nil {
+		t.Fatalf("payload is not JSON: %v", err)
+	}
+	if payload.Title != "Evening diary" || payload.Message != "How was your day?" || payload.Priority != 5 ||
+		payload.Extras.Notification.Click.URL != testNotification.URL {
+		t.Errorf("payload = %+v", payload)
+	}
+
+	// The application token is required
+	reminder = newReminderRecord(map[string]any{"url": srv.URL})
+	if err := (gotifyChannel{client: testClient()}).Send(context.Background(), reminder, testNotification); err == nil {
+		t.Error("Send without a token succeeded")
+	}
+	if len(requests) > 0 {
+		t.Error("a request was sent without a token")
+	}
+}
+
+func TestPostFailsOnErrorStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		reply  string
+		want   string
+	}{
+		{http.StatusUnauthorized, "invalid token\n", "responded with status 401: invalid token"},
+		{http.StatusTooManyRequests, "", "responded with status 429"},
+		{http.StatusInternalServerError, strings.Repeat("x", 2000), "responded with status 500"},
+	}
+	for _, tt := range tests {
+		srv, _ := newPushServer(t, tt.status, tt.reply)
+		reminder := newReminderRecord(map[string]any{"url": srv.URL})
+		err := (webhookChannel{client: testClient()}).Send(context.Background(), reminder, testNotification)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("status %d: Send = %v, want an error containing %q", tt.status, err, tt.want)
+		}
+		if err != nil && len(err.Error()) > 700 {
+			t.Errorf("status %d: the error quotes %d bytes of the response", tt.status, len(err.Error()))
+		}
+	}
+
+	if err := post(context.Background(), testClient(), "", nil, nil); err == nil {
+		t.Error("post without a URL succeeded")
+	}
+}
+
+func TestInternalTargetsAreRefused(t *testing.T) {
+	srv, requests := newPushServer(t, http.StatusOK, "")
+	reminder := newReminderRecord(map[string]any{"url": srv.URL})
+
+	// Without an allow-list the loopback server cannot be reached
+	client := newHTTPClient(parseAllowList(nil))
+	err := (webhookChannel{client: client}).Send(context.Background(), reminder, testNotification)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("Send to loopback = %v, want ErrBlockedAddress", err)
+	}
+	if len(requests) > 0 {
+		t.Fatal("the loopback server was reached")
+	}
+}
+
+func TestRedirectsToInternalTargetsAreRefused(t *testing.T) {
+	internal, requests := newPushServer(t, http.StatusOK, "")
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL+"/admin", http.StatusTemporaryRedirect)
+	}))
+	defer redirect.Close()
+
+	// Only the redirecting host is allowed
+	client := newHTTPClient(parseAllowList([]string{"localhost"}))
+	target := strings.Replace(redirect.URL, "127.0.0.1", "localhost", 1)
+	reminder := newReminderRecord(map[string]any{"url": target})
+
+	err := (webhookChannel{client: client}).Send(context.Background(), reminder, testNotification)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("Send = %v, want ErrBlockedAddress", err)
+	}
+	if len(requests) > 0 {
+		t.Fatal("the redirect reached the internal server")
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	allow := parseAllowList([]string{" 192.168.1.0/24", "ntfy.lan", "10.0.0.7", ""})
+
+	tests := []struct {
+		url     string
+		blocked bool
+		invalid bool
+	}{
+		{url: "https://ntfy.sh/diary"},
+		{url: "http://push.example.com:8080/hook"},
+		{url: "http://ntfy.lan/topic"},
+		{url: "http://NTFY.LAN./topic"},
+		{url: "http://192.168.1.20/hook"},
+		{url: "http://10.0.0.7/hook"},
+		// Host names are checked when connecting
+		{url: "http://internal.example.com/"},
+		{url: "http://127.0.0.1:8090/api/", blocked: true},
+		{url: "http://[::1]/", blocked: true},
+		{url: "http://[::ffff:127.0.0.1]/", blocked: true},
+		{url: "http://localhost:8090/", blocked: true},
+		{url: "http://api.localhost/", blocked: true},
+		{url: "http://169.254.169.254/latest/meta-data/", blocked: true},
+		{url: "http://[fe80::1]/", blocked: true},
+		{url: "http://[fd00:ec2::254]/", blocked: true},
+		{url: "http://10.0.0.8/", blocked: true},
+		{url: "http://172.16.5.4/", blocked: true},
+		{url: "http://192.168.2.20/", blocked: true},
+		{url: "http://100.64.0.1/", blocked: true},
+		{url: "http://0.0.0.0/", blocked: true},
+		{url: "ftp://ntfy.sh/diary", invalid: true},
+		{url: "file:///etc/passwd", invalid: true},
+		{url: "ntfy.sh/diary", invalid: true},
+		{url: "http:///path", invalid: true},
+	}
+	for _, tt := range tests {
+		err := allow.checkURL(tt.url)
+		switch {
+		case tt.blocked:
+			if !errors.Is(err, ErrBlockedAddress) {
+				t.Errorf("checkURL(%q) = %v, want ErrBlockedAddress", tt.url, err)
+			}
+		case tt.invalid:
+			if err == nil || errors.Is(err, ErrBlockedAddress) {
+				t.Errorf("checkURL(%q) = %v, want an invalid URL error", tt.url, err)
+			}
+		default:
+			if err != nil {
+				t.Errorf("checkURL(%q) = %v, want nil", tt.url, err)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := &Service{allow: parseAllowList(nil)}
+
+	tests := []struct {
+		name   string
+		fields map[string]any
+		ok     bool
+	}{
+		{"email", map[string]any{"cron": "0 21 * * *", "channel": ChannelEmail}, true},
+		{"webhook", map[string]any{"cron": "0 21 * * *", "channel": ChannelWebhook, "url": "https://example.com/hook"}, true},
+		{"invalid cron", map[string]any{"cron": "at nine", "channel": ChannelEmail}, false},
+		{"webhook without URL", map[string]any{"cron": "0 21 * * *", "channel": ChannelWebhook}, false},
+		{"ntfy on loopback", map[string]any{"cron": "0 21 * * *", "channel": ChannelNtfy, "url": "http://127.0.0.1/topic"}, false},
+		{"gotify on metadata address", map[string]any{"cron": "0 21 * * *", "channel": ChannelGotify, "url": "http://169.254.169.254", "token": "t"}, false},
+		{"gotify without token", map[string]any{"cron": "0 21 * * *", "channel": ChannelGotify, "url": "https://gotify.example.com"}, false},
+		{"webhook with another scheme", map[string]any{"cron": "0 21 * * *", "channel": ChannelWebhook, "url": "gopher://example.com"}, false},
+	}
+	for _, tt := range tests {
+		err := s.Validate(newReminderRecord(tt.fields))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxRedirects is how many redirects a push request follows
+const maxRedirects = 3
+
+// ErrBlockedAddress is returned for push targets on internal networks that the allow-list does not permit
+var ErrBlockedAddress = errors.New("internal addresses are not allowed as reminder targets")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), internal like the private ranges
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// allowList holds the host names and networks that reminders may reach although they
+// are internal, e.g. a self-hosted ntfy server on the LAN
+type allowList struct {
+	hosts    map[string]bool
+	prefixes []netip.Prefix
+}
+
+// parseAllowList reads host names, IP addresses and CIDR ranges
+func parseAllowList(entries []string) allowList {
+	a := allowList{hosts: make(map[string]bool)}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			a.prefixes = append(a.prefixes, prefix.Masked())
+		} else if ip, err := netip.ParseAddr(entry); err == nil {
+			a.prefixes = append(a.prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+		} else {
+			a.hosts[strings.TrimSuffix(entry, ".")] = true
+		}
+	}
+	return a
+}
+
+func (a allowList) allowsHost(host string) bool {
+	return a.hosts[strings.TrimSuffix(strings.ToLower(host), ".")]
+}
+
+func (a allowList) allowsAddr(ip netip.Addr) bool {
+	for _, prefix := range a.prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isInternal reports whether ip belongs to the server or a private network: loopback,
+// private, link-local (including cloud metadata endpoints such as 169.254.169.254),
+// shared, unspecified and multicast addresses
+func isInternal(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// checkURL validates the URL of a push target. Host names are checked again when
+// connecting, since they may resolve to internal addresses.
+func (a allowList) checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("the URL must start with http:// or https://")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("the URL has no host")
+	}
+	if a.allowsHost(host) {
+		return nil
+	}
+
+	lower := strings.TrimSuffix(strings.ToLower(host), ".")
+	if lower == "localhost" || strings.HasSuffix(lower, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && isInternal(ip) && !a.allowsAddr(ip.Unmap()) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
+
+// newHTTPClient creates the client of the push channels. Every connection, including
+// those of redirects, goes to an address resolved and checked here, so a public host
+// name cannot resolve or redirect to an internal service. Environment proxies are not
+// used, as they would connect on the client's behalf.
+func newHTTPClient(allow allowList) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if allow.allowsHost(host) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if isInternal(ip) && !allow.allowsAddr(ip.Unmap()) {
+				return nil, fmt.Errorf("%w: %s resolves to %s", ErrBlockedAddress, host, ip.Unmap())
+			}
+		}
+
+		var lastErr error
+		for _, ip := range ips {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dial,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return allow.checkURL(req.URL.String())
+		},
+	}
+}
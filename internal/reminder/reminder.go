@@ -0,0 +1,282 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/cron"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/email"
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+const (
+	cronJobID  = "diarum_reminders"
+	dateLayout = "2006-01-02"
+
+	defaultTitle   = "Time to write your diary"
+	defaultMessage = "You haven't written today's diary yet."
+
+	// sendConcurrency is how many reminders are sent at once
+	sendConcurrency = 4
+	// runTimeout ends a run before the next minute's run starts
+	runTimeout = 50 * time.Second
+)
+
+// Config holds the reminder settings, read from the environment
+type Config struct {
+	// Disabled turns off scheduled reminders (DIARUM_REMINDERS=off)
+	Disabled bool
+
+	// SMTP delivers email reminders. Without a host PocketBase's mail settings are used.
+	SMTP email.SMTPConfig
+
+	// AllowedHosts are host names, IP addresses and CIDR ranges on internal networks that
+	// webhook, ntfy and Gotify reminders may reach (DIARUM_REMINDER_ALLOWED_HOSTS,
+	// comma separated). Other internal addresses are refused.
+	AllowedHosts []string
+}
+
+// LoadConfig reads the reminder config from DIARUM_REMINDERS, DIARUM_REMINDER_ALLOWED_HOSTS
+// and DIARUM_SMTP_* environment variables
+func LoadConfig() Config {
+	return Config{
+		Disabled:     strings.TrimSpace(os.Getenv("DIARUM_REMINDERS")) == "off",
+		SMTP:         email.LoadSMTPConfig(),
+		AllowedHosts: strings.Split(os.Getenv("DIARUM_REMINDER_ALLOWED_HOSTS"), ","),
+	}
+}
+
+// Service sends the reminders users schedule in the reminders collection. Every minute
+// each reminder's cron expression is checked in its owner's time zone; due reminders
+// are skipped when the day already has a diary.
+type Service struct {
+	app           *pocketbase.PocketBase
+	configService *config.ConfigService
+	cfg           Config
+	allow         allowList
+	channels      map[string]Channel
+	cron          *cron.Cron
+	running       sync.Mutex
+}
+
+// NewService creates a new reminder service with the built-in channels
+func NewService(app *pocketbase.PocketBase, cfg Config) *Service {
+	s := &Service{
+		app:           app,
+		configService: config.NewConfigService(app),
+		cfg:           cfg,
+		allow:         parseAllowList(cfg.AllowedHosts),
+		channels:      make(map[string]Channel),
+	}
+	client := newHTTPClient(s.allow)
+	s.RegisterChannel(ChannelEmail, &emailChannel{app: app, smtp: cfg.SMTP})
+	s.RegisterChannel(ChannelWebhook, webhookChannel{client: client})
+	s.RegisterChannel(ChannelNtfy, ntfyChannel{client: client})
+	s.RegisterChannel(ChannelGotify, gotifyChannel{client: client})
+	return s
+}
+
+// RegisterChannel adds or replaces a delivery channel
+func (s *Service) RegisterChannel(name string, channel Channel) {
+	s.channels[name] = channel
+}
+
+// Start checks for due reminders every minute
+func (s *Service) Start() error {
+	if s.cfg.Disabled {
+		return nil
+	}
+	c := cron.New()
+	if err := c.Add(cronJobID, "* * * * *", func() {
+		s.RunDue(context.Background(), time.Now())
+	}); err != nil {
+		return fmt.Errorf("failed to schedule reminders: %w", err)
+	}
+	c.Start()
+	s.cron = c
+	logger.Info("[Reminder] checking reminders every minute")
+	return nil
+}
+
+// Stop stops the scheduler
+func (s *Service) Stop() {
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+}
+
+// RunDue sends the reminders due at now. Up to sendConcurrency reminders are sent at
+// once and the run is cut off after runTimeout; while a run is still sending, the next
+// one is skipped.
+func (s *Service) RunDue(ctx context.Context, now time.Time) {
+	if !s.running.TryLock() {
+		logger.Warn("[Reminder] previous run is still sending, skipping the run of %s", now.Format(time.RFC3339))
+		return
+	}
+	defer s.running.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, runTimeout)
+	defer cancel()
+
+	reminders, err := s.app.Dao().FindRecordsByFilter("reminders", "paused = false", "owner", 0, 0)
+	if err != nil {
+		logger.Error("[Reminder] failed to list reminders: %v", err)
+		return
+	}
+
+	type dueReminder struct {
+		record *models.Record
+		date   string
+	}
+	var due []dueReminder
+
+	locations := make(map[string]*time.Location)
+	for _, reminder := range reminders {
+		owner := reminder.GetString("owner")
+		loc, ok := locations[owner]
+		if !ok {
+			loc = s.configService.Location(owner)
+			locations[owner] = loc
+		}
+
+		local := now.In(loc)
+		schedule, err := cron.NewSchedule(reminder.GetString("cron"))
+		if err != nil || !schedule.IsDue(cron.NewMoment(local)) {
+			continue
+		}
+		// The minute was already handled, e.g. after a test or a slow previous run
+		if lastSent := reminder.GetDateTime("last_sent"); !lastSent.IsZero() &&
+			lastSent.Time().Truncate(time.Minute).Equal(now.Truncate(time.Minute)) {
+			continue
+		}
+
+		date := local.Format(dateLayout)
+		written, err := s.hasDiary(owner, date)
+		if err != nil {
+			logger.Error("[Reminder] failed to check the diary of user %s: %v", owner, err)
+			continue
+		}
+		if written {
+			logger.Debug("[Reminder] skipped reminder %s, diary of %s is written", reminder.Id, date)
+			continue
+		}
+
+		due = append(due, dueReminder{record: reminder, date: date})
+	}
+
+	slots := make(chan struct{}, sendConcurrency)
+	var wg sync.WaitGroup
+	for i, d := range due {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			logger.Error("[Reminder] run timed out, %d reminders were not sent", len(due)-i)
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func(reminder *models.Record, date string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			owner := reminder.GetString("owner")
+			if err := s.send(ctx, reminder, date, now); err != nil {
+				logger.Error("[Reminder] failed to send reminder %s of user %s: %v", reminder.Id, owner, err)
+			} else {
+				logger.Info("[Reminder] sent reminder %s of user %s via %s", reminder.Id, owner, reminder.GetString("channel"))
+			}
+		}(d.record, d.date)
+	}
+	wg.Wait()
+}
+
+// Test sends a reminder now, whether or not today's diary is written
+func (s *Service) Test(ctx context.Context, reminder *models.Record) error {
+	now := time.Now()
+	date := now.In(s.configService.Location(reminder.GetString("owner"))).Format(dateLayout)
+	return s.send(ctx, reminder, date, now)
+}
+
+// send delivers a reminder through its channel and records the outcome on it
+func (s *Service) send(ctx context.Context, reminder *models.Record, date string, now time.Time) error {
+	var err error
+	channel, ok := s.channels[reminder.GetString("channel")]
+	if !ok {
+		err = fmt.Errorf("unknown channel %q", reminder.GetString("channel"))
+	} else {
+		err = channel.Send(ctx, reminder, s.notification(reminder, date))
+	}
+
+	sentAt, _ := types.ParseDateTime(now)
+	reminder.Set("last_sent", sentAt)
+	reminder.Set("last_error", "")
+	if err != nil {
+		reminder.Set("last_error", err.Error())
+	}
+	if saveErr := s.app.Dao().SaveRecord(reminder); saveErr != nil {
+		logger.Error("[Reminder] failed to save reminder %s: %v", reminder.Id, saveErr)
+	}
+	return err
+}
+
+// notification builds the notification of a reminder for a day
+func (s *Service) notification(reminder *models.Record, date string) Notification {
+	n := Notification{
+		ReminderID: reminder.Id,
+		Title:      defaultTitle,
+		Message:    defaultMessage,
+		Date:       date,
+	}
+	if name := strings.TrimSpace(reminder.GetString("name")); name != "" {
+		n.Title = name
+	}
+	if message := strings.TrimSpace(reminder.GetString("message")); message != "" {
+		n.Message = message
+	}
+	if appURL := strings.TrimSuffix(s.app.Settings().Meta.AppUrl, "/"); appURL != "" {
+		n.URL = appURL + "/diary/" + date
+	}
+	return n
+}
+
+// hasDiary reports whether the user wrote a diary on a YYYY-MM-DD date
+func (s *Service) hasDiary(userID, date string) (bool, error) {
+	var count int
+	err := s.app.Dao().DB().
+		NewQuery("SELECT COUNT(*) FROM diaries WHERE owner = {:owner} AND date >= {:start} AND date <= {:end}").
+		Bind(map[string]any{"owner": userID, "start": date + " 00:00:00.000Z", "end": date + " 23:59:59.999Z"}).
+		Row(&count)
+	return count > 0, err
+}
+
+// Validate checks a reminder's cron expression and channel target. Push targets must
+// be http(s) URLs outside internal networks, unless the allow-list permits them.
+func (s *Service) Validate(reminder *models.Record) error {
+	if _, err := cron.NewSchedule(reminder.GetString("cron")); err != nil {
+		return fmt.Errorf("invalid cron expression: %w", err)
+	}
+	switch reminder.GetString("channel") {
+	case ChannelWebhook, ChannelNtfy:
+		if reminder.GetString("url") == "" {
+			return errors.New("a URL is required for this channel")
+		}
+	case ChannelGotify:
+		if reminder.GetString("url") == "" || reminder.GetString("token") == "" {
+			return errors.New("a Gotify server URL and application token are required")
+		}
+	default:
+		return nil
+	}
+	return s.allow.checkURL(reminder.GetString("url"))
+}
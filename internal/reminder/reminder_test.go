@@ -0,0 +1,230 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/migrate"
+
+	_ "github.com/songtianlun/diarum/internal/migrations"
+)
+
+// now is a Sunday evening, when reminders with the cron "0 21 * * *" are due
+var now = time.Date(2026, 10, 18, 21, 0, 0, 0, time.UTC)
+
+// newTestService creates a reminder service on a migrated app in a temporary directory
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	app := pocketbase.NewWithConfig(pocketbase.Config{DefaultDataDir: t.TempDir(), HideStartBanner: true})
+	if err := app.Bootstrap(); err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+	t.Cleanup(func() { app.ResetBootstrapState() })
+
+	runner, err := migrate.NewRunner(app.DB(), migrations.AppMigrations)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	if _, err := runner.Up(); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	return NewService(app, Config{})
+}
+
+// createUser creates a user who lives in UTC
+func createUser(t *testing.T, s *Service, name string) string {
+	t.Helper()
+	users, err := s.app.Dao().FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.NewRecord(users)
+	user.SetUsername(name)
+	user.SetEmail(name + "@example.com")
+	user.SetPassword("password12345")
+	if err := s.app.Dao().SaveRecord(user); err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+	if err := s.configService.Set(user.Id, "user.timezone", "UTC"); err != nil {
+		t.Fatalf("failed to set the time zone: %v", err)
+	}
+	return user.Id
+}
+
+// createRecord saves a record with fields in a collection
+func createRecord(t *testing.T, s *Service, collection string, fields map[string]any) *models.Record {
+	t.Helper()
+	c, err := s.app.Dao().FindCollectionByNameOrId(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := models.NewRecord(c)
+	for key, value := range fields {
+		record.Set(key, value)
+	}
+	if err := s.app.Dao().SaveRecord(record); err != nil {
+		t.Fatalf("failed to save %s record: %v", collection, err)
+	}
+	return record
+}
+
+func createReminder(t *testing.T, s *Service, owner, cron string, paused bool) *models.Record {
+	return createRecord(t, s, "reminders", map[string]any{
+		"owner":   owner,
+		"cron":    cron,
+		"channel": ChannelWebhook,
+		"url":     "https://example.com/hook",
+		"paused":  paused,
+	})
+}
+
+// fakeChannel records the reminders it sends. While block is open, sends wait for it.
+type fakeChannel struct {
+	mu       sync.Mutex
+	sent     []string
+	active   int
+	peak     int
+	err      error
+	delay    time.Duration
+	block    chan struct{}
+	started  chan struct{}
+	startOne sync.Once
+}
+
+func (c *fakeChannel) Send(ctx context.Context, reminder *models.Record, n Notification) error {
+	c.mu.Lock()
+	c.active++
+	if c.active > c.peak {
+		c.peak = c.active
+	}
+	c.mu.Unlock()
+	if c.started != nil {
+		c.startOne.Do(func() { close(c.started) })
+	}
+
+	if c.block != nil {
+		<-c.block
+	}
+	time.Sleep(c.delay)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	c.sent = append(c.sent, reminder.Id+" "+n.Date)
+	return c.err
+}
+
+func (c *fakeChannel) sentReminders() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.sent...)
+}
+
+func TestRunDueSkipsWrittenDays(t *testing.T) {
+	s := newTestService(t)
+	channel := &fakeChannel{}
+	s.RegisterChannel(ChannelWebhook, channel)
+
+	writer := createUser(t, s, "writer")
+	idler := createUser(t, s, "idler")
+	createRecord(t, s, "diaries", map[string]any{
+		"owner":   writer,
+		"date":    "2026-10-18 00:00:00.000Z",
+		"content": "<p>Went hiking.</p>",
+	})
+	createReminder(t, s, writer, "0 21 * * *", false)
+	due := createReminder(t, s, idler, "0 21 * * *", false)
+	createReminder(t, s, idler, "0 21 * * *", true)
+	createReminder(t, s, idler, "30 7 * * *", false)
+
+	s.RunDue(context.Background(), now)
+
+	sent := channel.sentReminders()
+	if len(sent) != 1 || sent[0] != due.Id+" 2026-10-18" {
+		t.Fatalf("sent = %v, want only %s", sent, due.Id)
+	}
+
+	saved, err := s.app.Dao().FindRecordById("reminders", due.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.GetDateTime("last_sent").Time().Equal(now) || saved.GetString("last_error") != "" {
+		t.Errorf("last_sent = %v, last_error = %q", saved.GetDateTime("last_sent"), saved.GetString("last_error"))
+	}
+
+	// The minute is not handled twice
+	s.RunDue(context.Background(), now.Add(30*time.Second))
+	if sent := channel.sentReminders(); len(sent) != 1 {
+		t.Fatalf("sent = %v after running the minute again", sent)
+	}
+}
+
+func TestRunDueRecordsFailures(t *testing.T) {
+	s := newTestService(t)
+	s.RegisterChannel(ChannelWebhook, &fakeChannel{err: errors.New("responded with status 503")})
+
+	reminder := createReminder(t, s, createUser(t, s, "idler"), "0 21 * * *", false)
+	s.RunDue(context.Background(), now)
+
+	saved, err := s.app.Dao().FindRecordById("reminders", reminder.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := saved.GetString("last_error"); got != "responded with status 503" {
+		t.Errorf("last_error = %q", got)
+	}
+}
+
+func TestRunDueBoundsConcurrentSends(t *testing.T) {
+	s := newTestService(t)
+	channel := &fakeChannel{delay: 20 * time.Millisecond}
+	s.RegisterChannel(ChannelWebhook, channel)
+
+	owner := createUser(t, s, "idler")
+	for i := 0; i < 3*sendConcurrency; i++ {
+		createReminder(t, s, owner, "0 21 * * *", false)
+	}
+	s.RunDue(context.Background(), now)
+
+	if sent := channel.sentReminders(); len(sent) != 3*sendConcurrency {
+		t.Fatalf("sent %d reminders, want %d", len(sent), 3*sendConcurrency)
+	}
+	if channel.peak > sendConcurrency || channel.peak < 2 {
+		t.Errorf("%d reminders were sent at once, want 2 to %d", channel.peak, sendConcurrency)
+	}
+}
+
+func TestRunDueSkipsOverlappingRuns(t *testing.T) {
+	s := newTestService(t)
+	channel := &fakeChannel{block: make(chan struct{}), started: make(chan struct{})}
+	s.RegisterChannel(ChannelWebhook, channel)
+
+	// Due every minute
+	createReminder(t, s, createUser(t, s, "idler"), "* * * * *", false)
+
+	finished := make(chan struct{})
+	go func() {
+		s.RunDue(context.Background(), now)
+		close(finished)
+	}()
+	select {
+	case <-channel.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first run sent nothing")
+	}
+
+	// The next minute's run starts while the first one is still sending
+	s.RunDue(context.Background(), now.Add(time.Minute))
+	close(channel.block)
+	<-finished
+
+	if sent := channel.sentReminders(); len(sent) != 1 {
+		t.Fatalf("sent = %v, want one reminder", sent)
+	}
+}
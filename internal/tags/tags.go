@@ -0,0 +1,120 @@
+package tags
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// maxNameLength is the longest tag name allowed by the tags collection
+const maxNameLength = 50
+
+// Names returns the names of the tags with the given IDs, in the same order
+func Names(dao *daos.Dao, ids []string) []string {
+	if len(ids) == 0 {
+		return nil
+	}
+	records, err := dao.FindRecordsByIds("tags", ids)
+	if err != nil {
+		return nil
+	}
+	byID := make(map[string]string, len(records))
+	for _, record := range records {
+		byID[record.Id] = record.GetString("name")
+	}
+	return ordered(byID, ids)
+}
+
+// Ensure returns the IDs of the user's tags with the given names, creating missing ones.
+// Names match case-insensitively, blank and too long names are skipped.
+func Ensure(dao *daos.Dao, owner string, names []string) ([]string, error) {
+	index, err := Load(dao, owner)
+	if err != nil {
+		return nil, err
+	}
+	return index.Ensure(names)
+}
+
+// Index holds a user's tags, loaded once for work on many diaries such as export and import
+type Index struct {
+	dao        *daos.Dao
+	owner      string
+	collection *models.Collection
+	byID       map[string]string // tag ID -> name
+	byName     map[string]string // lower-case name -> tag ID
+}
+
+// Load loads all tags of a user
+func Load(dao *daos.Dao, owner string) (*Index, error) {
+	existing, err := dao.FindRecordsByFilter("tags", "owner = {:owner}", "", 0, 0, map[string]any{"owner": owner})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tags: %w", err)
+	}
+	index := &Index{
+		dao:    dao,
+		owner:  owner,
+		byID:   make(map[string]string, len(existing)),
+		byName: make(map[string]string, len(existing)),
+	}
+	for _, record := range existing {
+		name := record.GetString("name")
+		index.byID[record.Id] = name
+		index.byName[strings.ToLower(name)] = record.Id
+	}
+	return index, nil
+}
+
+// Names returns the names of the user's tags with the given IDs, in the same order
+func (x *Index) Names(ids []string) []string {
+	if len(ids) == 0 {
+		return nil
+	}
+	return ordered(x.byID, ids)
+}
+
+// Ensure returns the IDs of the user's tags with the given names, creating missing ones.
+// Names match case-insensitively, blank and too long names are skipped.
+func (x *Index) Ensure(names []string) ([]string, error) {
+	ids := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || len([]rune(name)) > maxNameLength {
+			continue
+		}
+		if id, ok := x.byName[strings.ToLower(name)]; ok {
+			ids = append(ids, id)
+			continue
+		}
+
+		if x.collection == nil {
+			collection, err := x.dao.FindCollectionByNameOrId("tags")
+			if err != nil {
+				return nil, fmt.Errorf("failed to find tags collection: %w", err)
+			}
+			x.collection = collection
+		}
+		record := models.NewRecord(x.collection)
+		record.Set("name", name)
+		record.Set("owner", x.owner)
+		if err := x.dao.SaveRecord(record); err != nil {
+			return nil, fmt.Errorf("failed to create tag %q: %w", name, err)
+		}
+		x.byID[record.Id] = name
+		x.byName[strings.ToLower(name)] = record.Id
+		ids = append(ids, record.Id)
+	}
+	return ids, nil
+}
+
+// ordered looks up the names of ids, skipping unknown ones
+func ordered(byID map[string]string, ids []string) []string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if name, ok := byID[id]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,101 @@
+package templates
+
+// Template is a diary template. Built-in templates have a key instead of a record id.
+type Template struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Builtin bool   `json:"builtin"`
+}
+
+// builtins are the templates every user has, their ids are stable keys
+var builtins = []Template{
+	{
+		ID:   "gratitude",
+		Name: "Gratitude",
+		Content: `<h2>Gratitude · {{weekday}}, {{date}}</h2>
+<p>Yesterday's mood: {{yesterday_mood}}</p>
+<h3>Three things I'm grateful for</h3>
+<ol><li><p></p></li><li><p></p></li><li><p></p></li></ol>
+<h3>What would make today great?</h3>
+<p></p>
+<h3>Today's prompt</h3>
+<p><em>{{prompt}}</em></p>
+<p></p>`,
+		Builtin: true,
+	},
+	{
+		ID:   "morning-pages",
+		Name: "Morning Pages",
+		Content: `<h2>Morning Pages · {{weekday}}, {{date}}</h2>
+<p><em>Three pages of stream of consciousness, written first thing in the morning. Don't stop, don't edit, don't judge.</em></p>
+<p></p>`,
+		Builtin: true,
+	},
+	{
+		ID:   "weekly-review",
+		Name: "Weekly Review",
+		Content: `<h2>Weekly Review · week of {{week_start}}</h2>
+<h3>Highlights</h3>
+<ul><li><p></p></li></ul>
+<h3>Challenges</h3>
+<ul><li><p></p></li></ul>
+<h3>What I learned</h3>
+<p></p>
+<h3>Focus for next week</h3>
+<p></p>`,
+		Builtin: true,
+	},
+}
+
+// Builtins returns the built-in templates
+func Builtins() []Template {
+	list := make([]Template, len(builtins))
+	copy(list, builtins)
+	return list
+}
+
+// builtin returns the built-in template with an id
+func builtin(id string) (Template, bool) {
+	for _, template := range builtins {
+		if template.ID == id {
+			return template, true
+		}
+	}
+	return Template{}, false
+}
+
+// library is the built-in rotation of daily prompts
+var library = []string{
+	"What is one thing you are looking forward to today?",
+	"What made you smile recently, and why?",
+	"Describe a small moment from yesterday that you want to remember.",
+	"What is taking up most of your attention right now?",
+	"Who has made a difference in your life lately, and how?",
+	"What is something you learned this week?",
+	"What would you do today if you weren't afraid of failing?",
+	"Which habit would you like to build, and what is the first step?",
+	"What drained your energy recently, and what restored it?",
+	"Write about a place where you feel completely at ease.",
+	"What is a decision you are putting off, and what is holding you back?",
+	"What are you proud of that nobody else knows about?",
+	"How did you take care of yourself this week?",
+	"What would your ideal day look like from start to finish?",
+	"What is a belief you held a year ago that has changed?",
+	"Describe a conversation that stayed with you.",
+	"What are three things you can let go of today?",
+	"What surprised you recently?",
+	"What does a good life mean to you right now?",
+	"Write a short letter to yourself one year from now.",
+	"Which book, song or film has been on your mind, and why?",
+	"What is a challenge you are facing, and what would you tell a friend in your place?",
+	"When did you last feel fully absorbed in what you were doing?",
+	"What are you curious about at the moment?",
+	"What would you like to say no to more often?",
+	"Describe today's weather and how it matches your mood.",
+	"What is one kind thing you could do for someone today?",
+	"What did you do today that your younger self would be happy about?",
+	"What is something you have been avoiding thinking about?",
+	"Which moment of this month would you like to relive?",
+	"What are you grateful for that you usually take for granted?",
+}
@@ -0,0 +1,106 @@
+package templates
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/songtianlun/diarum/internal/logger"
+)
+
+// Daily prompt sources
+const (
+	SourceLibrary = "library"
+	SourceAI      = "ai"
+)
+
+// promptDiaries is how many recent diaries an AI prompt is based on
+const promptDiaries = 5
+
+// Prompt is the writing prompt of a day
+type Prompt struct {
+	Date   string `json:"date"`
+	Text   string `json:"text"`
+	Source string `json:"source"`
+}
+
+// AIEnabled reports whether the user turned on AI daily prompts
+func (s *Service) AIEnabled(userID string) bool {
+	aiEnabled, _ := s.configService.GetBool(userID, "ai.enabled")
+	dailyPrompt, _ := s.configService.GetBool(userID, "ai.daily_prompt")
+	return aiEnabled && dailyPrompt
+}
+
+// DailyPrompt returns the writing prompt of a YYYY-MM-DD date. With AI daily prompts
+// today's prompt is written by the chat model from the recent diaries and kept for the
+// rest of the day; other days and failed generations use the built-in library.
+func (s *Service) DailyPrompt(ctx context.Context, userID, date string) (Prompt, error) {
+	day, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return Prompt{}, fmt.Errorf("invalid date %q: %w", date, err)
+	}
+
+	if s.chatService != nil && date == s.Today(userID) && s.AIEnabled(userID) {
+		text, err := s.aiPrompt(ctx, userID, date)
+		if err == nil {
+			return Prompt{Date: date, Text: text, Source: SourceAI}, nil
+		}
+		logger.Error("[Templates] failed to generate the daily prompt of user %s, using the library: %v", userID, err)
+	}
+
+	return Prompt{Date: date, Text: LibraryPrompt(userID, day), Source: SourceLibrary}, nil
+}
+
+// LibraryPrompt returns the built-in prompt of a day. The library rotates one prompt
+// per day, starting at a different prompt for each user.
+func LibraryPrompt(userID string, day time.Time) string {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	days := int(time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+	return library[(int(h.Sum32()%uint32(len(library)))+days)%len(library)]
+}
+
+// aiPrompt returns the user's cached AI prompt of today or generates it
+func (s *Service) aiPrompt(ctx context.Context, userID, date string) (string, error) {
+	lock, _ := s.locks.LoadOrStore(userID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if cached, _ := s.configService.GetString(userID, "ai.daily_prompt_date"); cached == date {
+		if text, _ := s.configService.GetString(userID, "ai.daily_prompt_text"); text != "" {
+			return text, nil
+		}
+	}
+
+	records, err := s.app.Dao().FindRecordsByFilter(
+		"diaries",
+		"owner = {:owner} && date < {:before}",
+		"-date",
+		promptDiaries,
+		0,
+		map[string]any{"owner": userID, "before": date + " 00:00:00.000Z"},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to find recent diaries: %w", err)
+	}
+	// Oldest first
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	text, err := s.chatService.GenerateDailyPrompt(ctx, userID, date, records)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.configService.SetBatch(userID, map[string]any{
+		"ai.daily_prompt_date": date,
+		"ai.daily_prompt_text": text,
+	}); err != nil {
+		logger.Error("[Templates] failed to cache the daily prompt of user %s: %v", userID, err)
+	}
+	logger.Info("[Templates] generated the daily prompt of %s for user %s from %d diaries", date, userID, len(records))
+	return text, nil
+}
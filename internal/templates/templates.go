@@ -0,0 +1,166 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/models"
+
+	"github.com/songtianlun/diarum/internal/analytics"
+	"github.com/songtianlun/diarum/internal/chat"
+	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/placeholder"
+)
+
+const dateLayout = "2006-01-02"
+
+// ErrTemplateNotFound is returned for unknown templates and templates of other users
+var ErrTemplateNotFound = errors.New("template not found")
+
+// ErrDiaryExists is returned when the diary of the day already has content
+var ErrDiaryExists = errors.New("the diary of this day is already written")
+
+// Service renders diary templates and picks the daily writing prompt
+type Service struct {
+	app           *pocketbase.PocketBase
+	chatService   *chat.ChatService
+	configService *config.ConfigService
+	// locks serializes the AI prompt generation of each user
+	locks sync.Map
+}
+
+// NewService creates a new templates service
+func NewService(app *pocketbase.PocketBase, chatService *chat.ChatService) *Service {
+	return &Service{
+		app:           app,
+		chatService:   chatService,
+		configService: config.NewConfigService(app),
+	}
+}
+
+// List returns the user's templates followed by the built-in ones
+func (s *Service) List(userID string) ([]Template, error) {
+	records, err := s.app.Dao().FindRecordsByFilter("templates", "owner = {:owner}", "name", 0, 0,
+		map[string]any{"owner": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+
+	list := make([]Template, 0, len(records)+len(builtins))
+	for _, record := range records {
+		list = append(list, fromRecord(record))
+	}
+	return append(list, Builtins()...), nil
+}
+
+// Find returns a built-in template by its key or one of the user's templates by its id
+func (s *Service) Find(userID, id string) (Template, error) {
+	if template, ok := builtin(id); ok {
+		return template, nil
+	}
+	record, err := s.app.Dao().FindRecordById("templates", id)
+	if err != nil || record.GetString("owner") != userID {
+		return Template{}, ErrTemplateNotFound
+	}
+	return fromRecord(record), nil
+}
+
+// Today returns today's date in the user's time zone
+func (s *Service) Today(userID string) string {
+	return time.Now().In(s.configService.Location(userID)).Format(dateLayout)
+}
+
+// CreateDiary writes the rendered template into the user's diary of a YYYY-MM-DD date.
+// A diary without content is filled in, one with content is left alone.
+func (s *Service) CreateDiary(ctx context.Context, userID, templateID, date string) (*models.Record, error) {
+	template, err := s.Find(userID, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	diary, err := s.findDiary(userID, date)
+	if err != nil {
+		collection, err := s.app.Dao().FindCollectionByNameOrId("diaries")
+		if err != nil {
+			return nil, err
+		}
+		diary = models.NewRecord(collection)
+		diary.Set("owner", userID)
+		diary.Set("date", date+" 00:00:00.000Z")
+	} else if _, chars := analytics.CountText(diary.GetString("content")); chars > 0 {
+		return nil, ErrDiaryExists
+	}
+
+	vars, err := s.Variables(userID, date)
+	if err != nil {
+		return nil, err
+	}
+	// Only pick the prompt when it is used, it may be generated by the chat model
+	if placeholder.Uses(template.Content, "prompt") {
+		prompt, err := s.DailyPrompt(ctx, userID, date)
+		if err != nil {
+			return nil, err
+		}
+		vars["prompt"] = prompt.Text
+	}
+
+	diary.Set("content", placeholder.Render(template.Content, vars, html.EscapeString))
+	if err := s.app.Dao().SaveRecord(diary); err != nil {
+		return nil, fmt.Errorf("failed to save diary: %w", err)
+	}
+	return diary, nil
+}
+
+// Variables returns the values of the template placeholders for a YYYY-MM-DD date,
+// except the daily prompt. Values about yesterday are empty without a diary of yesterday.
+func (s *Service) Variables(userID, date string) (map[string]string, error) {
+	day, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q: %w", date, err)
+	}
+	yesterday := day.AddDate(0, 0, -1).Format(dateLayout)
+	weekStart := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+
+	vars := map[string]string{
+		"date":              date,
+		"weekday":           day.Weekday().String(),
+		"month":             day.Month().String(),
+		"year":              day.Format("2006"),
+		"week_start":        weekStart.Format(dateLayout),
+		"yesterday":         yesterday,
+		"yesterday_mood":    "",
+		"yesterday_weather": "",
+	}
+	if diary, err := s.findDiary(userID, yesterday); err == nil {
+		vars["yesterday_mood"] = diary.GetString("mood")
+		vars["yesterday_weather"] = diary.GetString("weather")
+	}
+	return vars, nil
+}
+
+// findDiary returns the user's diary of a YYYY-MM-DD date
+func (s *Service) findDiary(userID, date string) (*models.Record, error) {
+	return s.app.Dao().FindFirstRecordByFilter(
+		"diaries",
+		"date >= {:start} && date <= {:end} && owner = {:owner}",
+		map[string]any{
+			"start": date + " 00:00:00.000Z",
+			"end":   date + " 23:59:59.999Z",
+			"owner": userID,
+		},
+	)
+}
+
+// fromRecord converts a templates record
+func fromRecord(record *models.Record) Template {
+	return Template{
+		ID:      record.Id,
+		Name:    record.GetString("name"),
+		Content: record.GetString("content"),
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"io"
 	"io/fs"
@@ -10,18 +9,26 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
+	"github.com/songtianlun/diarum/internal/analytics"
 	"github.com/songtianlun/diarum/internal/api"
-	"github.com/songtianlun/diarum/internal/config"
+	"github.com/songtianlun/diarum/internal/backup"
+	"github.com/songtianlun/diarum/internal/chat"
+	"github.com/songtianlun/diarum/internal/cli"
+	"github.com/songtianlun/diarum/internal/digest"
 	"github.com/songtianlun/diarum/internal/embedding"
-	"github.com/songtianlun/diarum/internal/logger"
+	"github.com/songtianlun/diarum/internal/enrichment"
+	"github.com/songtianlun/diarum/internal/goals"
 	_ "github.com/songtianlun/diarum/internal/migrations"
+	"github.com/songtianlun/diarum/internal/reminder"
 	"github.com/songtianlun/diarum/internal/static"
+	"github.com/songtianlun/diarum/internal/templates"
 
 	"github.com/labstack/echo/v5"
 	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
 	"github.com/pocketbase/pocketbase/plugins/migratecmd"
 	"github.com/spf13/cobra"
 )
@@ -140,6 +147,20 @@ func main() {
 		},
 	})
 
+	// Add maintenance commands (export, import, vectors, doctor)
+	cli.Register(app)
+
+	// Apply a staged snapshot restore before the database is opened
+	app.OnBeforeBootstrap().Add(func(e *core.BootstrapEvent) error {
+		if backup.HasPendingRestore(app.DataDir()) {
+			log.Printf("Applying pending backup restore")
+			if err := backup.ApplyPendingRestore(app.DataDir()); err != nil {
+				return fmt.Errorf("failed to apply pending restore: %w", err)
+			}
+		}
+		return nil
+	})
+
 	// Register custom routes and serve embedded frontend
 	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
 		// Print data directory information
@@ -161,77 +182,116 @@ func main() {
 			embeddingService = embedding.NewEmbeddingService(app, vectorDB)
 		}
 
-		// Initialize config service for checking AI settings
-		configService := config.NewConfigService(app)
-
-		// Add diary create hook for auto vector build
-		app.OnRecordAfterCreateRequest("diaries").Add(func(e *core.RecordCreateEvent) error {
-			userID := e.Record.GetString("owner")
-			if userID == "" {
-				return nil
-			}
-
-			// Check if AI is enabled for this user
-			enabled, _ := configService.GetBool(userID, "ai.enabled")
-			if !enabled || embeddingService == nil {
-				return nil
-			}
-
-			// Run incremental vector build in background
-			go func() {
-				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-				defer cancel()
-
-				logger.Info("[AutoVectorBuild] triggered by diary create for user: %s", userID)
-				result, err := embeddingService.BuildIncrementalVectors(ctx, userID)
-				if err != nil {
-					logger.Error("[AutoVectorBuild] failed for user %s: %v", userID, err)
-					return
-				}
-				logger.Info("[AutoVectorBuild] completed for user %s: %d built, %d failed", userID, result.Success, result.Failed)
-			}()
+		// Writing analytics, shared by the stats endpoints and the chat tools
+		analyticsService := analytics.NewService(app)
 
+		// Initialize diary enrichment, suggestions are made a while after a diary was last saved
+		chatService := chat.NewChatService(app, embeddingService, analyticsService)
+		enrichmentService := enrichment.NewService(app, chatService)
+		app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+			enrichmentService.Stop()
 			return nil
 		})
 
-		// Add diary update hook for auto vector build
-		app.OnRecordAfterUpdateRequest("diaries").Add(func(e *core.RecordUpdateEvent) error {
-			userID := e.Record.GetString("owner")
-			if userID == "" {
+		// Enrich and index diaries after every save, including diaries created from
+		// templates and imported archives that do not go through the records API.
+		// Imports hold back enrichment with a batch, see enrichment.Service.Batch.
+		diarySaved := func(e *core.ModelEvent) error {
+			record, ok := e.Model.(*models.Record)
+			if !ok || record.GetString("owner") == "" {
 				return nil
 			}
-
-			// Check if AI is enabled for this user
-			enabled, _ := configService.GetBool(userID, "ai.enabled")
-			if !enabled || embeddingService == nil {
-				return nil
+			enrichmentService.Schedule(record)
+			if embeddingService != nil {
+				embeddingService.ScheduleIncrementalBuild(record.GetString("owner"))
 			}
+			return nil
+		}
+		app.OnModelAfterCreate("diaries").Add(diarySaved)
+		app.OnModelAfterUpdate("diaries").Add(diarySaved)
+		if embeddingService != nil {
+			app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+				embeddingService.StopScheduledBuilds()
+				return nil
+			})
+		}
 
-			// Run incremental vector build in background
-			go func() {
-				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-				defer cancel()
-
-				logger.Info("[AutoVectorBuild] triggered by diary update for user: %s", userID)
-				result, err := embeddingService.BuildIncrementalVectors(ctx, userID)
-				if err != nil {
-					logger.Error("[AutoVectorBuild] failed for user %s: %v", userID, err)
-					return
-				}
-				logger.Info("[AutoVectorBuild] completed for user %s: %d built, %d failed", userID, result.Success, result.Failed)
-			}()
+		// Writing goals
+		goalsService := goals.NewService(app, analyticsService)
 
+		// Vacations must not end before they start
+		app.OnRecordBeforeCreateRequest("vacations").Add(func(e *core.RecordCreateEvent) error {
+			if err := goals.ValidateVacation(e.Record); err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+			return nil
+		})
+		app.OnRecordBeforeUpdateRequest("vacations").Add(func(e *core.RecordUpdateEvent) error {
+			if err := goals.ValidateVacation(e.Record); err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
 			return nil
 		})
 
 		// Register API routes
-		api.RegisterDiaryRoutes(app, e)
+		api.RegisterDiaryRoutes(app, e, analyticsService, goalsService)
 		api.RegisterSettingsRoutes(app, e)
-		api.RegisterAIRoutes(app, e, embeddingService)
-		api.RegisterExportImportRoutes(app, e, embeddingService)
+		api.RegisterAIRoutes(app, e, embeddingService, chatService)
+		api.RegisterExportImportRoutes(app, e, enrichmentService)
 		api.RegisterPublicRoutes(app, e)
+		api.RegisterRelatedRoutes(app, e, embeddingService)
 		api.RegisterVersionRoutes(e, Version, Name)
 
+		// Initialize scheduled backups
+		backupService := api.NewBackupService(app, backup.LoadConfig(app.DataDir()))
+		if err := backupService.Start(); err != nil {
+			log.Printf("Warning: Failed to schedule backups: %v", err)
+		}
+		app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+			backupService.Stop()
+			return nil
+		})
+		api.RegisterBackupRoutes(app, e, backupService)
+
+		// Initialize scheduled reflection digests
+		digestService := digest.NewService(app, chatService, digest.LoadConfig())
+		if err := digestService.Start(); err != nil {
+			log.Printf("Warning: Failed to schedule digests: %v", err)
+		}
+		app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+			digestService.Stop()
+			return nil
+		})
+		api.RegisterDigestRoutes(app, e, digestService)
+
+		// Initialize writing reminders
+		reminderService := reminder.NewService(app, reminder.LoadConfig())
+		if err := reminderService.Start(); err != nil {
+			log.Printf("Warning: Failed to schedule reminders: %v", err)
+		}
+		app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+			reminderService.Stop()
+			return nil
+		})
+		app.OnRecordBeforeCreateRequest("reminders").Add(func(e *core.RecordCreateEvent) error {
+			if err := reminderService.Validate(e.Record); err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+			return nil
+		})
+		app.OnRecordBeforeUpdateRequest("reminders").Add(func(e *core.RecordUpdateEvent) error {
+			if err := reminderService.Validate(e.Record); err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+			return nil
+		})
+		api.RegisterReminderRoutes(app, e, reminderService)
+		api.RegisterEnrichmentRoutes(app, e, enrichmentService)
+		api.RegisterAnalyticsRoutes(app, e, analyticsService)
+		api.RegisterGoalRoutes(app, e, goalsService)
+		api.RegisterTemplateRoutes(app, e, templates.NewService(app, chatService))
+		api.RegisterDoctorRoutes(app, e, vectorDB)
+
 		// Serve embedded frontend static files with SPA fallback
 		staticFS, err := static.GetFS()
 		if err != nil {
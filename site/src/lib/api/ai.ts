@@ -6,6 +6,46 @@ export interface AISettings {
 	chat_model: string;
 	embedding_model: string;
 	enabled: boolean;
+	max_steps?: number;
+	context_window?: number;
+	chat_provider?: 'openai' | 'anthropic' | 'ollama';
+	chat_base_url?: string;
+	chat_api_key?: string;
+	system_prompt?: string;
+	persona?: 'default' | 'friend' | 'coach' | 'therapist' | 'analyst';
+	language?: string;
+	// Negative uses the provider's default
+	temperature?: number;
+	// 0 uses the provider's default
+	max_tokens?: number;
+	timezone?: string;
+	digest_weekly?: boolean;
+	digest_monthly?: boolean;
+	digest_email?: boolean;
+	enrichment?: boolean;
+	// AI daily prompts based on recent diaries instead of the built-in library
+	daily_prompt?: boolean;
+}
+
+export interface Digest {
+	id: string;
+	period: 'week' | 'month';
+	period_start: string;
+	period_end: string;
+	summary: string;
+	themes: string[];
+	moods: Record<string, number>;
+	mood_trend: string;
+	diaries: string[];
+	emailed: string;
+	created: string;
+}
+
+export interface DigestList {
+	items: Digest[];
+	page: number;
+	per_page: number;
+	total: number;
 }
 
 export interface ModelInfo {
@@ -161,3 +201,43 @@ export async function getVectorStats(): Promise<VectorStats> {
 
 	return await response.json();
 }
+
+/**
+ * List reflection digests, newest period first
+ */
+export async function getDigests(period?: 'week' | 'month', page = 1): Promise<DigestList> {
+	const params = new URLSearchParams({ page: String(page) });
+	if (period) params.set('period', period);
+	const response = await fetch(`/api/ai/digests?${params}`, {
+		headers: {
+			'Authorization': `Bearer ${pb.authStore.token}`
+		}
+	});
+
+	if (!response.ok) {
+		throw new Error('Failed to get digests');
+	}
+
+	return await response.json();
+}
+
+/**
+ * Generate the digest of the last finished period, or of the period containing date
+ */
+export async function generateDigest(period: 'week' | 'month', date?: string): Promise<Digest> {
+	const response = await fetch('/api/ai/digests/generate', {
+		method: 'POST',
+		headers: {
+			'Content-Type': 'application/json',
+			'Authorization': `Bearer ${pb.authStore.token}`
+		},
+		body: JSON.stringify({ period, date })
+	});
+
+	if (!response.ok) {
+		const error = await response.json().catch(() => ({}));
+		throw new Error(error.message || 'Failed to generate digest');
+	}
+
+	return await response.json();
+}
@@ -6,13 +6,34 @@ export interface Conversation {
 	created: string;
 	updated: string;
 	message_count?: number;
+	summary?: string;
+	generating?: boolean;
+}
+
+export interface ToolCall {
+	id: string;
+	type: string;
+	function: {
+		name: string;
+		arguments: string;
+	};
+}
+
+export interface BranchInfo {
+	index: number;
+	count: number;
+	siblings: string[];
 }
 
 export interface Message {
 	id: string;
-	role: 'user' | 'assistant';
+	role: 'user' | 'assistant' | 'tool';
 	content: string;
 	referenced_diaries?: string[];
+	tool_calls?: ToolCall[] | null;
+	tool_call_id?: string;
+	parent?: string;
+	branch?: BranchInfo;
 	created: string;
 }
 
@@ -111,12 +132,105 @@ export async function updateConversationTitle(id: string, title: string): Promis
 	return await response.json();
 }
 
+export interface ConversationSummary {
+	conversation_id: string;
+	summary: string;
+	summarized_until: string;
+	pending_messages: number;
+}
+
+/**
+ * Get the stored summary of a conversation
+ */
+export async function getConversationSummary(id: string): Promise<ConversationSummary> {
+	const response = await fetch(`/api/ai/conversations/${id}/summary`, {
+		headers: {
+			'Authorization': `Bearer ${pb.authStore.token}`
+		}
+	});
+
+	if (!response.ok) {
+		throw new Error('Failed to get conversation summary');
+	}
+
+	return await response.json();
+}
+
+/**
+ * Regenerate the summary of a conversation
+ */
+export async function regenerateConversationSummary(id: string): Promise<ConversationSummary> {
+	const response = await fetch(`/api/ai/conversations/${id}/summary`, {
+		method: 'POST',
+		headers: {
+			'Authorization': `Bearer ${pb.authStore.token}`
+		}
+	});
+
+	if (!response.ok) {
+		throw new Error('Failed to regenerate conversation summary');
+	}
+
+	return await response.json();
+}
+
+export interface ToolCallEvent {
+	id: string;
+	name: string;
+	arguments: string;
+	step: number;
+}
+
+export interface ToolResultEvent {
+	id: string;
+	name: string;
+	step: number;
+	content: string;
+	referenced_diaries?: string[] | null;
+	error?: string;
+}
+
+export interface Citation {
+	index: number;
+	marker: string;
+	diary_id: string;
+	date: string;
+	quote: string;
+}
+
+export interface ContextUsage {
+	context_window: number;
+	budget: number;
+	prompt_tokens: number;
+	completion_tokens: number;
+	total_tokens: number;
+	system_tokens: number;
+	summary_tokens: number;
+	tools_tokens: number;
+	history_tokens: number;
+	history_messages: number;
+	omitted_messages: number;
+	tool_result_tokens: number;
+	steps: number;
+	estimated: boolean;
+}
+
 export interface StreamChunk {
 	content?: string;
 	done?: boolean;
 	referenced_diaries?: string[];
 	error?: string;
 	title?: string;
+	tool_call?: ToolCallEvent;
+	tool_result?: ToolResultEvent;
+	citation?: Citation;
+	citations?: Citation[] | null;
+	usage?: ContextUsage;
+	message_id?: string;
+	user_message_id?: string;
+	stopped?: boolean;
+	// ID of the event, used to resume the stream after it
+	event_id?: string;
 }
 
 /**
@@ -142,13 +256,157 @@ export async function* streamChat(
 		throw new Error('Failed to send message');
 	}
 
+	yield* readStream(response);
+}
+
+/**
+ * Regenerate the answer to a message as a new branch and stream it
+ */
+export async function* regenerateMessage(
+	conversationId: string,
+	messageId: string
+): AsyncGenerator<StreamChunk> {
+	const response = await fetch(`/api/ai/conversations/${conversationId}/messages/${messageId}/regenerate`, {
+		method: 'POST',
+		headers: {
+			'Authorization': `Bearer ${pb.authStore.token}`
+		}
+	});
+
+	if (!response.ok) {
+		throw new Error('Failed to regenerate message');
+	}
+
+	yield* readStream(response);
+}
+
+/**
+ * Edit a user message, creating a new branch, and stream the new answer
+ */
+export async function* editMessage(
+	conversationId: string,
+	messageId: string,
+	content: string
+): AsyncGenerator<StreamChunk> {
+	const response = await fetch(`/api/ai/conversations/${conversationId}/messages/${messageId}/edit`, {
+		method: 'POST',
+		headers: {
+			'Authorization': `Bearer ${pb.authStore.token}`,
+			'Content-Type': 'application/json'
+		},
+		body: JSON.stringify({ content })
+	});
+
+	if (!response.ok) {
+		throw new Error('Failed to edit message');
+	}
+
+	yield* readStream(response);
+}
+
+/**
+ * Switch the active branch to the one through a message
+ */
+export async function switchBranch(conversationId: string, messageId: string): Promise<void> {
+	const response = await fetch(`/api/ai/conversations/${conversationId}/branch`, {
+		method: 'PUT',
+		headers: {
+			'Authorization': `Bearer ${pb.authStore.token}`,
+			'Content-Type': 'application/json'
+		},
+		body: JSON.stringify({ message_id: messageId })
+	});
+
+	if (!response.ok) {
+		throw new Error('Failed to switch branch');
+	}
+}
+
+/**
+ * Reattach to the response being generated, continuing after the last received event
+ */
+export async function* resumeStream(
+	conversationId: string,
+	lastEventId = ''
+): AsyncGenerator<StreamChunk> {
+	const response = await fetch(`/api/ai/conversations/${conversationId}/stream`, {
+		headers: {
+			'Authorization': `Bearer ${pb.authStore.token}`,
+			'Last-Event-ID': lastEventId
+		}
+	});
+
+	if (!response.ok) {
+		throw new Error('Failed to resume response');
+	}
+
+	yield* readStream(response);
+}
+
+/**
+ * Stop the response being generated, the partial answer is kept
+ */
+export async function stopGeneration(conversationId: string): Promise<void> {
+	const response = await fetch(`/api/ai/conversations/${conversationId}/stop`, {
+		method: 'POST',
+		headers: {
+			'Authorization': `Bearer ${pb.authStore.token}`
+		}
+	});
+
+	if (!response.ok) {
+		throw new Error('Failed to stop response');
+	}
+}
+
+// Version of the chat event protocol this client understands
+const CHAT_PROTOCOL_VERSION = '1';
+
+/**
+ * Convert a named chat event into a stream chunk
+ */
+function toChunk(event: string, data: any): StreamChunk | null {
+	switch (event) {
+		case 'delta':
+			return { content: data.content };
+		case 'title':
+			return { title: data.title };
+		case 'tool_call':
+			return { tool_call: data };
+		case 'tool_result':
+			return { tool_result: data };
+		case 'citation':
+			return { citation: data };
+		case 'usage':
+			return { usage: data };
+		case 'error':
+			return { error: data.message };
+		case 'done':
+			return { ...data, done: true };
+		default:
+			// Unknown events are ignored
+			return null;
+	}
+}
+
+/**
+ * Read the server-sent events of a chat response
+ */
+async function* readStream(response: Response): AsyncGenerator<StreamChunk> {
 	if (!response.body) {
 		throw new Error('No response body');
 	}
+	const version = response.headers.get('X-Diarum-Chat-Protocol');
+	if (version && version !== CHAT_PROTOCOL_VERSION) {
+		throw new Error(`Unsupported chat protocol version ${version}`);
+	}
 
 	const reader = response.body.getReader();
 	const decoder = new TextDecoder();
 	let buffer = '';
+	let eventId: string | undefined;
+	let eventName = 'message';
+	let data: string[] = [];
 
 	while (true) {
 		const { done, value } = await reader.read();
@@ -159,13 +417,26 @@ export async function* streamChat(
 		buffer = lines.pop() || '';
 
 		for (const line of lines) {
-			if (line.startsWith('data: ')) {
-				try {
-					const data = JSON.parse(line.slice(6));
-					yield data as StreamChunk;
-				} catch {
-					// Skip invalid JSON
+			if (line === '') {
+				// A blank line ends the event, keep-alive comments carry no data
+				if (data.length > 0) {
+					try {
+						const chunk = toChunk(eventName, JSON.parse(data.join('\n')));
+						if (chunk) {
+							yield { ...chunk, event_id: eventId };
+						}
+					} catch {
+						// Skip invalid JSON
+					}
 				}
+				eventName = 'message';
+				data = [];
+			} else if (line.startsWith('event: ')) {
+				eventName = line.slice(7);
+			} else if (line.startsWith('data: ')) {
+				data.push(line.slice(6));
+			} else if (line.startsWith('id: ')) {
+				eventId = line.slice(4);
 			}
 		}
 	}
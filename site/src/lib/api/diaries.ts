@@ -210,6 +210,58 @@ export async function getDiaryStats(): Promise<{ streak: number; total: number }
 	}
 }
 
+export interface Streak {
+	days: number;
+	start?: string;
+	end?: string;
+	// Missed days forgiven by streak freezes
+	freezes_used: number;
+}
+
+export interface DiaryAnalytics {
+	start: string;
+	end: string;
+	total_entries: number;
+	total_words: number;
+	total_chars: number;
+	current_streak: Streak;
+	longest_streak: Streak;
+	days: Array<{ date: string; entries: number; words: number; chars: number }>;
+	// Every day from start to end, level goes from 0 (no diary) to 4
+	heatmap: Array<{ date: string; words: number; level: number }>;
+	moods: Array<{ month: string; counts: Record<string, number> }>;
+	weather: Array<{ month: string; counts: Record<string, number> }>;
+	// Diaries by the hour they were first written
+	hours: number[];
+	monthly: Array<{ month: string; entries: number; words: number; chars: number }>;
+}
+
+/**
+ * Get writing analytics, the series cover start to end (default: the last 365 days)
+ */
+export async function getDiaryAnalytics(start?: string, end?: string): Promise<DiaryAnalytics | null> {
+	try {
+		const params = new URLSearchParams({ tz: Intl.DateTimeFormat().resolvedOptions().timeZone });
+		if (start) params.set('start', start);
+		if (end) params.set('end', end);
+
+		const response = await fetch(`/api/diaries/analytics?${params}`, {
+			headers: {
+				'Authorization': `Bearer ${pb.authStore.token}`
+			}
+		});
+
+		if (!response.ok) {
+			return null;
+		}
+
+		return await response.json();
+	} catch (error) {
+		console.error('Error fetching diary analytics:', error);
+		return null;
+	}
+}
+
 /**
  * Delete diary
  */
@@ -222,3 +274,140 @@ export async function deleteDiary(id: string): Promise<boolean> {
 		return false;
 	}
 }
+
+export interface EnrichmentSuggestions {
+	mood: string;
+	// -1 (very negative) to 1 (very positive), null until the diary is enriched
+	sentiment: number | null;
+	topics: string[];
+	people: string[];
+	places: string[];
+	enriched_at: string;
+}
+
+export interface DiaryEnrichment {
+	suggestions: EnrichmentSuggestions;
+	// An enrichment is scheduled or running
+	pending: boolean;
+	mood: string;
+	tags: string[];
+}
+
+export interface SuggestionSelection {
+	mood?: boolean;
+	topics?: string[];
+	people?: string[];
+	places?: string[];
+}
+
+async function enrichmentRequest(id: string, action: string, method: string, body?: SuggestionSelection): Promise<DiaryEnrichment | null> {
+	try {
+		const response = await fetch(`/api/diaries/${id}/enrichment${action}`, {
+			method,
+			headers: {
+				'Content-Type': 'application/json',
+				'Authorization': `Bearer ${pb.authStore.token}`
+			},
+			body: body ? JSON.stringify(body) : undefined
+		});
+
+		if (!response.ok) {
+			return null;
+		}
+
+		return await response.json();
+	} catch (error) {
+		console.error('Error requesting diary enrichment:', error);
+		return null;
+	}
+}
+
+/**
+ * Get the AI suggestions of a diary
+ */
+export function getDiaryEnrichment(id: string): Promise<DiaryEnrichment | null> {
+	return enrichmentRequest(id, '', 'GET');
+}
+
+/**
+ * Ask the AI for fresh suggestions now
+ */
+export function enrichDiary(id: string): Promise<DiaryEnrichment | null> {
+	return enrichmentRequest(id, '', 'POST');
+}
+
+/**
+ * Accept suggestions into the diary's mood and tags
+ */
+export function acceptSuggestions(id: string, selection: SuggestionSelection): Promise<DiaryEnrichment | null> {
+	return enrichmentRequest(id, '/accept', 'POST', selection);
+}
+
+/**
+ * Dismiss suggestions
+ */
+export function dismissSuggestions(id: string, selection: SuggestionSelection): Promise<DiaryEnrichment | null> {
+	return enrichmentRequest(id, '/dismiss', 'POST', selection);
+}
+
+export interface RelatedDiary {
+	id: string;
+	date: string;
+	content: string;
+	mood: string;
+	weather: string;
+	// Similarity to the diary, higher is closer
+	score?: number;
+	years_ago?: number;
+}
+
+export interface RelatedDiaries {
+	id: string;
+	// False while the diary has no vector yet
+	indexed: boolean;
+	related: RelatedDiary[];
+}
+
+export interface OnThisDay {
+	date: string;
+	entries: RelatedDiary[];
+	// Only with semantic matches: entries around the same time in previous years
+	similar?: RelatedDiary[];
+	indexed?: boolean;
+}
+
+async function getJSON<T>(url: string, errorMessage: string): Promise<T | null> {
+	try {
+		const response = await fetch(url, {
+			headers: {
+				'Authorization': `Bearer ${pb.authStore.token}`
+			}
+		});
+
+		if (!response.ok) {
+			return null;
+		}
+
+		return await response.json();
+	} catch (error) {
+		console.error(errorMessage, error);
+		return null;
+	}
+}
+
+/**
+ * Get the diaries closest in meaning to a diary
+ */
+export function getRelatedDiaries(id: string, limit: number = 5): Promise<RelatedDiaries | null> {
+	return getJSON(`/api/diaries/${id}/related?limit=${limit}`, 'Error fetching related diaries:');
+}
+
+/**
+ * Get the diaries of the same day in previous years, optionally with
+ * similar diaries from the same time of year
+ */
+export function getOnThisDay(date: string, semantic: boolean = false): Promise<OnThisDay | null> {
+	const params = new URLSearchParams({ date });
+	if (semantic) params.set('semantic', 'true');
+	return getJSON(`/api/diaries/on-this-day?${params}`, 'Error fetching on this day diaries:');
+}
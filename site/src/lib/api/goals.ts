@@ -0,0 +1,164 @@
+import { pb } from './client';
+
+export type GoalMetric = 'words' | 'chars' | 'entries';
+export type GoalPeriod = 'day' | 'week' | 'month';
+
+export interface Goal {
+	id?: string;
+	name: string;
+	metric: GoalMetric;
+	target: number;
+	period: GoalPeriod;
+	paused?: boolean;
+}
+
+export interface Vacation {
+	id?: string;
+	// First and last day, YYYY-MM-DD
+	start: string;
+	end: string;
+	note?: string;
+}
+
+export interface PeriodProgress {
+	start: string;
+	end: string;
+	value: number;
+	target: number;
+	percent: number;
+	met: boolean;
+	// The period was spent on vacation
+	excused: boolean;
+}
+
+export interface GoalProgress {
+	id: string;
+	name: string;
+	metric: GoalMetric;
+	target: number;
+	period: GoalPeriod;
+	current: PeriodProgress;
+	// Previous periods, most recent first
+	history: PeriodProgress[];
+	// Consecutive periods the goal was met
+	streak: number;
+}
+
+/**
+ * Get the user's goals
+ */
+export async function getGoals(): Promise<Goal[]> {
+	try {
+		const records = await pb.collection('goals').getFullList({ sort: 'created' });
+		return records.map((record: any) => ({
+			id: record.id,
+			name: record.name,
+			metric: record.metric,
+			target: record.target,
+			period: record.period,
+			paused: record.paused
+		}));
+	} catch (error) {
+		console.error('Error fetching goals:', error);
+		return [];
+	}
+}
+
+/**
+ * Create or update a goal
+ */
+export async function saveGoal(goal: Goal): Promise<boolean> {
+	try {
+		const { id, ...data } = goal;
+		if (id) {
+			await pb.collection('goals').update(id, data);
+		} else {
+			await pb.collection('goals').create({ ...data, owner: pb.authStore.model?.id });
+		}
+		return true;
+	} catch (error) {
+		console.error('Error saving goal:', error);
+		return false;
+	}
+}
+
+/**
+ * Delete a goal
+ */
+export async function deleteGoal(id: string): Promise<boolean> {
+	try {
+		await pb.collection('goals').delete(id);
+		return true;
+	} catch (error) {
+		console.error('Error deleting goal:', error);
+		return false;
+	}
+}
+
+/**
+ * Get the progress of the goals in the current and the previous periods
+ */
+export async function getGoalProgress(periods: number = 1): Promise<GoalProgress[]> {
+	try {
+		const tz = Intl.DateTimeFormat().resolvedOptions().timeZone;
+		const response = await fetch(`/api/goals/progress?periods=${periods}&tz=${encodeURIComponent(tz)}`, {
+			headers: {
+				'Authorization': `Bearer ${pb.authStore.token}`
+			}
+		});
+
+		if (!response.ok) {
+			return [];
+		}
+
+		const data = await response.json();
+		return data.goals || [];
+	} catch (error) {
+		console.error('Error fetching goal progress:', error);
+		return [];
+	}
+}
+
+/**
+ * Get the user's vacations
+ */
+export async function getVacations(): Promise<Vacation[]> {
+	try {
+		const records = await pb.collection('vacations').getFullList({ sort: '-start' });
+		return records.map((record: any) => ({
+			id: record.id,
+			start: record.start,
+			end: record.end,
+			note: record.note
+		}));
+	} catch (error) {
+		console.error('Error fetching vacations:', error);
+		return [];
+	}
+}
+
+/**
+ * Add a vacation, its days neither count towards nor break streaks
+ */
+export async function addVacation(vacation: Vacation): Promise<boolean> {
+	try {
+		await pb.collection('vacations').create({ ...vacation, owner: pb.authStore.model?.id });
+		return true;
+	} catch (error) {
+		console.error('Error adding vacation:', error);
+		return false;
+	}
+}
+
+/**
+ * Delete a vacation
+ */
+export async function deleteVacation(id: string): Promise<boolean> {
+	try {
+		await pb.collection('vacations').delete(id);
+		return true;
+	} catch (error) {
+		console.error('Error deleting vacation:', error);
+		return false;
+	}
+}
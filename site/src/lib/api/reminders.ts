@@ -0,0 +1,98 @@
+import { pb } from './client';
+
+export type ReminderChannel = 'email' | 'webhook' | 'ntfy' | 'gotify';
+
+export interface Reminder {
+	id?: string;
+	name?: string;
+	// Five-field cron expression in the user's time zone, e.g. "0 21 * * *"
+	cron: string;
+	channel: ReminderChannel;
+	// Webhook URL, ntfy topic URL or Gotify server URL
+	url?: string;
+	// Gotify application token, ntfy access token or webhook bearer token
+	token?: string;
+	message?: string;
+	paused?: boolean;
+	last_sent?: string;
+	last_error?: string;
+}
+
+/**
+ * Get the user's reminders
+ */
+export async function getReminders(): Promise<Reminder[]> {
+	try {
+		const records = await pb.collection('reminders').getFullList({ sort: 'created' });
+		return records.map((record: any) => ({
+			id: record.id,
+			name: record.name,
+			cron: record.cron,
+			channel: record.channel,
+			url: record.url,
+			token: record.token,
+			message: record.message,
+			paused: record.paused,
+			last_sent: record.last_sent,
+			last_error: record.last_error
+		}));
+	} catch (error) {
+		console.error('Error fetching reminders:', error);
+		return [];
+	}
+}
+
+/**
+ * Create or update a reminder, returns an error message on failure
+ */
+export async function saveReminder(reminder: Reminder): Promise<string | null> {
+	try {
+		const { id, last_sent, last_error, ...data } = reminder;
+		if (id) {
+			await pb.collection('reminders').update(id, data);
+		} else {
+			await pb.collection('reminders').create({ ...data, owner: pb.authStore.model?.id });
+		}
+		return null;
+	} catch (error: any) {
+		console.error('Error saving reminder:', error);
+		return error?.message || 'Failed to save reminder';
+	}
+}
+
+/**
+ * Delete a reminder
+ */
+export async function deleteReminder(id: string): Promise<boolean> {
+	try {
+		await pb.collection('reminders').delete(id);
+		return true;
+	} catch (error) {
+		console.error('Error deleting reminder:', error);
+		return false;
+	}
+}
+
+/**
+ * Send a reminder now, returns an error message on failure
+ */
+export async function testReminder(id: string): Promise<string | null> {
+	try {
+		const response = await fetch(`/api/reminders/${id}/test`, {
+			method: 'POST',
+			headers: {
+				'Authorization': `Bearer ${pb.authStore.token}`
+			}
+		});
+
+		if (!response.ok) {
+			const data = await response.json().catch(() => ({}));
+			return data.message || 'Failed to send reminder';
+		}
+
+		return null;
+	} catch (error) {
+		console.error('Error testing reminder:', error);
+		return 'Failed to send reminder';
+	}
+}
@@ -0,0 +1,119 @@
+import { pb } from './client';
+
+export interface DiaryTemplate {
+	// Record id, or the key of a built-in template such as "gratitude"
+	id?: string;
+	name: string;
+	// Editor HTML with placeholders such as {{date}}, {{weekday}} and {{yesterday_mood}}
+	content: string;
+	builtin?: boolean;
+}
+
+export interface DailyPrompt {
+	date: string;
+	text: string;
+	source: 'library' | 'ai';
+}
+
+/**
+ * Get the user's templates followed by the built-in ones
+ */
+export async function getTemplates(): Promise<DiaryTemplate[]> {
+	try {
+		const response = await fetch('/api/templates', {
+			headers: {
+				'Authorization': `Bearer ${pb.authStore.token}`
+			}
+		});
+
+		if (!response.ok) {
+			return [];
+		}
+
+		const data = await response.json();
+		return data.templates || [];
+	} catch (error) {
+		console.error('Error fetching templates:', error);
+		return [];
+	}
+}
+
+/**
+ * Create or update one of the user's templates, returns an error message on failure
+ */
+export async function saveTemplate(template: DiaryTemplate): Promise<string | null> {
+	try {
+		const { id, builtin, ...data } = template;
+		if (id && !builtin) {
+			await pb.collection('templates').update(id, data);
+		} else {
+			await pb.collection('templates').create({ ...data, owner: pb.authStore.model?.id });
+		}
+		return null;
+	} catch (error: any) {
+		console.error('Error saving template:', error);
+		return error?.message || 'Failed to save template';
+	}
+}
+
+/**
+ * Delete one of the user's templates
+ */
+export async function deleteTemplate(id: string): Promise<boolean> {
+	try {
+		await pb.collection('templates').delete(id);
+		return true;
+	} catch (error) {
+		console.error('Error deleting template:', error);
+		return false;
+	}
+}
+
+/**
+ * Create a day's diary from a template, today's by default. Returns an error message
+ * on failure, e.g. when the diary is already written.
+ */
+export async function createDiaryFromTemplate(template: string, date?: string): Promise<{ id: string; date: string; content: string } | string> {
+	try {
+		const response = await fetch('/api/diaries/from-template', {
+			method: 'POST',
+			headers: {
+				'Content-Type': 'application/json',
+				'Authorization': `Bearer ${pb.authStore.token}`
+			},
+			body: JSON.stringify({ template, date })
+		});
+
+		const data = await response.json().catch(() => ({}));
+		if (!response.ok) {
+			return data.message || 'Failed to create diary from template';
+		}
+		return data;
+	} catch (error) {
+		console.error('Error creating diary from template:', error);
+		return 'Failed to create diary from template';
+	}
+}
+
+/**
+ * Get the writing prompt of a day, today's by default
+ */
+export async function getDailyPrompt(date?: string): Promise<DailyPrompt | null> {
+	try {
+		const params = date ? `?${new URLSearchParams({ date })}` : '';
+		const response = await fetch(`/api/prompts/daily${params}`, {
+			headers: {
+				'Authorization': `Bearer ${pb.authStore.token}`
+			}
+		});
+
+		if (!response.ok) {
+			return null;
+		}
+
+		return await response.json();
+	} catch (error) {
+		console.error('Error fetching daily prompt:', error);
+		return null;
+	}
+}